@@ -3,19 +3,25 @@ package main
 import (
 	"context"
 	"embed"
+	"errors"
 	"flag"
 	"fmt"
+	"net"
 	"os"
 	"path/filepath"
+	"strconv"
+	"strings"
 
 	"bandwidth-income-manager/backend/api"
 	"bandwidth-income-manager/backend/apps"
+	"bandwidth-income-manager/backend/backup"
 	"bandwidth-income-manager/backend/config"
 	"bandwidth-income-manager/backend/docker"
 	"bandwidth-income-manager/backend/monitor"
 	"bandwidth-income-manager/backend/notifications"
 	"bandwidth-income-manager/backend/orchestrator"
 	"bandwidth-income-manager/backend/proxy"
+	"bandwidth-income-manager/backend/state"
 
 	"github.com/wailsapp/wails/v2"
 	"github.com/wailsapp/wails/v2/pkg/options"
@@ -26,41 +32,52 @@ import (
 var assets embed.FS
 
 func main() {
-	// Define command-line flags
-	headless := flag.Bool("headless", false, "Run in headless mode")
-	port := flag.Int("port", 8080, "Port for headless server")
-	flag.Parse()
+	if len(os.Args) > 1 && os.Args[1] == "validate" {
+		os.Exit(runValidate(os.Args[2:]))
+	}
+	if len(os.Args) > 1 && os.Args[1] == "export" {
+		os.Exit(runExport(os.Args[2:]))
+	}
 
-	// Get current working directory
-	wd, err := os.Getwd()
-	if err != nil {
-		fmt.Printf("Error getting working directory: %v\n", err)
+	// Config file, BIM_* environment variables and flags, in that order
+	opts, err := config.LoadOptions(os.Args[1:])
+	if errors.Is(err, flag.ErrHelp) {
 		return
 	}
+	if err != nil {
+		fmt.Printf("Invalid configuration:\n%v\n", err)
+		os.Exit(2)
+	}
+	if opts.ConfigFile != "" {
+		fmt.Printf("Using config file %s\n", opts.ConfigFile)
+	}
+	apps.DefaultRestartPolicy = opts.RestartPolicy
 
-	configsDir := filepath.Join(wd, "configs")
-	dbPath := filepath.Join(wd, "data", "monitor.db")
-
-	// Create data directory if it doesn't exist
-	_ = os.MkdirAll(filepath.Join(wd, "data"), 0755)
+	paths, err := openDataDir(opts.DataDir)
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		return
+	}
 
 	// Initialize Docker client
-	dockerClient, err := docker.NewDockerClient("")
+	dockerClient, err := docker.NewDockerClient(opts.DockerHost)
 	if err != nil {
 		fmt.Printf("Warning: Failed to initialize Docker client: %v\n", err)
 		// Continue without Docker for now
 	}
 
 	// Initialize config loader
-	configLoader := config.NewLoader(configsDir)
+	configLoader := config.NewLoader(paths.Configs())
 	if err := configLoader.LoadAppConfigs(); err != nil {
 		fmt.Printf("Warning: Failed to load app configs: %v\n", err)
 	}
 
 	// Initialize monitor
-	monitorCollector, err := monitor.NewCollector(dbPath)
+	monitorCollector, err := monitor.NewCollector(paths.MonitorDB())
 	if err != nil {
 		fmt.Printf("Warning: Failed to initialize monitor: %v\n", err)
+	} else if opts.MetricsInterval > 0 {
+		go monitorCollector.StartCollecting(opts.MetricsInterval)
 	}
 
 	// Initialize proxy manager
@@ -70,29 +87,102 @@ func main() {
 	instanceManager := apps.NewInstanceManager()
 
 	// Initialize credential store
-	credentialStore := config.NewCredentialStore()
+	credentialStore, err := config.OpenCredentialStore(opts.CredentialBackend, paths.Root)
+	if errors.Is(err, config.ErrKeyringUnavailable) {
+		// Falling back to files would look like all credentials were lost
+		fmt.Printf("Error opening credential store: %v\n", err)
+		return
+	}
+	if err != nil {
+		fmt.Printf("Warning: Failed to open credential store, using files: %v\n", err)
+		credentialStore, err = config.NewCredentialStore(config.NewFileBackend(paths.Root))
+		if err != nil {
+			fmt.Printf("Error opening credential store: %v\n", err)
+			return
+		}
+	}
+	if passphrase := os.Getenv("BIM_PASSPHRASE"); passphrase != "" {
+		// Lets headless installs start unlocked
+		if err := credentialStore.Unlock(passphrase); err != nil {
+			fmt.Printf("Warning: Failed to unlock credentials: %v\n", err)
+		}
+	}
 
 	// Initialize orchestrator (not used yet)
 	_ = orchestrator.NewManager()
 
-	// Initialize notifications (not used yet)
+	// Initialize notifications
 	notifConfig := &notifications.Config{
-		Enabled: true,
+		Enabled:           opts.Notifications.Enabled,
+		AppStopped:        opts.Notifications.AppStopped,
+		EarningsMilestone: opts.Notifications.EarningsMilestone,
+		UpdateAvailable:   opts.Notifications.UpdateAvailable,
+		ProxyFailure:      opts.Notifications.ProxyFailure,
+		DiscordWebhook:    opts.Notifications.DiscordWebhook,
+		TelegramBotToken:  opts.Notifications.TelegramBotToken,
+		TelegramChatID:    opts.Notifications.TelegramChatID,
+	}
+	notifHandler := notifications.NewHandler(notifConfig, monitorCollector)
+
+	// Restore proxies, instances and activity from the last run
+	stateStore, err := state.Open(paths.StateFile())
+	if err != nil {
+		// Refuse to start rather than overwrite state we could not read
+		fmt.Printf("Error loading state: %v\n", err)
+		return
+	}
+	state.Bind(stateStore, proxyManager, instanceManager, credentialStore)
+
+	// Snapshots of instance data, taken on demand, on schedule and before removal
+	backupManager := backup.NewManager(paths.Backups(), dockerClient, instanceManager)
+
+	// Check registries for newer app images
+	var updateChecker *apps.UpdateChecker
+	if dockerClient != nil {
+		updateChecker = apps.NewUpdateChecker(dockerClient, docker.NewRegistryClient(), instanceManager)
+		updateChecker.Subscribe(func(update apps.ImageUpdate) {
+			notifHandler.NotifyUpdateAvailable(update.AppID, update.Version())
+		})
 	}
-	_ = notifications.NewHandler(notifConfig, monitorCollector)
 
 	// Initialize API
-	appsAPI := api.NewAppsAPI(dockerClient, configLoader, monitorCollector, instanceManager, credentialStore, proxyManager)
+	appsAPI := api.NewAppsAPI(dockerClient, configLoader, monitorCollector, instanceManager, credentialStore, proxyManager, stateStore, backupManager, updateChecker)
+
+	// Pick up edits to configs/apps without a restart
+	if err := configLoader.WatchConfigs(context.Background()); err != nil {
+		fmt.Printf("Warning: Failed to watch app configs: %v\n", err)
+	}
+
+	// Keep instance status in sync with Docker events
+	if dockerClient != nil {
+		// Pick up containers deployed before this start
+		if _, err := appsAPI.AdoptContainers(); err != nil {
+			fmt.Printf("Warning: Failed to adopt existing containers: %v\n", err)
+		}
+
+		statusWatcher := apps.NewStatusWatcher(dockerClient, instanceManager)
+		go statusWatcher.Run(context.Background())
+
+		if opts.UpdateCheckInterval > 0 {
+			go updateChecker.Run(context.Background(), opts.UpdateCheckInterval)
+		}
+
+		go backupManager.Run(context.Background())
+	}
 
 	// Initialize Proxy API
 	proxyAPI := api.NewProxyAPI(proxyManager, instanceManager, credentialStore, appsAPI)
 
 	// Settings API
-	settingsAPI := api.NewSettingsAPI(wd)
+	settingsAPI := api.NewSettingsAPI(paths.Root, opts)
+
+	// Export and import of the whole setup
+	transferAPI := api.NewTransferAPI(appsAPI, settingsAPI)
 
-	if *headless {
+	if opts.Headless {
 		// Start headless server
-		api.StartHeadlessServer(*port, appsAPI, proxyAPI, settingsAPI, assets)
+		addr := net.JoinHostPort(opts.BindAddress, strconv.Itoa(opts.Port))
+		api.StartHeadlessServer(addr, appsAPI, proxyAPI, settingsAPI, transferAPI, assets)
 	} else {
 		// Create application with options
 		err = wails.Run(&options.App{
@@ -107,11 +197,13 @@ func main() {
 				appsAPI.OnStartup(ctx)
 				proxyAPI.OnStartup(ctx)
 				settingsAPI.OnStartup(ctx)
+				transferAPI.OnStartup(ctx)
 			},
 			Bind: []interface{}{
 				appsAPI,
 				proxyAPI,
 				settingsAPI,
+				transferAPI,
 			},
 		})
 
@@ -120,3 +212,31 @@ func main() {
 		}
 	}
 }
+
+// openDataDir resolves the data directory, moves files earlier versions
+// kept in the working directory into it and points relative app volumes
+// at it
+func openDataDir(dir string) (config.DataPaths, error) {
+	root, err := config.ResolveDataDir(dir)
+	if err != nil {
+		return config.DataPaths{}, err
+	}
+	paths := config.DataPaths{Root: root}
+	if err := os.MkdirAll(filepath.Dir(paths.StateFile()), 0755); err != nil {
+		return paths, fmt.Errorf("failed to create data directory: %w", err)
+	}
+
+	if wd, err := os.Getwd(); err == nil {
+		moved, err := config.MigrateDataDir(wd, root)
+		if len(moved) > 0 {
+			fmt.Printf("Moved %s from %s to %s\n", strings.Join(moved, ", "), wd, root)
+		}
+		if err != nil {
+			return paths, err
+		}
+	}
+
+	apps.DataDir = root
+	fmt.Printf("Using data directory %s\n", root)
+	return paths, nil
+}
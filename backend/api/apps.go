@@ -2,21 +2,24 @@ package api
 
 import (
 	"context"
-	"encoding/json"
 	"fmt"
+	"maps"
 	"math/rand"
-	"os"
-	"os/exec"
-	"path/filepath"
+	"slices"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"bandwidth-income-manager/backend/apps"
+	"bandwidth-income-manager/backend/backup"
 	"bandwidth-income-manager/backend/config"
 	"bandwidth-income-manager/backend/docker"
 	"bandwidth-income-manager/backend/monitor"
 	"bandwidth-income-manager/backend/proxy"
+	"bandwidth-income-manager/backend/state"
+
+	wailsruntime "github.com/wailsapp/wails/v2/pkg/runtime"
 )
 
 type wailsRuntime interface {
@@ -27,36 +30,113 @@ type wailsRuntime interface {
 // AppsAPI provides the API for app management
 type AppsAPI struct {
 	ctx             context.Context
-	docker          *docker.Client
+	docker          docker.Runtime
 	config          *config.Loader
 	monitor         *monitor.Collector
 	instanceManager *apps.InstanceManager
 	credentialStore *config.CredentialStore
 	proxyManager    *proxy.Manager
+	store           *state.Store
+	backups         *backup.Manager
+	updates         *apps.UpdateChecker
 	startTime       time.Time
 	recentActivity  []string
+	activityMu      sync.Mutex
+	logStreams      map[string]context.CancelFunc
+	logStreamsMu    sync.Mutex
+	updating        map[string]bool // apps with a rolling update in progress
+	updatingMu      sync.Mutex
 }
 
 // NewAppsAPI creates a new AppsAPI
-func NewAppsAPI(dockerClient *docker.Client, configLoader *config.Loader, monitorCollector *monitor.Collector, instanceManager *apps.InstanceManager, credentialStore *config.CredentialStore, proxyManager *proxy.Manager) *AppsAPI {
-	return &AppsAPI{
+func NewAppsAPI(dockerClient docker.Runtime, configLoader *config.Loader, monitorCollector *monitor.Collector, instanceManager *apps.InstanceManager, credentialStore *config.CredentialStore, proxyManager *proxy.Manager, store *state.Store, backups *backup.Manager, updates *apps.UpdateChecker) *AppsAPI {
+	a := &AppsAPI{
 		docker:          dockerClient,
 		config:          configLoader,
 		monitor:         monitorCollector,
 		instanceManager: instanceManager,
 		credentialStore: credentialStore,
 		proxyManager:    proxyManager,
+		store:           store,
+		backups:         backups,
+		updates:         updates,
 		startTime:       time.Now(),
 		recentActivity:  make([]string, 0, 50),
+		logStreams:      make(map[string]context.CancelFunc),
+		updating:        make(map[string]bool),
+	}
+	if store != nil {
+		a.recentActivity = append(a.recentActivity, store.State().Activity...)
+	}
+	instanceManager.SetOnStatusChange(a.onInstanceStatusChange)
+	if configLoader != nil {
+		configLoader.Subscribe(a.onCatalogChange)
+	}
+	if updates != nil {
+		updates.Subscribe(a.onUpdateAvailable)
 	}
+	return a
 }
 
 func (a *AppsAPI) addActivity(entry string) {
+	a.activityMu.Lock()
+	defer a.activityMu.Unlock()
+
 	// keep last 50 entries
 	a.recentActivity = append(a.recentActivity, time.Now().Format(time.RFC3339)+" "+entry)
 	if len(a.recentActivity) > 50 {
 		a.recentActivity = a.recentActivity[len(a.recentActivity)-50:]
 	}
+
+	if a.store != nil {
+		activity := append([]string{}, a.recentActivity...)
+		if err := a.store.Update(func(s *state.State) { s.Activity = activity }); err != nil {
+			fmt.Printf("failed to save activity log: %v\n", err)
+		}
+	}
+}
+
+// activitySnapshot returns a copy of the activity log
+func (a *AppsAPI) activitySnapshot() []string {
+	a.activityMu.Lock()
+	defer a.activityMu.Unlock()
+
+	return append([]string{}, a.recentActivity...)
+}
+
+// onInstanceStatusChange records status changes reported by the status watcher
+func (a *AppsAPI) onInstanceStatusChange(instance *apps.AppInstance, previousStatus string) {
+	a.addActivity(fmt.Sprintf("Instance %s (%s) changed from %s to %s", instance.InstanceID, instance.AppID, previousStatus, instance.Status))
+	a.emitEvent("instance:status", map[string]interface{}{
+		"instance_id":     instance.InstanceID,
+		"app_id":          instance.AppID,
+		"container_id":    instance.ContainerID,
+		"status":          instance.Status,
+		"previous_status": previousStatus,
+	})
+}
+
+// onCatalogChange records app config reloads and tells the frontend to
+// refresh its app list
+func (a *AppsAPI) onCatalogChange(change config.CatalogChange) {
+	for _, appID := range change.Added {
+		a.addActivity("App config added: " + appID)
+	}
+	for _, appID := range change.Changed {
+		a.addActivity("App config reloaded: " + appID)
+	}
+	for _, appID := range change.Removed {
+		a.addActivity("App config removed: " + appID)
+	}
+	a.emitEvent("apps:catalog", change)
+}
+
+// emitEvent forwards an event to the frontend when running under Wails
+func (a *AppsAPI) emitEvent(name string, data ...interface{}) {
+	if a.ctx == nil {
+		return
+	}
+	wailsruntime.EventsEmit(a.ctx, name, data...)
 }
 
 // GetDashboardSummary returns active containers, uptime and recent activity
@@ -66,15 +146,9 @@ func (a *AppsAPI) GetDashboardSummary() (map[string]interface{}, error) {
 		return nil, err
 	}
 
-	appInstanceContainers := map[string]struct{}{}
-	allInstances := a.instanceManager.GetAllInstances()
-	for _, inst := range allInstances {
-		appInstanceContainers[inst.ContainerID] = struct{}{}
-	}
-
 	var runningIDs []string
-	for _, c := range containers {
-		if _, ok := appInstanceContainers[c.ID]; ok && strings.ToLower(c.State) == "running" {
+	for _, c := range a.appContainers(containers) {
+		if strings.ToLower(c.State) == "running" {
 			runningIDs = append(runningIDs, c.ID)
 		}
 	}
@@ -101,30 +175,108 @@ func (a *AppsAPI) GetDashboardSummary() (map[string]interface{}, error) {
 		"active_apps":     len(runningIDs),
 		"bandwidth_used":  totalNet,
 		"uptime_seconds":  uptimeSec,
-		"recent_activity": a.recentActivity,
+		"recent_activity": a.activitySnapshot(),
 	}
 	return summary, nil
 }
 
+// appContainers returns the app containers of this manager: those labelled
+// as ours, plus unlabelled ones that an instance still tracks
+func (a *AppsAPI) appContainers(containers []docker.ContainerInfo) []docker.ContainerInfo {
+	tracked := make(map[string]bool)
+	for _, inst := range a.instanceManager.GetAllInstances() {
+		if inst.ContainerID != "" {
+			tracked[inst.ContainerID] = true
+		}
+	}
+
+	result := apps.ManagedContainers(containers, apps.RoleApp)
+	for _, c := range containers {
+		if c.Labels[apps.LabelManager] == "" && tracked[c.ID] {
+			result = append(result, c)
+		}
+	}
+	return result
+}
+
 // GetAvailableApps returns all available apps from config
 func (a *AppsAPI) GetAvailableApps() (map[string]interface{}, error) {
-	apps := a.config.GetApps()
+	manifests := a.config.GetApps()
 
 	result := make(map[string]interface{})
-	for id, appConfig := range apps {
+	for id, manifest := range manifests {
+		fields := appFields(manifest)
+		envVars := make([]map[string]interface{}, 0, len(fields))
+		for _, field := range fields {
+			envVars = append(envVars, map[string]interface{}{
+				"key":         field["name"],
+				"required":    field["required"],
+				"description": field["help"],
+			})
+		}
 		result[id] = map[string]interface{}{
-			"app_id":           appConfig.AppID,
-			"name":             appConfig.Name,
-			"docker_image":     appConfig.DockerImage,
-			"description":      appConfig.Description,
-			"proxy_support":    appConfig.ProxySupport,
-			"environment_vars": appConfig.EnvironmentVars,
+			"app_id":           manifest.ID,
+			"name":             manifest.Name,
+			"docker_image":     manifest.Image,
+			"description":      manifest.Description,
+			"dashboard":        manifest.Dashboard,
+			"link":             manifest.Link,
+			"environment_vars": envVars,
+			"fields":           fields,
 		}
 	}
 
 	return result, nil
 }
 
+// GetAppSchema returns the form fields of an app in display order: device
+// name first, then required fields, then optional ones
+func (a *AppsAPI) GetAppSchema(appID string) ([]map[string]interface{}, error) {
+	manifest := apps.GetAppManifest(appID)
+	if manifest == nil {
+		return nil, fmt.Errorf("app not found: %s", appID)
+	}
+	return appFields(manifest), nil
+}
+
+func appFields(manifest *apps.AppManifest) []map[string]interface{} {
+	specs := manifest.FieldSpecs()
+	names := slices.Sorted(maps.Keys(specs))
+	rank := func(spec *apps.FieldSpec) int {
+		switch {
+		case spec.Type == apps.FieldDeviceName:
+			return 0
+		case spec.Required:
+			return 1
+		default:
+			return 2
+		}
+	}
+	slices.SortStableFunc(names, func(x, y string) int { return rank(specs[x]) - rank(specs[y]) })
+
+	fields := make([]map[string]interface{}, 0, len(names))
+	for _, name := range names {
+		spec := specs[name]
+		fields = append(fields, map[string]interface{}{
+			"name":     name,
+			"type":     spec.Type,
+			"label":    spec.Label,
+			"required": spec.Required,
+			"pattern":  spec.Pattern,
+			"default":  spec.Default,
+			"secret":   spec.Secret,
+			"help":     spec.Help,
+		})
+	}
+	return fields
+}
+
+// GetConfigErrors returns the app config files rejected by the last load,
+// keyed by file path. Their apps keep the last good config.
+func (a *AppsAPI) GetConfigErrors() (map[string]string, error) {
+	return a.config.FileErrors(), nil
+}
+
 // GetRunningApps returns all running apps
 func (a *AppsAPI) GetRunningApps() ([]map[string]interface{}, error) {
 	containers, err := a.docker.ListContainers()
@@ -133,28 +285,32 @@ func (a *AppsAPI) GetRunningApps() ([]map[string]interface{}, error) {
 	}
 
 	// Get SDK node IDs from instances
-	instanceMap := make(map[string]string) // containerID -> sdkNodeID
+	instanceMap := make(map[string]string) // instanceID or containerID -> sdkNodeID
 	allInstances := a.instanceManager.GetAllInstances()
 	for _, inst := range allInstances {
 		if inst.SDKNodeID != "" {
+			instanceMap[inst.InstanceID] = inst.SDKNodeID
 			instanceMap[inst.ContainerID] = inst.SDKNodeID
 		}
 	}
 
 	result := make([]map[string]interface{}, 0)
-	for _, container := range containers {
-		// Filter only our app containers
-		// TODO: Add filtering logic based on container name patterns
+	for _, container := range a.appContainers(containers) {
 		containerData := map[string]interface{}{
-			"id":     container.ID,
-			"name":   container.Name,
-			"image":  container.Image,
-			"status": container.Status,
-			"state":  container.State,
+			"id":          container.ID,
+			"name":        container.Name,
+			"image":       container.Image,
+			"status":      container.Status,
+			"state":       container.State,
+			"app_id":      container.Labels[apps.LabelAppID],
+			"instance_id": container.Labels[apps.LabelInstanceID],
+			"proxy_id":    container.Labels[apps.LabelProxyID],
 		}
 
 		// Get SDK node ID from instance manager or from container environment
-		if sdkNodeID, exists := instanceMap[container.ID]; exists {
+		if sdkNodeID, exists := instanceMap[container.Labels[apps.LabelInstanceID]]; exists {
+			containerData["sdkNodeID"] = sdkNodeID
+		} else if sdkNodeID, exists := instanceMap[container.ID]; exists {
 			containerData["sdkNodeID"] = sdkNodeID
 		} else {
 			// Try to get from container environment variables
@@ -250,6 +406,25 @@ func (a *AppsAPI) DeployApp(appID string, formData map[string]string) error {
 
 // DeployAppWithProxyId deploys an app with a specific proxy (or without if proxyID is empty)
 func (a *AppsAPI) DeployAppWithProxyId(appID string, formData map[string]string, proxyID string) error {
+	return a.DeployAppWithProfile(appID, "", formData, proxyID)
+}
+
+// DeployAppWithProfile deploys an app with a specific proxy (or without if
+// proxyID is empty) and saves the form data as the named credential
+// profile, the default profile if profile is empty
+func (a *AppsAPI) DeployAppWithProfile(appID, profile string, formData map[string]string, proxyID string) error {
+	if profile == "" {
+		profile = config.DefaultProfile
+	}
+	if err := config.ValidateProfileName(profile); err != nil {
+		return err
+	}
+
+	// Credentials are saved with every deployment
+	if a.credentialStore.Locked() {
+		return config.ErrLocked
+	}
+
 	// Check if deploying local instance (proxyID empty) and if one already exists
 	if proxyID == "" {
 		instances := a.instanceManager.GetAppInstances(appID)
@@ -267,6 +442,17 @@ func (a *AppsAPI) DeployAppWithProxyId(appID string, formData map[string]string,
 		return fmt.Errorf("app not found: %s", appID)
 	}
 
+	// Secrets the form got redacted keep their saved values
+	if saved, err := a.credentialStore.LoadProfile(appID, profile); err == nil {
+		formData = manifest.Unredact(formData, saved.Credentials)
+	}
+
+	// Check the form against the app's field schema before touching Docker
+	formData, err := manifest.ValidateFormData(formData)
+	if err != nil {
+		return err
+	}
+
 	// Get device name from form data
 	deviceName, ok := formData["DEVICE_NAME"]
 	if !ok || deviceName == "" {
@@ -283,50 +469,21 @@ func (a *AppsAPI) DeployAppWithProxyId(appID string, formData map[string]string,
 		proxyURL = prox.FormatProxy()
 	}
 
-	// Build environment variables
-	env := []string{}
-
-	// Add environment variables from manifest
-	for key, value := range manifest.Environment {
-		// Handle placeholders ($VARIABLE_NAME) that will be filled by auto-generation
-		if strings.HasPrefix(value, "$") {
-			// Skip placeholders, they'll be handled by auto-generation below
-			continue
-		}
-		// Add static environment variables from manifest
-		if value != "" {
-			env = append(env, fmt.Sprintf("%s=%s", key, value))
-		}
+	// Build environment variables and command from the manifest templates
+	vars, generated, err := a.templateVars(manifest, formData, deviceName, proxyID)
+	if err != nil {
+		return err
 	}
-
-	// Handle auto-generated fields (like EARNAPP_UUID)
-	if manifest.AutoGenerateFields != nil {
-		for fieldName, genConfig := range manifest.AutoGenerateFields {
-			// Generate UUID
-			uuid := generateUUID(genConfig.Length, genConfig.Charset)
-			// Combine prefix with UUID
-			fullValue := genConfig.Prefix + uuid
-			env = append(env, fmt.Sprintf("%s=%s", fieldName, fullValue))
-
-			// Store for later claim URL generation
-			if fieldName == "EARNAPP_UUID" {
-				formData["claimURL"] = fullValue
-			}
-		}
+	env, command, err := renderManifest(manifest, vars)
+	if err != nil {
+		return err
 	}
 
-	// Add regular environment variables from form data
-	for key, value := range formData {
-		// Skip fields that are handled elsewhere or shouldn't be added
-		if key == "DEVICE_NAME" || key == "claimURL" || key == "EARNAPP_UUID" {
-			continue
-		}
-		env = append(env, fmt.Sprintf("%s=%s", key, value))
+	// Store for later claim URL generation
+	if uuid := vars["EARNAPP_UUID"]; uuid != "" {
+		formData["claimURL"] = uuid
 	}
 
-	// Also add device name to environment
-	env = append(env, fmt.Sprintf("DEVICE_NAME=%s", deviceName))
-
 	// Resolve port mappings: replace ${VAR} with user-specified or default to container port
 	ports := []string{}
 	if len(manifest.Ports) > 0 {
@@ -351,18 +508,33 @@ func (a *AppsAPI) DeployAppWithProxyId(appID string, formData map[string]string,
 		}
 	}
 
+	// Resolve resource limits before touching Docker
+	limits := manifest.ResourceLimits
+	if _, err := limits.Resources(); err != nil {
+		return fmt.Errorf("%w for %s: %w", apps.ErrInvalidLimits, appID, err)
+	}
+
+	// Generate instance ID
+	instanceID := apps.NewInstanceID(appID, deviceName, time.Now())
+
 	// Create deployment config
 	deployment := &apps.AppDeployment{
-		AppID:         appID,
-		ProxyID:       proxyID,
-		ProxyURL:      proxyURL,
-		DeviceName:    deviceName,
-		Image:         manifest.Image,
-		Environment:   env,
-		Volumes:       manifest.Volumes,
-		Ports:         ports,
-		Command:       manifest.Command,
-		RestartPolicy: "always",
+		AppID:          appID,
+		InstanceID:     instanceID,
+		ProxyID:        proxyID,
+		ProxyURL:       proxyURL,
+		DeviceName:     deviceName,
+		Profile:        profile,
+		Image:          manifest.Image,
+		Environment:    env,
+		SecretEnv:      manifest.SecretEnvKeys(env),
+		Volumes:        manifest.Volumes,
+		SharedVolumes:  manifest.SharedVolumes,
+		Ports:          ports,
+		Command:        command,
+		SecretCommand:  manifest.SecretCommand(),
+		RestartPolicy:  apps.DefaultRestartPolicy,
+		ResourceLimits: limits,
 	}
 
 	// Deploy the app
@@ -370,27 +542,11 @@ func (a *AppsAPI) DeployAppWithProxyId(appID string, formData map[string]string,
 	if proxyID != "" && proxyURL != "" {
 		// Deploy using TUN proxy approach
 		// Note: One tun2socks container per proxy, shared by all apps
-		proxyContainerName, err := apps.DeployProxyTun(proxyID, proxyURL)
+		proxyContainerName, err := apps.DeployProxyTun(a.docker, proxyID, proxyURL)
 		if err != nil {
 			return fmt.Errorf("failed to deploy proxy tun: %w", err)
 		}
 
-		// Ensure per-instance data volume directory for EarnApp (proxy instance)
-		if appID == "earnapp" {
-			// Set deterministic container name matching deploy helper
-			deployment.ContainerName = fmt.Sprintf("%s_%s_proxy%s", deviceName, appID, apps.GetProxyHash(proxyID))
-			// Rewrite volumes to use .data/<container>_earnapp
-			newVolumes := make([]string, 0, len(deployment.Volumes))
-			for _, v := range deployment.Volumes {
-				if strings.Contains(v, ".data/.earnapp:") {
-					newVolumes = append(newVolumes, strings.Replace(v, ".data/.earnapp:", ".data/"+deployment.ContainerName+"_earnapp:", 1))
-				} else {
-					newVolumes = append(newVolumes, v)
-				}
-			}
-			deployment.Volumes = newVolumes
-		}
-
 		// Offset ports for proxy instances to avoid conflicts
 		if len(deployment.Ports) > 0 {
 			proxyOffset := int(apps.GetProxyHash(proxyID)[0]) % 50 // simple small offset
@@ -413,40 +569,19 @@ func (a *AppsAPI) DeployAppWithProxyId(appID string, formData map[string]string,
 		}
 
 		// Deploy app with network_mode: service:proxy
-		containerID, err = apps.DeployAppWithProxyTun(deployment, proxyContainerName)
+		containerID, err = apps.DeployAppWithProxyTun(a.docker, deployment, proxyContainerName)
 		if err != nil {
 			return fmt.Errorf("failed to deploy app with proxy: %w", err)
 		}
 	} else {
 		// Deploy without proxy
 		var err error
-		// Ensure per-instance data volume directory for EarnApp
-		if appID == "earnapp" {
-			// Generate container name to derive unique volume path (local instance)
-			containerName := deployment.ContainerName
-			if containerName == "" {
-				containerName = fmt.Sprintf("%s_%s_local", deviceName, appID)
-			}
-			// Rewrite volumes to use .data/<container>_earnapp
-			newVolumes := make([]string, 0, len(deployment.Volumes))
-			for _, v := range deployment.Volumes {
-				if strings.Contains(v, ".data/.earnapp:") {
-					newVolumes = append(newVolumes, strings.Replace(v, ".data/.earnapp:", ".data/"+containerName+"_earnapp:", 1))
-				} else {
-					newVolumes = append(newVolumes, v)
-				}
-			}
-			deployment.Volumes = newVolumes
-		}
-		containerID, err = apps.DeployApp(deployment)
+		containerID, err = apps.DeployApp(a.docker, deployment)
 		if err != nil {
 			return err
 		}
 	}
 
-	// Generate instance ID
-	instanceID := fmt.Sprintf("%s_%s_%d", appID, deviceName, time.Now().Unix())
-
 	// Extract SDK node ID for EarnApp (if present)
 	sdkNodeID := ""
 	if appID == "earnapp" && formData["claimURL"] != "" {
@@ -465,12 +600,18 @@ func (a *AppsAPI) DeployAppWithProxyId(appID string, formData map[string]string,
 		ProxyID:     proxyID,
 		ContainerID: containerID,
 		DeviceName:  deviceName,
+		Profile:     profile,
 		Credentials: formData,
-		Status:      "running",
+		Status:      apps.StatusRunning,
 		ProxyURL:    proxyURL,
 		SDKNodeID:   sdkNodeID,
+		Generated:   generated,
+
+		ResourceLimits: limits,
 	}
 
+	a.saveGenerated(apps.IdentityKey(appID, deviceName, proxyID), generated)
+
 	// Add instance to manager
 	if err := a.instanceManager.AddInstance(instance); err != nil {
 		return fmt.Errorf("failed to add instance: %w", err)
@@ -479,6 +620,7 @@ func (a *AppsAPI) DeployAppWithProxyId(appID string, formData map[string]string,
 	// Save credentials
 	creds := &config.AppCredentials{
 		AppID:       appID,
+		Profile:     profile,
 		DeviceName:  deviceName,
 		Credentials: formData,
 	}
@@ -497,6 +639,15 @@ func (a *AppsAPI) DeployAppWithProxy(deploymentData map[string]interface{}) (map
 	proxyID, _ := deploymentData["proxy_id"].(string)
 	proxyURL, _ := deploymentData["proxy_url"].(string)
 	credentials, _ := deploymentData["credentials"].(map[string]string)
+	profile, _ := deploymentData["profile"].(string)
+	if profile == "" {
+		profile = config.DefaultProfile
+	}
+
+	// The instance's credentials are kept in its profile
+	if a.credentialStore.Locked() {
+		return nil, config.ErrLocked
+	}
 
 	// Get manifest
 	manifest := apps.GetAppManifest(appID)
@@ -505,36 +656,56 @@ func (a *AppsAPI) DeployAppWithProxy(deploymentData map[string]interface{}) (map
 	}
 
 	// Build environment
-	env := []string{}
-	for key, value := range credentials {
-		if key != "DEVICE_NAME" && key != "claimURL" {
-			env = append(env, fmt.Sprintf("%s=%s", key, value))
-		}
+	credentials = maps.Clone(credentials)
+	if credentials == nil {
+		credentials = make(map[string]string)
+	}
+	credentials["DEVICE_NAME"] = deviceName
+	if saved, err := a.credentialStore.LoadProfile(appID, profile); err == nil {
+		credentials = manifest.Unredact(credentials, saved.Credentials)
+	}
+	credentials, err := manifest.ValidateFormData(credentials)
+	if err != nil {
+		return nil, err
+	}
+	vars, generated, err := a.templateVars(manifest, credentials, deviceName, proxyID)
+	if err != nil {
+		return nil, err
+	}
+	env, command, err := renderManifest(manifest, vars)
+	if err != nil {
+		return nil, err
 	}
-	env = append(env, fmt.Sprintf("DEVICE_NAME=%s", deviceName))
+
+	// Generate instance ID
+	instanceID := apps.NewInstanceID(appID, deviceName, time.Now())
 
 	// Create deployment
+	limits := manifest.ResourceLimits
 	deployment := &apps.AppDeployment{
-		AppID:         appID,
-		ProxyID:       proxyID,
-		ProxyURL:      proxyURL,
-		DeviceName:    deviceName,
-		Image:         manifest.Image,
-		Environment:   env,
-		Volumes:       manifest.Volumes,
-		Command:       manifest.Command,
-		RestartPolicy: "always",
+		AppID:          appID,
+		InstanceID:     instanceID,
+		ProxyID:        proxyID,
+		ProxyURL:       proxyURL,
+		DeviceName:     deviceName,
+		Profile:        profile,
+		Image:          manifest.Image,
+		Environment:    env,
+		SecretEnv:      manifest.SecretEnvKeys(env),
+		Volumes:        manifest.Volumes,
+		SharedVolumes:  manifest.SharedVolumes,
+		Command:        command,
+		SecretCommand:  manifest.SecretCommand(),
+		RestartPolicy:  apps.DefaultRestartPolicy,
+		ResourceLimits: limits,
 	}
 
 	// Deploy
-	containerID, err := apps.DeployApp(deployment)
+	containerID, err := apps.DeployApp(a.docker, deployment)
 	if err != nil {
 		return nil, err
 	}
 
-	// Generate instance ID
-	instanceID := fmt.Sprintf("%s_%s_%d", appID, deviceName, time.Now().Unix())
-
 	// Add instance
 	instance := &apps.AppInstance{
 		InstanceID:  instanceID,
@@ -542,14 +713,30 @@ func (a *AppsAPI) DeployAppWithProxy(deploymentData map[string]interface{}) (map
 		ProxyID:     proxyID,
 		ContainerID: containerID,
 		DeviceName:  deviceName,
+		Profile:     profile,
 		Credentials: credentials,
-		Status:      "running",
+		Status:      apps.StatusRunning,
 		ProxyURL:    proxyURL,
+		Generated:   generated,
+
+		ResourceLimits: limits,
 	}
 
+	a.saveGenerated(apps.IdentityKey(appID, deviceName, proxyID), generated)
 	if err := a.instanceManager.AddInstance(instance); err != nil {
 		return nil, fmt.Errorf("failed to add instance: %w", err)
 	}
+	if _, err := a.credentialStore.LoadProfile(appID, profile); err != nil {
+		creds := &config.AppCredentials{
+			AppID:       appID,
+			Profile:     profile,
+			DeviceName:  deviceName,
+			Credentials: credentials,
+		}
+		if err := a.credentialStore.SaveCredentials(creds); err != nil {
+			return nil, fmt.Errorf("failed to save credentials: %w", err)
+		}
+	}
 
 	return map[string]interface{}{
 		"instance_id":  instanceID,
@@ -681,12 +868,177 @@ func (a *AppsAPI) GetAppInstances(appID string) ([]map[string]interface{}, error
 			"device_name":  instance.DeviceName,
 			"status":       instance.Status,
 			"proxy_url":    instance.ProxyURL,
+
+			"resource_limits":  resourceLimitsMap(instance.ResourceLimits),
+			"update_available": instance.UpdateAvailable,
 		})
 	}
 
 	return result, nil
 }
 
+// UpdateApp pulls the latest image of an app and recreates its instances one
+// by one, rolling an instance back to its previous container if the new one
+// does not come up healthy. Progress is reported as "app:update" events.
+func (a *AppsAPI) UpdateApp(appID string) (map[string]interface{}, error) {
+	manifest := apps.GetAppManifest(appID)
+	if manifest == nil {
+		return nil, fmt.Errorf("unknown app: %s", appID)
+	}
+
+	done, err := a.beginUpdate(appID)
+	if err != nil {
+		return nil, err
+	}
+	defer done()
+
+	a.addActivity("Updating " + appID + " to " + manifest.Image)
+	result, err := apps.UpdateApp(a.docker, a.instanceManager, appID, manifest.Image, apps.UpdateOptions{
+		OnProgress: func(instanceID, message string) {
+			a.emitEvent("app:update", map[string]string{
+				"app_id":      appID,
+				"instance_id": instanceID,
+				"message":     message,
+			})
+		},
+	})
+
+	response := map[string]interface{}{
+		"app_id":      result.AppID,
+		"image":       result.Image,
+		"updated":     result.Updated,
+		"skipped":     result.Skipped,
+		"rolled_back": result.RolledBack,
+	}
+	if err != nil {
+		if result.RolledBack != "" {
+			a.addActivity("Update of " + appID + " rolled back on instance " + result.RolledBack)
+		}
+		return response, err
+	}
+	a.addActivity(fmt.Sprintf("Updated %d instance(s) of %s", len(result.Updated), appID))
+	return response, nil
+}
+
+// beginUpdate marks an app as having its containers recreated, so image
+// updates and credential rotations of the same app do not overlap. Call
+// the returned function when done.
+func (a *AppsAPI) beginUpdate(appID string) (func(), error) {
+	a.updatingMu.Lock()
+	defer a.updatingMu.Unlock()
+	if a.updating[appID] {
+		return nil, fmt.Errorf("an update of %s is already in progress", appID)
+	}
+	a.updating[appID] = true
+	return func() {
+		a.updatingMu.Lock()
+		delete(a.updating, appID)
+		a.updatingMu.Unlock()
+	}, nil
+}
+
+// AdoptContainers scans Docker for app containers that no instance tracks,
+// e.g. after a restart, and adds instances for them. Containers that look
+// like ours but cannot be attributed are returned with the reason.
+func (a *AppsAPI) AdoptContainers() (map[string]interface{}, error) {
+	proxies := a.proxyManager.ListProxies()
+	known := make([]apps.KnownProxy, 0, len(proxies))
+	for _, p := range proxies {
+		known = append(known, apps.KnownProxy{ID: p.ID, URL: p.FormatProxy()})
+	}
+
+	report, err := apps.AdoptContainers(a.docker, a.instanceManager, known)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(report.Adopted) > 0 {
+		a.addActivity(fmt.Sprintf("Adopted %d existing container(s)", len(report.Adopted)))
+	}
+	for name, reason := range report.Unattributed {
+		fmt.Printf("could not adopt container %s: %s\n", name, reason)
+	}
+
+	return map[string]interface{}{
+		"adopted":      report.Adopted,
+		"unattributed": report.Unattributed,
+	}, nil
+}
+
+// GetInstanceResourceLimits returns the CPU and memory limits of an instance
+func (a *AppsAPI) GetInstanceResourceLimits(instanceID string) (map[string]string, error) {
+	instance, err := a.instanceManager.GetInstance(instanceID)
+	if err != nil {
+		return nil, err
+	}
+	return resourceLimitsMap(instance.ResourceLimits), nil
+}
+
+// UpdateInstanceResourceLimits changes the limits of an instance and applies
+// them to its container. Keys are cpus, memory_reservation and
+// memory_limit; empty values keep the current limit and "none" removes it.
+// New limits are applied in place; removing one recreates the container,
+// as Docker cannot lift a limit of a running container.
+func (a *AppsAPI) UpdateInstanceResourceLimits(instanceID string, limits map[string]string) error {
+	instance, err := a.instanceManager.GetInstance(instanceID)
+	if err != nil {
+		return err
+	}
+	// The stored limits were validated when they were applied
+	current, _ := instance.ResourceLimits.Resources()
+
+	updated := instance.ResourceLimits.Merge(&apps.ResourceLimits{
+		CPUs:              limits["cpus"],
+		MemoryReservation: limits["memory_reservation"],
+		MemoryLimit:       limits["memory_limit"],
+	})
+	resources, err := updated.Resources()
+	if err != nil {
+		return fmt.Errorf("%w: %w", apps.ErrInvalidLimits, err)
+	}
+
+	cleared := (current.NanoCPUs > 0 && resources.NanoCPUs == 0) ||
+		(current.Memory > 0 && resources.Memory == 0) ||
+		(current.MemoryReservation > 0 && resources.MemoryReservation == 0)
+	switch {
+	case instance.ContainerID == "":
+	case cleared:
+		err := apps.RecreateWithResources(a.docker, a.instanceManager, instance, resources, apps.UpdateOptions{
+			OnProgress: func(instanceID, message string) {
+				a.emitEvent("instance:resources", map[string]string{
+					"instance_id": instanceID,
+					"message":     message,
+				})
+			},
+		})
+		if err != nil {
+			return err
+		}
+	default:
+		// Live update of the running container, no recreate needed
+		if err := a.docker.UpdateContainerResources(instance.ContainerID, resources); err != nil {
+			return err
+		}
+	}
+
+	if err := a.instanceManager.UpdateInstanceResourceLimits(instanceID, updated); err != nil {
+		return err
+	}
+	a.addActivity("Updated resource limits of instance " + instanceID)
+	return nil
+}
+
+func resourceLimitsMap(limits *apps.ResourceLimits) map[string]string {
+	if limits == nil {
+		return map[string]string{}
+	}
+	return map[string]string{
+		"cpus":               limits.CPUs,
+		"memory_reservation": limits.MemoryReservation,
+		"memory_limit":       limits.MemoryLimit,
+	}
+}
+
 // RemoveAppInstance removes a specific app instance
 func (a *AppsAPI) RemoveAppInstance(instanceID string) error {
 	instance, err := a.instanceManager.GetInstance(instanceID)
@@ -694,12 +1046,17 @@ func (a *AppsAPI) RemoveAppInstance(instanceID string) error {
 		return err
 	}
 
-	// Stop and remove container
+	// Stop and remove container, keeping a snapshot of its data
 	if instance.ContainerID != "" {
 		if err := a.docker.StopContainer(instance.ContainerID); err != nil {
 			fmt.Printf("failed to stop container: %v\n", err)
 		}
-		// TODO: Remove container
+		archived := a.archiveContainer(instance.ContainerID)
+		if err := a.docker.RemoveContainer(instance.ContainerID); err != nil {
+			fmt.Printf("failed to remove container: %v\n", err)
+		} else {
+			backup.RemoveData(archived)
+		}
 	}
 
 	// Remove from instance manager
@@ -712,26 +1069,12 @@ func (a *AppsAPI) RemoveApp(containerID string) error {
 	if err := a.docker.StopContainer(containerID); err != nil {
 		fmt.Printf("failed to stop container: %v\n", err)
 	}
-	// Attempt to backup EarnApp data directory if present
-	// Determine container info to derive name
-	cont, contErr := a.docker.GetContainer(containerID)
-	if contErr == nil {
-		// Expected volume path: .data/<container>_earnapp
-		dataDir := filepath.Join(".data", cont.Name+"_earnapp")
-		if _, statErr := os.Stat(dataDir); statErr == nil {
-			// Create backup dir
-			backupRoot := filepath.Join(".data", "backup")
-			_ = os.MkdirAll(backupRoot, 0755)
-			backupDir := filepath.Join(backupRoot, cont.Name+"_earnapp_"+time.Now().Format("20060102_150405"))
-			// Rename (move) directory to backup location
-			if err := os.Rename(dataDir, backupDir); err != nil {
-				fmt.Printf("failed to backup data dir %s: %v\n", dataDir, err)
-			}
-		}
-	}
-	// Remove container
+	// Keep a snapshot of the instance's data
+	archived := a.archiveContainer(containerID)
+	// Remove container, then the data it no longer uses
 	err := a.docker.RemoveContainer(containerID)
 	if err == nil {
+		backup.RemoveData(archived)
 		a.addActivity("Removed container " + containerID)
 	}
 	return err
@@ -746,10 +1089,18 @@ func (a *AppsAPI) GetConfiguredApps() ([]map[string]interface{}, error) {
 
 	result := make([]map[string]interface{}, 0, len(appIDs))
 	for _, appID := range appIDs {
+		profiles, err := a.credentialStore.ListProfiles(appID)
+		if err != nil || len(profiles) == 0 {
+			continue
+		}
 		creds, err := a.credentialStore.LoadCredentials(appID)
 		if err != nil {
 			continue
 		}
+		profileNames := make([]string, 0, len(profiles))
+		for _, p := range profiles {
+			profileNames = append(profileNames, p.Profile)
+		}
 
 		// Get app config for display name
 		config, err := a.config.GetApp(appID)
@@ -762,20 +1113,43 @@ func (a *AppsAPI) GetConfiguredApps() ([]map[string]interface{}, error) {
 			"app_id":      appID,
 			"app_name":    appName,
 			"device_name": creds.DeviceName,
+			"profiles":    profileNames,
 		})
 	}
 
 	return result, nil
 }
 
-// GetAppCredentials returns saved credentials for an app (for editing)
+// GetAppCredentials returns saved credentials for an app (for editing) with
+// secret fields redacted. Deploying with a redacted value keeps the saved
+// one.
 func (a *AppsAPI) GetAppCredentials(appID string) (map[string]string, error) {
+	return a.appCredentials(appID, false)
+}
+
+// GetAppCredentialsUnmasked returns saved credentials for an app including
+// secret fields
+func (a *AppsAPI) GetAppCredentialsUnmasked(appID string) (map[string]string, error) {
+	return a.appCredentials(appID, true)
+}
+
+func (a *AppsAPI) appCredentials(appID string, unmask bool) (map[string]string, error) {
 	creds, err := a.credentialStore.LoadCredentials(appID)
 	if err != nil {
 		return nil, fmt.Errorf("failed to load credentials: %w", err)
 	}
 	// Return only the credentials map; DEVICE_NAME is part of credentials as well
-	return creds.Credentials, nil
+	return redactCredentials(appID, creds.Credentials, unmask), nil
+}
+
+// redactCredentials hides the secret fields of saved credentials unless
+// unmask is set
+func redactCredentials(appID string, credentials map[string]string, unmask bool) map[string]string {
+	manifest := apps.GetAppManifest(appID)
+	if unmask || manifest == nil {
+		return credentials
+	}
+	return manifest.Redact(credentials)
 }
 
 // DeployAppWithProxiesSelective deploys app with selected proxies
@@ -783,23 +1157,25 @@ func (a *AppsAPI) DeployAppWithProxiesSelective(proxyID string, proxyURL string,
 	results := make([]map[string]interface{}, 0)
 
 	for _, appID := range appIDs {
-		// Load credentials for this app
-		creds, err := a.credentialStore.LoadCredentials(appID)
+		// Use the profile with the fewest instances, so accounts spread
+		// evenly over proxies
+		creds, err := a.leastUsedProfile(appID)
 		if err != nil {
 			fmt.Printf("failed to load credentials for app %s: %v\n", appID, err)
 			continue
 		}
 
 		// Deploy app with this proxy
-		err = a.DeployAppWithProxyId(appID, creds.Credentials, proxyID)
+		err = a.DeployAppWithProfile(appID, creds.Profile, creds.Credentials, proxyID)
 		if err != nil {
 			fmt.Printf("failed to deploy app %s with proxy: %v\n", appID, err)
 			continue
 		}
 
 		results = append(results, map[string]interface{}{
-			"app_id": appID,
-			"status": "deployed",
+			"app_id":  appID,
+			"profile": creds.Profile,
+			"status":  "deployed",
 		})
 	}
 
@@ -807,29 +1183,41 @@ func (a *AppsAPI) DeployAppWithProxiesSelective(proxyID string, proxyURL string,
 }
 
 // GetContainerEnvironmentVars gets environment variables from a container
+// with secrets redacted
 func (a *AppsAPI) GetContainerEnvironmentVars(containerID string) (map[string]string, error) {
-	cmd := exec.Command("docker", "inspect", "--format", "{{json .Config.Env}}", containerID)
-	// Hide flashing console on Windows
-	hideConsoleWindow(cmd)
-	output, err := cmd.Output()
+	return a.containerEnvironment(containerID, false)
+}
+
+// GetContainerEnvironmentVarsUnmasked gets environment variables from a
+// container including secrets
+func (a *AppsAPI) GetContainerEnvironmentVarsUnmasked(containerID string) (map[string]string, error) {
+	return a.containerEnvironment(containerID, true)
+}
+
+func (a *AppsAPI) containerEnvironment(containerID string, unmask bool) (map[string]string, error) {
+	details, err := a.docker.InspectContainer(containerID)
 	if err != nil {
 		return nil, fmt.Errorf("failed to inspect container: %w", err)
 	}
 
-	var envArray []string
-	if err := json.Unmarshal(output, &envArray); err != nil {
-		return nil, fmt.Errorf("failed to parse env: %w", err)
-	}
-
 	env := make(map[string]string)
-	for _, envVar := range envArray {
+	for _, envVar := range details.Config.Env {
 		parts := strings.SplitN(envVar, "=", 2)
 		if len(parts) == 2 {
 			env[parts[0]] = parts[1]
 		}
 	}
+	if unmask {
+		return env, nil
+	}
 
-	return env, nil
+	// Containers from before secrets were labelled fall back to the
+	// manifest's secret fields
+	secret := apps.SecretEnv(details.Config.Labels)
+	if manifest := apps.GetAppManifest(details.Config.Labels[apps.LabelAppID]); manifest != nil {
+		secret = append(secret, manifest.SecretEnvKeys(details.Config.Env)...)
+	}
+	return apps.RedactEnv(env, secret), nil
 }
 
 // OnStartup is called when the Wails app starts
@@ -847,13 +1235,74 @@ func (a *AppsAPI) OnStartupContext(ctx context.Context) {
 	a.ctx = ctx
 }
 
-// generateUUID generates a random UUID with specified length and charset
-func generateUUID(length int, charset string) string {
-	rand.Seed(time.Now().UnixNano())
-	chars := []rune(charset)
-	result := make([]rune, length)
-	for i := range result {
-		result[i] = chars[rand.Intn(len(chars))]
+// templateVars returns the values manifest templates are resolved against:
+// the form data plus the app's auto-generated fields. Values generated for
+// an earlier deployment of the same app, device and proxy are reused, so a
+// redeploy keeps its node identity; a value in the form data wins over both.
+func (a *AppsAPI) templateVars(manifest *apps.AppManifest, formData map[string]string, deviceName, proxyID string) (vars, generated map[string]string, err error) {
+	existing := a.savedGenerated(apps.IdentityKey(manifest.ID, deviceName, proxyID))
+	for field := range manifest.AutoGenerateFields {
+		if value := formData[field]; value != "" {
+			existing[field] = value
+		}
+	}
+	generated, err = manifest.GenerateFields(deviceName, proxyID, existing)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	vars = make(map[string]string, len(formData)+len(generated))
+	for key, value := range formData {
+		if key != "claimURL" {
+			vars[key] = value
+		}
+	}
+	maps.Copy(vars, generated)
+	return vars, generated, nil
+}
+
+// savedGenerated returns the generated values saved for an app identity
+func (a *AppsAPI) savedGenerated(key string) map[string]string {
+	values := make(map[string]string)
+	if a.store != nil {
+		maps.Copy(values, a.store.State().Generated[key])
+	}
+	return values
+}
+
+// saveGenerated remembers the generated values of an app identity
+func (a *AppsAPI) saveGenerated(key string, values map[string]string) {
+	if a.store == nil || len(values) == 0 {
+		return
+	}
+	err := a.store.Update(func(s *state.State) {
+		if s.Generated == nil {
+			s.Generated = make(map[string]map[string]string)
+		}
+		s.Generated[key] = maps.Clone(values)
+	})
+	if err != nil {
+		fmt.Printf("failed to save generated values: %v\n", err)
+	}
+}
+
+// renderManifest resolves the manifest environment and command against
+// vars. Vars the manifest does not map to a variable of its own are passed
+// to the container unchanged.
+func renderManifest(manifest *apps.AppManifest, vars map[string]string) ([]string, []string, error) {
+	env, command, err := manifest.Render(vars)
+	if err != nil {
+		return nil, nil, fmt.Errorf("cannot deploy %s: %w", manifest.Name, err)
+	}
+	rendered := make(map[string]bool, len(env))
+	for _, e := range env {
+		key, _, _ := strings.Cut(e, "=")
+		rendered[key] = true
+	}
+	for _, key := range slices.Sorted(maps.Keys(vars)) {
+		if _, mapped := manifest.Environment[key]; !mapped && !rendered[key] {
+			env = append(env, key+"="+vars[key])
+		}
 	}
-	return string(result)
+	return env, command, nil
 }
@@ -0,0 +1,196 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"time"
+
+	"bandwidth-income-manager/backend/apps"
+	"bandwidth-income-manager/backend/backup"
+)
+
+func backupMap(meta *backup.Metadata) map[string]interface{} {
+	return map[string]interface{}{
+		"id":             meta.ID,
+		"app_id":         meta.AppID,
+		"instance_id":    meta.InstanceID,
+		"container_name": meta.ContainerName,
+		"device_name":    meta.DeviceName,
+		"proxy_id":       meta.ProxyID,
+		"image":          meta.Image,
+		"image_digest":   meta.ImageDigest,
+		"reason":         meta.Reason,
+		"created_at":     meta.CreatedAt.Format(time.RFC3339),
+		"volumes":        meta.Volumes,
+		"size":           meta.Size,
+	}
+}
+
+func (a *AppsAPI) backupManager() (*backup.Manager, error) {
+	if a.backups == nil {
+		return nil, fmt.Errorf("backups are not available")
+	}
+	return a.backups, nil
+}
+
+// CreateBackup takes a snapshot of an instance's data volumes now
+func (a *AppsAPI) CreateBackup(instanceID string) (map[string]interface{}, error) {
+	backups, err := a.backupManager()
+	if err != nil {
+		return nil, err
+	}
+	meta, err := backups.BackupInstance(instanceID, backup.ReasonManual)
+	if err != nil {
+		return nil, err
+	}
+	a.addActivity("Backed up " + meta.ContainerName)
+	return backupMap(meta), nil
+}
+
+// ListBackups returns the snapshots of an app, or of every app if appID is
+// empty, newest first
+func (a *AppsAPI) ListBackups(appID string) ([]map[string]interface{}, error) {
+	backups, err := a.backupManager()
+	if err != nil {
+		return nil, err
+	}
+	list, err := backups.List(appID)
+	if err != nil {
+		return nil, err
+	}
+	result := make([]map[string]interface{}, 0, len(list))
+	for i := range list {
+		result = append(result, backupMap(&list[i]))
+	}
+	return result, nil
+}
+
+// DeleteBackup deletes a snapshot
+func (a *AppsAPI) DeleteBackup(backupID string) error {
+	backups, err := a.backupManager()
+	if err != nil {
+		return err
+	}
+	if err := backups.Delete(backupID); err != nil {
+		return err
+	}
+	a.addActivity("Deleted backup " + backupID)
+	return nil
+}
+
+// RestoreBackup replaces the data of an existing instance with a snapshot
+// of the same app. The instance is stopped while its data is swapped.
+func (a *AppsAPI) RestoreBackup(backupID, instanceID string) error {
+	backups, err := a.backupManager()
+	if err != nil {
+		return err
+	}
+	instance, err := a.instanceManager.GetInstance(instanceID)
+	if err != nil {
+		return err
+	}
+	if instance.ContainerID == "" {
+		return fmt.Errorf("instance %s has no container", instanceID)
+	}
+	if err := backups.Restore(backupID, instance.ContainerID); err != nil {
+		return err
+	}
+	a.addActivity("Restored backup " + backupID + " into " + instanceID)
+	return nil
+}
+
+// RestoreBackupAsNewInstance deploys a new instance of the snapshot's app
+// that starts from the snapshot's data. The device name defaults to the
+// one the snapshot was taken of; the app's saved credentials are used.
+func (a *AppsAPI) RestoreBackupAsNewInstance(backupID, deviceName, proxyID string) error {
+	backups, err := a.backupManager()
+	if err != nil {
+		return err
+	}
+	meta, err := backups.Get(backupID)
+	if err != nil {
+		return err
+	}
+	if deviceName == "" {
+		deviceName = meta.DeviceName
+	}
+
+	containerName := apps.ContainerName(meta.AppID, deviceName, proxyID)
+	if _, err := a.docker.GetContainer(containerName); err == nil {
+		return fmt.Errorf("container %s already exists; restore into its instance instead", containerName)
+	}
+
+	formData := map[string]string{}
+	if creds, err := a.credentialStore.LoadCredentials(meta.AppID); err == nil {
+		formData = maps.Clone(creds.Credentials)
+	}
+	formData["DEVICE_NAME"] = deviceName
+
+	if err := backups.Extract(backupID, containerName); err != nil {
+		return err
+	}
+	if err := a.DeployAppWithProxyId(meta.AppID, formData, proxyID); err != nil {
+		return fmt.Errorf("backup restored to disk but deployment failed: %w", err)
+	}
+	a.addActivity("Restored backup " + backupID + " as " + containerName)
+	return nil
+}
+
+// GetBackupPolicy returns how many snapshots are kept per instance, for
+// how many days, and the hours between scheduled snapshots
+func (a *AppsAPI) GetBackupPolicy() (map[string]int, error) {
+	backups, err := a.backupManager()
+	if err != nil {
+		return nil, err
+	}
+	policy := backups.Policy()
+	return map[string]int{
+		"keep_last":      policy.KeepLast,
+		"max_age_days":   policy.MaxAgeDays,
+		"interval_hours": policy.IntervalHours,
+	}, nil
+}
+
+// SetBackupPolicy changes the retention and schedule policy. Keys that
+// are left out keep their current value; 0 means unlimited, or no
+// scheduled snapshots for interval_hours.
+func (a *AppsAPI) SetBackupPolicy(values map[string]int) error {
+	backups, err := a.backupManager()
+	if err != nil {
+		return err
+	}
+	policy := backups.Policy()
+	for key, value := range values {
+		switch key {
+		case "keep_last":
+			policy.KeepLast = value
+		case "max_age_days":
+			policy.MaxAgeDays = value
+		case "interval_hours":
+			policy.IntervalHours = value
+		default:
+			return fmt.Errorf("unknown backup policy setting: %s", key)
+		}
+	}
+	return backups.SetPolicy(policy)
+}
+
+// archiveContainer snapshots the data of a container about to be removed
+// and returns the data directories to delete once it is. Nothing is
+// returned when the snapshot fails, so the data stays in place.
+func (a *AppsAPI) archiveContainer(containerID string) []string {
+	if a.backups == nil {
+		return nil
+	}
+	meta, dirs, err := a.backups.Archive(containerID)
+	if errors.Is(err, backup.ErrNoData) {
+		return nil
+	}
+	if err != nil {
+		fmt.Printf("failed to back up data of %s, keeping it in place: %v\n", containerID, err)
+		return nil
+	}
+	a.addActivity("Backed up " + meta.ContainerName + " before removal")
+	return dirs
+}
@@ -0,0 +1,59 @@
+package api
+
+import (
+	"bandwidth-income-manager/backend/config"
+)
+
+// GetCredentialStoreStatus reports whether the credential store is
+// unprotected (no master passphrase yet), kept by a keyring, locked or
+// unlocked, and which backend holds it
+func (a *AppsAPI) GetCredentialStoreStatus() (map[string]interface{}, error) {
+	return map[string]interface{}{
+		"status":                a.credentialStore.Status(),
+		"backend":               a.credentialStore.Backend(),
+		"min_passphrase_length": config.MinPassphraseLength,
+	}, nil
+}
+
+// SetCredentialPassphrase protects the credential store with a master
+// passphrase and re-encrypts the saved credentials
+func (a *AppsAPI) SetCredentialPassphrase(passphrase string) error {
+	if err := a.credentialStore.SetPassphrase(passphrase); err != nil {
+		return err
+	}
+	a.addActivity("Protected credentials with a master passphrase")
+	a.emitCredentialStatus()
+	return nil
+}
+
+// UnlockCredentials unlocks the credential store for this session
+func (a *AppsAPI) UnlockCredentials(passphrase string) error {
+	if err := a.credentialStore.Unlock(passphrase); err != nil {
+		return err
+	}
+	a.emitCredentialStatus()
+	return nil
+}
+
+// LockCredentials locks the credential store until it is unlocked again.
+// Running instances are not affected.
+func (a *AppsAPI) LockCredentials() error {
+	if err := a.credentialStore.Lock(); err != nil {
+		return err
+	}
+	a.emitCredentialStatus()
+	return nil
+}
+
+// ChangeCredentialPassphrase replaces the master passphrase
+func (a *AppsAPI) ChangeCredentialPassphrase(oldPassphrase, newPassphrase string) error {
+	if err := a.credentialStore.ChangePassphrase(oldPassphrase, newPassphrase); err != nil {
+		return err
+	}
+	a.addActivity("Changed the master passphrase")
+	return nil
+}
+
+func (a *AppsAPI) emitCredentialStatus() {
+	a.emitEvent("credentials:status", a.credentialStore.Status())
+}
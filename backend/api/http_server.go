@@ -3,10 +3,19 @@ package api
 import (
 	"embed"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"mime/multipart"
 	"net/http"
+	"os"
 	"strconv"
 	"strings"
+	"time"
+
+	"bandwidth-income-manager/backend/apps"
+	"bandwidth-income-manager/backend/config"
+	"bandwidth-income-manager/backend/docker"
 )
 
 func jsonResponse(w http.ResponseWriter, data interface{}, statusCode int) {
@@ -17,7 +26,82 @@ func jsonResponse(w http.ResponseWriter, data interface{}, statusCode int) {
 	}
 }
 
-func StartHeadlessServer(port int, appsAPI *AppsAPI, proxyAPI *ProxyAPI, settingsAPI *SettingsAPI, assets embed.FS) {
+// deployErrorResponse reports a failed deployment. Form data that does not
+// match the app's field schema is a client error and lists the bad fields.
+func deployErrorResponse(w http.ResponseWriter, err error) {
+	var fieldErrs apps.FieldErrors
+	if errors.As(err, &fieldErrs) {
+		jsonResponse(w, map[string]interface{}{"error": err.Error(), "fields": fieldErrs}, http.StatusBadRequest)
+		return
+	}
+	if errors.Is(err, config.ErrLocked) {
+		jsonResponse(w, map[string]string{"error": err.Error()}, http.StatusLocked)
+		return
+	}
+	jsonResponse(w, map[string]string{"error": err.Error()}, http.StatusInternalServerError)
+}
+
+// instanceErrorResponse reports a failed operation on an instance: 404 for
+// an unknown instance or a missing container, 400 for invalid limits and
+// 409 when Docker reports a conflict
+func instanceErrorResponse(w http.ResponseWriter, err error) {
+	status := http.StatusInternalServerError
+	switch {
+	case errors.Is(err, apps.ErrInstanceNotFound), docker.IsNotFound(err):
+		status = http.StatusNotFound
+	case errors.Is(err, apps.ErrInvalidLimits):
+		status = http.StatusBadRequest
+	case docker.IsConflict(err):
+		status = http.StatusConflict
+	}
+	jsonResponse(w, map[string]string{"error": err.Error()}, status)
+}
+
+// credentialErrorResponse reports a failed passphrase operation. A wrong
+// passphrase is a 401 so clients can prompt again.
+func credentialErrorResponse(w http.ResponseWriter, err error) {
+	if errors.Is(err, config.ErrWrongPassphrase) {
+		jsonResponse(w, map[string]string{"error": err.Error()}, http.StatusUnauthorized)
+		return
+	}
+	jsonResponse(w, map[string]string{"error": err.Error()}, http.StatusBadRequest)
+}
+
+// transferErrorResponse reports a failed export or import. A wrong
+// passphrase is a 401 so clients can prompt again, and a locked credential
+// store a 423.
+func transferErrorResponse(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, config.ErrWrongPassphrase):
+		jsonResponse(w, map[string]string{"error": err.Error()}, http.StatusUnauthorized)
+	case errors.Is(err, config.ErrLocked):
+		jsonResponse(w, map[string]string{"error": err.Error()}, http.StatusLocked)
+	default:
+		jsonResponse(w, map[string]string{"error": err.Error()}, http.StatusBadRequest)
+	}
+}
+
+// exportUpload opens the export file and passphrase of a multipart
+// import request
+func exportUpload(r *http.Request) (multipart.File, string, error) {
+	if err := r.ParseMultipartForm(32 << 20); err != nil {
+		return nil, "", fmt.Errorf("expected a multipart form: %w", err)
+	}
+	file, _, err := r.FormFile("file")
+	if err != nil {
+		return nil, "", fmt.Errorf("missing export file: %w", err)
+	}
+	return file, r.FormValue("passphrase"), nil
+}
+
+// unmasked reports whether a request asks for secrets in clear with
+// ?unmask=true
+func unmasked(r *http.Request) bool {
+	unmask, _ := strconv.ParseBool(r.URL.Query().Get("unmask"))
+	return unmask
+}
+
+func StartHeadlessServer(addr string, appsAPI *AppsAPI, proxyAPI *ProxyAPI, settingsAPI *SettingsAPI, transferAPI *TransferAPI, assets embed.FS) {
 	mux := http.NewServeMux()
 
 	// API handlers
@@ -39,6 +123,25 @@ func StartHeadlessServer(port int, appsAPI *AppsAPI, proxyAPI *ProxyAPI, setting
 		jsonResponse(w, apps, http.StatusOK)
 	})
 
+	mux.HandleFunc("/api/apps/schema/", func(w http.ResponseWriter, r *http.Request) {
+		appID := strings.TrimPrefix(r.URL.Path, "/api/apps/schema/")
+		schema, err := appsAPI.GetAppSchema(appID)
+		if err != nil {
+			jsonResponse(w, map[string]string{"error": err.Error()}, http.StatusNotFound)
+			return
+		}
+		jsonResponse(w, schema, http.StatusOK)
+	})
+
+	mux.HandleFunc("/api/apps/config-errors", func(w http.ResponseWriter, r *http.Request) {
+		configErrors, err := appsAPI.GetConfigErrors()
+		if err != nil {
+			jsonResponse(w, map[string]string{"error": err.Error()}, http.StatusInternalServerError)
+			return
+		}
+		jsonResponse(w, configErrors, http.StatusOK)
+	})
+
 	mux.HandleFunc("/api/apps/running", func(w http.ResponseWriter, r *http.Request) {
 		running, err := appsAPI.GetRunningApps()
 		if err != nil {
@@ -61,6 +164,55 @@ func StartHeadlessServer(port int, appsAPI *AppsAPI, proxyAPI *ProxyAPI, setting
 		jsonResponse(w, map[string]string{"status": "started"}, http.StatusOK)
 	})
 
+	mux.HandleFunc("/api/apps/update/", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			jsonResponse(w, map[string]string{"error": "Method not allowed"}, http.StatusMethodNotAllowed)
+			return
+		}
+		appID := strings.TrimPrefix(r.URL.Path, "/api/apps/update/")
+		result, err := appsAPI.UpdateApp(appID)
+		if err != nil {
+			jsonResponse(w, map[string]interface{}{"error": err.Error(), "result": result}, http.StatusInternalServerError)
+			return
+		}
+		jsonResponse(w, result, http.StatusOK)
+	})
+
+	mux.HandleFunc("/api/apps/image-updates", func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			updates, err := appsAPI.GetImageUpdates()
+			if err != nil {
+				jsonResponse(w, map[string]string{"error": err.Error()}, http.StatusInternalServerError)
+				return
+			}
+			jsonResponse(w, updates, http.StatusOK)
+		case http.MethodPost:
+			// Check the registries now instead of waiting for the next run
+			updates, err := appsAPI.CheckImageUpdates()
+			if err != nil {
+				jsonResponse(w, map[string]interface{}{"error": err.Error(), "result": updates}, http.StatusInternalServerError)
+				return
+			}
+			jsonResponse(w, updates, http.StatusOK)
+		default:
+			jsonResponse(w, map[string]string{"error": "Method not allowed"}, http.StatusMethodNotAllowed)
+		}
+	})
+
+	mux.HandleFunc("/api/apps/adopt", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			jsonResponse(w, map[string]string{"error": "Method not allowed"}, http.StatusMethodNotAllowed)
+			return
+		}
+		report, err := appsAPI.AdoptContainers()
+		if err != nil {
+			jsonResponse(w, map[string]string{"error": err.Error()}, http.StatusInternalServerError)
+			return
+		}
+		jsonResponse(w, report, http.StatusOK)
+	})
+
 	mux.HandleFunc("/api/apps/stop/", func(w http.ResponseWriter, r *http.Request) {
 		if r.Method != http.MethodPost {
 			jsonResponse(w, map[string]string{"error": "Method not allowed"}, http.StatusMethodNotAllowed)
@@ -107,6 +259,20 @@ func StartHeadlessServer(port int, appsAPI *AppsAPI, proxyAPI *ProxyAPI, setting
 		jsonResponse(w, map[string]string{"logs": logs}, http.StatusOK)
 	})
 
+	mux.HandleFunc("/api/container/logs/stream/", func(w http.ResponseWriter, r *http.Request) {
+		containerID := strings.TrimPrefix(r.URL.Path, "/api/container/logs/stream/")
+		params := map[string]string{}
+		for key := range r.URL.Query() {
+			params[key] = r.URL.Query().Get(key)
+		}
+		opts, err := parseLogOptions(params)
+		if err != nil {
+			jsonResponse(w, map[string]string{"error": err.Error()}, http.StatusBadRequest)
+			return
+		}
+		serveLogStream(w, r, appsAPI, containerID, opts)
+	})
+
 	mux.HandleFunc("/api/container/logs/all/", func(w http.ResponseWriter, r *http.Request) {
 		containerID := strings.TrimPrefix(r.URL.Path, "/api/container/logs/all/")
 		logs, err := appsAPI.GetContainerLogsAll(containerID)
@@ -162,6 +328,40 @@ func StartHeadlessServer(port int, appsAPI *AppsAPI, proxyAPI *ProxyAPI, setting
 		jsonResponse(w, instances, http.StatusOK)
 	})
 
+	mux.HandleFunc("/api/apps/instance-resources/", func(w http.ResponseWriter, r *http.Request) {
+		instanceID := strings.TrimPrefix(r.URL.Path, "/api/apps/instance-resources/")
+		switch r.Method {
+		case http.MethodGet:
+			limits, err := appsAPI.GetInstanceResourceLimits(instanceID)
+			if err != nil {
+				instanceErrorResponse(w, err)
+				return
+			}
+			jsonResponse(w, limits, http.StatusOK)
+		case http.MethodPost:
+			// A key sent as null removes that limit
+			var body map[string]*string
+			if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+				jsonResponse(w, map[string]string{"error": "Invalid request body"}, http.StatusBadRequest)
+				return
+			}
+			limits := make(map[string]string, len(body))
+			for key, value := range body {
+				limits[key] = apps.ClearLimit
+				if value != nil {
+					limits[key] = *value
+				}
+			}
+			if err := appsAPI.UpdateInstanceResourceLimits(instanceID, limits); err != nil {
+				instanceErrorResponse(w, err)
+				return
+			}
+			jsonResponse(w, map[string]string{"status": "updated"}, http.StatusOK)
+		default:
+			jsonResponse(w, map[string]string{"error": "Method not allowed"}, http.StatusMethodNotAllowed)
+		}
+	})
+
 	mux.HandleFunc("/api/apps/configured", func(w http.ResponseWriter, r *http.Request) {
 		configured, err := appsAPI.GetConfiguredApps()
 		if err != nil {
@@ -173,7 +373,11 @@ func StartHeadlessServer(port int, appsAPI *AppsAPI, proxyAPI *ProxyAPI, setting
 
 	mux.HandleFunc("/api/apps/credentials/", func(w http.ResponseWriter, r *http.Request) {
 		appID := strings.TrimPrefix(r.URL.Path, "/api/apps/credentials/")
-		credentials, err := appsAPI.GetAppCredentials(appID)
+		getCredentials := appsAPI.GetAppCredentials
+		if unmasked(r) {
+			getCredentials = appsAPI.GetAppCredentialsUnmasked
+		}
+		credentials, err := getCredentials(appID)
 		if err != nil {
 			jsonResponse(w, map[string]string{"error": err.Error()}, http.StatusInternalServerError)
 			return
@@ -181,9 +385,173 @@ func StartHeadlessServer(port int, appsAPI *AppsAPI, proxyAPI *ProxyAPI, setting
 		jsonResponse(w, credentials, http.StatusOK)
 	})
 
+	mux.HandleFunc("/api/apps/profiles/", func(w http.ResponseWriter, r *http.Request) {
+		appID := strings.TrimPrefix(r.URL.Path, "/api/apps/profiles/")
+		switch r.Method {
+		case http.MethodGet:
+			profiles, err := appsAPI.ListCredentialProfiles(appID)
+			if err != nil {
+				jsonResponse(w, map[string]string{"error": err.Error()}, http.StatusInternalServerError)
+				return
+			}
+			jsonResponse(w, profiles, http.StatusOK)
+		case http.MethodPost:
+			var data struct {
+				Profile     string            `json:"profile"`
+				Credentials map[string]string `json:"credentials"`
+			}
+			if err := json.NewDecoder(r.Body).Decode(&data); err != nil {
+				jsonResponse(w, map[string]string{"error": "Invalid request body"}, http.StatusBadRequest)
+				return
+			}
+			if err := appsAPI.CreateCredentialProfile(appID, data.Profile, data.Credentials); err != nil {
+				deployErrorResponse(w, err)
+				return
+			}
+			jsonResponse(w, map[string]string{"status": "created"}, http.StatusOK)
+		default:
+			jsonResponse(w, map[string]string{"error": "Method not allowed"}, http.StatusMethodNotAllowed)
+		}
+	})
+
+	// /api/apps/profile/<appID>/<profile>
+	mux.HandleFunc("/api/apps/profile/", func(w http.ResponseWriter, r *http.Request) {
+		appID, profile, ok := strings.Cut(strings.TrimPrefix(r.URL.Path, "/api/apps/profile/"), "/")
+		if !ok || appID == "" || profile == "" {
+			jsonResponse(w, map[string]string{"error": "Expected /api/apps/profile/<app>/<profile>"}, http.StatusBadRequest)
+			return
+		}
+		switch r.Method {
+		case http.MethodGet:
+			getProfile := appsAPI.GetCredentialProfile
+			if unmasked(r) {
+				getProfile = appsAPI.GetCredentialProfileUnmasked
+			}
+			credentials, err := getProfile(appID, profile)
+			if err != nil {
+				jsonResponse(w, map[string]string{"error": err.Error()}, http.StatusNotFound)
+				return
+			}
+			jsonResponse(w, credentials, http.StatusOK)
+		case http.MethodPost:
+			var credentials map[string]string
+			if err := json.NewDecoder(r.Body).Decode(&credentials); err != nil {
+				jsonResponse(w, map[string]string{"error": "Invalid request body"}, http.StatusBadRequest)
+				return
+			}
+			if err := appsAPI.UpdateCredentialProfile(appID, profile, credentials); err != nil {
+				deployErrorResponse(w, err)
+				return
+			}
+			jsonResponse(w, map[string]string{"status": "updated"}, http.StatusOK)
+		case http.MethodDelete:
+			if err := appsAPI.DeleteCredentialProfile(appID, profile); err != nil {
+				jsonResponse(w, map[string]string{"error": err.Error()}, http.StatusConflict)
+				return
+			}
+			jsonResponse(w, map[string]string{"status": "deleted"}, http.StatusOK)
+		default:
+			jsonResponse(w, map[string]string{"error": "Method not allowed"}, http.StatusMethodNotAllowed)
+		}
+	})
+
+	// /api/apps/rotate/<appID>/<profile>
+	mux.HandleFunc("/api/apps/rotate/", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			jsonResponse(w, map[string]string{"error": "Method not allowed"}, http.StatusMethodNotAllowed)
+			return
+		}
+		appID, profile, ok := strings.Cut(strings.TrimPrefix(r.URL.Path, "/api/apps/rotate/"), "/")
+		if !ok || appID == "" || profile == "" {
+			jsonResponse(w, map[string]string{"error": "Expected /api/apps/rotate/<app>/<profile>"}, http.StatusBadRequest)
+			return
+		}
+		var credentials map[string]string
+		if err := json.NewDecoder(r.Body).Decode(&credentials); err != nil {
+			jsonResponse(w, map[string]string{"error": "Invalid request body"}, http.StatusBadRequest)
+			return
+		}
+		results, err := appsAPI.RotateCredentialProfile(appID, profile, credentials)
+		if err != nil {
+			deployErrorResponse(w, err)
+			return
+		}
+		jsonResponse(w, results, http.StatusOK)
+	})
+
+	mux.HandleFunc("/api/credentials/status", func(w http.ResponseWriter, r *http.Request) {
+		status, err := appsAPI.GetCredentialStoreStatus()
+		if err != nil {
+			jsonResponse(w, map[string]string{"error": err.Error()}, http.StatusInternalServerError)
+			return
+		}
+		jsonResponse(w, status, http.StatusOK)
+	})
+
+	mux.HandleFunc("/api/credentials/passphrase", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			jsonResponse(w, map[string]string{"error": "Method not allowed"}, http.StatusMethodNotAllowed)
+			return
+		}
+		var data struct {
+			Passphrase    string `json:"passphrase"`
+			OldPassphrase string `json:"old_passphrase"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&data); err != nil {
+			jsonResponse(w, map[string]string{"error": "Invalid request body"}, http.StatusBadRequest)
+			return
+		}
+		var err error
+		if data.OldPassphrase != "" {
+			err = appsAPI.ChangeCredentialPassphrase(data.OldPassphrase, data.Passphrase)
+		} else {
+			err = appsAPI.SetCredentialPassphrase(data.Passphrase)
+		}
+		if err != nil {
+			credentialErrorResponse(w, err)
+			return
+		}
+		jsonResponse(w, map[string]string{"status": "updated"}, http.StatusOK)
+	})
+
+	mux.HandleFunc("/api/credentials/unlock", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			jsonResponse(w, map[string]string{"error": "Method not allowed"}, http.StatusMethodNotAllowed)
+			return
+		}
+		var data struct {
+			Passphrase string `json:"passphrase"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&data); err != nil {
+			jsonResponse(w, map[string]string{"error": "Invalid request body"}, http.StatusBadRequest)
+			return
+		}
+		if err := appsAPI.UnlockCredentials(data.Passphrase); err != nil {
+			credentialErrorResponse(w, err)
+			return
+		}
+		jsonResponse(w, map[string]string{"status": "unlocked"}, http.StatusOK)
+	})
+
+	mux.HandleFunc("/api/credentials/lock", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			jsonResponse(w, map[string]string{"error": "Method not allowed"}, http.StatusMethodNotAllowed)
+			return
+		}
+		if err := appsAPI.LockCredentials(); err != nil {
+			jsonResponse(w, map[string]string{"error": err.Error()}, http.StatusBadRequest)
+			return
+		}
+		jsonResponse(w, map[string]string{"status": "locked"}, http.StatusOK)
+	})
+
 	mux.HandleFunc("/api/container/env/", func(w http.ResponseWriter, r *http.Request) {
 		containerID := strings.TrimPrefix(r.URL.Path, "/api/container/env/")
-		env, err := appsAPI.GetContainerEnvironmentVars(containerID)
+		getEnv := appsAPI.GetContainerEnvironmentVars
+		if unmasked(r) {
+			getEnv = appsAPI.GetContainerEnvironmentVarsUnmasked
+		}
+		env, err := getEnv(containerID)
 		if err != nil {
 			jsonResponse(w, map[string]string{"error": err.Error()}, http.StatusInternalServerError)
 			return
@@ -202,8 +570,8 @@ func StartHeadlessServer(port int, appsAPI *AppsAPI, proxyAPI *ProxyAPI, setting
 			jsonResponse(w, map[string]string{"error": "Invalid request body"}, http.StatusBadRequest)
 			return
 		}
-		if err := appsAPI.DeployApp(appID, formData); err != nil {
-			jsonResponse(w, map[string]string{"error": err.Error()}, http.StatusInternalServerError)
+		if err := appsAPI.DeployAppWithProfile(appID, r.URL.Query().Get("profile"), formData, ""); err != nil {
+			deployErrorResponse(w, err)
 			return
 		}
 		jsonResponse(w, map[string]string{"status": "deployed"}, http.StatusOK)
@@ -218,13 +586,14 @@ func StartHeadlessServer(port int, appsAPI *AppsAPI, proxyAPI *ProxyAPI, setting
 		var data struct {
 			FormData map[string]string `json:"formData"`
 			ProxyID  string            `json:"proxyID"`
+			Profile  string            `json:"profile"`
 		}
 		if err := json.NewDecoder(r.Body).Decode(&data); err != nil {
 			jsonResponse(w, map[string]string{"error": "Invalid request body"}, http.StatusBadRequest)
 			return
 		}
-		if err := appsAPI.DeployAppWithProxyId(appID, data.FormData, data.ProxyID); err != nil {
-			jsonResponse(w, map[string]string{"error": err.Error()}, http.StatusInternalServerError)
+		if err := appsAPI.DeployAppWithProfile(appID, data.Profile, data.FormData, data.ProxyID); err != nil {
+			deployErrorResponse(w, err)
 			return
 		}
 		jsonResponse(w, map[string]string{"status": "deployed"}, http.StatusOK)
@@ -246,7 +615,7 @@ func StartHeadlessServer(port int, appsAPI *AppsAPI, proxyAPI *ProxyAPI, setting
 		}
 		result, err := appsAPI.DeployAppWithProxies(appID, data.FormData, data.ProxyIDs)
 		if err != nil {
-			jsonResponse(w, map[string]string{"error": err.Error()}, http.StatusInternalServerError)
+			deployErrorResponse(w, err)
 			return
 		}
 		jsonResponse(w, result, http.StatusOK)
@@ -278,6 +647,102 @@ func StartHeadlessServer(port int, appsAPI *AppsAPI, proxyAPI *ProxyAPI, setting
 		jsonResponse(w, map[string]string{"status": "removed"}, http.StatusOK)
 	})
 
+	mux.HandleFunc("/api/backups", func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			backups, err := appsAPI.ListBackups(r.URL.Query().Get("app_id"))
+			if err != nil {
+				jsonResponse(w, map[string]string{"error": err.Error()}, http.StatusInternalServerError)
+				return
+			}
+			jsonResponse(w, backups, http.StatusOK)
+		case http.MethodPost:
+			var data struct {
+				InstanceID string `json:"instance_id"`
+			}
+			if err := json.NewDecoder(r.Body).Decode(&data); err != nil {
+				jsonResponse(w, map[string]string{"error": "Invalid request body"}, http.StatusBadRequest)
+				return
+			}
+			result, err := appsAPI.CreateBackup(data.InstanceID)
+			if err != nil {
+				jsonResponse(w, map[string]string{"error": err.Error()}, http.StatusInternalServerError)
+				return
+			}
+			jsonResponse(w, result, http.StatusOK)
+		default:
+			jsonResponse(w, map[string]string{"error": "Method not allowed"}, http.StatusMethodNotAllowed)
+		}
+	})
+
+	mux.HandleFunc("/api/backups/delete/", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			jsonResponse(w, map[string]string{"error": "Method not allowed"}, http.StatusMethodNotAllowed)
+			return
+		}
+		backupID := strings.TrimPrefix(r.URL.Path, "/api/backups/delete/")
+		if err := appsAPI.DeleteBackup(backupID); err != nil {
+			jsonResponse(w, map[string]string{"error": err.Error()}, http.StatusInternalServerError)
+			return
+		}
+		jsonResponse(w, map[string]string{"status": "deleted"}, http.StatusOK)
+	})
+
+	// Restores into instance_id if given, otherwise deploys a new instance
+	// for device_name (default: the snapshot's) and proxy_id
+	mux.HandleFunc("/api/backups/restore/", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			jsonResponse(w, map[string]string{"error": "Method not allowed"}, http.StatusMethodNotAllowed)
+			return
+		}
+		backupID := strings.TrimPrefix(r.URL.Path, "/api/backups/restore/")
+		var data struct {
+			InstanceID string `json:"instance_id"`
+			DeviceName string `json:"device_name"`
+			ProxyID    string `json:"proxy_id"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&data); err != nil {
+			jsonResponse(w, map[string]string{"error": "Invalid request body"}, http.StatusBadRequest)
+			return
+		}
+		var err error
+		if data.InstanceID != "" {
+			err = appsAPI.RestoreBackup(backupID, data.InstanceID)
+		} else {
+			err = appsAPI.RestoreBackupAsNewInstance(backupID, data.DeviceName, data.ProxyID)
+		}
+		if err != nil {
+			deployErrorResponse(w, err)
+			return
+		}
+		jsonResponse(w, map[string]string{"status": "restored"}, http.StatusOK)
+	})
+
+	mux.HandleFunc("/api/backups/policy", func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			policy, err := appsAPI.GetBackupPolicy()
+			if err != nil {
+				jsonResponse(w, map[string]string{"error": err.Error()}, http.StatusInternalServerError)
+				return
+			}
+			jsonResponse(w, policy, http.StatusOK)
+		case http.MethodPost:
+			var policy map[string]int
+			if err := json.NewDecoder(r.Body).Decode(&policy); err != nil {
+				jsonResponse(w, map[string]string{"error": "Invalid request body"}, http.StatusBadRequest)
+				return
+			}
+			if err := appsAPI.SetBackupPolicy(policy); err != nil {
+				jsonResponse(w, map[string]string{"error": err.Error()}, http.StatusBadRequest)
+				return
+			}
+			jsonResponse(w, map[string]string{"status": "updated"}, http.StatusOK)
+		default:
+			jsonResponse(w, map[string]string{"error": "Method not allowed"}, http.StatusMethodNotAllowed)
+		}
+	})
+
 	mux.HandleFunc("/api/apps/deploy-selective", func(w http.ResponseWriter, r *http.Request) {
 		if r.Method != http.MethodPost {
 			jsonResponse(w, map[string]string{"error": "Method not allowed"}, http.StatusMethodNotAllowed)
@@ -335,7 +800,7 @@ func StartHeadlessServer(port int, appsAPI *AppsAPI, proxyAPI *ProxyAPI, setting
 		}
 		result, err := proxyAPI.AddProxy(data.ProxyStr, data.AutoDeploy, data.SelectedAppIDs)
 		if err != nil {
-			jsonResponse(w, map[string]string{"error": err.Error()}, http.StatusInternalServerError)
+			deployErrorResponse(w, err)
 			return
 		}
 		jsonResponse(w, result, http.StatusOK)
@@ -430,6 +895,19 @@ func StartHeadlessServer(port int, appsAPI *AppsAPI, proxyAPI *ProxyAPI, setting
 		jsonResponse(w, settings, http.StatusOK)
 	})
 
+	mux.HandleFunc("/api/settings/config", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			jsonResponse(w, map[string]string{"error": "Method not allowed"}, http.StatusMethodNotAllowed)
+			return
+		}
+		effective, err := settingsAPI.GetEffectiveConfig()
+		if err != nil {
+			jsonResponse(w, map[string]string{"error": err.Error()}, http.StatusInternalServerError)
+			return
+		}
+		jsonResponse(w, effective, http.StatusOK)
+	})
+
 	mux.HandleFunc("/api/settings/autostart", func(w http.ResponseWriter, r *http.Request) {
 		if r.Method != http.MethodPost {
 			jsonResponse(w, map[string]string{"error": "Method not allowed"}, http.StatusMethodNotAllowed)
@@ -470,12 +948,96 @@ func StartHeadlessServer(port int, appsAPI *AppsAPI, proxyAPI *ProxyAPI, setting
 		jsonResponse(w, map[string]string{"status": "ok"}, http.StatusOK)
 	})
 
+	// TransferAPI Handlers
+	mux.HandleFunc("/api/transfer/export", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			jsonResponse(w, map[string]string{"error": "Method not allowed"}, http.StatusMethodNotAllowed)
+			return
+		}
+		var data struct {
+			Passphrase     string `json:"passphrase"`
+			IncludeVolumes bool   `json:"include_volumes"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&data); err != nil {
+			jsonResponse(w, map[string]string{"error": "Invalid request body"}, http.StatusBadRequest)
+			return
+		}
+		// Written to a temporary file first so a failure can still be
+		// reported as an error response
+		f, err := os.CreateTemp("", "bim-export-*")
+		if err != nil {
+			jsonResponse(w, map[string]string{"error": err.Error()}, http.StatusInternalServerError)
+			return
+		}
+		defer os.Remove(f.Name())
+		defer f.Close()
+		if _, err := transferAPI.export(f, data.Passphrase, data.IncludeVolumes); err != nil {
+			transferErrorResponse(w, err)
+			return
+		}
+		if _, err := f.Seek(0, io.SeekStart); err != nil {
+			jsonResponse(w, map[string]string{"error": err.Error()}, http.StatusInternalServerError)
+			return
+		}
+		name := "bim-export-" + time.Now().Format("20060102-150405") + ".bimx"
+		w.Header().Set("Content-Type", "application/octet-stream")
+		w.Header().Set("Content-Disposition", `attachment; filename="`+name+`"`)
+		io.Copy(w, f)
+	})
+
+	// Multipart form with the export file, its passphrase and, for an
+	// import, the resolutions as a JSON object
+	mux.HandleFunc("/api/transfer/preview", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			jsonResponse(w, map[string]string{"error": "Method not allowed"}, http.StatusMethodNotAllowed)
+			return
+		}
+		file, passphrase, err := exportUpload(r)
+		if err != nil {
+			jsonResponse(w, map[string]string{"error": err.Error()}, http.StatusBadRequest)
+			return
+		}
+		defer file.Close()
+		preview, err := transferAPI.preview(file, passphrase)
+		if err != nil {
+			transferErrorResponse(w, err)
+			return
+		}
+		jsonResponse(w, preview, http.StatusOK)
+	})
+
+	mux.HandleFunc("/api/transfer/import", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			jsonResponse(w, map[string]string{"error": "Method not allowed"}, http.StatusMethodNotAllowed)
+			return
+		}
+		file, passphrase, err := exportUpload(r)
+		if err != nil {
+			jsonResponse(w, map[string]string{"error": err.Error()}, http.StatusBadRequest)
+			return
+		}
+		defer file.Close()
+		resolutions := map[string]string{}
+		if raw := r.FormValue("resolutions"); raw != "" {
+			if err := json.Unmarshal([]byte(raw), &resolutions); err != nil {
+				jsonResponse(w, map[string]string{"error": "Invalid resolutions"}, http.StatusBadRequest)
+				return
+			}
+		}
+		results, err := transferAPI.importState(file, passphrase, resolutions)
+		if err != nil {
+			transferErrorResponse(w, err)
+			return
+		}
+		jsonResponse(w, results, http.StatusOK)
+	})
+
 	server := &http.Server{
-		Addr:    fmt.Sprintf(":%d", port),
+		Addr:    addr,
 		Handler: mux,
 	}
 
-	fmt.Printf("Starting headless server on port %d\n", port)
+	fmt.Printf("Starting headless server on %s\n", addr)
 	if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
 		fmt.Printf("Error starting headless server: %v\n", err)
 	}
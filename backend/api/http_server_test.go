@@ -0,0 +1,32 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"bandwidth-income-manager/backend/apps"
+	"bandwidth-income-manager/backend/docker"
+)
+
+func TestInstanceErrorResponse(t *testing.T) {
+	tests := []struct {
+		err  error
+		want int
+	}{
+		{fmt.Errorf("%w: earnapp_box", apps.ErrInstanceNotFound), http.StatusNotFound},
+		{&docker.APIError{StatusCode: http.StatusNotFound, Message: "No such container"}, http.StatusNotFound},
+		{fmt.Errorf("%w: cpus: not a number", apps.ErrInvalidLimits), http.StatusBadRequest},
+		{&docker.APIError{StatusCode: http.StatusConflict, Message: "container is restarting"}, http.StatusConflict},
+		{errors.New("connection refused"), http.StatusInternalServerError},
+	}
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		instanceErrorResponse(w, tt.err)
+		if w.Code != tt.want {
+			t.Fatalf("status for %q = %d, want %d", tt.err, w.Code, tt.want)
+		}
+	}
+}
@@ -0,0 +1,204 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"bandwidth-income-manager/backend/docker"
+)
+
+// defaultStreamTail is how many existing lines a log stream starts with
+const defaultStreamTail = "100"
+
+// parseLogOptions builds stream options from query or binding parameters:
+// follow, timestamps, stdout, stderr (booleans), tail (a count or "all"),
+// since and until (RFC3339, unix seconds, or a duration like "10m" meaning
+// that long ago). Streams follow and include both outputs by default.
+func parseLogOptions(params map[string]string) (docker.LogOptions, error) {
+	opts := docker.LogOptions{
+		Follow: true,
+		Tail:   defaultStreamTail,
+		Stdout: true,
+		Stderr: true,
+	}
+
+	var err error
+	for key, target := range map[string]*bool{
+		"follow":     &opts.Follow,
+		"timestamps": &opts.Timestamps,
+		"stdout":     &opts.Stdout,
+		"stderr":     &opts.Stderr,
+	} {
+		value, ok := params[key]
+		if !ok || value == "" {
+			continue
+		}
+		if *target, err = strconv.ParseBool(value); err != nil {
+			return opts, fmt.Errorf("invalid %s value: %s", key, value)
+		}
+	}
+
+	if tail := params["tail"]; tail != "" {
+		if tail != "all" {
+			if n, err := strconv.Atoi(tail); err != nil || n < 0 {
+				return opts, fmt.Errorf("invalid tail value: %s", tail)
+			}
+		}
+		opts.Tail = tail
+	}
+	if opts.Since, err = parseLogTime(params["since"]); err != nil {
+		return opts, fmt.Errorf("invalid since value: %w", err)
+	}
+	if opts.Until, err = parseLogTime(params["until"]); err != nil {
+		return opts, fmt.Errorf("invalid until value: %w", err)
+	}
+	if !opts.Since.IsZero() && !opts.Until.IsZero() && opts.Until.Before(opts.Since) {
+		return opts, fmt.Errorf("until is before since")
+	}
+	if !opts.Stdout && !opts.Stderr {
+		return opts, fmt.Errorf("at least one of stdout and stderr must be enabled")
+	}
+	return opts, nil
+}
+
+// parseLogTime accepts the same formats as docker logs --since
+func parseLogTime(value string) (time.Time, error) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.Parse(time.RFC3339Nano, value); err == nil {
+		return t, nil
+	}
+	if secs, err := strconv.ParseFloat(value, 64); err == nil {
+		return time.Unix(0, int64(secs*1e9)), nil
+	}
+	if d, err := time.ParseDuration(value); err == nil && d >= 0 {
+		return time.Now().Add(-d), nil
+	}
+	return time.Time{}, fmt.Errorf("%s is not a timestamp or duration", value)
+}
+
+// StartLogStream starts following a container's logs and delivers them as
+// Wails events. Lines arrive on "logs:<streamID>" and a final event on
+// "logs:<streamID>:end" carries an error message, if any. Options are the
+// same as for the /api/container/logs/stream/ endpoint.
+func (a *AppsAPI) StartLogStream(containerID string, options map[string]string) (string, error) {
+	if a.ctx == nil {
+		return "", fmt.Errorf("log event streams are only available in the desktop app; use /api/container/logs/stream/ instead")
+	}
+	opts, err := parseLogOptions(options)
+	if err != nil {
+		return "", err
+	}
+
+	ctx, cancel := context.WithCancel(a.ctx)
+	streamID := fmt.Sprintf("%s_%d", containerID, time.Now().UnixNano())
+
+	a.logStreamsMu.Lock()
+	a.logStreams[streamID] = cancel
+	a.logStreamsMu.Unlock()
+
+	go func() {
+		defer a.StopLogStream(streamID)
+
+		err := a.docker.StreamLogs(ctx, containerID, opts, func(line docker.LogLine) {
+			a.emitEvent("logs:"+streamID, line)
+		})
+		end := map[string]string{}
+		if err != nil {
+			end["error"] = err.Error()
+		}
+		a.emitEvent("logs:"+streamID+":end", end)
+	}()
+
+	return streamID, nil
+}
+
+// StopLogStream stops a stream started with StartLogStream
+func (a *AppsAPI) StopLogStream(streamID string) error {
+	a.logStreamsMu.Lock()
+	defer a.logStreamsMu.Unlock()
+
+	cancel, exists := a.logStreams[streamID]
+	if !exists {
+		return fmt.Errorf("log stream not found: %s", streamID)
+	}
+	cancel()
+	delete(a.logStreams, streamID)
+	return nil
+}
+
+// sseKeepAlive is how often an idle log stream sends a comment line so
+// proxies do not close the connection
+const sseKeepAlive = 15 * time.Second
+
+// serveLogStream writes container logs as server-sent events: a "log" event
+// per line and a final "end" event, with an error message if streaming failed
+func serveLogStream(w http.ResponseWriter, r *http.Request, appsAPI *AppsAPI, containerID string, opts docker.LogOptions) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		jsonResponse(w, map[string]string{"error": "Streaming not supported"}, http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	var mu sync.Mutex
+	send := func(event string, data interface{}) {
+		payload, _ := json.Marshal(data)
+		mu.Lock()
+		defer mu.Unlock()
+		fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event, payload)
+		flusher.Flush()
+	}
+
+	// The writer is only valid until the handler returns, so the pinger is
+	// stopped and waited for first
+	ctx, cancel := context.WithCancel(r.Context())
+	pingDone := make(chan struct{})
+	defer func() {
+		cancel()
+		<-pingDone
+	}()
+	go func() {
+		defer close(pingDone)
+		ticker := time.NewTicker(sseKeepAlive)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				mu.Lock()
+				fmt.Fprint(w, ": ping\n\n")
+				flusher.Flush()
+				mu.Unlock()
+			}
+		}
+	}()
+
+	err := appsAPI.docker.StreamLogs(ctx, containerID, opts, func(line docker.LogLine) {
+		send("log", line)
+	})
+	if r.Context().Err() != nil {
+		// Client went away
+		return
+	}
+	end := map[string]string{}
+	if err != nil {
+		end["error"] = err.Error()
+	}
+	send("end", end)
+}
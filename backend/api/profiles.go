@@ -0,0 +1,256 @@
+package api
+
+import (
+	"fmt"
+	"maps"
+	"sort"
+
+	"bandwidth-income-manager/backend/apps"
+	"bandwidth-income-manager/backend/config"
+)
+
+// profileOf returns the credential profile an instance was deployed with.
+// Instances from before profiles existed use the default profile.
+func profileOf(instance *apps.AppInstance) string {
+	if instance.Profile == "" {
+		return config.DefaultProfile
+	}
+	return instance.Profile
+}
+
+// profileUsage counts the instances of an app per credential profile
+func (a *AppsAPI) profileUsage(appID string) map[string]int {
+	usage := make(map[string]int)
+	for _, instance := range a.instanceManager.GetAppInstances(appID) {
+		usage[profileOf(instance)]++
+	}
+	return usage
+}
+
+// leastUsedProfile returns the credential profile of an app that the
+// fewest instances use, the first by name on a tie
+func (a *AppsAPI) leastUsedProfile(appID string) (*config.AppCredentials, error) {
+	profiles, err := a.credentialStore.ListProfiles(appID)
+	if err != nil {
+		return nil, err
+	}
+	if len(profiles) == 0 {
+		return nil, fmt.Errorf("credentials not found for app: %s", appID)
+	}
+
+	usage := a.profileUsage(appID)
+	best := profiles[0]
+	for _, creds := range profiles[1:] {
+		if usage[creds.Profile] < usage[best.Profile] {
+			best = creds
+		}
+	}
+	return best, nil
+}
+
+// ListCredentialProfiles returns the credential profiles of an app with
+// the device name and number of instances of each
+func (a *AppsAPI) ListCredentialProfiles(appID string) ([]map[string]interface{}, error) {
+	profiles, err := a.credentialStore.ListProfiles(appID)
+	if err != nil {
+		return nil, err
+	}
+
+	usage := a.profileUsage(appID)
+	result := make([]map[string]interface{}, 0, len(profiles))
+	for _, creds := range profiles {
+		result = append(result, map[string]interface{}{
+			"profile":     creds.Profile,
+			"device_name": creds.DeviceName,
+			"instances":   usage[creds.Profile],
+		})
+	}
+	return result, nil
+}
+
+// GetCredentialProfile returns the saved credentials of a profile (for
+// editing) with secret fields redacted
+func (a *AppsAPI) GetCredentialProfile(appID, profile string) (map[string]string, error) {
+	return a.credentialProfile(appID, profile, false)
+}
+
+// GetCredentialProfileUnmasked returns the saved credentials of a profile
+// including secret fields
+func (a *AppsAPI) GetCredentialProfileUnmasked(appID, profile string) (map[string]string, error) {
+	return a.credentialProfile(appID, profile, true)
+}
+
+func (a *AppsAPI) credentialProfile(appID, profile string, unmask bool) (map[string]string, error) {
+	creds, err := a.credentialStore.LoadProfile(appID, profile)
+	if err != nil {
+		return nil, err
+	}
+	return redactCredentials(appID, creds.Credentials, unmask), nil
+}
+
+// CreateCredentialProfile saves a new credential profile for an app. The
+// credentials are checked against the app's field schema.
+func (a *AppsAPI) CreateCredentialProfile(appID, profile string, credentials map[string]string) error {
+	if err := config.ValidateProfileName(profile); err != nil {
+		return err
+	}
+	if _, err := a.credentialStore.LoadProfile(appID, profile); err == nil {
+		return fmt.Errorf("credential profile %q already exists for app: %s", profile, appID)
+	}
+	if err := a.saveProfile(appID, profile, credentials); err != nil {
+		return err
+	}
+	a.addActivity("Created credential profile " + profile + " for " + appID)
+	return nil
+}
+
+// UpdateCredentialProfile replaces the credentials of a profile. Secret
+// fields left redacted keep their saved values. Running instances keep the
+// credentials they were deployed with; RotateCredentialProfile recreates
+// them.
+func (a *AppsAPI) UpdateCredentialProfile(appID, profile string, credentials map[string]string) error {
+	saved, err := a.credentialStore.LoadProfile(appID, profile)
+	if err != nil {
+		return err
+	}
+	if manifest := apps.GetAppManifest(appID); manifest != nil {
+		credentials = manifest.Unredact(credentials, saved.Credentials)
+	}
+	if err := a.saveProfile(appID, profile, credentials); err != nil {
+		return err
+	}
+	a.addActivity("Updated credential profile " + profile + " for " + appID)
+	return nil
+}
+
+// RotateCredentialProfile replaces the credentials of a profile and
+// recreates every instance using it with the new values, one at a time.
+// Each instance keeps its proxy, ports, data volumes and generated
+// identity; one that does not come up healthy is rolled back to its old
+// container. Progress is reported as "credentials:rotate" events and the
+// result lists the outcome per instance.
+func (a *AppsAPI) RotateCredentialProfile(appID, profile string, credentials map[string]string) ([]map[string]interface{}, error) {
+	if profile == "" {
+		profile = config.DefaultProfile
+	}
+	manifest := apps.GetAppManifest(appID)
+	if manifest == nil {
+		return nil, fmt.Errorf("app not found: %s", appID)
+	}
+	saved, err := a.credentialStore.LoadProfile(appID, profile)
+	if err != nil {
+		return nil, err
+	}
+	credentials, err = manifest.ValidateFormData(manifest.Unredact(credentials, saved.Credentials))
+	if err != nil {
+		return nil, err
+	}
+
+	done, err := a.beginUpdate(appID)
+	if err != nil {
+		return nil, err
+	}
+	defer done()
+
+	if _, err := a.credentialStore.UpdateCredentials(&config.AppCredentials{
+		AppID:       appID,
+		Profile:     profile,
+		DeviceName:  credentials["DEVICE_NAME"],
+		Credentials: credentials,
+	}); err != nil {
+		return nil, err
+	}
+
+	instances := a.instanceManager.GetAppInstances(appID)
+	sort.Slice(instances, func(i, j int) bool { return instances[i].InstanceID < instances[j].InstanceID })
+	results := make([]map[string]interface{}, 0)
+	failed := 0
+	for _, instance := range instances {
+		if profileOf(instance) != profile {
+			continue
+		}
+		result := map[string]interface{}{
+			"instance_id": instance.InstanceID,
+			"device_name": instance.DeviceName,
+			"proxy_id":    instance.ProxyID,
+			"status":      "updated",
+		}
+		if err := a.rotateInstance(manifest, instance, credentials); err != nil {
+			failed++
+			result["status"] = "failed"
+			result["error"] = err.Error()
+		}
+		results = append(results, result)
+	}
+
+	a.addActivity(fmt.Sprintf("Rotated credential profile %s for %s: %d instance(s) updated, %d failed", profile, appID, len(results)-failed, failed))
+	return results, nil
+}
+
+// rotateInstance recreates one instance with new profile credentials
+func (a *AppsAPI) rotateInstance(manifest *apps.AppManifest, instance *apps.AppInstance, credentials map[string]string) error {
+	formData := maps.Clone(credentials)
+	if instance.DeviceName != "" {
+		formData["DEVICE_NAME"] = instance.DeviceName
+	}
+	for field, value := range instance.Generated {
+		if formData[field] == "" {
+			formData[field] = value
+		}
+	}
+
+	vars, generated, err := a.templateVars(manifest, formData, instance.DeviceName, instance.ProxyID)
+	if err != nil {
+		return err
+	}
+	env, command, err := renderManifest(manifest, vars)
+	if err != nil {
+		return err
+	}
+
+	err = apps.RecreateInstance(a.docker, a.instanceManager, instance, env, manifest.SecretEnvKeys(env), command, manifest.SecretCommand(), apps.UpdateOptions{
+		OnProgress: func(instanceID, message string) {
+			a.emitEvent("credentials:rotate", map[string]string{
+				"app_id":      manifest.ID,
+				"instance_id": instanceID,
+				"message":     message,
+			})
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	a.saveGenerated(apps.IdentityKey(manifest.ID, instance.DeviceName, instance.ProxyID), generated)
+	return a.instanceManager.UpdateInstanceCredentials(instance.InstanceID, formData)
+}
+
+func (a *AppsAPI) saveProfile(appID, profile string, credentials map[string]string) error {
+	manifest := apps.GetAppManifest(appID)
+	if manifest == nil {
+		return fmt.Errorf("app not found: %s", appID)
+	}
+	credentials, err := manifest.ValidateFormData(credentials)
+	if err != nil {
+		return err
+	}
+	return a.credentialStore.SaveCredentials(&config.AppCredentials{
+		AppID:       appID,
+		Profile:     profile,
+		DeviceName:  credentials["DEVICE_NAME"],
+		Credentials: credentials,
+	})
+}
+
+// DeleteCredentialProfile deletes a credential profile that no instance
+// uses
+func (a *AppsAPI) DeleteCredentialProfile(appID, profile string) error {
+	if n := a.profileUsage(appID)[profile]; n > 0 {
+		return fmt.Errorf("credential profile %q is used by %d instance(s) of %s", profile, n, appID)
+	}
+	if err := a.credentialStore.DeleteProfile(appID, profile); err != nil {
+		return err
+	}
+	a.addActivity("Deleted credential profile " + profile + " for " + appID)
+	return nil
+}
@@ -30,10 +30,14 @@ func NewProxyAPI(proxyManager *proxy.Manager, instanceManager *apps.InstanceMana
 // AddProxy adds a new proxy with optional auto-deployment
 func (p *ProxyAPI) AddProxy(proxyStr string, autoDeploy bool, selectedAppIDs []string) (map[string]interface{}, error) {
 	// Validate proxy format
-	_, err := proxy.ParseProxy(proxyStr)
+	parsed, err := proxy.ParseProxy(proxyStr)
 	if err != nil {
 		return nil, fmt.Errorf("invalid proxy format: %w", err)
 	}
+	// The password is saved in the credential store
+	if parsed.Password != "" && p.credentialStore.Locked() {
+		return nil, config.ErrLocked
+	}
 
 	// Add proxy to manager
 	addedProxy, err := p.proxyManager.AddProxy(proxyStr)
@@ -99,9 +103,7 @@ func (p *ProxyAPI) RemoveProxy(proxyID string) (map[string]interface{}, error) {
 func (p *ProxyAPI) ConfirmRemoveProxy(proxyID string) error {
 	// Get all instances using this proxy
 	instances := p.instanceManager.GetProxyInstances(proxyID)
-
-	// Track proxy containers to remove
-	proxyContainers := make(map[string]bool)
+	removed := make(map[string]bool)
 
 	// Stop and remove all containers
 	for _, instance := range instances {
@@ -117,31 +119,34 @@ func (p *ProxyAPI) ConfirmRemoveProxy(proxyID string) error {
 				// Log error but continue
 				fmt.Printf("failed to remove container %s: %v\n", instance.ContainerID, err)
 			}
-
-			// Track proxy container (one per proxy, shared by all apps)
-			proxyContainerName := fmt.Sprintf("tun2socks_proxy_%s", p.hashProxyID(proxyID))
-			proxyContainers[proxyContainerName] = true
+			removed[instance.ContainerID] = true
 		}
 
 		// Remove from instance manager
 		p.instanceManager.RemoveInstance(instance.InstanceID)
 	}
 
-	// Remove proxy containers
-	for proxyContainerName := range proxyContainers {
-		if err := apps.RemoveProxyNetwork(proxyContainerName); err != nil {
-			fmt.Printf("failed to remove proxy container %s: %v\n", proxyContainerName, err)
+	// Remove app containers labelled with this proxy that no instance tracks
+	if containers, err := p.appsAPI.docker.ListContainers(); err == nil {
+		for _, c := range apps.ManagedContainers(containers, apps.RoleApp) {
+			if c.Labels[apps.LabelProxyID] != proxyID || removed[c.ID] {
+				continue
+			}
+			if err := p.appsAPI.RemoveApp(c.ID); err != nil {
+				fmt.Printf("failed to remove container %s: %v\n", c.Name, err)
+			}
 		}
 	}
 
+	// Remove proxy containers (one per proxy, shared by all apps)
+	if err := apps.RemoveProxySidecars(p.appsAPI.docker, proxyID); err != nil {
+		fmt.Printf("failed to remove proxy containers of %s: %v\n", proxyID, err)
+	}
+
 	// Remove proxy
 	return p.proxyManager.RemoveProxy(proxyID)
 }
 
-func (p *ProxyAPI) hashProxyID(proxyID string) string {
-	return apps.GetProxyHash(proxyID)
-}
-
 // ListProxies returns all proxies with usage stats
 func (p *ProxyAPI) ListProxies() ([]map[string]interface{}, error) {
 	proxies := p.proxyManager.ListProxies()
@@ -209,7 +214,7 @@ func (p *ProxyAPI) GetAppsRunningOnProxies(proxyIDs []string) ([]string, error)
 	for _, proxyID := range proxyIDs {
 		instances := p.instanceManager.GetProxyInstances(proxyID)
 		for _, instance := range instances {
-			if instance.Status == "running" {
+			if instance.Status == apps.StatusRunning {
 				runningApps[instance.AppID] = true
 			}
 		}
@@ -237,9 +242,10 @@ func (p *ProxyAPI) deployToSelectedApps(proxyID, proxyURL string, selectedAppIDs
 		appIDs = configuredAppIDs
 	}
 
-	// For each app, load credentials and deploy
+	// For each app, pick the credential profile with the fewest instances
+	// and deploy, so each new proxy takes the next account in turn
 	for _, appID := range appIDs {
-		creds, err := p.credentialStore.LoadCredentials(appID)
+		creds, err := p.appsAPI.leastUsedProfile(appID)
 		if err != nil {
 			// Skip apps without credentials
 			fmt.Printf("skipping app %s: credentials not found\n", appID)
@@ -247,7 +253,7 @@ func (p *ProxyAPI) deployToSelectedApps(proxyID, proxyURL string, selectedAppIDs
 		}
 
 		// Deploy app with this proxy
-		err = p.appsAPI.DeployAppWithProxyId(appID, creds.Credentials, proxyID)
+		err = p.appsAPI.DeployAppWithProfile(appID, creds.Profile, creds.Credentials, proxyID)
 		if err != nil {
 			// Log error but continue
 			fmt.Printf("failed to deploy app %s with proxy: %v\n", appID, err)
@@ -255,8 +261,9 @@ func (p *ProxyAPI) deployToSelectedApps(proxyID, proxyURL string, selectedAppIDs
 		}
 
 		deployedContainers = append(deployedContainers, map[string]interface{}{
-			"app_id": appID,
-			"status": "deployed",
+			"app_id":  appID,
+			"profile": creds.Profile,
+			"status":  "deployed",
 		})
 	}
 
@@ -7,6 +7,8 @@ import (
 	"os"
 	"path/filepath"
 	"runtime"
+
+	"bandwidth-income-manager/backend/config"
 )
 
 type AppSettings struct {
@@ -17,10 +19,14 @@ type AppSettings struct {
 type SettingsAPI struct {
 	ctx     context.Context
 	baseDir string
+	options *config.Options // startup settings, fixed until a restart
 }
 
-func NewSettingsAPI(baseDir string) *SettingsAPI {
-	return &SettingsAPI{baseDir: baseDir}
+func NewSettingsAPI(baseDir string, options *config.Options) *SettingsAPI {
+	if options == nil {
+		options = config.DefaultOptions()
+	}
+	return &SettingsAPI{baseDir: baseDir, options: options}
 }
 
 func (s *SettingsAPI) OnStartup(ctx context.Context) {
@@ -78,3 +84,24 @@ func (s *SettingsAPI) SetShowInTray(enabled bool) (bool, error) {
 	}
 	return true, nil
 }
+
+// GetEffectiveConfig returns the config file that was read and every
+// startup setting with its value and where it came from: default, file,
+// env or flag. Secrets are redacted. Changes take effect after a restart.
+func (s *SettingsAPI) GetEffectiveConfig() (map[string]interface{}, error) {
+	effective := s.options.Effective()
+	settings := make([]map[string]string, 0, len(effective))
+	for _, opt := range effective {
+		settings = append(settings, map[string]string{
+			"key":    opt.Key,
+			"value":  opt.Value,
+			"source": opt.Source,
+			"env":    opt.Env,
+			"flag":   opt.Flag,
+		})
+	}
+	return map[string]interface{}{
+		"config_file": s.options.ConfigFile,
+		"settings":    settings,
+	}, nil
+}
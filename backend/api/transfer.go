@@ -0,0 +1,337 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"maps"
+	"os"
+
+	"bandwidth-income-manager/backend/apps"
+	"bandwidth-income-manager/backend/config"
+	"bandwidth-income-manager/backend/proxy"
+	"bandwidth-income-manager/backend/state"
+	"bandwidth-income-manager/backend/transfer"
+)
+
+// TransferAPI moves the whole setup between hosts as one encrypted export
+// file: credential profiles, proxies, instances, settings and optionally
+// the instances' data
+type TransferAPI struct {
+	ctx         context.Context
+	appsAPI     *AppsAPI
+	settingsAPI *SettingsAPI
+}
+
+// NewTransferAPI creates a new TransferAPI
+func NewTransferAPI(appsAPI *AppsAPI, settingsAPI *SettingsAPI) *TransferAPI {
+	return &TransferAPI{appsAPI: appsAPI, settingsAPI: settingsAPI}
+}
+
+func (t *TransferAPI) OnStartup(ctx context.Context) {
+	t.ctx = ctx
+}
+
+// ExportState writes an export encrypted with passphrase to path. With
+// includeVolumes the data of every instance is included too.
+func (t *TransferAPI) ExportState(path, passphrase string, includeVolumes bool) (map[string]interface{}, error) {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return nil, err
+	}
+	result, err := t.export(f, passphrase, includeVolumes)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(path)
+		return nil, err
+	}
+	result["path"] = path
+	return result, nil
+}
+
+// export writes an export to w and summarizes what it holds
+func (t *TransferAPI) export(w io.Writer, passphrase string, includeVolumes bool) (map[string]interface{}, error) {
+	a := t.appsAPI
+	src := &transfer.Source{Credentials: a.credentialStore, Backups: a.backups}
+	if a.store != nil {
+		src.State = a.store.State()
+	}
+	if data, err := os.ReadFile(t.settingsAPI.settingsPath()); err == nil {
+		src.Settings = data
+	}
+
+	bundle, err := transfer.Export(w, passphrase, src, includeVolumes)
+	if err != nil {
+		return nil, err
+	}
+	a.addActivity(fmt.Sprintf("Exported %d profiles, %d proxies and %d instances", len(bundle.Credentials), len(bundle.Proxies), len(bundle.Instances)))
+	return map[string]interface{}{
+		"profiles":  len(bundle.Credentials),
+		"proxies":   len(bundle.Proxies),
+		"instances": len(bundle.Instances),
+		"snapshots": len(bundle.Snapshots),
+	}, nil
+}
+
+// PreviewImport lists what importing the export at path would add, and
+// for every item that conflicts with this host the resolutions it can
+// take: skip, overwrite or rename
+func (t *TransferAPI) PreviewImport(path, passphrase string) (map[string]interface{}, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return t.preview(f, passphrase)
+}
+
+func (t *TransferAPI) preview(r io.Reader, passphrase string) (map[string]interface{}, error) {
+	bundle, err := transfer.Read(r, passphrase, "")
+	if err != nil {
+		return nil, err
+	}
+	items, err := transfer.Preview(bundle, t.existing())
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]map[string]interface{}, 0, len(items))
+	conflicts := 0
+	for _, item := range items {
+		entry := itemMap(item)
+		if item.Conflict != "" {
+			conflicts++
+		}
+		if item.Kind == transfer.KindInstance {
+			_, entry["has_snapshot"] = bundle.Snapshots[item.Key]
+		}
+		result = append(result, entry)
+	}
+	return map[string]interface{}{
+		"created_at": bundle.CreatedAt,
+		"hostname":   bundle.Hostname,
+		"items":      result,
+		"conflicts":  conflicts,
+	}, nil
+}
+
+func itemMap(item transfer.Item) map[string]interface{} {
+	return map[string]interface{}{
+		"id":          item.ID(),
+		"kind":        item.Kind,
+		"key":         item.Key,
+		"name":        item.Name,
+		"conflict":    item.Conflict,
+		"resolutions": item.Resolutions,
+	}
+}
+
+// existing describes this host for planning an import
+func (t *TransferAPI) existing() *transfer.Existing {
+	a := t.appsAPI
+	existing := &transfer.Existing{
+		Profiles:  make(map[string]bool),
+		Proxies:   make(map[string]string),
+		Instances: make(map[string]string),
+		LocalApps: make(map[string]string),
+	}
+	if creds, err := a.credentialStore.LoadAllCredentials(); err == nil {
+		for key := range creds {
+			existing.Profiles[key] = true
+		}
+	}
+	for _, p := range a.proxyManager.ListProxies() {
+		existing.Proxies[p.ID] = p.FormatProxy()
+	}
+	for _, instance := range a.instanceManager.GetAllInstances() {
+		existing.Instances[apps.IdentityKey(instance.AppID, instance.DeviceName, instance.ProxyID)] = instance.InstanceID
+		if instance.ProxyID == "" {
+			existing.LocalApps[instance.AppID] = instance.InstanceID
+		}
+	}
+	if data, err := os.ReadFile(t.settingsAPI.settingsPath()); err == nil {
+		existing.Settings = data
+	}
+	return existing
+}
+
+// ImportState imports the export at path. resolutions maps the id of a
+// conflicting preview item to skip, overwrite or rename; conflicts left
+// out are skipped. Proxies and profiles are added first, then every
+// instance is deployed, starting from its snapshot if the export has one.
+// The result lists each item with its status: imported, skipped or failed.
+func (t *TransferAPI) ImportState(path, passphrase string, resolutions map[string]string) ([]map[string]interface{}, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return t.importState(f, passphrase, resolutions)
+}
+
+func (t *TransferAPI) importState(r io.Reader, passphrase string, resolutions map[string]string) ([]map[string]interface{}, error) {
+	a := t.appsAPI
+	// Profiles are saved and instances deployed with their credentials
+	if a.credentialStore.Locked() {
+		return nil, config.ErrLocked
+	}
+
+	dir, err := os.MkdirTemp("", "bim-import-*")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+	bundle, err := transfer.Read(r, passphrase, dir)
+	if err != nil {
+		return nil, err
+	}
+	plan, _, err := transfer.NewPlan(bundle, t.existing(), resolutions)
+	if err != nil {
+		return nil, err
+	}
+
+	results := make([]map[string]interface{}, 0)
+	report := func(item transfer.Item, err error) {
+		entry := itemMap(item)
+		entry["status"] = "imported"
+		if err != nil {
+			entry["status"] = "failed"
+			entry["error"] = err.Error()
+			fmt.Printf("failed to import %s %s: %v\n", item.Kind, item.Name, err)
+		}
+		results = append(results, entry)
+		a.emitEvent("transfer:import", entry)
+	}
+	for _, item := range plan.Skipped {
+		entry := itemMap(item)
+		entry["status"] = "skipped"
+		results = append(results, entry)
+	}
+
+	if plan.Settings != nil {
+		report(transfer.Item{Kind: transfer.KindSettings, Key: transfer.KindSettings, Name: "application settings"}, t.applySettings(plan.Settings))
+	}
+	for _, planned := range plan.Profiles {
+		creds := planned.Credentials
+		report(planned.Item, a.credentialStore.SaveCredentials(&creds))
+	}
+	for _, planned := range plan.Proxies {
+		p := planned.Proxy
+		a.proxyManager.PutProxy(&proxy.Proxy{
+			ID:       p.ID,
+			Original: p.Original,
+			Protocol: p.Protocol,
+			Host:     p.Host,
+			Port:     p.Port,
+			Username: p.Username,
+			Password: p.Password,
+		})
+		report(planned.Item, nil)
+	}
+	t.importGenerated(bundle.Generated)
+	for _, planned := range plan.Instances {
+		report(planned.Item, t.importInstance(planned))
+	}
+
+	a.addActivity(fmt.Sprintf("Imported export from %s created %s", bundle.Hostname, bundle.CreatedAt.Format("2006-01-02 15:04")))
+	return results, nil
+}
+
+// applySettings applies imported settings through the setters, so
+// autostart is registered with the system too
+func (t *TransferAPI) applySettings(data json.RawMessage) error {
+	var settings AppSettings
+	if err := json.Unmarshal(data, &settings); err != nil {
+		return fmt.Errorf("invalid settings: %w", err)
+	}
+	if _, err := t.settingsAPI.SetAutoStart(settings.AutoStart); err != nil {
+		return err
+	}
+	_, err := t.settingsAPI.SetShowInTray(settings.ShowInTray)
+	return err
+}
+
+// importGenerated adds the generated values of app identities this host
+// has none for, so redeploying a removed instance keeps its node identity
+func (t *TransferAPI) importGenerated(generated map[string]map[string]string) {
+	a := t.appsAPI
+	if a.store == nil || len(generated) == 0 {
+		return
+	}
+	err := a.store.Update(func(s *state.State) {
+		if s.Generated == nil {
+			s.Generated = make(map[string]map[string]string)
+		}
+		for key, values := range generated {
+			if _, exists := s.Generated[key]; !exists {
+				s.Generated[key] = maps.Clone(values)
+			}
+		}
+	})
+	if err != nil {
+		fmt.Printf("failed to save generated values: %v\n", err)
+	}
+}
+
+// importInstance deploys an instance of an export
+func (t *TransferAPI) importInstance(planned transfer.PlannedInstance) error {
+	a := t.appsAPI
+	inst := planned.Instance
+	if apps.GetAppManifest(inst.AppID) == nil {
+		return fmt.Errorf("app not found: %s", inst.AppID)
+	}
+
+	if planned.Replace != "" {
+		// The replaced instance's data is kept as a removal snapshot
+		if err := a.RemoveAppInstance(planned.Replace); err != nil {
+			return fmt.Errorf("failed to remove instance %s: %w", planned.Replace, err)
+		}
+	}
+
+	// The instance keeps its node identity unless it was renamed
+	identity := apps.IdentityKey(inst.AppID, inst.DeviceName, inst.ProxyID)
+	a.saveGenerated(identity, inst.Generated)
+
+	if planned.Snapshot != "" {
+		backups, err := a.backupManager()
+		if err != nil {
+			return err
+		}
+		meta, err := backups.Import(planned.Snapshot)
+		if err != nil {
+			return err
+		}
+		if err := backups.Extract(meta.ID, apps.ContainerName(inst.AppID, inst.DeviceName, inst.ProxyID)); err != nil {
+			return err
+		}
+	}
+
+	var formData map[string]string
+	if profile, err := a.credentialStore.LoadProfile(inst.AppID, inst.Profile); err == nil {
+		formData = maps.Clone(profile.Credentials)
+	}
+	if formData == nil {
+		formData = make(map[string]string)
+	}
+	formData["DEVICE_NAME"] = inst.DeviceName
+	if err := a.DeployAppWithProfile(inst.AppID, inst.Profile, formData, inst.ProxyID); err != nil {
+		return err
+	}
+
+	if inst.ResourceLimits == nil {
+		return nil
+	}
+	for _, instance := range a.instanceManager.GetAppInstances(inst.AppID) {
+		if apps.IdentityKey(instance.AppID, instance.DeviceName, instance.ProxyID) == identity {
+			return a.UpdateInstanceResourceLimits(instance.InstanceID, map[string]string{
+				"cpus":               inst.ResourceLimits.CPUs,
+				"memory_reservation": inst.ResourceLimits.MemoryReservation,
+				"memory_limit":       inst.ResourceLimits.MemoryLimit,
+			})
+		}
+	}
+	return nil
+}
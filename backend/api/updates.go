@@ -0,0 +1,64 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"bandwidth-income-manager/backend/apps"
+)
+
+func imageUpdateMap(update apps.ImageUpdate) map[string]interface{} {
+	return map[string]interface{}{
+		"app_id":        update.AppID,
+		"image":         update.Image,
+		"version":       update.Version(),
+		"local_digest":  update.LocalDigest,
+		"remote_digest": update.RemoteDigest,
+		"instances":     update.Instances,
+		"checked_at":    update.CheckedAt.Format(time.RFC3339),
+	}
+}
+
+func imageUpdateMaps(updates []apps.ImageUpdate) []map[string]interface{} {
+	result := make([]map[string]interface{}, 0, len(updates))
+	for _, update := range updates {
+		result = append(result, imageUpdateMap(update))
+	}
+	return result
+}
+
+func (a *AppsAPI) updateChecker() (*apps.UpdateChecker, error) {
+	if a.updates == nil {
+		return nil, fmt.Errorf("image update checks are not available")
+	}
+	return a.updates, nil
+}
+
+// onUpdateAvailable records a newly found image update and tells the
+// frontend about it
+func (a *AppsAPI) onUpdateAvailable(update apps.ImageUpdate) {
+	a.addActivity(fmt.Sprintf("Update available for %s: %s", update.AppID, update.Version()))
+	a.emitEvent("update:available", imageUpdateMap(update))
+}
+
+// GetImageUpdates returns the app image updates found by the last checks
+func (a *AppsAPI) GetImageUpdates() ([]map[string]interface{}, error) {
+	updates, err := a.updateChecker()
+	if err != nil {
+		return nil, err
+	}
+	return imageUpdateMaps(updates.Updates()), nil
+}
+
+// CheckImageUpdates asks the registries for newer app images now and
+// returns all pending updates. Apps whose registry could not be reached
+// keep their previous result and are named in the error.
+func (a *AppsAPI) CheckImageUpdates() ([]map[string]interface{}, error) {
+	updates, err := a.updateChecker()
+	if err != nil {
+		return nil, err
+	}
+	found, err := updates.Check(context.Background())
+	return imageUpdateMaps(found), err
+}
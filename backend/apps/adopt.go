@@ -0,0 +1,193 @@
+package apps
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"bandwidth-income-manager/backend/docker"
+)
+
+// KnownProxy is a configured proxy that containers can be attributed to
+type KnownProxy struct {
+	ID  string
+	URL string
+}
+
+// AdoptionReport lists what AdoptContainers did
+type AdoptionReport struct {
+	Adopted      []string          // instance IDs of adopted containers
+	Unattributed map[string]string // container name -> why it could not be adopted
+}
+
+// AdoptContainers rebuilds instance records for app containers that are
+// already running, so a restarted manager picks up where it left off.
+// Containers are recognized by their labels or, for containers created
+// before labels existed, by the <device>_<app>_local and
+// <device>_<app>_proxy<hash> naming. Containers already tracked by im are
+// left alone.
+func AdoptContainers(rt docker.Runtime, im *InstanceManager, proxies []KnownProxy) (*AdoptionReport, error) {
+	containers, err := rt.ListContainers()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list containers: %w", err)
+	}
+
+	proxiesByID := make(map[string]KnownProxy, len(proxies))
+	proxiesByHash := make(map[string]KnownProxy, len(proxies))
+	for _, p := range proxies {
+		proxiesByID[p.ID] = p
+		proxiesByHash[GetProxyHash(p.ID)] = p
+	}
+
+	report := &AdoptionReport{Unattributed: make(map[string]string)}
+	for _, c := range containers {
+		if _, err := im.GetInstanceByContainerID(c.ID); err == nil {
+			continue
+		}
+
+		instance, reason := attributeContainer(c, proxiesByID, proxiesByHash)
+		if instance == nil {
+			if reason != "" {
+				report.Unattributed[c.Name] = reason
+			}
+			continue
+		}
+
+		if err := fillFromContainer(rt, instance); err != nil {
+			report.Unattributed[c.Name] = err.Error()
+			continue
+		}
+		if _, err := im.GetInstance(instance.InstanceID); err == nil {
+			report.Unattributed[c.Name] = fmt.Sprintf("instance %s already exists", instance.InstanceID)
+			continue
+		}
+		instance.Status = containerStatus(c)
+
+		if err := im.AddInstance(instance); err != nil {
+			report.Unattributed[c.Name] = err.Error()
+			continue
+		}
+		report.Adopted = append(report.Adopted, instance.InstanceID)
+	}
+
+	sort.Strings(report.Adopted)
+	return report, nil
+}
+
+// attributeContainer works out which app instance a container belongs to.
+// It returns a nil instance and an empty reason for containers that are not
+// ours at all, such as proxy sidecars or unrelated containers.
+func attributeContainer(c docker.ContainerInfo, proxiesByID, proxiesByHash map[string]KnownProxy) (*AppInstance, string) {
+	if c.Labels[LabelManager] != "" {
+		if !IsManaged(c.Labels) || c.Labels[LabelRole] != RoleApp {
+			return nil, ""
+		}
+		appID := c.Labels[LabelAppID]
+		if GetAppManifest(appID) == nil {
+			return nil, fmt.Sprintf("unknown app %q in labels", appID)
+		}
+		instance := &AppInstance{
+			InstanceID:  c.Labels[LabelInstanceID],
+			AppID:       appID,
+			ProxyID:     c.Labels[LabelProxyID],
+			Profile:     c.Labels[LabelProfile],
+			ContainerID: c.ID,
+		}
+		if instance.ProxyID != "" {
+			// The label is authoritative even if the proxy is gone
+			instance.ProxyURL = proxiesByID[instance.ProxyID].URL
+		}
+		return instance, ""
+	}
+
+	// Legacy naming: <device>_<app>_local or <device>_<app>_proxy<hash>
+	rest, suffix, ok := cutLast(c.Name, "_")
+	if !ok || (suffix != "local" && !strings.HasPrefix(suffix, "proxy")) {
+		return nil, ""
+	}
+	device, appID, ok := cutLast(rest, "_")
+	if !ok || device == "" || GetAppManifest(appID) == nil {
+		return nil, ""
+	}
+
+	instance := &AppInstance{
+		AppID:       appID,
+		ContainerID: c.ID,
+		DeviceName:  device,
+	}
+	if suffix != "local" {
+		proxy, found := proxiesByHash[strings.TrimPrefix(suffix, "proxy")]
+		if !found {
+			return nil, fmt.Sprintf("no configured proxy matches hash %s", strings.TrimPrefix(suffix, "proxy"))
+		}
+		instance.ProxyID = proxy.ID
+		instance.ProxyURL = proxy.URL
+	}
+	return instance, ""
+}
+
+// fillFromContainer completes an instance from the container's settings
+func fillFromContainer(rt docker.Runtime, instance *AppInstance) error {
+	details, err := rt.InspectContainer(instance.ContainerID)
+	if err != nil {
+		return fmt.Errorf("failed to inspect container: %w", err)
+	}
+
+	env := details.Config.Env
+	if image, err := rt.InspectImage(details.ImageID); err == nil {
+		env = withoutImageEnv(env, image.Env)
+	}
+	instance.Credentials = make(map[string]string)
+	for _, e := range env {
+		key, value, _ := strings.Cut(e, "=")
+		switch key {
+		case "HTTP_PROXY", "HTTPS_PROXY", "ALL_PROXY":
+			// Added by DeployApp, not user input
+			continue
+		}
+		instance.Credentials[key] = value
+	}
+
+	if manifest := GetAppManifest(instance.AppID); manifest != nil {
+		// Keep the node identity if the instance is ever redeployed
+		for field := range manifest.AutoGenerateFields {
+			if value := instance.Credentials[field]; value != "" {
+				if instance.Generated == nil {
+					instance.Generated = make(map[string]string)
+				}
+				instance.Generated[field] = value
+			}
+		}
+	}
+	if device := instance.Credentials["DEVICE_NAME"]; device != "" {
+		instance.DeviceName = device
+	}
+	if uuid := instance.Credentials["EARNAPP_UUID"]; strings.HasPrefix(uuid, "sdk-node-") {
+		instance.SDKNodeID = uuid
+	}
+	if !details.Config.Resources.IsZero() {
+		instance.ResourceLimits = &ResourceLimits{
+			CPUs:              docker.FormatCPUs(details.Config.Resources.NanoCPUs),
+			MemoryReservation: docker.FormatMemory(details.Config.Resources.MemoryReservation),
+			MemoryLimit:       docker.FormatMemory(details.Config.Resources.Memory),
+		}
+	}
+	if instance.InstanceID == "" {
+		// Same format as a fresh deployment, dated by the container start
+		started := details.StartedAt
+		if started.IsZero() {
+			started = time.Now()
+		}
+		instance.InstanceID = NewInstanceID(instance.AppID, instance.DeviceName, started)
+	}
+	return nil
+}
+
+// cutLast slices s around the last instance of sep
+func cutLast(s, sep string) (before, after string, found bool) {
+	if i := strings.LastIndex(s, sep); i >= 0 {
+		return s[:i], s[i+len(sep):], true
+	}
+	return s, "", false
+}
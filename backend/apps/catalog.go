@@ -0,0 +1,131 @@
+package apps
+
+import (
+	"bytes"
+	"embed"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+	"sync"
+
+	"gopkg.in/yaml.v3"
+)
+
+// The built-in catalog, one <app_id>.yaml manifest per app. Files in the
+// user's configs/apps directory are layered on top of these by the config
+// loader, so an app can be added or patched without a rebuild.
+//
+//go:embed catalog/*.yaml
+var defaultCatalog embed.FS
+
+// catalog holds the manifests apps are deployed from
+var catalog = struct {
+	mu        sync.RWMutex
+	manifests map[string]*AppManifest
+}{manifests: mustLoadDefaultManifests()}
+
+// DefaultManifestSources returns the raw YAML of the built-in manifests,
+// keyed by app ID
+func DefaultManifestSources() (map[string][]byte, error) {
+	entries, err := defaultCatalog.ReadDir("catalog")
+	if err != nil {
+		return nil, err
+	}
+
+	sources := make(map[string][]byte, len(entries))
+	for _, entry := range entries {
+		data, err := defaultCatalog.ReadFile(path.Join("catalog", entry.Name()))
+		if err != nil {
+			return nil, err
+		}
+		sources[strings.TrimSuffix(entry.Name(), ".yaml")] = data
+	}
+	return sources, nil
+}
+
+// ParseManifest decodes a manifest from one or more YAML documents. Each
+// document is applied on top of the previous ones: fields it sets replace
+// the earlier value, maps are merged key by key and fields it leaves out
+// are kept. This is how a user file patches a built-in manifest.
+//
+// Unknown fields are rejected. Errors are ValidationErrors whose line
+// numbers refer to the last document. A document in the config format of
+// earlier versions is upgraded first, see upgradeLegacy.
+func ParseManifest(docs ...[]byte) (*AppManifest, error) {
+	manifest := &AppManifest{}
+	var last *yaml.Node
+	for i, doc := range docs {
+		var root yaml.Node
+		if err := yaml.Unmarshal(doc, &root); err != nil {
+			return nil, decodeErrors(err)
+		}
+		// Configs written for earlier versions are read as manifests
+		upgraded, err := upgradeLegacy(&root, i > 0)
+		if err != nil {
+			return nil, decodeErrors(err)
+		}
+		if upgraded {
+			if doc, err = yaml.Marshal(&root); err != nil {
+				return nil, err
+			}
+		}
+		dec := yaml.NewDecoder(bytes.NewReader(doc))
+		dec.KnownFields(true)
+		if err := dec.Decode(manifest); err != nil && err != io.EOF {
+			return nil, decodeErrors(err)
+		}
+		last = &root
+	}
+
+	if err := manifest.Validate(); err != nil {
+		var errs ValidationErrors
+		if errors.As(err, &errs) {
+			errs.locate(last)
+		}
+		return nil, err
+	}
+	return manifest, nil
+}
+
+// DefaultManifests returns the built-in manifests, keyed by app ID
+func DefaultManifests() (map[string]*AppManifest, error) {
+	sources, err := DefaultManifestSources()
+	if err != nil {
+		return nil, err
+	}
+
+	manifests := make(map[string]*AppManifest, len(sources))
+	for appID, data := range sources {
+		manifest, err := ParseManifest(data)
+		if err != nil {
+			return nil, fmt.Errorf("built-in manifest %s: %w", appID, err)
+		}
+		if manifest.ID != appID {
+			return nil, fmt.Errorf("built-in manifest %s.yaml has app_id %q", appID, manifest.ID)
+		}
+		manifests[appID] = manifest
+	}
+	return manifests, nil
+}
+
+func mustLoadDefaultManifests() map[string]*AppManifest {
+	manifests, err := DefaultManifests()
+	if err != nil {
+		panic(err)
+	}
+	return manifests
+}
+
+// SetManifests replaces the active catalog
+func SetManifests(manifests map[string]*AppManifest) {
+	active := make(map[string]*AppManifest, len(manifests))
+	for appID, manifest := range manifests {
+		active[appID] = manifest.clone()
+	}
+
+	catalog.mu.Lock()
+	catalog.manifests = active
+	catalog.mu.Unlock()
+}
@@ -3,108 +3,127 @@ package apps
 import (
 	"crypto/sha256"
 	"fmt"
-	"os/exec"
-	"strings"
+
+	"bandwidth-income-manager/backend/docker"
 )
 
+// DefaultRestartPolicy is the restart policy of app and proxy containers
+// that do not set one
+var DefaultRestartPolicy = "always"
+
 // AppDeployment represents an app deployment configuration
 type AppDeployment struct {
-	AppID         string
-	ProxyID       string
-	ProxyURL      string
-	DeviceName    string
-	Image         string
-	Environment   []string
-	Volumes       []string
-	Ports         []string
-	Command       string
-	RestartPolicy string
-	NetworkMode   string
-	ContainerName string
+	AppID          string
+	InstanceID     string
+	ProxyID        string
+	ProxyURL       string
+	DeviceName     string
+	Profile        string // credential profile, recorded in a label
+	Image          string
+	Environment    []string
+	SecretEnv      []string // keys of Environment entries holding secrets
+	Volumes        []string // as in the manifest; isolated per container on deploy
+	SharedVolumes  []string
+	Ports          []string
+	Command        []string // already expanded, see AppManifest.Render
+	SecretCommand  bool     // Command refers to secrets, see AppManifest.SecretCommand
+	RestartPolicy  string
+	NetworkMode    string
+	ContainerName  string
+	ResourceLimits *ResourceLimits
 }
 
-// DeployApp deploys an app using Docker CLI
-func DeployApp(deployment *AppDeployment) (string, error) {
+// DeployApp deploys an app through the given Docker runtime
+func DeployApp(rt docker.Runtime, deployment *AppDeployment) (string, error) {
 	// Generate container name if not provided
 	containerName := deployment.ContainerName
 	if containerName == "" {
-		containerName = getContainerName(deployment.AppID, deployment.DeviceName, deployment.ProxyID)
+		containerName = ContainerName(deployment.AppID, deployment.DeviceName, deployment.ProxyID)
 	}
 
 	// First, pull the image
-	if err := pullImage(deployment.Image); err != nil {
+	if err := rt.PullImage(deployment.Image); err != nil {
 		return "", fmt.Errorf("failed to pull image: %w", err)
 	}
 
-	// Build docker run command
-	args := []string{"run", "-d", "--name", containerName}
-
-	// Add restart policy
-	if deployment.RestartPolicy != "" {
-		args = append(args, "--restart", deployment.RestartPolicy)
-	} else {
-		args = append(args, "--restart", "always")
+	// A local instance takes over the data earlier versions kept in the
+	// directories all instances shared
+	if deployment.ProxyID == "" {
+		if err := adoptSharedVolumes(deployment.Volumes, deployment.SharedVolumes, containerName); err != nil {
+			return "", err
+		}
 	}
 
-	// Add environment variables
-	for _, env := range deployment.Environment {
-		args = append(args, "-e", env)
+	config, err := newContainerConfig(rt, containerName, deployment)
+	if err != nil {
+		return "", err
 	}
 
 	// Add proxy environment variables if proxy is configured
 	if deployment.ProxyURL != "" {
-		args = append(args, "-e", fmt.Sprintf("HTTP_PROXY=%s", deployment.ProxyURL))
-		args = append(args, "-e", fmt.Sprintf("HTTPS_PROXY=%s", deployment.ProxyURL))
-		args = append(args, "-e", fmt.Sprintf("ALL_PROXY=%s", deployment.ProxyURL))
+		config.Env = append(config.Env,
+			fmt.Sprintf("HTTP_PROXY=%s", deployment.ProxyURL),
+			fmt.Sprintf("HTTPS_PROXY=%s", deployment.ProxyURL),
+			fmt.Sprintf("ALL_PROXY=%s", deployment.ProxyURL),
+		)
+		if hasPassword(deployment.ProxyURL) {
+			addSecretEnv(config, proxyEnvKeys...)
+		}
 	}
 
-	// Add volumes
-	for _, vol := range deployment.Volumes {
-		args = append(args, "-v", vol)
-	}
+	// Add network mode
+	config.NetworkMode = deployment.NetworkMode
 
-	// Add ports
-	for _, p := range deployment.Ports {
-		args = append(args, "-p", p)
+	containerID, err := docker.RunContainer(rt, config)
+	if err != nil {
+		return "", fmt.Errorf("failed to create container: %w", err)
 	}
 
-	// Add network mode
-	if deployment.NetworkMode != "" {
-		args = append(args, "--network", deployment.NetworkMode)
-	}
+	return containerID, nil
+}
 
-	// Add command
-	if deployment.Command != "" {
-		args = append(args, deployment.Image)
-		args = append(args, strings.Split(deployment.Command, " ")...)
-	} else {
-		args = append(args, deployment.Image)
+// newContainerConfig builds the container settings shared by direct and
+// proxied deployments. The image must be pulled already.
+func newContainerConfig(rt docker.Runtime, containerName string, deployment *AppDeployment) (*docker.ContainerConfig, error) {
+	resources, err := deployment.ResourceLimits.Resources()
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidLimits, err)
 	}
 
-	// Run docker command
-	cmd := exec.Command("docker", args...)
-	output, err := cmd.CombinedOutput()
-	if err != nil {
-		return "", fmt.Errorf("failed to create container: %w, output: %s", err, string(output))
+	config := &docker.ContainerConfig{
+		Name:          containerName,
+		Image:         deployment.Image,
+		Env:           append([]string{}, deployment.Environment...),
+		Volumes:       isolateVolumes(deployment.Volumes, deployment.SharedVolumes, containerName),
+		Ports:         deployment.Ports,
+		RestartPolicy: deployment.RestartPolicy,
+		Labels:        appLabels(deployment),
+		Resources:     resources,
 	}
 
-	// Extract container ID from output
-	containerID := strings.TrimSpace(string(output))
+	addSecretEnv(config, deployment.SecretEnv...)
 
-	return containerID, nil
-}
+	// Add restart policy
+	if config.RestartPolicy == "" {
+		config.RestartPolicy = DefaultRestartPolicy
+	}
 
-// pullImage pulls a Docker image
-func pullImage(image string) error {
-	cmd := exec.Command("docker", "pull", image)
-	if err := cmd.Run(); err != nil {
-		return fmt.Errorf("failed to pull image %s: %w", image, err)
+	// Add command
+	if len(deployment.Command) > 0 {
+		config.Cmd = append([]string{}, deployment.Command...)
 	}
-	return nil
+	if deployment.SecretCommand {
+		if err := wrapSecretCommand(rt, config); err != nil {
+			return nil, err
+		}
+	}
+
+	return config, nil
 }
 
-// getContainerName generates container name from app ID, device name, and proxy ID
-func getContainerName(appID, deviceName, proxyID string) string {
+// ContainerName returns the container name of an app instance from app ID,
+// device name, and proxy ID
+func ContainerName(appID, deviceName, proxyID string) string {
 	if proxyID == "" {
 		// Local instance
 		return fmt.Sprintf("%s_%s_local", deviceName, appID)
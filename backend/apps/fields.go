@@ -0,0 +1,173 @@
+package apps
+
+import (
+	"fmt"
+	"maps"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// FieldType tells the frontend which input to show for a form field and
+// the backend how to check its value
+type FieldType string
+
+const (
+	FieldText       FieldType = "text"
+	FieldEmail      FieldType = "email"
+	FieldPassword   FieldType = "password"
+	FieldToken      FieldType = "token"
+	FieldUUID       FieldType = "uuid"
+	FieldPort       FieldType = "port"
+	FieldDeviceName FieldType = "device_name"
+)
+
+var fieldTypes = []FieldType{FieldText, FieldEmail, FieldPassword, FieldToken, FieldUUID, FieldPort, FieldDeviceName}
+
+var (
+	emailPattern = regexp.MustCompile(`^[^@\s]+@[^@\s]+\.[^@\s]+$`)
+	uuidPattern  = regexp.MustCompile(`^[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}$`)
+	// Device names end up in container names, so they follow Docker's rules
+	deviceNamePattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_.-]{0,62}$`)
+)
+
+// FieldSpec describes one form field of an app
+type FieldSpec struct {
+	Type     FieldType `yaml:"type" json:"type"`
+	Label    string    `yaml:"label,omitempty" json:"label,omitempty"`
+	Required bool      `yaml:"required,omitempty" json:"required"`
+	Pattern  string    `yaml:"pattern,omitempty" json:"pattern,omitempty"` // must match the whole value
+	Default  string    `yaml:"default,omitempty" json:"default,omitempty"`
+	Secret   bool      `yaml:"secret,omitempty" json:"secret"`
+	Help     string    `yaml:"help,omitempty" json:"help,omitempty"`
+}
+
+// FieldSpecs returns the form fields of the app keyed by name. Entries of
+// the older required_fields list that the fields section does not describe
+// get a spec with the type guessed from their name.
+func (m *AppManifest) FieldSpecs() map[string]*FieldSpec {
+	specs := make(map[string]*FieldSpec, len(m.Fields)+len(m.RequiredFields))
+	for name, required := range m.RequiredFields {
+		fieldType := inferFieldType(name)
+		specs[name] = &FieldSpec{
+			Type:     fieldType,
+			Required: required,
+			Secret:   fieldType == FieldPassword || fieldType == FieldToken,
+		}
+	}
+	for name, spec := range m.Fields {
+		if spec != nil {
+			specCopy := *spec
+			specs[name] = &specCopy
+		}
+	}
+	return specs
+}
+
+// inferFieldType guesses the type of a field from its name
+func inferFieldType(name string) FieldType {
+	switch {
+	case name == "DEVICE_NAME":
+		return FieldDeviceName
+	case strings.HasSuffix(name, "EMAIL"):
+		return FieldEmail
+	case strings.HasSuffix(name, "PASSWORD"), strings.HasSuffix(name, "_PASS"):
+		return FieldPassword
+	case strings.HasSuffix(name, "TOKEN"), strings.HasSuffix(name, "APIKEY"), strings.HasSuffix(name, "API_KEY"):
+		return FieldToken
+	case strings.HasSuffix(name, "UUID"):
+		return FieldUUID
+	case strings.Contains(name, "PORT"):
+		return FieldPort
+	default:
+		return FieldText
+	}
+}
+
+// FieldErrors maps form fields to what is wrong with their value
+type FieldErrors map[string]string
+
+func (e FieldErrors) Error() string {
+	messages := make([]string, 0, len(e))
+	for _, name := range slices.Sorted(maps.Keys(e)) {
+		messages = append(messages, name+": "+e[name])
+	}
+	return "invalid form data: " + strings.Join(messages, "; ")
+}
+
+// ValidateFormData checks form data against the app's field specs. It
+// returns a copy of formData with defaults filled in for empty fields, or a
+// FieldErrors listing every bad field. Values for fields without a spec
+// are passed through unchecked.
+func (m *AppManifest) ValidateFormData(formData map[string]string) (map[string]string, error) {
+	result := maps.Clone(formData)
+	if result == nil {
+		result = make(map[string]string)
+	}
+
+	errs := FieldErrors{}
+	for name, spec := range m.FieldSpecs() {
+		value := result[name]
+		if spec.Type != FieldPassword {
+			// Passwords may legitimately start or end with a space
+			value = strings.TrimSpace(value)
+		}
+		if value == "" && spec.Default != "" {
+			value = spec.Default
+		}
+		if value == "" {
+			if spec.Required {
+				errs[name] = "is required"
+			}
+			continue
+		}
+		if err := spec.Check(value); err != nil {
+			errs[name] = err.Error()
+			continue
+		}
+		result[name] = value
+	}
+
+	if len(errs) > 0 {
+		return nil, errs
+	}
+	return result, nil
+}
+
+// Check validates a non-empty value against the field's type and pattern
+func (s *FieldSpec) Check(value string) error {
+	switch s.Type {
+	case FieldEmail:
+		if !emailPattern.MatchString(value) {
+			return fmt.Errorf("must be an email address")
+		}
+	case FieldToken:
+		if strings.ContainsAny(value, " \t\r\n") {
+			return fmt.Errorf("must not contain whitespace")
+		}
+	case FieldUUID:
+		if !uuidPattern.MatchString(value) {
+			return fmt.Errorf("must be a UUID like 123e4567-e89b-12d3-a456-426614174000")
+		}
+	case FieldPort:
+		if port, err := strconv.Atoi(value); err != nil || port < 1 || port > 65535 {
+			return fmt.Errorf("must be a port number between 1 and 65535")
+		}
+	case FieldDeviceName:
+		if !deviceNamePattern.MatchString(value) {
+			return fmt.Errorf("must be up to 63 letters, digits, '.', '_' or '-', starting with a letter or digit")
+		}
+	}
+
+	if s.Pattern != "" {
+		re, err := regexp.Compile(`^(?:` + s.Pattern + `)$`)
+		if err != nil {
+			return fmt.Errorf("invalid pattern in app manifest: %v", err)
+		}
+		if !re.MatchString(value) {
+			return fmt.Errorf("does not match the expected format %s", s.Pattern)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,171 @@
+package apps
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"math/big"
+	"net"
+	"slices"
+	"strconv"
+)
+
+// Built-in generator names for auto_generate_fields
+const (
+	GeneratorRandom  = "random"  // crypto-random string from charset
+	GeneratorUUID    = "uuid"    // RFC 4122 version 4 UUID
+	GeneratorHex     = "hex"     // crypto-random lowercase hex
+	GeneratorPort    = "port"    // free TCP port on this host
+	GeneratorDerived = "derived" // device name, or hex hash of app, field, device and proxy
+)
+
+const defaultCharset = "abcdefghijklmnopqrstuvwxyz0123456789"
+
+// GeneratorContext is what a generator can base a value on
+type GeneratorContext struct {
+	AppID      string
+	Field      string
+	DeviceName string
+	ProxyID    string
+	Config     AutoGenerateConfig
+}
+
+// Generator produces the value of an auto-generated field, without the
+// configured prefix
+type Generator func(ctx GeneratorContext) (string, error)
+
+var generators = map[string]Generator{
+	GeneratorRandom:  generateRandom,
+	GeneratorUUID:    generateUUID,
+	GeneratorHex:     generateHex,
+	GeneratorPort:    generatePort,
+	GeneratorDerived: generateDerived,
+}
+
+// RegisterGenerator makes a generator available to manifests under name.
+// It is meant to be called from init functions.
+func RegisterGenerator(name string, generator Generator) {
+	generators[name] = generator
+}
+
+// GeneratorNames returns the names of all registered generators
+func GeneratorNames() []string {
+	names := make([]string, 0, len(generators))
+	for name := range generators {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	return names
+}
+
+// IdentityKey identifies the node an app runs as on a device and proxy;
+// generated values are saved under it
+func IdentityKey(appID, deviceName, proxyID string) string {
+	return appID + "/" + deviceName + "/" + proxyID
+}
+
+// GenerateFields returns a value for every auto-generated field of the app.
+// Values in existing, such as those saved with an earlier deployment of the
+// same device and proxy, are kept so the node keeps its identity.
+func (m *AppManifest) GenerateFields(deviceName, proxyID string, existing map[string]string) (map[string]string, error) {
+	values := make(map[string]string, len(m.AutoGenerateFields))
+	for field, config := range m.AutoGenerateFields {
+		if value := existing[field]; value != "" {
+			values[field] = value
+			continue
+		}
+
+		name := config.Generator
+		if name == "" {
+			name = GeneratorRandom
+		}
+		generator, ok := generators[name]
+		if !ok {
+			return nil, fmt.Errorf("%s: unknown generator %q", field, name)
+		}
+		value, err := generator(GeneratorContext{
+			AppID:      m.ID,
+			Field:      field,
+			DeviceName: deviceName,
+			ProxyID:    proxyID,
+			Config:     *config,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to generate %s: %w", field, err)
+		}
+		values[field] = config.Prefix + value
+	}
+	return values, nil
+}
+
+func lengthOr(config AutoGenerateConfig, fallback int) int {
+	if config.Length > 0 {
+		return config.Length
+	}
+	return fallback
+}
+
+func generateRandom(ctx GeneratorContext) (string, error) {
+	charset := ctx.Config.Charset
+	if charset == "" {
+		charset = defaultCharset
+	}
+	chars := []rune(charset)
+	max := big.NewInt(int64(len(chars)))
+
+	result := make([]rune, lengthOr(ctx.Config, 32))
+	for i := range result {
+		n, err := rand.Int(rand.Reader, max)
+		if err != nil {
+			return "", err
+		}
+		result[i] = chars[n.Int64()]
+	}
+	return string(result), nil
+}
+
+func generateUUID(GeneratorContext) (string, error) {
+	var b [16]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return "", err
+	}
+	b[6] = b[6]&0x0f | 0x40 // version 4
+	b[8] = b[8]&0x3f | 0x80 // RFC 4122 variant
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:16]), nil
+}
+
+func generateHex(ctx GeneratorContext) (string, error) {
+	length := lengthOr(ctx.Config, 32)
+	b := make([]byte, (length+1)/2)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b)[:length], nil
+}
+
+// generatePort asks the OS for a TCP port that is free right now
+func generatePort(GeneratorContext) (string, error) {
+	listener, err := net.Listen("tcp", ":0")
+	if err != nil {
+		return "", err
+	}
+	defer listener.Close()
+	return strconv.Itoa(listener.Addr().(*net.TCPAddr).Port), nil
+}
+
+// generateDerived hashes the app, field, device name and proxy, so the same
+// device behind the same proxy always gets the same value. A device without
+// a proxy gets its name unhashed, the value earlier versions put in the
+// manifest directly, so existing nodes keep their identity.
+func generateDerived(ctx GeneratorContext) (string, error) {
+	if ctx.ProxyID == "" && ctx.DeviceName != "" {
+		return ctx.DeviceName, nil
+	}
+	length := lengthOr(ctx.Config, 16)
+	if length > sha256.Size*2 {
+		return "", fmt.Errorf("derived values are at most %d characters", sha256.Size*2)
+	}
+	sum := sha256.Sum256([]byte(ctx.AppID + "\x00" + ctx.Field + "\x00" + ctx.DeviceName + "\x00" + ctx.ProxyID))
+	return hex.EncodeToString(sum[:])[:length], nil
+}
@@ -0,0 +1,53 @@
+package apps
+
+import "testing"
+
+func TestGenerateDerived(t *testing.T) {
+	base := GeneratorContext{AppID: "grass", Field: "NODE_ID", DeviceName: "box", ProxyID: "proxy1"}
+	first, err := generateDerived(base)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(first) != 16 {
+		t.Fatalf("derived value %q has %d characters, want 16", first, len(first))
+	}
+
+	tests := []struct {
+		name string
+		ctx  GeneratorContext
+		same bool
+	}{
+		{"same inputs", base, true},
+		{"other app", GeneratorContext{AppID: "dawn", Field: "NODE_ID", DeviceName: "box", ProxyID: "proxy1"}, false},
+		{"other field", GeneratorContext{AppID: "grass", Field: "OTHER", DeviceName: "box", ProxyID: "proxy1"}, false},
+		{"other device", GeneratorContext{AppID: "grass", Field: "NODE_ID", DeviceName: "pc", ProxyID: "proxy1"}, false},
+		{"other proxy", GeneratorContext{AppID: "grass", Field: "NODE_ID", DeviceName: "box", ProxyID: "proxy2"}, false},
+		// The separator keeps shifted boundaries apart
+		{"shifted boundary", GeneratorContext{AppID: "gras", Field: "sNODE_ID", DeviceName: "box", ProxyID: "proxy1"}, false},
+	}
+	for _, tt := range tests {
+		got, err := generateDerived(tt.ctx)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if (got == first) != tt.same {
+			t.Fatalf("%s: derived value %q, base %q, want same = %v", tt.name, got, first, tt.same)
+		}
+	}
+
+	// Local instances keep the device name earlier versions used
+	local, err := generateDerived(GeneratorContext{AppID: "pawns", Field: "DEVICE_ID", DeviceName: "box"})
+	if err != nil || local != "box" {
+		t.Fatalf("derived value of a local instance = %q, %v, want %q", local, err, "box")
+	}
+
+	long := base
+	long.Config.Length = 64
+	if got, err := generateDerived(long); err != nil || len(got) != 64 {
+		t.Fatalf("derived value of length 64 = %q, %v", got, err)
+	}
+	long.Config.Length = 65
+	if _, err := generateDerived(long); err == nil {
+		t.Fatal("derived value longer than a SHA-256 hex digest succeeded, want an error")
+	}
+}
@@ -1,10 +1,40 @@
 package apps
 
 import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
 	"fmt"
+	"sort"
+	"strings"
 	"sync"
+	"time"
 )
 
+// Instance status values
+const (
+	StatusRunning   = "running"
+	StatusExited    = "exited"
+	StatusOOMKilled = "oom_killed"
+	StatusUnhealthy = "unhealthy"
+	StatusRemoved   = "removed"
+)
+
+// ErrInstanceNotFound is returned for an unknown instance ID
+var ErrInstanceNotFound = errors.New("instance not found")
+
+// NewInstanceID returns an ID for a new instance of an app on a device,
+// dated by created. A random suffix keeps instances deployed within the
+// same second apart.
+func NewInstanceID(appID, deviceName string, created time.Time) string {
+	var b [4]byte
+	_, _ = rand.Read(b[:])
+	return fmt.Sprintf("%s_%s_%d_%s", appID, deviceName, created.Unix(), hex.EncodeToString(b[:]))
+}
+
+// InstanceStatusCallback is called after an instance's status changed
+type InstanceStatusCallback func(instance *AppInstance, previousStatus string)
+
 // AppInstance represents a container instance of an app (with or without proxy)
 type AppInstance struct {
 	InstanceID  string            // Unique instance ID
@@ -12,10 +42,15 @@ type AppInstance struct {
 	ProxyID     string            // Proxy ID (empty string for local)
 	ContainerID string            // Docker container ID
 	DeviceName  string            // Device name for this instance
+	Profile     string            // credential profile the instance was deployed with
 	Credentials map[string]string // App credentials
 	Status      string            // Running, Stopped, etc.
 	ProxyURL    string            // Proxy URL if using proxy
 	SDKNodeID   string            // SDK node ID (for EarnApp etc.)
+	Generated   map[string]string // auto-generated field values, reused on redeploy
+
+	ResourceLimits  *ResourceLimits // CPU and memory limits applied to the container
+	UpdateAvailable bool            // container runs an older image than the registry has
 }
 
 // InstanceManager manages all app instances
@@ -24,6 +59,9 @@ type InstanceManager struct {
 	appMap    map[string][]string     // appID -> []instanceID
 	proxyMap  map[string][]string     // proxyID -> []instanceID
 	mu        sync.RWMutex
+
+	onStatusChange InstanceStatusCallback
+	onChange       func()
 }
 
 // NewInstanceManager creates a new instance manager
@@ -35,11 +73,57 @@ func NewInstanceManager() *InstanceManager {
 	}
 }
 
+// SetOnStatusChange sets the callback for when an instance's status changes
+func (im *InstanceManager) SetOnStatusChange(callback InstanceStatusCallback) {
+	im.mu.Lock()
+	defer im.mu.Unlock()
+	im.onStatusChange = callback
+}
+
+// SetOnChange sets the callback for any change to the set of instances or
+// their persisted fields
+func (im *InstanceManager) SetOnChange(callback func()) {
+	im.mu.Lock()
+	defer im.mu.Unlock()
+	im.onChange = callback
+}
+
+// notifyChange calls the change callback; callers must not hold im.mu
+func (im *InstanceManager) notifyChange() {
+	im.mu.RLock()
+	onChange := im.onChange
+	im.mu.RUnlock()
+
+	if onChange != nil {
+		onChange()
+	}
+}
+
 // AddInstance adds a new app instance
 func (im *InstanceManager) AddInstance(instance *AppInstance) error {
+	im.mu.Lock()
+	im.addInstance(instance)
+	im.mu.Unlock()
+
+	im.notifyChange()
+	return nil
+}
+
+// RestoreInstances loads previously saved instances without firing any
+// callbacks
+func (im *InstanceManager) RestoreInstances(instances []*AppInstance) {
 	im.mu.Lock()
 	defer im.mu.Unlock()
 
+	for _, instance := range instances {
+		if _, exists := im.instances[instance.InstanceID]; !exists {
+			im.addInstance(instance)
+		}
+	}
+}
+
+// addInstance indexes an instance; callers hold im.mu
+func (im *InstanceManager) addInstance(instance *AppInstance) {
 	// Add instance
 	im.instances[instance.InstanceID] = instance
 
@@ -50,8 +134,6 @@ func (im *InstanceManager) AddInstance(instance *AppInstance) error {
 	if instance.ProxyID != "" {
 		im.proxyMap[instance.ProxyID] = append(im.proxyMap[instance.ProxyID], instance.InstanceID)
 	}
-
-	return nil
 }
 
 // GetInstance retrieves an instance by ID
@@ -61,7 +143,7 @@ func (im *InstanceManager) GetInstance(instanceID string) (*AppInstance, error)
 
 	instance, exists := im.instances[instanceID]
 	if !exists {
-		return nil, fmt.Errorf("instance not found: %s", instanceID)
+		return nil, fmt.Errorf("%w: %s", ErrInstanceNotFound, instanceID)
 	}
 
 	return instance, nil
@@ -110,11 +192,11 @@ func (im *InstanceManager) GetProxyInstances(proxyID string) []*AppInstance {
 // RemoveInstance removes an instance
 func (im *InstanceManager) RemoveInstance(instanceID string) error {
 	im.mu.Lock()
-	defer im.mu.Unlock()
 
 	instance, exists := im.instances[instanceID]
 	if !exists {
-		return fmt.Errorf("instance not found: %s", instanceID)
+		im.mu.Unlock()
+		return fmt.Errorf("%w: %s", ErrInstanceNotFound, instanceID)
 	}
 
 	// Remove from app map
@@ -139,7 +221,9 @@ func (im *InstanceManager) RemoveInstance(instanceID string) error {
 
 	// Remove instance
 	delete(im.instances, instanceID)
+	im.mu.Unlock()
 
+	im.notifyChange()
 	return nil
 }
 
@@ -156,30 +240,141 @@ func (im *InstanceManager) GetAllInstances() []*AppInstance {
 	return result
 }
 
+// GetInstanceByContainerID finds the instance running in a container. Short
+// (truncated) container IDs are matched by prefix.
+func (im *InstanceManager) GetInstanceByContainerID(containerID string) (*AppInstance, error) {
+	im.mu.RLock()
+	defer im.mu.RUnlock()
+
+	if containerID == "" {
+		return nil, fmt.Errorf("%w for empty container ID", ErrInstanceNotFound)
+	}
+	for _, instance := range im.instances {
+		if instance.ContainerID == "" {
+			continue
+		}
+		if strings.HasPrefix(instance.ContainerID, containerID) || strings.HasPrefix(containerID, instance.ContainerID) {
+			return instance, nil
+		}
+	}
+
+	return nil, fmt.Errorf("%w for container: %s", ErrInstanceNotFound, containerID)
+}
+
 // UpdateInstanceStatus updates an instance's status
 func (im *InstanceManager) UpdateInstanceStatus(instanceID, status string) error {
+	return im.UpdateInstanceStatusUnless(instanceID, status, "")
+}
+
+// UpdateInstanceStatusUnless updates an instance's status unless it is
+// keep, so a more specific status like an OOM kill is not overwritten by
+// the die event that follows it. The check and update happen under one
+// lock.
+func (im *InstanceManager) UpdateInstanceStatusUnless(instanceID, status, keep string) error {
 	im.mu.Lock()
-	defer im.mu.Unlock()
 
 	instance, exists := im.instances[instanceID]
 	if !exists {
-		return fmt.Errorf("instance not found: %s", instanceID)
+		im.mu.Unlock()
+		return fmt.Errorf("%w: %s", ErrInstanceNotFound, instanceID)
 	}
 
+	previous := instance.Status
+	if keep != "" && previous == keep {
+		im.mu.Unlock()
+		return nil
+	}
 	instance.Status = status
+
+	// Call callback if set and the status actually changed
+	onStatusChange := im.onStatusChange
+	im.mu.Unlock()
+
+	if previous != status {
+		if onStatusChange != nil {
+			onStatusChange(instance, previous)
+		}
+		im.notifyChange()
+	}
+
 	return nil
 }
 
 // UpdateInstanceContainerID updates an instance's container ID
 func (im *InstanceManager) UpdateInstanceContainerID(instanceID, containerID string) error {
 	im.mu.Lock()
-	defer im.mu.Unlock()
 
 	instance, exists := im.instances[instanceID]
 	if !exists {
-		return fmt.Errorf("instance not found: %s", instanceID)
+		im.mu.Unlock()
+		return fmt.Errorf("%w: %s", ErrInstanceNotFound, instanceID)
 	}
 
 	instance.ContainerID = containerID
+	im.mu.Unlock()
+
+	im.notifyChange()
+	return nil
+}
+
+// UpdateInstanceCredentials updates the credentials an instance runs with
+func (im *InstanceManager) UpdateInstanceCredentials(instanceID string, credentials map[string]string) error {
+	im.mu.Lock()
+
+	instance, exists := im.instances[instanceID]
+	if !exists {
+		im.mu.Unlock()
+		return fmt.Errorf("%w: %s", ErrInstanceNotFound, instanceID)
+	}
+
+	instance.Credentials = credentials
+	im.mu.Unlock()
+
+	im.notifyChange()
+	return nil
+}
+
+// UpdateInstanceResourceLimits updates an instance's resource limits
+func (im *InstanceManager) UpdateInstanceResourceLimits(instanceID string, limits *ResourceLimits) error {
+	im.mu.Lock()
+
+	instance, exists := im.instances[instanceID]
+	if !exists {
+		im.mu.Unlock()
+		return fmt.Errorf("%w: %s", ErrInstanceNotFound, instanceID)
+	}
+
+	instance.ResourceLimits = limits
+	im.mu.Unlock()
+
+	im.notifyChange()
 	return nil
 }
+
+// SetInstanceUpdateAvailable records whether an instance runs an outdated image
+func (im *InstanceManager) SetInstanceUpdateAvailable(instanceID string, available bool) error {
+	im.mu.Lock()
+	defer im.mu.Unlock()
+
+	instance, exists := im.instances[instanceID]
+	if !exists {
+		return fmt.Errorf("%w: %s", ErrInstanceNotFound, instanceID)
+	}
+
+	instance.UpdateAvailable = available
+	return nil
+}
+
+// Snapshot returns copies of all instances, safe to read while the manager
+// keeps changing
+func (im *InstanceManager) Snapshot() []AppInstance {
+	im.mu.RLock()
+	defer im.mu.RUnlock()
+
+	result := make([]AppInstance, 0, len(im.instances))
+	for _, instance := range im.instances {
+		result = append(result, *instance)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].InstanceID < result[j].InstanceID })
+	return result
+}
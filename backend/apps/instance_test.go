@@ -0,0 +1,21 @@
+package apps
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestNewInstanceID(t *testing.T) {
+	created := time.Unix(1700000000, 0)
+	first := NewInstanceID("grass", "box", created)
+	second := NewInstanceID("grass", "box", created)
+	if first == second {
+		t.Fatalf("NewInstanceID twice in one second = %q both times", first)
+	}
+	for _, id := range []string{first, second} {
+		if !strings.HasPrefix(id, "grass_box_1700000000_") {
+			t.Fatalf("NewInstanceID = %q, want grass_box_1700000000_<suffix>", id)
+		}
+	}
+}
@@ -0,0 +1,109 @@
+package apps
+
+import (
+	"maps"
+	"strings"
+
+	"bandwidth-income-manager/backend/docker"
+)
+
+// labelPrefix starts every label the manager sets
+const labelPrefix = "io.bandwidth-income-manager."
+
+// Labels put on every container the manager creates, so our containers can
+// be told apart from anything else running on the host
+const (
+	LabelManager    = "io.bandwidth-income-manager.manager"
+	LabelAppID      = "io.bandwidth-income-manager.app-id"
+	LabelInstanceID = "io.bandwidth-income-manager.instance-id"
+	LabelProxyID    = "io.bandwidth-income-manager.proxy-id"
+	LabelProfile    = "io.bandwidth-income-manager.profile"
+	LabelRole       = "io.bandwidth-income-manager.role"
+	// LabelSecretEnv lists the environment variables holding secrets, so
+	// the manager redacts them when it shows the container's environment
+	// and keeps them secret when it recreates the container. Docker itself
+	// still shows them in docker inspect.
+	LabelSecretEnv = "io.bandwidth-income-manager.secret-env"
+)
+
+// Container roles
+const (
+	RoleApp          = "app"
+	RoleProxySidecar = "proxy-sidecar"
+)
+
+// ManagerID identifies this manager in container labels. Managers with
+// different IDs on the same host ignore each other's containers.
+var ManagerID = "default"
+
+// appLabels returns the labels of an app container
+func appLabels(deployment *AppDeployment) map[string]string {
+	labels := map[string]string{
+		LabelManager:    ManagerID,
+		LabelAppID:      deployment.AppID,
+		LabelInstanceID: deployment.InstanceID,
+		LabelRole:       RoleApp,
+	}
+	if deployment.ProxyID != "" {
+		labels[LabelProxyID] = deployment.ProxyID
+	}
+	if deployment.Profile != "" {
+		labels[LabelProfile] = deployment.Profile
+	}
+	return labels
+}
+
+// proxyLabels returns the labels of a tun2socks sidecar container
+func proxyLabels(proxyID string) map[string]string {
+	return map[string]string{
+		LabelManager: ManagerID,
+		LabelProxyID: proxyID,
+		LabelRole:    RoleProxySidecar,
+	}
+}
+
+// managedLabels returns a copy of the labels the manager set on a
+// container. Inspect also reports the labels of the image, which a new
+// container should take from its own image instead.
+func managedLabels(labels map[string]string) map[string]string {
+	result := maps.Clone(labels)
+	if result == nil {
+		return make(map[string]string)
+	}
+	maps.DeleteFunc(result, func(key, _ string) bool {
+		return !strings.HasPrefix(key, labelPrefix)
+	})
+	return result
+}
+
+// IsManaged reports whether a container was created by this manager
+func IsManaged(labels map[string]string) bool {
+	return labels[LabelManager] == ManagerID
+}
+
+// ManagedContainers returns the containers of this manager with the given
+// role, or with any role if role is empty
+func ManagedContainers(containers []docker.ContainerInfo, role string) []docker.ContainerInfo {
+	result := make([]docker.ContainerInfo, 0, len(containers))
+	for _, c := range containers {
+		if !IsManaged(c.Labels) {
+			continue
+		}
+		if role != "" && c.Labels[LabelRole] != role {
+			continue
+		}
+		result = append(result, c)
+	}
+	return result
+}
+
+// ProxySidecars returns the sidecar containers serving a proxy
+func ProxySidecars(containers []docker.ContainerInfo, proxyID string) []docker.ContainerInfo {
+	result := []docker.ContainerInfo{}
+	for _, c := range ManagedContainers(containers, RoleProxySidecar) {
+		if c.Labels[LabelProxyID] == proxyID {
+			result = append(result, c)
+		}
+	}
+	return result
+}
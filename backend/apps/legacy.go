@@ -0,0 +1,124 @@
+package apps
+
+import (
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// legacyConfig is the app config format of earlier versions, when the
+// files in configs/apps only described apps for display. Such a file is
+// recognised by its docker_image key.
+type legacyConfig struct {
+	EnvironmentVars []struct {
+		Key         string `yaml:"key"`
+		Required    bool   `yaml:"required"`
+		Description string `yaml:"description"`
+	} `yaml:"environment_vars"`
+	Volumes []struct {
+		Host      string `yaml:"host"`
+		Container string `yaml:"container"`
+		Type      string `yaml:"type"`
+	} `yaml:"volumes"`
+	ResourceLimits *struct {
+		CPU    string `yaml:"cpu"`
+		Memory string `yaml:"memory"`
+	} `yaml:"resource_limits"`
+}
+
+// upgradeLegacy rewrites a document in the earlier config format into a
+// manifest in place and reports whether it did. Keys with the same meaning
+// are kept with their line numbers; proxy_support and earnings_api have no
+// equivalent and are dropped. A patch of a built-in manifest keeps the
+// built-in form fields, so its environment_vars are dropped as well.
+func upgradeLegacy(root *yaml.Node, patch bool) (bool, error) {
+	if root.Kind != yaml.DocumentNode || len(root.Content) == 0 {
+		return false, nil
+	}
+	mapping := root.Content[0]
+	if mapping.Kind != yaml.MappingNode || mappingValue(mapping, "docker_image") == nil {
+		return false, nil
+	}
+
+	var legacy legacyConfig
+	if err := mapping.Decode(&legacy); err != nil {
+		return false, err
+	}
+
+	content := make([]*yaml.Node, 0, len(mapping.Content))
+	add := func(key *yaml.Node, name string, value interface{}) error {
+		node := &yaml.Node{}
+		if err := node.Encode(value); err != nil {
+			return err
+		}
+		content = append(content, &yaml.Node{Kind: yaml.ScalarNode, Value: name, Line: key.Line, Column: key.Column}, node)
+		return nil
+	}
+	for i := 0; i+1 < len(mapping.Content); i += 2 {
+		key, value := mapping.Content[i], mapping.Content[i+1]
+		var err error
+		switch key.Value {
+		case "docker_image":
+			key.Value = "image"
+			content = append(content, key, value)
+		case "environment_vars":
+			if patch {
+				continue
+			}
+			environment := make(map[string]string)
+			fields := map[string]*FieldSpec{
+				"DEVICE_NAME": {Type: FieldDeviceName, Label: "Device name", Required: true},
+			}
+			for _, env := range legacy.EnvironmentVars {
+				fieldType := inferFieldType(env.Key)
+				environment[env.Key] = "$" + env.Key
+				fields[env.Key] = &FieldSpec{
+					Type:     fieldType,
+					Required: env.Required,
+					Secret:   fieldType == FieldPassword || fieldType == FieldToken,
+					Help:     env.Description,
+				}
+			}
+			delete(environment, "DEVICE_NAME")
+			if err = add(key, "environment", environment); err == nil {
+				err = add(key, "fields", fields)
+			}
+		case "volumes":
+			volumes := make([]string, 0, len(legacy.Volumes))
+			for _, volume := range legacy.Volumes {
+				if volume.Type == "tmpfs" {
+					continue
+				}
+				volumes = append(volumes, volume.Host+":"+volume.Container)
+			}
+			err = add(key, "volumes", volumes)
+		case "resource_limits":
+			if legacy.ResourceLimits != nil {
+				err = add(key, "resource_limits", &ResourceLimits{
+					CPUs:        strings.TrimSpace(legacy.ResourceLimits.CPU),
+					MemoryLimit: strings.TrimSpace(legacy.ResourceLimits.Memory),
+				})
+			}
+		case "proxy_support", "earnings_api":
+			// Not part of a manifest
+		default:
+			content = append(content, key, value)
+		}
+		if err != nil {
+			return false, err
+		}
+	}
+	mapping.Content = content
+	return true, nil
+}
+
+// mappingValue returns the value of key in a mapping node, nil if it has
+// none
+func mappingValue(mapping *yaml.Node, key string) *yaml.Node {
+	for i := 0; i+1 < len(mapping.Content); i += 2 {
+		if mapping.Content[i].Value == key {
+			return mapping.Content[i+1]
+		}
+	}
+	return nil
+}
@@ -0,0 +1,94 @@
+package apps
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	"bandwidth-income-manager/backend/docker"
+)
+
+// RecreateInstance replaces an instance's container with one running env
+// and cmd, e.g. after its credentials changed. secretCommand tells that cmd
+// refers to secrets, see AppManifest.SecretCommand. The image, volumes,
+// ports, network, proxy settings, manager labels and limits of the current
+// container are kept. If the new container does not come up healthy the
+// old one is restored.
+func RecreateInstance(rt docker.Runtime, im *InstanceManager, instance *AppInstance, env, secretEnv, cmd []string, secretCommand bool, opts UpdateOptions) error {
+	opts = opts.withDefaults()
+	if instance.ContainerID == "" {
+		return fmt.Errorf("instance %s has no container", instance.InstanceID)
+	}
+
+	old, err := rt.InspectContainer(instance.ContainerID)
+	if err != nil {
+		return fmt.Errorf("failed to inspect container: %w", err)
+	}
+
+	config := old.Config
+	config.Labels = managedLabels(old.Config.Labels)
+	delete(config.Labels, LabelSecretEnv)
+	config.Env = append([]string{}, env...)
+	config.SecretEnv = nil
+	addSecretEnv(&config, secretEnv...)
+	config.Entrypoint = nil
+	config.Cmd = nil
+	if len(cmd) > 0 {
+		config.Cmd = append([]string{}, cmd...)
+	}
+	if secretCommand {
+		// The image by ID, as the tag may have moved on since
+		config.Image = old.ImageID
+		err := wrapSecretCommand(rt, &config)
+		config.Image = old.Config.Image
+		if err != nil {
+			return err
+		}
+	}
+
+	// Proxy settings were added by DeployApp, not rendered from the manifest
+	oldSecret := SecretEnv(old.Config.Labels)
+	for _, e := range old.Config.Env {
+		key, _, _ := strings.Cut(e, "=")
+		if slices.Contains(proxyEnvKeys, key) {
+			config.Env = append(config.Env, e)
+			if slices.Contains(oldSecret, key) {
+				addSecretEnv(&config, key)
+			}
+		}
+	}
+
+	if err := replaceContainer(rt, im, instance, old, &config, "starting container with new settings", opts); err != nil {
+		return err
+	}
+	opts.OnProgress(instance.InstanceID, "recreated")
+	return nil
+}
+
+// RecreateWithResources replaces an instance's container with one that has
+// resources as its limits. Docker cannot lift a limit of a running
+// container, so removing one takes a new container; everything else of the
+// current container is kept.
+func RecreateWithResources(rt docker.Runtime, im *InstanceManager, instance *AppInstance, resources docker.Resources, opts UpdateOptions) error {
+	opts = opts.withDefaults()
+	if instance.ContainerID == "" {
+		return fmt.Errorf("instance %s has no container", instance.InstanceID)
+	}
+
+	old, err := rt.InspectContainer(instance.ContainerID)
+	if err != nil {
+		return fmt.Errorf("failed to inspect container: %w", err)
+	}
+
+	config := old.Config
+	config.Labels = managedLabels(old.Config.Labels)
+	config.SecretEnv = SecretEnv(old.Config.Labels)
+	config.Resources = resources
+	keepEntrypoint(&config)
+
+	if err := replaceContainer(rt, im, instance, old, &config, "starting container with new limits", opts); err != nil {
+		return err
+	}
+	opts.OnProgress(instance.InstanceID, "recreated")
+	return nil
+}
@@ -0,0 +1,250 @@
+package apps
+
+import (
+	"fmt"
+	"slices"
+	"sort"
+	"time"
+
+	"bandwidth-income-manager/backend/docker"
+)
+
+// Rolling update defaults
+const (
+	DefaultHealthTimeout = 2 * time.Minute
+	DefaultStablePeriod  = 15 * time.Second
+
+	healthPollInterval = time.Second
+)
+
+// UpdateOptions controls a rolling update
+type UpdateOptions struct {
+	HealthTimeout time.Duration // how long a new container may take to become healthy
+	StablePeriod  time.Duration // how long it then has to keep running without a restart
+	OnProgress    func(instanceID, message string)
+}
+
+func (opts UpdateOptions) withDefaults() UpdateOptions {
+	if opts.HealthTimeout <= 0 {
+		opts.HealthTimeout = DefaultHealthTimeout
+	}
+	if opts.StablePeriod <= 0 {
+		opts.StablePeriod = DefaultStablePeriod
+	}
+	if opts.OnProgress == nil {
+		opts.OnProgress = func(string, string) {}
+	}
+	return opts
+}
+
+// UpdateResult reports what a rolling update did
+type UpdateResult struct {
+	AppID      string
+	Image      string
+	Updated    []string // instances now running the new image
+	Skipped    []string // instances that already ran it or have no container
+	RolledBack string   // instance whose update failed and was rolled back
+}
+
+// UpdateApp pulls image and recreates the app's instances one at a time with
+// it, keeping each container's env, volumes, ports, network and limits. Every
+// new container has to come up healthy; if one does not, it is replaced by
+// the previous container (and so the previous image) and the update stops.
+func UpdateApp(rt docker.Runtime, im *InstanceManager, appID, image string, opts UpdateOptions) (*UpdateResult, error) {
+	opts = opts.withDefaults()
+
+	result := &UpdateResult{AppID: appID, Image: image}
+	instances := im.GetAppInstances(appID)
+	if len(instances) == 0 {
+		return result, fmt.Errorf("no instances of %s to update", appID)
+	}
+	sort.Slice(instances, func(i, j int) bool { return instances[i].InstanceID < instances[j].InstanceID })
+
+	if err := rt.PullImage(image); err != nil {
+		return result, fmt.Errorf("failed to pull image: %w", err)
+	}
+	newImage, err := rt.InspectImage(image)
+	if err != nil {
+		return result, fmt.Errorf("failed to inspect pulled image: %w", err)
+	}
+
+	for _, instance := range instances {
+		if instance.ContainerID == "" {
+			result.Skipped = append(result.Skipped, instance.InstanceID)
+			continue
+		}
+
+		updated, err := updateInstance(rt, im, instance, image, newImage, opts)
+		if err != nil {
+			result.RolledBack = instance.InstanceID
+			return result, fmt.Errorf("update of instance %s failed: %w", instance.InstanceID, err)
+		}
+		if updated {
+			result.Updated = append(result.Updated, instance.InstanceID)
+		} else {
+			result.Skipped = append(result.Skipped, instance.InstanceID)
+		}
+		_ = im.SetInstanceUpdateAvailable(instance.InstanceID, false)
+	}
+
+	return result, nil
+}
+
+// updateInstance replaces one instance's container with one running
+// newImage. It reports false if the container already runs newImage.
+func updateInstance(rt docker.Runtime, im *InstanceManager, instance *AppInstance, image string, newImage *docker.ImageDetails, opts UpdateOptions) (bool, error) {
+	old, err := rt.InspectContainer(instance.ContainerID)
+	if err != nil {
+		return false, fmt.Errorf("failed to inspect container: %w", err)
+	}
+	if old.ImageID == newImage.ID {
+		return false, nil
+	}
+
+	config := old.Config
+	config.Image = image
+	config.Labels = managedLabels(old.Config.Labels)
+	config.SecretEnv = SecretEnv(config.Labels)
+	keepEntrypoint(&config)
+	// Inspect reports the image's own env and command merged into the
+	// container's; drop them so the new image's defaults apply
+	if oldImage, err := rt.InspectImage(old.ImageID); err == nil {
+		config.Env = withoutImageEnv(config.Env, oldImage.Env)
+		if config.Entrypoint != nil {
+			swapImageEntrypoint(&config, oldImage.Entrypoint, newImage.Entrypoint)
+		} else if slices.Equal(config.Cmd, oldImage.Cmd) {
+			config.Cmd = nil
+		}
+	}
+
+	if config.Entrypoint != nil {
+		if err := checkShell(rt, newImage.ID, old.Name); err != nil {
+			return false, err
+		}
+	}
+
+	if err := replaceContainer(rt, im, instance, old, &config, "starting container with new image", opts); err != nil {
+		return false, err
+	}
+	opts.OnProgress(instance.InstanceID, "updated")
+	return true, nil
+}
+
+// replaceContainer swaps an instance's container old for a new one created
+// from config. The old container is stopped and renamed rather than
+// removed, so if the new one fails to come up healthy it is restored
+// exactly.
+func replaceContainer(rt docker.Runtime, im *InstanceManager, instance *AppInstance, old *docker.ContainerDetails, config *docker.ContainerConfig, message string, opts UpdateOptions) error {
+	backupName := old.Name + "_rollback"
+	if _, err := rt.GetContainer(backupName); err == nil {
+		return fmt.Errorf("container %s from an interrupted update still exists", backupName)
+	} else if !docker.IsNotFound(err) {
+		return err
+	}
+
+	opts.OnProgress(instance.InstanceID, "stopping old container")
+	if err := rt.StopContainer(old.ID); err != nil {
+		return fmt.Errorf("failed to stop old container: %w", err)
+	}
+	if err := rt.RenameContainer(old.ID, backupName); err != nil {
+		_ = rt.StartContainer(old.ID)
+		return fmt.Errorf("failed to rename old container: %w", err)
+	}
+
+	opts.OnProgress(instance.InstanceID, message)
+	newID, err := rt.CreateContainer(config)
+	if err == nil {
+		// Point the instance at the new container before it starts so status
+		// events are attributed correctly
+		_ = im.UpdateInstanceContainerID(instance.InstanceID, newID)
+		if err = rt.StartContainer(newID); err == nil {
+			err = waitHealthy(rt, newID, opts)
+		}
+	}
+	if err != nil {
+		opts.OnProgress(instance.InstanceID, "rolling back: "+err.Error())
+		if rollbackErr := rollback(rt, im, instance, newID, old, backupName); rollbackErr != nil {
+			return fmt.Errorf("%w (rollback failed: %v)", err, rollbackErr)
+		}
+		return err
+	}
+
+	if err := rt.RemoveContainer(old.ID); err != nil {
+		fmt.Printf("failed to remove old container %s: %v\n", backupName, err)
+	}
+	return nil
+}
+
+// rollback removes the new container (if any) and brings back the old one
+func rollback(rt docker.Runtime, im *InstanceManager, instance *AppInstance, newID string, old *docker.ContainerDetails, backupName string) error {
+	if newID != "" {
+		if err := rt.RemoveContainer(newID); err != nil && !docker.IsNotFound(err) {
+			return fmt.Errorf("failed to remove new container: %w", err)
+		}
+	}
+	if err := rt.RenameContainer(old.ID, old.Name); err != nil {
+		return fmt.Errorf("failed to restore name of %s: %w", backupName, err)
+	}
+	_ = im.UpdateInstanceContainerID(instance.InstanceID, old.ID)
+	if err := rt.StartContainer(old.ID); err != nil {
+		return fmt.Errorf("failed to restart old container: %w", err)
+	}
+	return nil
+}
+
+// waitHealthy waits until a container is running, passes its health check
+// if it has one, and keeps doing so for the stable period
+func waitHealthy(rt docker.Runtime, containerID string, opts UpdateOptions) error {
+	deadline := time.Now().Add(opts.HealthTimeout)
+	var startedAt, readySince time.Time
+
+	for {
+		details, err := rt.InspectContainer(containerID)
+		if err != nil {
+			return fmt.Errorf("failed to inspect new container: %w", err)
+		}
+		if !details.Running {
+			return fmt.Errorf("new container is %s", details.State)
+		}
+		if details.Health == "unhealthy" {
+			return fmt.Errorf("new container is unhealthy")
+		}
+		// A changed start time means the restart policy brought it back up
+		if startedAt.IsZero() {
+			startedAt = details.StartedAt
+		} else if !details.StartedAt.Equal(startedAt) {
+			return fmt.Errorf("new container restarted")
+		}
+
+		now := time.Now()
+		if details.Health == "" || details.Health == "healthy" {
+			if readySince.IsZero() {
+				readySince = now
+			}
+			if now.Sub(readySince) >= opts.StablePeriod {
+				return nil
+			}
+		} else {
+			readySince = time.Time{}
+		}
+		if readySince.IsZero() && now.After(deadline) {
+			return fmt.Errorf("new container did not become healthy within %s", opts.HealthTimeout)
+		}
+		time.Sleep(healthPollInterval)
+	}
+}
+
+// withoutImageEnv removes the entries of env that the image itself sets
+func withoutImageEnv(env, imageEnv []string) []string {
+	defaults := make(map[string]bool, len(imageEnv))
+	for _, e := range imageEnv {
+		defaults[e] = true
+	}
+	result := make([]string, 0, len(env))
+	for _, e := range env {
+		if !defaults[e] {
+			result = append(result, e)
+		}
+	}
+	return result
+}
@@ -0,0 +1,263 @@
+package apps
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"net/url"
+	"slices"
+	"strings"
+
+	"bandwidth-income-manager/backend/docker"
+)
+
+// RedactedValue replaces secret values shown to the user. Sending it back
+// unchanged in a form keeps the saved value.
+const RedactedValue = "********"
+
+// proxyEnvKeys are the variables DeployApp sets to the proxy URL
+var proxyEnvKeys = []string{"HTTP_PROXY", "HTTPS_PROXY", "ALL_PROXY"}
+
+// secretFields returns the names of the app's secret form fields
+func (m *AppManifest) secretFields() map[string]bool {
+	secret := make(map[string]bool)
+	for name, spec := range m.FieldSpecs() {
+		if spec.Secret {
+			secret[name] = true
+		}
+	}
+	return secret
+}
+
+// SecretEnvKeys returns the keys of the rendered env entries that hold a
+// secret: secret fields passed through as they are, and manifest
+// environment entries whose template refers to a secret field
+func (m *AppManifest) SecretEnvKeys(env []string) []string {
+	secret := m.secretFields()
+	keys := []string{}
+	for _, e := range env {
+		key, _, _ := strings.Cut(e, "=")
+		if secret[key] {
+			keys = append(keys, key)
+			continue
+		}
+		if raw, ok := m.Environment[key]; ok && slices.ContainsFunc(referencedVars(raw), func(name string) bool { return secret[name] }) {
+			keys = append(keys, key)
+		}
+	}
+	slices.Sort(keys)
+	return slices.Compact(keys)
+}
+
+// Redact returns a copy of form values with the secret fields replaced by
+// RedactedValue
+func (m *AppManifest) Redact(values map[string]string) map[string]string {
+	result := maps.Clone(values)
+	for name := range m.secretFields() {
+		if result[name] != "" {
+			result[name] = RedactedValue
+		}
+	}
+	return result
+}
+
+// Unredact returns a copy of form values with secret fields left at
+// RedactedValue set back to their saved values
+func (m *AppManifest) Unredact(values, saved map[string]string) map[string]string {
+	result := maps.Clone(values)
+	for name := range m.secretFields() {
+		if result[name] == RedactedValue {
+			if value, ok := saved[name]; ok {
+				result[name] = value
+			}
+		}
+	}
+	return result
+}
+
+// secretEnvSeparator joins the keys in the LabelSecretEnv label. It is not
+// a comma, as docker ps prints all labels as one comma separated list.
+const secretEnvSeparator = ";"
+
+// SecretEnv returns the secret env keys recorded in a container's labels
+func SecretEnv(labels map[string]string) []string {
+	if labels[LabelSecretEnv] == "" {
+		return nil
+	}
+	return strings.Split(labels[LabelSecretEnv], secretEnvSeparator)
+}
+
+// RedactEnv returns a copy of env with the values of keys replaced by
+// RedactedValue
+func RedactEnv(env map[string]string, keys []string) map[string]string {
+	result := maps.Clone(env)
+	for _, key := range keys {
+		if _, ok := result[key]; ok {
+			result[key] = RedactedValue
+		}
+	}
+	return result
+}
+
+// addSecretEnv marks env keys of a container config as secret and records
+// them in its labels
+func addSecretEnv(config *docker.ContainerConfig, keys ...string) {
+	config.SecretEnv = append(config.SecretEnv, keys...)
+	slices.Sort(config.SecretEnv)
+	config.SecretEnv = slices.Compact(config.SecretEnv)
+	if len(config.SecretEnv) > 0 {
+		config.Labels[LabelSecretEnv] = strings.Join(config.SecretEnv, secretEnvSeparator)
+	}
+}
+
+// secretCommandScript runs its arguments as the container's command, each
+// evaluated as a double quoted shell word first. Command arguments that
+// refer to secrets hold ${NAME} references to the secret env entries, so
+// the values never appear in the container's command.
+const secretCommandScript = `for arg do eval "arg=\"$arg\""; set -- "$@" "$arg"; shift; done; exec "$@"`
+
+// secretCommandEntrypoint replaces the image's entrypoint of a container
+// whose command refers to secrets. The image needs a /bin/sh, see
+// checkShell.
+var secretCommandEntrypoint = []string{"/bin/sh", "-c", secretCommandScript, "sh"}
+
+// secretMarker brackets the names of secret fields in a command while it
+// is split, see AppManifest.Render
+const secretMarker = "\x00"
+
+// SecretCommand reports whether the manifest's command refers to a secret
+// field. Such a command is rendered for secretCommandEntrypoint.
+func (m *AppManifest) SecretCommand() bool {
+	return len(m.commandSecrets()) > 0
+}
+
+// commandSecrets returns the secret fields the command refers to
+func (m *AppManifest) commandSecrets() []string {
+	if m.Command == "" {
+		return nil
+	}
+	secret := m.secretFields()
+	var names []string
+	for _, name := range commandVars(m.Command) {
+		if secret[name] {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// commandArg turns an argument split with secretMarker around secret
+// names into a word for secretCommandScript
+func commandArg(arg string) string {
+	var b strings.Builder
+	for i, part := range strings.Split(arg, secretMarker) {
+		if i%2 == 1 {
+			b.WriteString("${" + part + "}")
+		} else {
+			b.WriteString(quoteForEval(part))
+		}
+	}
+	return b.String()
+}
+
+// quoteForEval escapes s so secretCommandScript passes it on unchanged
+func quoteForEval(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "$", `\$`, "`", "\\`").Replace(s)
+}
+
+// quoteAllForEval quotes each of args with quoteForEval
+func quoteAllForEval(args []string) []string {
+	quoted := make([]string, 0, len(args))
+	for _, arg := range args {
+		quoted = append(quoted, quoteForEval(arg))
+	}
+	return quoted
+}
+
+// ErrNoShell is returned for an app whose command refers to secrets when
+// its image has no /bin/sh to run secretCommandEntrypoint
+var ErrNoShell = errors.New("the image has no /bin/sh to pass secrets to its command; set the secret fields through environment variables instead")
+
+// checkShell runs a throwaway container of image with the shell of
+// secretCommandEntrypoint as its entrypoint. Docker refuses to start it if
+// the image does not have that shell.
+func checkShell(rt docker.Runtime, image, containerName string) error {
+	probe := &docker.ContainerConfig{
+		Name:          containerName + "_shell_check",
+		Image:         image,
+		Entrypoint:    []string{secretCommandEntrypoint[0], "-c", "exit 0"},
+		NetworkMode:   "none",
+		RestartPolicy: "no",
+	}
+	// A probe left over from an interrupted check
+	_ = rt.RemoveContainer(probe.Name)
+	if _, err := rt.CreateContainer(probe); err != nil {
+		return fmt.Errorf("failed to check the image for a shell: %w", err)
+	}
+	defer rt.RemoveContainer(probe.Name)
+	if err := rt.StartContainer(probe.Name); err != nil {
+		return fmt.Errorf("%s: %w (%v)", image, ErrNoShell, err)
+	}
+	return nil
+}
+
+// wrapSecretCommand makes a container run its rendered secret command
+// through secretCommandEntrypoint, after the image's own entrypoint. It
+// fails with ErrNoShell if the image cannot run it.
+func wrapSecretCommand(rt docker.Runtime, config *docker.ContainerConfig) error {
+	image, err := rt.InspectImage(config.Image)
+	if err != nil {
+		return fmt.Errorf("failed to inspect image: %w", err)
+	}
+	if err := checkShell(rt, config.Image, config.Name); err != nil {
+		return err
+	}
+	config.Entrypoint = slices.Clone(secretCommandEntrypoint)
+	config.Cmd = append(quoteAllForEval(image.Entrypoint), config.Cmd...)
+	return nil
+}
+
+// swapImageEntrypoint moves a container config using
+// secretCommandEntrypoint from the entrypoint of image from to that of to
+func swapImageEntrypoint(config *docker.ContainerConfig, from, to []string) {
+	prefix := quoteAllForEval(from)
+	if len(config.Cmd) < len(prefix) || !slices.Equal(config.Cmd[:len(prefix)], prefix) {
+		return
+	}
+	config.Cmd = append(quoteAllForEval(to), config.Cmd[len(prefix):]...)
+}
+
+// secretCommandArgs returns the arguments a container passes to
+// secretCommandEntrypoint, and false if it does not use it. The docker
+// CLI reports only the first word as the entrypoint.
+func secretCommandArgs(config *docker.ContainerConfig) ([]string, bool) {
+	full := append(slices.Clone(config.Entrypoint), config.Cmd...)
+	n := len(secretCommandEntrypoint)
+	if len(full) < n || !slices.Equal(full[:n], secretCommandEntrypoint) {
+		return nil, false
+	}
+	return full[n:], true
+}
+
+// keepEntrypoint leaves a container config copied from an inspected
+// container with an entrypoint only if it is secretCommandEntrypoint.
+// Inspect reports the image's entrypoint otherwise, which the new
+// container takes from its image.
+func keepEntrypoint(config *docker.ContainerConfig) {
+	if args, ok := secretCommandArgs(config); ok {
+		config.Entrypoint = slices.Clone(secretCommandEntrypoint)
+		config.Cmd = args
+		return
+	}
+	config.Entrypoint = nil
+}
+
+// hasPassword reports whether a proxy URL carries a password
+func hasPassword(proxyURL string) bool {
+	u, err := url.Parse(proxyURL)
+	if err != nil || u.User == nil {
+		return false
+	}
+	_, ok := u.User.Password()
+	return ok
+}
@@ -0,0 +1,179 @@
+package apps
+
+import (
+	"errors"
+	"os/exec"
+	"slices"
+	"strings"
+	"testing"
+
+	"bandwidth-income-manager/backend/docker"
+)
+
+// runSecretCommand runs args through secretCommandScript with a local sh
+// and returns what printf prints for them, one argument per line
+func runSecretCommand(t *testing.T, env []string, args ...string) string {
+	t.Helper()
+	sh, err := exec.LookPath("sh")
+	if err != nil {
+		t.Skip("sh is not installed")
+	}
+	words := []string{quoteForEval("printf"), quoteForEval(`%s\n`)}
+	for _, arg := range args {
+		words = append(words, commandArg(arg))
+	}
+	cmd := exec.Command(sh, append(slices.Clone(secretCommandEntrypoint[1:]), words...)...)
+	cmd.Env = env
+	out, err := cmd.Output()
+	if err != nil {
+		t.Fatalf("secret command %q failed: %v", words, err)
+	}
+	return string(out)
+}
+
+func TestSecretCommandPassesSecretsLiterally(t *testing.T) {
+	dir := t.TempDir()
+	secrets := []string{
+		"$(touch " + dir + "/ran)",
+		"`touch " + dir + "/ran`",
+		`quote" back\slash $HOME ${HOME}`,
+		"it's; rm -rf /",
+	}
+	for _, secret := range secrets {
+		arg := "--token=" + secretMarker + "TOKEN" + secretMarker
+		got := runSecretCommand(t, []string{"TOKEN=" + secret, "HOME=/home/me"}, arg)
+		if want := "--token=" + secret + "\n"; got != want {
+			t.Fatalf("secret command printed %q, want %q", got, want)
+		}
+	}
+	if out, _ := exec.Command("ls", dir).Output(); len(out) > 0 {
+		t.Fatalf("a secret was run as a command, created %q", out)
+	}
+
+	// Plain arguments are not expanded either
+	plain := "$(id) `id` $HOME \\ \""
+	if got := runSecretCommand(t, []string{"HOME=/home/me"}, plain); got != plain+"\n" {
+		t.Fatalf("secret command printed %q, want %q", got, plain+"\n")
+	}
+}
+
+func TestQuoteForEval(t *testing.T) {
+	tests := []struct {
+		in   string
+		want string
+	}{
+		{"plain", "plain"},
+		{`a "quoted" word`, `a \"quoted\" word`},
+		{`back\slash`, `back\\slash`},
+		{"$HOME and ${HOME}", `\$HOME and \${HOME}`},
+		{"$(id) `id`", "\\$(id) \\`id\\`"},
+		{"it's", "it's"},
+	}
+	for _, tt := range tests {
+		if got := quoteForEval(tt.in); got != tt.want {
+			t.Fatalf("quoteForEval(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestCommandArg(t *testing.T) {
+	m := secretMarker
+	tests := []struct {
+		in   string
+		want string
+	}{
+		{"--verbose", "--verbose"},
+		{m + "TOKEN" + m, "${TOKEN}"},
+		{"--token=" + m + "TOKEN" + m, "--token=${TOKEN}"},
+		{m + "USER" + m + ":" + m + "PASS" + m + "@$host", `${USER}:${PASS}@\$host`},
+		{`"` + m + "TOKEN" + m + `"`, `\"${TOKEN}\"`},
+	}
+	for _, tt := range tests {
+		if got := commandArg(tt.in); got != tt.want {
+			t.Fatalf("commandArg(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestSecretEnv(t *testing.T) {
+	tests := []struct {
+		labels map[string]string
+		want   []string
+	}{
+		{nil, nil},
+		{map[string]string{LabelSecretEnv: ""}, nil},
+		{map[string]string{LabelSecretEnv: "TOKEN"}, []string{"TOKEN"}},
+		{map[string]string{LabelSecretEnv: "HTTP_PROXY;PASSWORD;TOKEN"}, []string{"HTTP_PROXY", "PASSWORD", "TOKEN"}},
+		{map[string]string{LabelAppID: "earnapp"}, nil},
+	}
+	for _, tt := range tests {
+		if got := SecretEnv(tt.labels); !slices.Equal(got, tt.want) {
+			t.Fatalf("SecretEnv(%v) = %q, want %q", tt.labels, got, tt.want)
+		}
+	}
+
+	// The label addSecretEnv writes reads back the same
+	config := &docker.ContainerConfig{Labels: map[string]string{}}
+	addSecretEnv(config, "TOKEN", "PASSWORD", "TOKEN")
+	if got, want := SecretEnv(config.Labels), []string{"PASSWORD", "TOKEN"}; !slices.Equal(got, want) {
+		t.Fatalf("SecretEnv after addSecretEnv = %q, want %q", got, want)
+	}
+}
+
+// shellRuntime is a runtime whose images have /bin/sh if shell is set
+type shellRuntime struct {
+	docker.Runtime
+	shell      bool
+	entrypoint []string
+	created    []string
+	removed    []string
+}
+
+func (r *shellRuntime) InspectImage(image string) (*docker.ImageDetails, error) {
+	return &docker.ImageDetails{ID: "sha256:1", Entrypoint: r.entrypoint}, nil
+}
+
+func (r *shellRuntime) CreateContainer(config *docker.ContainerConfig) (string, error) {
+	r.created = append(r.created, config.Name)
+	return config.Name, nil
+}
+
+func (r *shellRuntime) StartContainer(name string) error {
+	if !r.shell {
+		return errors.New(`exec: "/bin/sh": stat /bin/sh: no such file or directory`)
+	}
+	return nil
+}
+
+func (r *shellRuntime) RemoveContainer(name string) error {
+	r.removed = append(r.removed, name)
+	return nil
+}
+
+func TestWrapSecretCommand(t *testing.T) {
+	rt := &shellRuntime{shell: true, entrypoint: []string{"/app/run"}}
+	config := &docker.ContainerConfig{Name: "box_earn_local", Image: "earn/app", Cmd: []string{"${TOKEN}"}}
+	if err := wrapSecretCommand(rt, config); err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(config.Entrypoint, secretCommandEntrypoint) {
+		t.Fatalf("Entrypoint = %q, want %q", config.Entrypoint, secretCommandEntrypoint)
+	}
+	if want := []string{"/app/run", "${TOKEN}"}; !slices.Equal(config.Cmd, want) {
+		t.Fatalf("Cmd = %q, want %q", config.Cmd, want)
+	}
+	probe := []string{"box_earn_local_shell_check"}
+	if !slices.Equal(rt.created, probe) || !slices.Contains(rt.removed, probe[0]) {
+		t.Fatalf("shell probe created %q, removed %q, want %q both", rt.created, rt.removed, probe)
+	}
+
+	rt = &shellRuntime{}
+	config = &docker.ContainerConfig{Name: "box_earn_local", Image: "earn/app", Cmd: []string{"${TOKEN}"}}
+	err := wrapSecretCommand(rt, config)
+	if !errors.Is(err, ErrNoShell) || !strings.Contains(err.Error(), "earn/app") {
+		t.Fatalf("wrapSecretCommand for an image without a shell = %v, want ErrNoShell", err)
+	}
+	if config.Entrypoint != nil {
+		t.Fatalf("Entrypoint = %q after a failed wrap, want none", config.Entrypoint)
+	}
+}
@@ -0,0 +1,307 @@
+package apps
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+)
+
+// MissingVarsError reports template variables that have no value
+type MissingVarsError struct {
+	Names []string
+}
+
+func (e *MissingVarsError) Error() string {
+	return fmt.Sprintf("missing value for %s", strings.Join(e.Names, ", "))
+}
+
+// expander resolves $VAR and ${VAR} references against vars. An empty
+// value counts as unset, since an empty credential is never what an app
+// wants.
+type expander struct {
+	vars    map[string]string
+	missing []string
+	used    []string // every variable referenced, with or without a value
+}
+
+// variable expands the reference starting at s[i] == '$' and returns its
+// value and the index just after it. Supported forms are $VAR, ${VAR},
+// ${VAR:-default} and $$ for a literal dollar sign; a '$' that starts none
+// of these is kept as is.
+func (e *expander) variable(s string, i int) (string, int, error) {
+	if i+1 >= len(s) {
+		return "$", i + 1, nil
+	}
+
+	switch c := s[i+1]; {
+	case c == '$':
+		return "$", i + 2, nil
+	case c == '{':
+		end := strings.IndexByte(s[i+2:], '}')
+		if end < 0 {
+			return "", 0, fmt.Errorf("unterminated ${ in %q", s)
+		}
+		inner := s[i+2 : i+2+end]
+		name, fallback, hasFallback := strings.Cut(inner, ":-")
+		if !envKeyPattern.MatchString(name) {
+			return "", 0, fmt.Errorf("invalid variable reference ${%s}", inner)
+		}
+		e.used = append(e.used, name)
+		if value := e.vars[name]; value != "" {
+			return value, i + 3 + end, nil
+		}
+		if !hasFallback {
+			e.missing = append(e.missing, name)
+		}
+		return fallback, i + 3 + end, nil
+	case isNameStart(c):
+		end := i + 2
+		for end < len(s) && isNameChar(s[end]) {
+			end++
+		}
+		name := s[i+1 : end]
+		e.used = append(e.used, name)
+		value := e.vars[name]
+		if value == "" {
+			e.missing = append(e.missing, name)
+		}
+		return value, end, nil
+	default:
+		return "$", i + 1, nil
+	}
+}
+
+// err returns a MissingVarsError for the references that had no value
+func (e *expander) err() error {
+	if len(e.missing) == 0 {
+		return nil
+	}
+	slices.Sort(e.missing)
+	return &MissingVarsError{Names: slices.Compact(e.missing)}
+}
+
+// ExpandVars replaces variable references in s with their values from vars.
+// It fails with a MissingVarsError if a referenced variable has no value
+// and no ${VAR:-default}.
+func ExpandVars(s string, vars map[string]string) (string, error) {
+	e := &expander{vars: vars}
+	result, err := e.expand(s)
+	if err != nil {
+		return "", err
+	}
+	if err := e.err(); err != nil {
+		return "", err
+	}
+	return result, nil
+}
+
+// referencedVars returns the names of the variables s refers to
+func referencedVars(s string) []string {
+	e := &expander{}
+	_, _ = e.expand(s)
+	slices.Sort(e.used)
+	return slices.Compact(e.used)
+}
+
+// expand replaces variable references in s, recording missing ones
+func (e *expander) expand(s string) (string, error) {
+	var b strings.Builder
+	for i := 0; i < len(s); {
+		if s[i] != '$' {
+			b.WriteByte(s[i])
+			i++
+			continue
+		}
+		value, next, err := e.variable(s, i)
+		if err != nil {
+			return "", err
+		}
+		b.WriteString(value)
+		i = next
+	}
+	return b.String(), nil
+}
+
+// SplitCommand splits a command line into arguments the way a POSIX shell
+// would, expanding variables from vars as it goes. Single quotes keep their
+// contents literally, double quotes allow variables and \" \\ \$ escapes,
+// and a backslash outside quotes escapes the next character. An expanded
+// value is never split further, so a password with spaces or quotes stays
+// one argument.
+func SplitCommand(command string, vars map[string]string) ([]string, error) {
+	e := &expander{vars: vars}
+	args, err := e.split(command)
+	if err != nil {
+		return nil, err
+	}
+	if err := e.err(); err != nil {
+		return nil, err
+	}
+	return args, nil
+}
+
+// commandVars returns the names of the variables a command line refers to
+func commandVars(command string) []string {
+	e := &expander{}
+	_, _ = e.split(command)
+	slices.Sort(e.used)
+	return slices.Compact(e.used)
+}
+
+// split splits a command line into arguments, recording missing variables
+func (e *expander) split(command string) ([]string, error) {
+	var args []string
+	var arg strings.Builder
+	inArg := false
+
+	for i := 0; i < len(command); {
+		c := command[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n':
+			if inArg {
+				args = append(args, arg.String())
+				arg.Reset()
+				inArg = false
+			}
+			i++
+		case c == '\'':
+			end := strings.IndexByte(command[i+1:], '\'')
+			if end < 0 {
+				return nil, fmt.Errorf("unterminated single quote in command")
+			}
+			arg.WriteString(command[i+1 : i+1+end])
+			i += end + 2
+			inArg = true
+		case c == '"':
+			i++
+			for {
+				if i >= len(command) {
+					return nil, fmt.Errorf("unterminated double quote in command")
+				}
+				c = command[i]
+				if c == '"' {
+					i++
+					break
+				}
+				if c == '\\' && i+1 < len(command) && strings.IndexByte("\"\\$`", command[i+1]) >= 0 {
+					arg.WriteByte(command[i+1])
+					i += 2
+					continue
+				}
+				if c == '$' {
+					value, next, err := e.variable(command, i)
+					if err != nil {
+						return nil, err
+					}
+					arg.WriteString(value)
+					i = next
+					continue
+				}
+				arg.WriteByte(c)
+				i++
+			}
+			inArg = true
+		case c == '\\':
+			if i+1 < len(command) {
+				arg.WriteByte(command[i+1])
+				i += 2
+			} else {
+				arg.WriteByte(c)
+				i++
+			}
+			inArg = true
+		case c == '$':
+			value, next, err := e.variable(command, i)
+			if err != nil {
+				return nil, err
+			}
+			arg.WriteString(value)
+			i = next
+			inArg = true
+		default:
+			arg.WriteByte(c)
+			i++
+			inArg = true
+		}
+	}
+	if inArg {
+		args = append(args, arg.String())
+	}
+	return args, nil
+}
+
+// Render resolves the manifest's environment and command against vars.
+// Environment entries with an empty value are left out. A command that
+// refers to secret fields is rendered for secretCommandEntrypoint: those
+// fields become env entries and the command refers to them, see
+// SecretCommand.
+func (m *AppManifest) Render(vars map[string]string) (env []string, cmd []string, err error) {
+	var missing []string
+	for _, key := range slices.Sorted(maps.Keys(m.Environment)) {
+		raw := m.Environment[key]
+		if raw == "" {
+			continue
+		}
+		value, err := ExpandVars(raw, vars)
+		var missingErr *MissingVarsError
+		if errors.As(err, &missingErr) {
+			missing = append(missing, missingErr.Names...)
+			continue
+		}
+		if err != nil {
+			return nil, nil, fmt.Errorf("environment %s: %w", key, err)
+		}
+		env = append(env, key+"="+value)
+	}
+
+	if m.Command != "" {
+		// Secret fields stay references, resolved in the container from
+		// env entries of their own
+		commandVars := vars
+		secrets := m.commandSecrets()
+		if len(secrets) > 0 {
+			for key, value := range vars {
+				if strings.Contains(value, secretMarker) {
+					return nil, nil, fmt.Errorf("value of %s contains a NUL byte", key)
+				}
+			}
+			commandVars = maps.Clone(vars)
+			for _, name := range secrets {
+				if vars[name] != "" {
+					commandVars[name] = secretMarker + name + secretMarker
+					if _, ok := m.Environment[name]; !ok {
+						env = append(env, name+"="+vars[name])
+					}
+				}
+			}
+		}
+		cmd, err = SplitCommand(m.Command, commandVars)
+		var missingErr *MissingVarsError
+		if errors.As(err, &missingErr) {
+			missing = append(missing, missingErr.Names...)
+		} else if err != nil {
+			return nil, nil, fmt.Errorf("command: %w", err)
+		}
+		if len(secrets) > 0 {
+			for i, arg := range cmd {
+				cmd[i] = commandArg(arg)
+			}
+		}
+	}
+
+	if len(missing) > 0 {
+		slices.Sort(missing)
+		return nil, nil, &MissingVarsError{Names: slices.Compact(missing)}
+	}
+	return env, cmd, nil
+}
+
+func isNameStart(c byte) bool {
+	return c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
+
+func isNameChar(c byte) bool {
+	return isNameStart(c) || (c >= '0' && c <= '9')
+}
@@ -0,0 +1,212 @@
+package apps
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"bandwidth-income-manager/backend/docker"
+)
+
+// DefaultUpdateInterval is how often the registries are asked for new images
+const DefaultUpdateInterval = 6 * time.Hour
+
+// ImageUpdate describes a newer image for an app and the instances that
+// are not running it yet
+type ImageUpdate struct {
+	AppID        string
+	Image        string
+	LocalDigest  string // digest of the pulled image, empty if unknown
+	RemoteDigest string // digest the tag currently points to in the registry
+	Instances    []string
+	CheckedAt    time.Time
+}
+
+// Version is a short human readable form of the new image, used in
+// notifications
+func (u ImageUpdate) Version() string {
+	digest := strings.TrimPrefix(u.RemoteDigest, "sha256:")
+	if len(digest) > 12 {
+		digest = digest[:12]
+	}
+	return u.Image + " (" + digest + ")"
+}
+
+// UpdateCallback is called when an update is found for the first time
+type UpdateCallback func(update ImageUpdate)
+
+// UpdateChecker periodically compares the images that app instances run
+// with the current registry digest of their manifest image
+type UpdateChecker struct {
+	runtime   docker.Runtime
+	registry  *docker.RegistryClient
+	instances *InstanceManager
+
+	updates     map[string]ImageUpdate // appID -> pending update
+	notified    map[string]string      // appID -> remote digest already reported
+	subscribers []UpdateCallback
+	mu          sync.Mutex
+}
+
+// NewUpdateChecker creates an update checker for the instances in im
+func NewUpdateChecker(rt docker.Runtime, registry *docker.RegistryClient, im *InstanceManager) *UpdateChecker {
+	return &UpdateChecker{
+		runtime:   rt,
+		registry:  registry,
+		instances: im,
+		updates:   make(map[string]ImageUpdate),
+		notified:  make(map[string]string),
+	}
+}
+
+// Subscribe registers callback to be called for newly found updates. Each
+// new registry digest is reported once per app.
+func (c *UpdateChecker) Subscribe(callback UpdateCallback) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.subscribers = append(c.subscribers, callback)
+}
+
+// Run checks for updates every interval until ctx is cancelled
+func (c *UpdateChecker) Run(ctx context.Context, interval time.Duration) {
+	if interval <= 0 {
+		interval = DefaultUpdateInterval
+	}
+
+	// Give startup (and the first deployments) a moment before going online
+	timer := time.NewTimer(time.Minute)
+	defer timer.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-timer.C:
+		}
+
+		if _, err := c.Check(ctx); err != nil && ctx.Err() == nil {
+			fmt.Printf("image update check finished with errors: %v\n", err)
+		}
+		timer.Reset(interval)
+	}
+}
+
+// Check runs a single update check and returns all pending updates. Apps
+// whose registry could not be reached keep their previous result; their
+// errors are joined into the returned error.
+func (c *UpdateChecker) Check(ctx context.Context) ([]ImageUpdate, error) {
+	byApp := make(map[string][]*AppInstance)
+	for _, instance := range c.instances.GetAllInstances() {
+		byApp[instance.AppID] = append(byApp[instance.AppID], instance)
+	}
+
+	var errs []error
+	var found []ImageUpdate
+	for appID, instances := range byApp {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		manifest := GetAppManifest(appID)
+		if manifest == nil || manifest.Image == "" {
+			continue
+		}
+
+		update, err := c.checkApp(ctx, appID, manifest.Image, instances)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", appID, err))
+			continue
+		}
+
+		c.mu.Lock()
+		if update == nil {
+			delete(c.updates, appID)
+		} else {
+			c.updates[appID] = *update
+			if c.notified[appID] != update.RemoteDigest {
+				c.notified[appID] = update.RemoteDigest
+				found = append(found, *update)
+			}
+		}
+		c.mu.Unlock()
+	}
+
+	c.mu.Lock()
+	subscribers := append([]UpdateCallback{}, c.subscribers...)
+	c.mu.Unlock()
+	for _, update := range found {
+		for _, callback := range subscribers {
+			callback(update)
+		}
+	}
+
+	return c.Updates(), errors.Join(errs...)
+}
+
+// checkApp compares one app's instances against the registry. It returns
+// nil if every instance runs the current image.
+func (c *UpdateChecker) checkApp(ctx context.Context, appID, image string, instances []*AppInstance) (*ImageUpdate, error) {
+	remoteDigest, err := c.registry.Digest(ctx, image)
+	if err != nil {
+		return nil, err
+	}
+
+	local, err := c.runtime.InspectImage(image)
+	if err != nil && !docker.IsNotFound(err) {
+		return nil, err
+	}
+
+	// A locally built image has no repo digests and cannot be compared
+	imageOutdated := local == nil || (len(local.RepoDigests) > 0 && !local.HasDigest(remoteDigest))
+
+	update := &ImageUpdate{
+		AppID:        appID,
+		Image:        image,
+		RemoteDigest: remoteDigest,
+		CheckedAt:    time.Now(),
+	}
+	if local != nil && len(local.RepoDigests) > 0 {
+		_, update.LocalDigest, _ = strings.Cut(local.RepoDigests[0], "@")
+	}
+
+	for _, instance := range instances {
+		if instance.ContainerID == "" {
+			continue
+		}
+		outdated := imageOutdated
+		if !outdated {
+			// The new image may already be pulled while the container still
+			// runs the old one
+			details, err := c.runtime.InspectContainer(instance.ContainerID)
+			if err != nil {
+				continue
+			}
+			outdated = local != nil && details.ImageID != local.ID
+		}
+		_ = c.instances.SetInstanceUpdateAvailable(instance.InstanceID, outdated)
+		if outdated {
+			update.Instances = append(update.Instances, instance.InstanceID)
+		}
+	}
+
+	if len(update.Instances) == 0 {
+		return nil, nil
+	}
+	sort.Strings(update.Instances)
+	return update, nil
+}
+
+// Updates returns the pending updates found by the last checks
+func (c *UpdateChecker) Updates() []ImageUpdate {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	result := make([]ImageUpdate, 0, len(c.updates))
+	for _, update := range c.updates {
+		result = append(result, update)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].AppID < result[j].AppID })
+	return result
+}
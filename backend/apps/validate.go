@@ -0,0 +1,414 @@
+package apps
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"bandwidth-income-manager/backend/docker"
+
+	"gopkg.in/yaml.v3"
+)
+
+var (
+	appIDPattern      = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]*$`)
+	envKeyPattern     = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+	placeholder       = regexp.MustCompile(`^\$(\{[A-Za-z_][A-Za-z0-9_]*\}|[A-Za-z_][A-Za-z0-9_]*)$`)
+	volumeNamePattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_.-]*$`)
+	yamlLinePrefix    = regexp.MustCompile(`^(?:yaml: )?line (\d+): (.*)$`)
+)
+
+// Network modes a manifest may ask for, besides container:<name>
+var knownNetworkModes = []string{"bridge", "default", "host", "none"}
+
+// Platform values accepted in supported_platforms
+var (
+	knownOSes          = []string{"darwin", "linux", "windows"}
+	knownArchitectures = []string{"386", "amd64", "arm", "arm64", "ppc64le", "riscv64", "s390x"}
+	knownArmVariants   = []string{"v5", "v6", "v7", "v8"}
+)
+
+// ValidationError is one problem found in a manifest. Field is the YAML
+// path of the offending value, such as "ports[1]" or "resource_limits.cpus";
+// Line is its line in the manifest file, or 0 when it is not known.
+type ValidationError struct {
+	Field   string
+	Line    int
+	Message string
+
+	path []string
+}
+
+func (e *ValidationError) Error() string {
+	var b strings.Builder
+	if e.Line > 0 {
+		fmt.Fprintf(&b, "line %d: ", e.Line)
+	}
+	if e.Field != "" {
+		b.WriteString(e.Field + ": ")
+	}
+	b.WriteString(e.Message)
+	return b.String()
+}
+
+// ValidationErrors is every problem found in a manifest
+type ValidationErrors []*ValidationError
+
+func (e ValidationErrors) Error() string {
+	messages := make([]string, len(e))
+	for i, err := range e {
+		messages[i] = err.Error()
+	}
+	return strings.Join(messages, "; ")
+}
+
+// validator collects errors while walking a manifest
+type validator struct {
+	errs ValidationErrors
+}
+
+func (v *validator) addf(path []string, format string, args ...interface{}) {
+	v.errs = append(v.errs, &ValidationError{
+		Field:   formatPath(path),
+		Message: fmt.Sprintf(format, args...),
+		path:    slices.Clone(path),
+	})
+}
+
+// Validate checks a manifest against the schema. The returned error is a
+// ValidationErrors listing every problem, not just the first.
+func (m *AppManifest) Validate() error {
+	v := &validator{}
+
+	switch {
+	case m.ID == "":
+		v.addf([]string{"app_id"}, "is required")
+	case !appIDPattern.MatchString(m.ID):
+		v.addf([]string{"app_id"}, "%q must be lowercase letters, digits, '-' or '_'", m.ID)
+	}
+	if m.Name == "" {
+		v.addf([]string{"name"}, "is required")
+	}
+	if m.Image == "" {
+		v.addf([]string{"image"}, "is required")
+	} else if _, err := docker.ParseImageReference(m.Image); err != nil {
+		v.addf([]string{"image"}, "%v", err)
+	}
+
+	for i, platform := range m.SupportedPlatforms {
+		if err := validatePlatform(platform); err != nil {
+			v.addf([]string{"supported_platforms", strconv.Itoa(i)}, "%v", err)
+		}
+	}
+	for _, key := range slices.Sorted(maps.Keys(m.Environment)) {
+		if !envKeyPattern.MatchString(key) {
+			v.addf([]string{"environment", key}, "%q is not a valid environment variable name", key)
+		}
+	}
+	for _, key := range slices.Sorted(maps.Keys(m.RequiredFields)) {
+		if !envKeyPattern.MatchString(key) {
+			v.addf([]string{"required_fields", key}, "%q is not a valid environment variable name", key)
+		}
+	}
+	for _, name := range slices.Sorted(maps.Keys(m.Fields)) {
+		spec := m.Fields[name]
+		if !envKeyPattern.MatchString(name) {
+			v.addf([]string{"fields", name}, "%q is not a valid environment variable name", name)
+		}
+		if spec == nil {
+			v.addf([]string{"fields", name}, "must have a type")
+			continue
+		}
+		if !slices.Contains(fieldTypes, spec.Type) {
+			v.addf([]string{"fields", name, "type"}, "unknown type %q", spec.Type)
+		}
+		if spec.Pattern != "" {
+			if _, err := regexp.Compile(spec.Pattern); err != nil {
+				v.addf([]string{"fields", name, "pattern"}, "%v", err)
+			}
+		}
+		if spec.Default != "" && slices.Contains(fieldTypes, spec.Type) {
+			if err := spec.Check(spec.Default); err != nil {
+				v.addf([]string{"fields", name, "default"}, "%v", err)
+			}
+		}
+	}
+	for i, volume := range m.Volumes {
+		if err := validateVolume(volume); err != nil {
+			v.addf([]string{"volumes", strconv.Itoa(i)}, "%v", err)
+		}
+	}
+	for i, shared := range m.SharedVolumes {
+		declared := slices.ContainsFunc(m.Volumes, func(volume string) bool {
+			_, container, _, ok := splitVolume(volume)
+			return ok && container == shared
+		})
+		if !declared {
+			v.addf([]string{"shared_volumes", strconv.Itoa(i)}, "%q is not the container path of any volume", shared)
+		}
+	}
+	for i, port := range m.Ports {
+		if err := validatePortMapping(port); err != nil {
+			v.addf([]string{"ports", strconv.Itoa(i)}, "%v", err)
+		}
+	}
+	if m.Command != "" {
+		var missing *MissingVarsError
+		if _, err := SplitCommand(m.Command, nil); err != nil && !errors.As(err, &missing) {
+			v.addf([]string{"command"}, "%v", err)
+		}
+		// The container reads secrets in the command from its environment
+		for _, name := range m.commandSecrets() {
+			if raw, ok := m.Environment[name]; ok && raw != "$"+name && raw != "${"+name+"}" {
+				v.addf([]string{"command"}, "refers to secret field %s, so environment must not set %s to anything but $%s", name, name, name)
+			}
+		}
+	}
+	for _, key := range slices.Sorted(maps.Keys(m.Environment)) {
+		var missing *MissingVarsError
+		if _, err := ExpandVars(m.Environment[key], nil); err != nil && !errors.As(err, &missing) {
+			v.addf([]string{"environment", key}, "%v", err)
+		}
+	}
+	if mode := m.NetworkMode; mode != "" && !slices.Contains(knownNetworkModes, mode) {
+		if name, ok := strings.CutPrefix(mode, "container:"); !ok || name == "" {
+			v.addf([]string{"network_mode"}, "%q must be one of %s or container:<name>", mode, strings.Join(knownNetworkModes, ", "))
+		}
+	}
+	if r := m.ResourceLimits; r != nil {
+		before := len(v.errs)
+		if _, err := docker.ParseCPUs(r.CPUs); err != nil {
+			v.addf([]string{"resource_limits", "cpus"}, "%v", err)
+		}
+		if _, err := docker.ParseMemory(r.MemoryReservation); err != nil {
+			v.addf([]string{"resource_limits", "memory_reservation"}, "%v", err)
+		}
+		if _, err := docker.ParseMemory(r.MemoryLimit); err != nil {
+			v.addf([]string{"resource_limits", "memory_limit"}, "%v", err)
+		}
+		if len(v.errs) == before {
+			if _, err := r.Resources(); err != nil {
+				v.addf([]string{"resource_limits"}, "%v", err)
+			}
+		}
+	}
+	if hc := m.HealthCheck; hc != nil && hc.Interval != "" {
+		if d, err := time.ParseDuration(hc.Interval); err != nil {
+			v.addf([]string{"health_check", "interval"}, "%q is not a duration such as 30s or 5m", hc.Interval)
+		} else if d <= 0 {
+			v.addf([]string{"health_check", "interval"}, "must be positive")
+		}
+	}
+	for _, field := range slices.Sorted(maps.Keys(m.AutoGenerateFields)) {
+		gen := m.AutoGenerateFields[field]
+		if !envKeyPattern.MatchString(field) {
+			v.addf([]string{"auto_generate_fields", field}, "%q is not a valid environment variable name", field)
+		}
+		if gen == nil {
+			v.addf([]string{"auto_generate_fields", field}, "must have settings")
+			continue
+		}
+		if gen.Generator != "" && !slices.Contains(GeneratorNames(), gen.Generator) {
+			v.addf([]string{"auto_generate_fields", field, "generator"}, "unknown generator %q, expected one of %s", gen.Generator, strings.Join(GeneratorNames(), ", "))
+		}
+		if gen.Length < 0 {
+			v.addf([]string{"auto_generate_fields", field, "length"}, "must not be negative")
+		}
+		if gen.Generator == GeneratorDerived && gen.Length > 64 {
+			v.addf([]string{"auto_generate_fields", field, "length"}, "derived values are at most 64 characters")
+		}
+	}
+
+	if len(v.errs) == 0 {
+		return nil
+	}
+	return v.errs
+}
+
+// validatePlatform checks an os/arch[/variant] platform string
+func validatePlatform(platform string) error {
+	parts := strings.Split(platform, "/")
+	if len(parts) < 2 || len(parts) > 3 {
+		return fmt.Errorf("%q must be os/arch or os/arch/variant", platform)
+	}
+	if !slices.Contains(knownOSes, parts[0]) {
+		return fmt.Errorf("unknown OS %q, expected one of %s", parts[0], strings.Join(knownOSes, ", "))
+	}
+	if !slices.Contains(knownArchitectures, parts[1]) {
+		return fmt.Errorf("unknown architecture %q, expected one of %s", parts[1], strings.Join(knownArchitectures, ", "))
+	}
+	if len(parts) == 3 && (!strings.HasPrefix(parts[1], "arm") || !slices.Contains(knownArmVariants, parts[2])) {
+		return fmt.Errorf("unknown variant %q for %s", parts[2], parts[1])
+	}
+	return nil
+}
+
+// validateVolume checks a host:container[:mode] volume mapping. The host
+// side may be a path, a named volume or a $VAR placeholder; the container
+// side must be an absolute path.
+func validateVolume(volume string) error {
+	host, container, mode, ok := splitVolume(volume)
+	if !ok {
+		return fmt.Errorf("%q must be host:container or host:container:mode", volume)
+	}
+
+	if !strings.HasPrefix(container, "/") {
+		return fmt.Errorf("container path %q must be absolute", container)
+	}
+	if strings.Contains(container, "..") {
+		return fmt.Errorf("container path %q must not contain '..'", container)
+	}
+	if mode != "" {
+		for _, option := range strings.Split(mode, ",") {
+			switch option {
+			case "ro", "rw", "z", "Z", "cached", "delegated", "consistent", "nocopy":
+			default:
+				return fmt.Errorf("unknown volume option %q", option)
+			}
+		}
+	}
+
+	if !isHostPath(host) && !volumeNamePattern.MatchString(host) {
+		return fmt.Errorf("host side %q is neither a path nor a volume name", host)
+	}
+	return nil
+}
+
+// validatePortMapping checks a [ip:][host:]container[/protocol] port
+// mapping. Each port may be a number, a range or a $VAR placeholder.
+func validatePortMapping(mapping string) error {
+	ports, protocol, hasProtocol := strings.Cut(mapping, "/")
+	if hasProtocol && protocol != "tcp" && protocol != "udp" && protocol != "sctp" {
+		return fmt.Errorf("unknown protocol %q, expected tcp, udp or sctp", protocol)
+	}
+
+	parts := strings.Split(ports, ":")
+	if len(parts) > 3 {
+		return fmt.Errorf("%q must be [ip:][host:]container[/protocol]", mapping)
+	}
+	if len(parts) == 3 {
+		if ip := strings.Trim(parts[0], "[]"); ip == "" || strings.ContainsAny(ip, " /") {
+			return fmt.Errorf("invalid host IP %q", parts[0])
+		}
+		parts = parts[1:]
+	}
+	for i, port := range parts {
+		if i == 0 && len(parts) == 2 && port == "" {
+			continue // ":80" publishes on a random host port
+		}
+		if err := validatePort(port); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// validatePort checks one port, port range or placeholder
+func validatePort(port string) error {
+	if placeholder.MatchString(port) {
+		return nil
+	}
+	first, last, isRange := strings.Cut(port, "-")
+	low, err := strconv.Atoi(first)
+	if err != nil || low < 1 || low > 65535 {
+		return fmt.Errorf("port %q must be 1-65535 or a ${VAR} placeholder", port)
+	}
+	if isRange {
+		high, err := strconv.Atoi(last)
+		if err != nil || high < low || high > 65535 {
+			return fmt.Errorf("invalid port range %q", port)
+		}
+	}
+	return nil
+}
+
+// locate fills in the line of every error from the manifest's YAML
+func (e ValidationErrors) locate(root *yaml.Node) {
+	for _, err := range e {
+		if err.Line == 0 {
+			err.Line = nodeLine(root, err.path)
+		}
+	}
+	slices.SortStableFunc(e, func(a, b *ValidationError) int { return a.Line - b.Line })
+}
+
+// nodeLine returns the line of the value at path, or of its closest
+// ancestor that exists in the document
+func nodeLine(root *yaml.Node, path []string) int {
+	node := root
+	if node == nil {
+		return 0
+	}
+	if node.Kind == yaml.DocumentNode && len(node.Content) > 0 {
+		node = node.Content[0]
+	}
+
+	line := 0
+	for _, segment := range path {
+		var next *yaml.Node
+		switch node.Kind {
+		case yaml.MappingNode:
+			for i := 0; i+1 < len(node.Content); i += 2 {
+				if node.Content[i].Value == segment {
+					line = node.Content[i].Line
+					next = node.Content[i+1]
+					break
+				}
+			}
+		case yaml.SequenceNode:
+			if i, err := strconv.Atoi(segment); err == nil && i >= 0 && i < len(node.Content) {
+				next = node.Content[i]
+				line = next.Line
+			}
+		}
+		if next == nil {
+			break
+		}
+		node = next
+	}
+	return line
+}
+
+// decodeErrors converts a yaml.v3 error into ValidationErrors, keeping the
+// line numbers the decoder reports
+func decodeErrors(err error) ValidationErrors {
+	var messages []string
+	var typeErr *yaml.TypeError
+	if errors.As(err, &typeErr) {
+		messages = typeErr.Errors
+	} else {
+		messages = []string{err.Error()}
+	}
+
+	errs := make(ValidationErrors, 0, len(messages))
+	for _, message := range messages {
+		verr := &ValidationError{Message: strings.TrimPrefix(message, "yaml: ")}
+		if m := yamlLinePrefix.FindStringSubmatch(message); m != nil {
+			verr.Line, _ = strconv.Atoi(m[1])
+			verr.Message = m[2]
+		}
+		errs = append(errs, verr)
+	}
+	return errs
+}
+
+// formatPath renders a path as a YAML field reference like ports[1]
+func formatPath(path []string) string {
+	var b strings.Builder
+	for _, segment := range path {
+		if _, err := strconv.Atoi(segment); err == nil && b.Len() > 0 {
+			b.WriteString("[" + segment + "]")
+			continue
+		}
+		if b.Len() > 0 {
+			b.WriteString(".")
+		}
+		b.WriteString(segment)
+	}
+	return b.String()
+}
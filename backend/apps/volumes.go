@@ -0,0 +1,135 @@
+package apps
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// DataDir is the directory relative bind mount paths of manifests, like
+// .data/.earnapp, are resolved against. Empty leaves them relative to
+// wherever Docker resolves them.
+var DataDir = ""
+
+// splitVolume splits a host:container[:mode] volume mapping. A Windows
+// drive letter on the host side, as in C:\data:/data, is kept together.
+func splitVolume(volume string) (host, container, mode string, ok bool) {
+	host, rest, ok := strings.Cut(volume, ":")
+	if len(host) == 1 && len(rest) > 0 && (rest[0] == '\\' || rest[0] == '/') {
+		var drivePath string
+		drivePath, rest, ok = strings.Cut(rest, ":")
+		host = host + ":" + drivePath
+	}
+	if !ok || host == "" || rest == "" {
+		return "", "", "", false
+	}
+	container, mode, _ = strings.Cut(rest, ":")
+	return host, container, mode, true
+}
+
+// isHostPath reports whether the host side of a volume is a bind mount
+// path rather than a named volume
+func isHostPath(host string) bool {
+	return strings.HasPrefix(host, ".") || strings.HasPrefix(host, "~") || strings.HasPrefix(host, "$") ||
+		strings.ContainsAny(host, `/\`)
+}
+
+// resolveHost makes a relative bind mount path absolute under DataDir.
+// Absolute paths, paths from the home directory or a variable, and named
+// volumes are returned as they are.
+func resolveHost(host string) string {
+	if DataDir == "" || !isHostPath(host) || strings.HasPrefix(host, "~") || strings.HasPrefix(host, "$") ||
+		strings.HasPrefix(host, "/") || strings.HasPrefix(host, `\`) || filepath.IsAbs(host) || (len(host) > 1 && host[1] == ':') {
+		return host
+	}
+	return filepath.Join(DataDir, filepath.FromSlash(host))
+}
+
+// instanceHost returns the host side of a volume for one container: the
+// last path element, or the volume name, becomes <container>_<name>. So
+// .data/.earnapp turns into .data/<container>_earnapp.
+func instanceHost(host, containerName string) string {
+	dir, base := "", host
+	if i := strings.LastIndexAny(host, `/\`); i >= 0 {
+		dir, base = host[:i+1], host[i+1:]
+	}
+	return dir + containerName + "_" + strings.TrimPrefix(base, ".")
+}
+
+// isolateVolumes gives every volume a host directory or named volume of
+// its own for containerName, so instances of the same app never share node
+// state. Volumes whose container path is listed in shared are left as the
+// manifest declares them. Relative host paths are resolved under DataDir.
+func isolateVolumes(volumes, shared []string, containerName string) []string {
+	result := make([]string, 0, len(volumes))
+	for _, volume := range volumes {
+		host, container, mode, ok := splitVolume(volume)
+		if !ok {
+			result = append(result, volume)
+			continue
+		}
+		if !slices.Contains(shared, container) {
+			host = instanceHost(host, containerName)
+		}
+		isolated := resolveHost(host) + ":" + container
+		if mode != "" {
+			isolated += ":" + mode
+		}
+		result = append(result, isolated)
+	}
+	return result
+}
+
+// adoptSharedVolumes moves the host directories of volumes that earlier
+// versions mounted into every instance, like .data/.grass, to the isolated
+// directories of containerName, so the node that already ran on this host
+// keeps its identity. Directories the container already has are left alone.
+func adoptSharedVolumes(volumes, shared []string, containerName string) error {
+	for _, volume := range volumes {
+		host, container, _, ok := splitVolume(volume)
+		if !ok || !isHostPath(host) || slices.Contains(shared, container) {
+			continue
+		}
+		old := filepath.FromSlash(resolveHost(host))
+		isolated := filepath.FromSlash(resolveHost(instanceHost(host, containerName)))
+		if _, err := os.Stat(isolated); !errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		if info, err := os.Stat(old); err != nil || !info.IsDir() {
+			continue
+		}
+		if err := os.Rename(old, isolated); err != nil {
+			return fmt.Errorf("failed to move %s to %s: %w", old, isolated, err)
+		}
+	}
+	return nil
+}
+
+// VolumeMount is a bind mount of one instance: the host directory and the
+// path it is mounted at in the container
+type VolumeMount struct {
+	Host      string `json:"host"`
+	Container string `json:"container"`
+}
+
+// InstanceVolumes returns the host directories that hold the isolated
+// volumes of a container. Shared volumes and named volumes are not
+// included.
+func InstanceVolumes(manifest *AppManifest, containerName string) []VolumeMount {
+	var mounts []VolumeMount
+	for _, volume := range manifest.Volumes {
+		host, container, _, ok := splitVolume(volume)
+		if !ok || !isHostPath(host) || slices.Contains(manifest.SharedVolumes, container) {
+			continue
+		}
+		mounts = append(mounts, VolumeMount{
+			Host:      filepath.FromSlash(resolveHost(instanceHost(host, containerName))),
+			Container: container,
+		})
+	}
+	return mounts
+}
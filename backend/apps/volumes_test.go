@@ -0,0 +1,91 @@
+package apps
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+// useDataDir points DataDir at a temporary directory for one test
+func useDataDir(t *testing.T) string {
+	t.Helper()
+	dir := t.TempDir()
+	old := DataDir
+	DataDir = dir
+	t.Cleanup(func() { DataDir = old })
+	return dir
+}
+
+func TestIsolateVolumes(t *testing.T) {
+	const name = "box_earnapp_local"
+	tests := []struct {
+		volumes []string
+		shared  []string
+		want    []string
+	}{
+		{[]string{".data/.earnapp:/etc/earnapp"}, nil, []string{".data/box_earnapp_local_earnapp:/etc/earnapp"}},
+		{[]string{".data/mysterium-node:/var/lib/mysterium-node:rw"}, nil, []string{".data/box_earnapp_local_mysterium-node:/var/lib/mysterium-node:rw"}},
+		{[]string{"/srv/earn:/data"}, nil, []string{"/srv/box_earnapp_local_earn:/data"}},
+		{[]string{`C:\earn\data:/data`}, nil, []string{`C:\earn\box_earnapp_local_data:/data`}},
+		{[]string{"earn-data:/data"}, nil, []string{"box_earnapp_local_earn-data:/data"}},
+		{[]string{".data/.earnapp:/etc/earnapp", ".data/cache:/cache"}, []string{"/cache"}, []string{".data/box_earnapp_local_earnapp:/etc/earnapp", ".data/cache:/cache"}},
+		{[]string{"/data"}, nil, []string{"/data"}},
+	}
+	for _, tt := range tests {
+		if got := isolateVolumes(tt.volumes, tt.shared, name); !slices.Equal(got, tt.want) {
+			t.Fatalf("isolateVolumes(%q, %q) = %q, want %q", tt.volumes, tt.shared, got, tt.want)
+		}
+	}
+
+	// Relative paths resolve under DataDir, others stay as they are
+	dir := useDataDir(t)
+	got := isolateVolumes([]string{".data/.earnapp:/etc/earnapp", "/srv/earn:/data", "~/earn:/home"}, nil, name)
+	want := []string{
+		filepath.Join(dir, ".data", "box_earnapp_local_earnapp") + ":/etc/earnapp",
+		"/srv/box_earnapp_local_earn:/data",
+		"~/box_earnapp_local_earn:/home",
+	}
+	if !slices.Equal(got, want) {
+		t.Fatalf("isolateVolumes under DataDir = %q, want %q", got, want)
+	}
+}
+
+func TestAdoptSharedVolumes(t *testing.T) {
+	dir := useDataDir(t)
+	volumes := []string{".data/.grass:/app/chrome_user_data", ".data/cache:/cache"}
+	shared := []string{"/cache"}
+	for _, name := range []string{".grass", "cache"} {
+		if err := os.MkdirAll(filepath.Join(dir, ".data", name), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.WriteFile(filepath.Join(dir, ".data", ".grass", "node"), []byte("identity"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := adoptSharedVolumes(volumes, shared, "box_grass_local"); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(filepath.Join(dir, ".data", "box_grass_local_grass", "node"))
+	if err != nil || string(data) != "identity" {
+		t.Fatalf("adopted node file = %q, %v, want %q", data, err, "identity")
+	}
+	if _, err := os.Stat(filepath.Join(dir, ".data", ".grass")); !os.IsNotExist(err) {
+		t.Fatalf("shared directory left after adopting it: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, ".data", "cache")); err != nil {
+		t.Fatalf("shared volume moved: %v", err)
+	}
+
+	// An instance that has a directory of its own keeps it
+	if err := os.MkdirAll(filepath.Join(dir, ".data", ".grass"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := adoptSharedVolumes(volumes, shared, "box_grass_local"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, ".data", ".grass")); err != nil {
+		t.Fatalf("shared directory moved over an existing instance directory: %v", err)
+	}
+}
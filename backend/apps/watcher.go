@@ -0,0 +1,141 @@
+package apps
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"bandwidth-income-manager/backend/docker"
+)
+
+// watchedActions are the container events that change an instance's status
+var watchedActions = []string{"start", "die", "oom", "destroy", "health_status"}
+
+// StatusWatcher keeps AppInstance statuses in sync with Docker container events
+type StatusWatcher struct {
+	runtime   docker.Runtime
+	instances *InstanceManager
+}
+
+// NewStatusWatcher creates a watcher for the instances in im
+func NewStatusWatcher(rt docker.Runtime, im *InstanceManager) *StatusWatcher {
+	return &StatusWatcher{
+		runtime:   rt,
+		instances: im,
+	}
+}
+
+// Run follows the Docker event stream until ctx is cancelled. After every
+// (re)connect it resyncs all instances from the container list, and replays
+// events from the moment of the resync so nothing falls in between.
+func (w *StatusWatcher) Run(ctx context.Context) {
+	backoff := time.Second
+	for {
+		since := time.Now()
+		if err := w.Sync(); err != nil {
+			fmt.Printf("failed to sync instance status: %v\n", err)
+		}
+
+		err := w.runtime.StreamEvents(ctx, since, watchedActions, w.handleEvent)
+		if ctx.Err() != nil {
+			return
+		}
+
+		// A stream that stayed up for a while was healthy; start over with a short delay
+		if time.Since(since) > time.Minute {
+			backoff = time.Second
+		}
+		fmt.Printf("docker event stream interrupted: %v (retrying in %s)\n", err, backoff)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(backoff):
+		}
+		if backoff < time.Minute {
+			backoff *= 2
+		}
+	}
+}
+
+// Sync sets every instance's status from the current container list
+func (w *StatusWatcher) Sync() error {
+	containers, err := w.runtime.ListContainers()
+	if err != nil {
+		return err
+	}
+
+	// Copies, as the API keeps changing the instances meanwhile
+	for _, instance := range w.instances.Snapshot() {
+		if instance.ContainerID == "" {
+			continue
+		}
+
+		status := StatusRemoved
+		for _, c := range containers {
+			if !strings.HasPrefix(c.ID, instance.ContainerID) && !strings.HasPrefix(instance.ContainerID, c.ID) {
+				continue
+			}
+			status = containerStatus(c)
+			break
+		}
+
+		// Keep the more specific OOM status for a container that stayed down
+		keep := ""
+		if status == StatusExited {
+			keep = StatusOOMKilled
+		}
+		_ = w.instances.UpdateInstanceStatusUnless(instance.InstanceID, status, keep)
+	}
+	return nil
+}
+
+// handleEvent applies a single container event to the matching instance
+func (w *StatusWatcher) handleEvent(event docker.Event) {
+	instance, err := w.instances.GetInstanceByContainerID(event.ContainerID)
+	if err != nil {
+		// Not one of our containers
+		return
+	}
+
+	var status, keep string
+	switch {
+	case event.Action == "start":
+		status = StatusRunning
+	case event.Action == "oom":
+		status = StatusOOMKilled
+	case event.Action == "die":
+		// An OOM kill is followed by a die event; keep the more useful status
+		status, keep = StatusExited, StatusOOMKilled
+	case event.Action == "destroy":
+		status = StatusRemoved
+	case strings.HasPrefix(event.Action, "health_status"):
+		if strings.HasSuffix(event.Action, "unhealthy") {
+			status = StatusUnhealthy
+		} else if strings.HasSuffix(event.Action, "healthy") {
+			status = StatusRunning
+		} else {
+			return
+		}
+	default:
+		return
+	}
+
+	_ = w.instances.UpdateInstanceStatusUnless(instance.InstanceID, status, keep)
+}
+
+// containerStatus maps a listed container onto an instance status
+func containerStatus(c docker.ContainerInfo) string {
+	switch strings.ToLower(c.State) {
+	case "running":
+		if strings.Contains(c.Status, "(unhealthy)") {
+			return StatusUnhealthy
+		}
+		return StatusRunning
+	case "exited", "dead", "":
+		return StatusExited
+	default:
+		return strings.ToLower(c.State)
+	}
+}
@@ -0,0 +1,284 @@
+package backup
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// Layout of a snapshot archive: metadata.json first, so listing only has to
+// read the start of each file, then the contents of every volume under
+// volumes/<index>/, where index points into Metadata.Volumes.
+const (
+	metadataEntry = "metadata.json"
+	volumesDir    = "volumes"
+)
+
+// writeArchive writes a gzipped tarball of meta and the given host
+// directories to file. Files that vanish while the directory is walked,
+// as they do in the profile directory of a running browser, are skipped.
+func writeArchive(file string, meta *Metadata, hostDirs []string) error {
+	f, err := os.Create(file)
+	if err != nil {
+		return err
+	}
+	gz := gzip.NewWriter(f)
+	tw := tar.NewWriter(gz)
+
+	err = writeEntries(tw, meta, hostDirs)
+	if closeErr := tw.Close(); err == nil {
+		err = closeErr
+	}
+	if closeErr := gz.Close(); err == nil {
+		err = closeErr
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+func writeEntries(tw *tar.Writer, meta *Metadata, hostDirs []string) error {
+	data, err := json.MarshalIndent(meta, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := tw.WriteHeader(&tar.Header{
+		Name:    metadataEntry,
+		Mode:    0644,
+		Size:    int64(len(data)),
+		ModTime: meta.CreatedAt,
+	}); err != nil {
+		return err
+	}
+	if _, err := tw.Write(data); err != nil {
+		return err
+	}
+
+	for i, dir := range hostDirs {
+		prefix := path.Join(volumesDir, strconv.Itoa(i))
+		if err := addDir(tw, dir, prefix); err != nil {
+			return fmt.Errorf("%s: %w", dir, err)
+		}
+	}
+	return nil
+}
+
+// addDir adds the tree under dir to the archive below prefix
+func addDir(tw *tar.Writer, dir, prefix string) error {
+	return filepath.WalkDir(dir, func(file string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if errors.Is(err, fs.ErrNotExist) {
+				return nil
+			}
+			return err
+		}
+		info, err := d.Info()
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		rel, err := filepath.Rel(dir, file)
+		if err != nil {
+			return err
+		}
+		name := path.Join(prefix, filepath.ToSlash(rel))
+
+		var link string
+		switch {
+		case info.Mode()&fs.ModeSymlink != 0:
+			if link, err = os.Readlink(file); err != nil {
+				return nil
+			}
+		case !info.Mode().IsRegular() && !info.IsDir():
+			// Sockets, pipes and devices cannot be restored meaningfully
+			return nil
+		}
+
+		header, err := tar.FileInfoHeader(info, link)
+		if err != nil {
+			return err
+		}
+		header.Name = name
+		if info.IsDir() {
+			header.Name += "/"
+		}
+
+		if !info.Mode().IsRegular() {
+			return tw.WriteHeader(header)
+		}
+		src, err := os.Open(file)
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		defer src.Close()
+		if err := tw.WriteHeader(header); err != nil {
+			return err
+		}
+		// A file written to while it is copied is cut or zero padded to the
+		// size it had when the walk reached it
+		n, err := io.Copy(tw, io.LimitReader(src, header.Size))
+		if err != nil {
+			return fmt.Errorf("%s: %w", rel, err)
+		}
+		_, err = io.CopyN(tw, zeros{}, header.Size-n)
+		return err
+	})
+}
+
+// zeros is an endless reader of zero bytes
+type zeros struct{}
+
+func (zeros) Read(p []byte) (int, error) {
+	clear(p)
+	return len(p), nil
+}
+
+// readMetadata reads the metadata entry at the start of an archive
+func readMetadata(file string) (*Metadata, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		return nil, err
+	}
+	defer gz.Close()
+
+	tr := tar.NewReader(gz)
+	header, err := tr.Next()
+	if err != nil {
+		return nil, err
+	}
+	if header.Name != metadataEntry {
+		return nil, fmt.Errorf("not a backup archive: first entry is %s", header.Name)
+	}
+	var meta Metadata
+	if err := json.NewDecoder(tr).Decode(&meta); err != nil {
+		return nil, fmt.Errorf("invalid backup metadata: %w", err)
+	}
+	return &meta, nil
+}
+
+// extractArchive unpacks the volumes of an archive into targets, which
+// maps a volume index to the directory it is restored to. Volumes without
+// a target are skipped.
+func extractArchive(file string, targets map[int]string) error {
+	f, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		return err
+	}
+	defer gz.Close()
+
+	tr := tar.NewReader(gz)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		index, rel, ok := volumeEntry(header.Name)
+		if !ok {
+			continue
+		}
+		root, ok := targets[index]
+		if !ok {
+			continue
+		}
+		target := filepath.Join(root, filepath.FromSlash(rel))
+		if !within(root, target) {
+			return fmt.Errorf("archive entry %s escapes the volume", header.Name)
+		}
+		if header.Typeflag == tar.TypeSymlink &&
+			(filepath.IsAbs(header.Linkname) || !within(root, filepath.Join(filepath.Dir(target), header.Linkname))) {
+			// Absolute links, such as a browser's singleton socket in /tmp,
+			// point at runtime state that is gone anyway
+			continue
+		}
+
+		if err := extractEntry(tr, header, target); err != nil {
+			return fmt.Errorf("%s: %w", header.Name, err)
+		}
+	}
+}
+
+// within reports whether target is root or below it
+func within(root, target string) bool {
+	root = filepath.Clean(root)
+	return target == root || strings.HasPrefix(target, root+string(filepath.Separator))
+}
+
+// volumeEntry splits volumes/<index>/<rel> into its parts
+func volumeEntry(name string) (int, string, bool) {
+	rest, ok := strings.CutPrefix(path.Clean(name), volumesDir+"/")
+	if !ok {
+		return 0, "", false
+	}
+	indexPart, rel, _ := strings.Cut(rest, "/")
+	index, err := strconv.Atoi(indexPart)
+	if err != nil {
+		return 0, "", false
+	}
+	if rel == "" {
+		rel = "."
+	}
+	return index, rel, true
+}
+
+func extractEntry(tr *tar.Reader, header *tar.Header, target string) error {
+	mode := fs.FileMode(header.Mode).Perm()
+	switch header.Typeflag {
+	case tar.TypeDir:
+		return os.MkdirAll(target, mode|0700)
+	case tar.TypeSymlink:
+		if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+			return err
+		}
+		return os.Symlink(header.Linkname, target)
+	case tar.TypeReg:
+		if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+			return err
+		}
+		dst, err := os.OpenFile(target, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, mode)
+		if err != nil {
+			return err
+		}
+		if _, err := io.Copy(dst, tr); err != nil {
+			dst.Close()
+			return err
+		}
+		if err := dst.Close(); err != nil {
+			return err
+		}
+		return os.Chtimes(target, header.ModTime, header.ModTime)
+	default:
+		return nil
+	}
+}
@@ -0,0 +1,668 @@
+package backup
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"bandwidth-income-manager/backend/apps"
+	"bandwidth-income-manager/backend/docker"
+)
+
+// Reasons a snapshot was taken
+const (
+	ReasonManual    = "manual"
+	ReasonScheduled = "scheduled"
+	ReasonRemoval   = "removal"     // taken before the instance was removed
+	ReasonRestore   = "pre-restore" // data that a restore replaced
+	ReasonExport    = "export"      // taken for an export to another host
+)
+
+const archiveExt = ".tar.gz"
+
+// ErrNoData is returned when an instance has no data directory to snapshot
+var ErrNoData = errors.New("instance has no data to back up")
+
+// Metadata describes a snapshot. It is stored as the first entry of the
+// archive.
+type Metadata struct {
+	ID            string    `json:"id"`
+	AppID         string    `json:"app_id"`
+	InstanceID    string    `json:"instance_id,omitempty"`
+	ContainerName string    `json:"container_name"`
+	DeviceName    string    `json:"device_name"`
+	ProxyID       string    `json:"proxy_id,omitempty"`
+	Image         string    `json:"image"`
+	ImageDigest   string    `json:"image_digest,omitempty"`
+	Reason        string    `json:"reason"`
+	CreatedAt     time.Time `json:"created_at"`
+	Volumes       []string  `json:"volumes"`        // container paths, in archive order
+	Size          int64     `json:"size,omitempty"` // archive size, set when listing
+}
+
+// identity groups the snapshots that retention counts together
+func (m *Metadata) identity() string {
+	return apps.IdentityKey(m.AppID, m.DeviceName, m.ProxyID)
+}
+
+// Policy controls scheduled snapshots and how long snapshots are kept
+type Policy struct {
+	KeepLast      int `json:"keep_last"`      // snapshots kept per instance, 0 keeps all
+	MaxAgeDays    int `json:"max_age_days"`   // older snapshots are deleted, 0 keeps them forever
+	IntervalHours int `json:"interval_hours"` // hours between scheduled snapshots, 0 disables them
+}
+
+// DefaultPolicy is used until a policy is saved
+var DefaultPolicy = Policy{KeepLast: 5, MaxAgeDays: 30, IntervalHours: 24}
+
+// Manager takes, restores and prunes snapshots of instance data volumes.
+// Snapshots are tar.gz files in a single directory.
+type Manager struct {
+	dir       string
+	runtime   docker.Runtime
+	instances *apps.InstanceManager
+
+	policy Policy
+	mu     sync.Mutex // serializes snapshots, restores and pruning
+}
+
+// NewManager creates a backup manager that keeps its snapshots and policy
+// in dir
+func NewManager(dir string, rt docker.Runtime, im *apps.InstanceManager) *Manager {
+	m := &Manager{
+		dir:       dir,
+		runtime:   rt,
+		instances: im,
+		policy:    DefaultPolicy,
+	}
+	if data, err := os.ReadFile(m.policyPath()); err == nil {
+		if err := json.Unmarshal(data, &m.policy); err != nil {
+			fmt.Printf("invalid backup policy, using defaults: %v\n", err)
+			m.policy = DefaultPolicy
+		}
+	}
+	return m
+}
+
+func (m *Manager) policyPath() string {
+	return filepath.Join(m.dir, "policy.json")
+}
+
+// Policy returns the current retention and schedule policy
+func (m *Manager) Policy() Policy {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.policy
+}
+
+// SetPolicy saves a new policy and prunes snapshots it no longer keeps
+func (m *Manager) SetPolicy(policy Policy) error {
+	if policy.KeepLast < 0 || policy.MaxAgeDays < 0 || policy.IntervalHours < 0 {
+		return fmt.Errorf("backup policy values must not be negative")
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if err := os.MkdirAll(m.dir, 0755); err != nil {
+		return fmt.Errorf("failed to create backup directory: %w", err)
+	}
+	data, _ := json.MarshalIndent(policy, "", "  ")
+	if err := os.WriteFile(m.policyPath(), data, 0644); err != nil {
+		return fmt.Errorf("failed to save backup policy: %w", err)
+	}
+	m.policy = policy
+	_, err := m.prune()
+	return err
+}
+
+// source is a container a snapshot is taken of or restored into
+type source struct {
+	meta    *Metadata
+	mounts  []apps.VolumeMount
+	running bool
+}
+
+// inspect resolves the app, identity, image and data directories of a
+// container. Tracked instances are authoritative; containers that are not
+// tracked are attributed by their labels and name.
+func (m *Manager) inspect(containerID string) (*source, error) {
+	details, err := m.runtime.InspectContainer(containerID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to inspect container: %w", err)
+	}
+	labels := details.Config.Labels
+	meta := &Metadata{
+		AppID:         labels[apps.LabelAppID],
+		InstanceID:    labels[apps.LabelInstanceID],
+		ProxyID:       labels[apps.LabelProxyID],
+		ContainerName: details.Name,
+		Image:         details.Config.Image,
+	}
+	if instance, err := m.instances.GetInstanceByContainerID(containerID); err == nil {
+		meta.AppID = instance.AppID
+		meta.InstanceID = instance.InstanceID
+		meta.ProxyID = instance.ProxyID
+		meta.DeviceName = instance.DeviceName
+	}
+	if meta.DeviceName == "" {
+		// Container names are <device>_<app>_local or <device>_<app>_proxy<hash>
+		meta.DeviceName, _, _ = strings.Cut(details.Name, "_"+meta.AppID+"_")
+	}
+
+	manifest := apps.GetAppManifest(meta.AppID)
+	if manifest == nil {
+		return nil, fmt.Errorf("container %s does not belong to a known app", details.Name)
+	}
+	if image, err := m.runtime.InspectImage(details.ImageID); err == nil && len(image.RepoDigests) > 0 {
+		_, meta.ImageDigest, _ = strings.Cut(image.RepoDigests[0], "@")
+	}
+
+	return &source{
+		meta:    meta,
+		mounts:  apps.InstanceVolumes(manifest, details.Name),
+		running: details.Running,
+	}, nil
+}
+
+// BackupInstance takes a snapshot of an instance's data
+func (m *Manager) BackupInstance(instanceID, reason string) (*Metadata, error) {
+	instance, err := m.instances.GetInstance(instanceID)
+	if err != nil {
+		return nil, err
+	}
+	if instance.ContainerID == "" {
+		return nil, fmt.Errorf("instance %s has no container", instanceID)
+	}
+	return m.BackupContainer(instance.ContainerID, reason)
+}
+
+// BackupContainer takes a snapshot of an app container's data. A running
+// container keeps running; files it changes meanwhile are copied as found.
+func (m *Manager) BackupContainer(containerID, reason string) (*Metadata, error) {
+	src, err := m.inspect(containerID)
+	if err != nil {
+		return nil, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	meta, err := m.snapshot(src, reason)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := m.prune(); err != nil {
+		fmt.Printf("failed to prune backups: %v\n", err)
+	}
+	return meta, nil
+}
+
+// Archive snapshots the data of a container that is about to be removed
+// and returns its data directories. They are left in place; callers
+// delete them with RemoveData once the container is gone.
+func (m *Manager) Archive(containerID string) (*Metadata, []string, error) {
+	src, err := m.inspect(containerID)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	meta, err := m.snapshot(src, ReasonRemoval)
+	if err != nil {
+		return nil, nil, err
+	}
+	dirs := make([]string, 0, len(src.mounts))
+	for _, mount := range src.mounts {
+		dirs = append(dirs, mount.Host)
+	}
+	if _, err := m.prune(); err != nil {
+		fmt.Printf("failed to prune backups: %v\n", err)
+	}
+	return meta, dirs, nil
+}
+
+// RemoveData deletes the data directories Archive returned
+func RemoveData(dirs []string) {
+	for _, dir := range dirs {
+		if err := os.RemoveAll(dir); err != nil {
+			fmt.Printf("failed to remove data directory %s: %v\n", dir, err)
+		}
+	}
+}
+
+// BackupAll snapshots every instance that has data, then prunes
+func (m *Manager) BackupAll(reason string) ([]Metadata, error) {
+	var taken []Metadata
+	var errs []error
+	for _, instance := range m.instances.GetAllInstances() {
+		if instance.ContainerID == "" {
+			continue
+		}
+		src, err := m.inspect(instance.ContainerID)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", instance.InstanceID, err))
+			continue
+		}
+		m.mu.Lock()
+		meta, err := m.snapshot(src, reason)
+		m.mu.Unlock()
+		if errors.Is(err, ErrNoData) {
+			continue
+		}
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", instance.InstanceID, err))
+			continue
+		}
+		taken = append(taken, *meta)
+	}
+
+	m.mu.Lock()
+	_, err := m.prune()
+	m.mu.Unlock()
+	return taken, errors.Join(append(errs, err)...)
+}
+
+// snapshot writes an archive of src's existing data directories. The
+// caller holds m.mu.
+func (m *Manager) snapshot(src *source, reason string) (*Metadata, error) {
+	meta, dirs, err := src.collect(reason)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := os.MkdirAll(m.dir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create backup directory: %w", err)
+	}
+	meta.ID = m.newID(meta.ContainerName, meta.CreatedAt)
+
+	file := m.archivePath(meta.ID)
+	tmp := file + ".tmp"
+	if err := writeArchive(tmp, meta, dirs); err != nil {
+		os.Remove(tmp)
+		return nil, fmt.Errorf("failed to write backup: %w", err)
+	}
+	if err := os.Rename(tmp, file); err != nil {
+		os.Remove(tmp)
+		return nil, fmt.Errorf("failed to write backup: %w", err)
+	}
+	if info, err := os.Stat(file); err == nil {
+		meta.Size = info.Size()
+	}
+	return meta, nil
+}
+
+// collect returns the metadata of a new snapshot of src and the data
+// directories it holds
+func (src *source) collect(reason string) (*Metadata, []string, error) {
+	meta := *src.meta
+	meta.Reason = reason
+	meta.CreatedAt = time.Now()
+
+	var dirs []string
+	for _, mount := range src.mounts {
+		if info, err := os.Stat(mount.Host); err == nil && info.IsDir() {
+			dirs = append(dirs, mount.Host)
+			meta.Volumes = append(meta.Volumes, mount.Container)
+		}
+	}
+	if len(dirs) == 0 {
+		return nil, nil, ErrNoData
+	}
+	return &meta, dirs, nil
+}
+
+// newID returns an unused snapshot ID for a container. The caller holds
+// m.mu.
+func (m *Manager) newID(containerName string, createdAt time.Time) string {
+	id := containerName + "_" + createdAt.Format("20060102_150405")
+	for n := 2; fileExists(m.archivePath(id)); n++ {
+		id = fmt.Sprintf("%s_%s_%d", containerName, createdAt.Format("20060102_150405"), n)
+	}
+	return id
+}
+
+// Export writes a snapshot of an app container's data to file. The
+// snapshot is not kept in the backup directory, so it is neither listed
+// nor counted by the retention policy.
+func (m *Manager) Export(containerID, file string) (*Metadata, error) {
+	src, err := m.inspect(containerID)
+	if err != nil {
+		return nil, err
+	}
+	meta, dirs, err := src.collect(ReasonExport)
+	if err != nil {
+		return nil, err
+	}
+	meta.ID = meta.ContainerName + "_" + meta.CreatedAt.Format("20060102_150405")
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if err := writeArchive(file, meta, dirs); err != nil {
+		return nil, fmt.Errorf("failed to write snapshot: %w", err)
+	}
+	return meta, nil
+}
+
+// Import copies a snapshot archive, such as one written by Export on
+// another host, into the backup directory under a new ID
+func (m *Manager) Import(file string) (*Metadata, error) {
+	meta, err := readMetadata(file)
+	if err != nil {
+		return nil, err
+	}
+	if apps.GetAppManifest(meta.AppID) == nil {
+		return nil, fmt.Errorf("snapshot is of unknown app: %s", meta.AppID)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if err := os.MkdirAll(m.dir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create backup directory: %w", err)
+	}
+	meta.ID = m.newID(meta.ContainerName, meta.CreatedAt)
+
+	target := m.archivePath(meta.ID)
+	tmp := target + ".tmp"
+	if err := copyFile(file, tmp); err != nil {
+		os.Remove(tmp)
+		return nil, fmt.Errorf("failed to import snapshot: %w", err)
+	}
+	if err := os.Rename(tmp, target); err != nil {
+		os.Remove(tmp)
+		return nil, fmt.Errorf("failed to import snapshot: %w", err)
+	}
+	if info, err := os.Stat(target); err == nil {
+		meta.Size = info.Size()
+	}
+	return meta, nil
+}
+
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+func (m *Manager) archivePath(id string) string {
+	return filepath.Join(m.dir, id+archiveExt)
+}
+
+func fileExists(file string) bool {
+	_, err := os.Stat(file)
+	return err == nil
+}
+
+// List returns the snapshots of an app, or of all apps if appID is empty,
+// newest first
+func (m *Manager) List(appID string) ([]Metadata, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	all, err := m.list()
+	if err != nil {
+		return nil, err
+	}
+	result := make([]Metadata, 0, len(all))
+	for _, meta := range all {
+		if appID == "" || meta.AppID == appID {
+			result = append(result, meta)
+		}
+	}
+	return result, nil
+}
+
+// list reads the metadata of every archive in the backup directory. The
+// caller holds m.mu.
+func (m *Manager) list() ([]Metadata, error) {
+	entries, err := os.ReadDir(m.dir)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var result []Metadata
+	for _, entry := range entries {
+		id, ok := strings.CutSuffix(entry.Name(), archiveExt)
+		if !ok || entry.IsDir() {
+			continue
+		}
+		meta, err := readMetadata(m.archivePath(id))
+		if err != nil {
+			fmt.Printf("skipping backup %s: %v\n", entry.Name(), err)
+			continue
+		}
+		meta.ID = id
+		if info, err := entry.Info(); err == nil {
+			meta.Size = info.Size()
+		}
+		result = append(result, *meta)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].CreatedAt.After(result[j].CreatedAt)
+	})
+	return result, nil
+}
+
+// Get returns the metadata of a snapshot
+func (m *Manager) Get(id string) (*Metadata, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.get(id)
+}
+
+func (m *Manager) get(id string) (*Metadata, error) {
+	if id == "" || id != filepath.Base(id) || strings.HasPrefix(id, ".") {
+		return nil, fmt.Errorf("invalid backup id: %q", id)
+	}
+	meta, err := readMetadata(m.archivePath(id))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("backup not found: %s", id)
+	}
+	if err != nil {
+		return nil, err
+	}
+	meta.ID = id
+	return meta, nil
+}
+
+// Delete removes a snapshot
+func (m *Manager) Delete(id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, err := m.get(id); err != nil {
+		return err
+	}
+	return os.Remove(m.archivePath(id))
+}
+
+// Restore replaces the data of an existing app container with a snapshot.
+// The container is stopped while its data is swapped, and the data it had
+// is kept as a pre-restore snapshot.
+func (m *Manager) Restore(id, containerID string) error {
+	src, err := m.inspect(containerID)
+	if err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	meta, err := m.get(id)
+	if err != nil {
+		return err
+	}
+	if meta.AppID != src.meta.AppID {
+		return fmt.Errorf("backup %s is of %s, not %s", id, meta.AppID, src.meta.AppID)
+	}
+
+	if src.running {
+		if err := m.runtime.StopContainer(containerID); err != nil {
+			return fmt.Errorf("failed to stop container: %w", err)
+		}
+	}
+	if _, err := m.snapshot(src, ReasonRestore); err != nil && !errors.Is(err, ErrNoData) {
+		return fmt.Errorf("failed to keep current data: %w", err)
+	}
+	restoreErr := m.restore(meta, src.mounts)
+	if src.running {
+		if err := m.runtime.StartContainer(containerID); err != nil {
+			return errors.Join(restoreErr, fmt.Errorf("failed to start container: %w", err))
+		}
+	}
+	return restoreErr
+}
+
+// Extract unpacks a snapshot into the data directories a new container
+// named containerName will use, replacing whatever they hold. It is used
+// to restore into an instance before deploying it.
+func (m *Manager) Extract(id, containerName string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	meta, err := m.get(id)
+	if err != nil {
+		return err
+	}
+	manifest := apps.GetAppManifest(meta.AppID)
+	if manifest == nil {
+		return fmt.Errorf("unknown app: %s", meta.AppID)
+	}
+	return m.restore(meta, apps.InstanceVolumes(manifest, containerName))
+}
+
+// restore unpacks a snapshot next to the target directories and swaps
+// them in once every volume is unpacked. The caller holds m.mu.
+func (m *Manager) restore(meta *Metadata, mounts []apps.VolumeMount) error {
+	targets := make(map[int]string)
+	final := make(map[string]string) // unpacked dir -> target dir
+	for i, volume := range meta.Volumes {
+		for _, mount := range mounts {
+			if mount.Container != volume {
+				continue
+			}
+			tmp := mount.Host + ".restore"
+			if err := os.RemoveAll(tmp); err != nil {
+				return err
+			}
+			targets[i] = tmp
+			final[tmp] = mount.Host
+		}
+	}
+	if len(targets) == 0 {
+		return fmt.Errorf("backup %s has no volumes this app uses", meta.ID)
+	}
+
+	cleanup := func() {
+		for tmp := range final {
+			os.RemoveAll(tmp)
+		}
+	}
+	for _, tmp := range targets {
+		if err := os.MkdirAll(tmp, 0755); err != nil {
+			cleanup()
+			return err
+		}
+	}
+	if err := extractArchive(m.archivePath(meta.ID), targets); err != nil {
+		cleanup()
+		return fmt.Errorf("failed to unpack backup: %w", err)
+	}
+
+	for tmp, dir := range final {
+		old := dir + ".old"
+		if err := os.RemoveAll(old); err != nil {
+			return err
+		}
+		if err := os.Rename(dir, old); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("failed to replace %s: %w", dir, err)
+		}
+		if err := os.Rename(tmp, dir); err != nil {
+			return fmt.Errorf("failed to replace %s: %w", dir, err)
+		}
+		os.RemoveAll(old)
+	}
+	return nil
+}
+
+// Prune deletes snapshots the policy no longer keeps and returns their IDs
+func (m *Manager) Prune() ([]string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.prune()
+}
+
+// prune applies the retention policy per instance identity. The caller
+// holds m.mu.
+func (m *Manager) prune() ([]string, error) {
+	all, err := m.list()
+	if err != nil {
+		return nil, err
+	}
+
+	var cutoff time.Time
+	if m.policy.MaxAgeDays > 0 {
+		cutoff = time.Now().AddDate(0, 0, -m.policy.MaxAgeDays)
+	}
+	kept := make(map[string]int)
+	var removed []string
+	var errs []error
+	// all is newest first, so the first KeepLast of each identity survive
+	for _, meta := range all {
+		identity := meta.identity()
+		expired := !cutoff.IsZero() && meta.CreatedAt.Before(cutoff)
+		if !expired && (m.policy.KeepLast == 0 || kept[identity] < m.policy.KeepLast) {
+			kept[identity]++
+			continue
+		}
+		if err := os.Remove(m.archivePath(meta.ID)); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		removed = append(removed, meta.ID)
+	}
+	return removed, errors.Join(errs...)
+}
+
+// Run takes scheduled snapshots of every instance until ctx is cancelled.
+// The interval is read from the policy each time, so policy changes apply
+// without a restart.
+func (m *Manager) Run(ctx context.Context) {
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+	last := time.Now()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		hours := m.Policy().IntervalHours
+		if hours <= 0 || time.Since(last) < time.Duration(hours)*time.Hour {
+			continue
+		}
+		last = time.Now()
+		if _, err := m.BackupAll(ReasonScheduled); err != nil {
+			fmt.Printf("scheduled backup finished with errors: %v\n", err)
+		}
+	}
+}
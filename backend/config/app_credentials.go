@@ -6,54 +6,184 @@ import (
 	"crypto/rand"
 	"crypto/sha256"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
-	"os"
+	"regexp"
+	"sort"
 	"sync"
 )
 
-// AppCredentials represents stored credentials for an app
+// DefaultProfile is the credential profile used when none is named
+const DefaultProfile = "default"
+
+// Profile names appear in container labels and URLs
+var profileNamePattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_.-]{0,62}$`)
+
+// AppCredentials represents a stored credential profile of an app. An app
+// can have several profiles, e.g. one per account.
 type AppCredentials struct {
 	AppID       string
+	Profile     string
 	DeviceName  string
 	Credentials map[string]string
 }
 
-// CredentialStore manages encrypted storage of app credentials
+// ProfileKey is the key a profile is stored under
+func ProfileKey(appID, profile string) string {
+	return appID + "/" + profile
+}
+
+// ValidateProfileName checks that a profile name can be used in labels
+// and URLs
+func ValidateProfileName(profile string) error {
+	if !profileNamePattern.MatchString(profile) {
+		return fmt.Errorf("invalid profile name %q: use up to 63 letters, digits, '.', '_' or '-', starting with a letter or digit", profile)
+	}
+	return nil
+}
+
+// CredentialStore manages encrypted storage of app credentials. Once a
+// master passphrase is set, the credentials are encrypted with a random
+// data key that is stored wrapped with the passphrase; see passphrase.go.
+// The encrypted items live in a Backend; see backend.go.
 type CredentialStore struct {
-	filePath string
-	key      []byte
-	mu       sync.RWMutex
+	backend   Backend
+	key       []byte // data key, nil while locked
+	protected bool   // a passphrase is set
+	onUnlock  func()
+	mu        sync.RWMutex
 }
 
-// NewCredentialStore creates a new credential store
-func NewCredentialStore() *CredentialStore {
-	return &CredentialStore{
-		filePath: "app_credentials.json.enc",
-		key:      getOrCreateKey(),
+// NewCredentialStore creates a credential store on backend. If a
+// passphrase has been set it starts locked. A secure backend without a
+// passphrase keeps a random data key itself.
+func NewCredentialStore(backend Backend) (*CredentialStore, error) {
+	cs := &CredentialStore{backend: backend}
+
+	_, err := backend.Get(itemKeyFile)
+	switch {
+	case err == nil:
+		cs.protected = true
+		return cs, nil
+	case !errors.Is(err, ErrItemNotFound):
+		return nil, fmt.Errorf("failed to read key from %s: %w", backend.Name(), err)
 	}
+
+	if !backend.Secure() {
+		cs.key = legacyKey()
+		return cs, nil
+	}
+
+	dataKey, err := backend.Get(itemDataKey)
+	if errors.Is(err, ErrItemNotFound) {
+		dataKey = make([]byte, 32)
+		if _, err := rand.Read(dataKey); err != nil {
+			return nil, err
+		}
+		err = backend.Set(itemDataKey, dataKey)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to load data key from %s: %w", backend.Name(), err)
+	}
+	cs.key = dataKey
+
+	// Credentials moved from files are still encrypted with the built-in key
+	if err := cs.finishMigration(); err != nil {
+		return nil, err
+	}
+	return cs, nil
+}
+
+// Backend returns the name of the backend the store keeps its items in
+func (cs *CredentialStore) Backend() string {
+	return cs.backend.Name()
+}
+
+// SetOnUnlock sets the callback for when the store gets unlocked
+func (cs *CredentialStore) SetOnUnlock(callback func()) {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+	cs.onUnlock = callback
 }
 
-// SaveCredentials saves credentials for an app
+// SaveCredentials saves a credential profile of an app, creating or
+// replacing it. An empty Profile saves the default profile.
 func (cs *CredentialStore) SaveCredentials(creds *AppCredentials) error {
+	if creds.Profile == "" {
+		creds.Profile = DefaultProfile
+	}
+	if err := ValidateProfileName(creds.Profile); err != nil {
+		return err
+	}
+
 	cs.mu.Lock()
 	defer cs.mu.Unlock()
 
-	// Load existing credentials
+	// Load existing credentials; a store without any yields an empty map
 	allCreds, err := cs.loadDecrypted()
 	if err != nil {
-		allCreds = make(map[string]*AppCredentials)
+		return err
 	}
 
 	// Add or update credentials
-	allCreds[creds.AppID] = creds
+	allCreds[ProfileKey(creds.AppID, creds.Profile)] = creds
 
 	// Save encrypted
 	return cs.saveEncrypted(allCreds)
 }
 
-// LoadCredentials loads credentials for an app
+// UpdateCredentials replaces an existing credential profile and returns
+// the credentials it held before
+func (cs *CredentialStore) UpdateCredentials(creds *AppCredentials) (*AppCredentials, error) {
+	if creds.Profile == "" {
+		creds.Profile = DefaultProfile
+	}
+
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+
+	allCreds, err := cs.loadDecrypted()
+	if err != nil {
+		return nil, err
+	}
+
+	key := ProfileKey(creds.AppID, creds.Profile)
+	previous, exists := allCreds[key]
+	if !exists {
+		return nil, fmt.Errorf("credential profile %q not found for app: %s", creds.Profile, creds.AppID)
+	}
+	allCreds[key] = creds
+	if err := cs.saveEncrypted(allCreds); err != nil {
+		return nil, err
+	}
+	return previous, nil
+}
+
+// LoadCredentials loads the default profile of an app, or its first
+// profile by name if it has no default
 func (cs *CredentialStore) LoadCredentials(appID string) (*AppCredentials, error) {
+	profiles, err := cs.ListProfiles(appID)
+	if err != nil {
+		return nil, err
+	}
+	if len(profiles) == 0 {
+		return nil, fmt.Errorf("credentials not found for app: %s", appID)
+	}
+	for _, creds := range profiles {
+		if creds.Profile == DefaultProfile {
+			return creds, nil
+		}
+	}
+	return profiles[0], nil
+}
+
+// LoadProfile loads one credential profile of an app
+func (cs *CredentialStore) LoadProfile(appID, profile string) (*AppCredentials, error) {
+	if profile == "" {
+		profile = DefaultProfile
+	}
+
 	cs.mu.RLock()
 	defer cs.mu.RUnlock()
 
@@ -62,15 +192,55 @@ func (cs *CredentialStore) LoadCredentials(appID string) (*AppCredentials, error
 		return nil, err
 	}
 
-	creds, exists := allCreds[appID]
+	creds, exists := allCreds[ProfileKey(appID, profile)]
 	if !exists {
-		return nil, fmt.Errorf("credentials not found for app: %s", appID)
+		return nil, fmt.Errorf("credential profile %q not found for app: %s", profile, appID)
 	}
 
 	return creds, nil
 }
 
-// LoadAllCredentials loads all stored credentials
+// ListProfiles returns the credential profiles of an app sorted by name
+func (cs *CredentialStore) ListProfiles(appID string) ([]*AppCredentials, error) {
+	cs.mu.RLock()
+	defer cs.mu.RUnlock()
+
+	allCreds, err := cs.loadDecrypted()
+	if err != nil {
+		return nil, err
+	}
+
+	profiles := make([]*AppCredentials, 0)
+	for _, creds := range allCreds {
+		if creds.AppID == appID {
+			profiles = append(profiles, creds)
+		}
+	}
+	sort.Slice(profiles, func(i, j int) bool {
+		return profiles[i].Profile < profiles[j].Profile
+	})
+	return profiles, nil
+}
+
+// DeleteProfile removes one credential profile of an app
+func (cs *CredentialStore) DeleteProfile(appID, profile string) error {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+
+	allCreds, err := cs.loadDecrypted()
+	if err != nil {
+		return err
+	}
+
+	key := ProfileKey(appID, profile)
+	if _, exists := allCreds[key]; !exists {
+		return fmt.Errorf("credential profile %q not found for app: %s", profile, appID)
+	}
+	delete(allCreds, key)
+	return cs.saveEncrypted(allCreds)
+}
+
+// LoadAllCredentials loads all stored profiles keyed by ProfileKey
 func (cs *CredentialStore) LoadAllCredentials() (map[string]*AppCredentials, error) {
 	cs.mu.RLock()
 	defer cs.mu.RUnlock()
@@ -78,7 +248,7 @@ func (cs *CredentialStore) LoadAllCredentials() (map[string]*AppCredentials, err
 	return cs.loadDecrypted()
 }
 
-// DeleteCredentials removes credentials for an app
+// DeleteCredentials removes every credential profile of an app
 func (cs *CredentialStore) DeleteCredentials(appID string) error {
 	cs.mu.Lock()
 	defer cs.mu.Unlock()
@@ -88,7 +258,11 @@ func (cs *CredentialStore) DeleteCredentials(appID string) error {
 		return err
 	}
 
-	delete(allCreds, appID)
+	for key, creds := range allCreds {
+		if creds.AppID == appID {
+			delete(allCreds, key)
+		}
+	}
 	return cs.saveEncrypted(allCreds)
 }
 
@@ -99,62 +273,132 @@ func (cs *CredentialStore) GetAllConfiguredApps() ([]string, error) {
 		return []string{}, nil
 	}
 
+	seen := make(map[string]bool)
 	appIDs := make([]string, 0, len(allCreds))
-	for appID := range allCreds {
-		appIDs = append(appIDs, appID)
+	for _, creds := range allCreds {
+		if !seen[creds.AppID] {
+			seen[creds.AppID] = true
+			appIDs = append(appIDs, creds.AppID)
+		}
 	}
+	sort.Strings(appIDs)
 
 	return appIDs, nil
 }
 
+// LoadProxyPasswords returns the saved proxy passwords by proxy ID
+func (cs *CredentialStore) LoadProxyPasswords() (map[string]string, error) {
+	cs.mu.RLock()
+	defer cs.mu.RUnlock()
+
+	passwords := make(map[string]string)
+	data, err := cs.loadItem(itemProxyPasswords)
+	if err != nil || data == nil {
+		return passwords, err
+	}
+	if err := json.Unmarshal(data, &passwords); err != nil {
+		return nil, fmt.Errorf("failed to parse proxy passwords: %w", err)
+	}
+	return passwords, nil
+}
+
+// SaveProxyPasswords replaces the saved proxy passwords
+func (cs *CredentialStore) SaveProxyPasswords(passwords map[string]string) error {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+
+	return cs.saveItem(itemProxyPasswords, passwords)
+}
+
 // Helper functions for encryption
 func (cs *CredentialStore) loadDecrypted() (map[string]*AppCredentials, error) {
-	encryptedData, err := os.ReadFile(cs.filePath)
+	decrypted, err := cs.loadItem(itemCredentials)
 	if err != nil {
-		if os.IsNotExist(err) {
-			return make(map[string]*AppCredentials), nil
-		}
 		return nil, err
 	}
-
-	if len(encryptedData) == 0 {
+	if decrypted == nil {
 		return make(map[string]*AppCredentials), nil
 	}
 
-	decrypted, err := decrypt(encryptedData, cs.key)
-	if err != nil {
-		return nil, fmt.Errorf("failed to decrypt credentials: %w", err)
+	var stored map[string]*AppCredentials
+	if err := json.Unmarshal(decrypted, &stored); err != nil {
+		return nil, fmt.Errorf("failed to parse credentials: %w", err)
 	}
 
-	var result map[string]*AppCredentials
-	if err := json.Unmarshal(decrypted, &result); err != nil {
-		return nil, fmt.Errorf("failed to parse credentials: %w", err)
+	// Files written before profiles existed are keyed by app ID alone;
+	// those entries become the app's default profile
+	result := make(map[string]*AppCredentials, len(stored))
+	for key, creds := range stored {
+		if creds == nil {
+			continue
+		}
+		if creds.AppID == "" {
+			creds.AppID = key
+		}
+		if creds.Profile == "" {
+			creds.Profile = DefaultProfile
+		}
+		result[ProfileKey(creds.AppID, creds.Profile)] = creds
 	}
 
 	return result, nil
 }
 
 func (cs *CredentialStore) saveEncrypted(creds map[string]*AppCredentials) error {
-	data, err := json.Marshal(creds)
+	return cs.saveItem(itemCredentials, creds)
+}
+
+// loadItem returns an item decrypted with the data key, nil if the
+// backend does not hold it
+func (cs *CredentialStore) loadItem(item string) ([]byte, error) {
+	if cs.key == nil {
+		return nil, ErrLocked
+	}
+
+	encryptedData, err := cs.backend.Get(item)
+	if err != nil {
+		if errors.Is(err, ErrItemNotFound) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	if len(encryptedData) == 0 {
+		return nil, nil
+	}
+
+	decrypted, err := decrypt(encryptedData, cs.key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt %s: %w", item, err)
+	}
+	return decrypted, nil
+}
+
+// saveItem stores v as JSON encrypted with the data key
+func (cs *CredentialStore) saveItem(item string, v interface{}) error {
+	if cs.key == nil {
+		return ErrLocked
+	}
+
+	data, err := json.Marshal(v)
 	if err != nil {
-		return fmt.Errorf("failed to marshal credentials: %w", err)
+		return fmt.Errorf("failed to marshal %s: %w", item, err)
 	}
 
 	encrypted, err := encrypt(data, cs.key)
 	if err != nil {
-		return fmt.Errorf("failed to encrypt credentials: %w", err)
+		return fmt.Errorf("failed to encrypt %s: %w", item, err)
 	}
 
-	if err := os.WriteFile(cs.filePath, encrypted, 0600); err != nil {
-		return fmt.Errorf("failed to write credentials file: %w", err)
+	if err := cs.backend.Set(item, encrypted); err != nil {
+		return fmt.Errorf("failed to write %s: %w", item, err)
 	}
 
 	return nil
 }
 
-func getOrCreateKey() []byte {
-	// In production, use a proper key derivation
-	// For now, use a simple derived key
+// legacyKey is the built-in key used until a master passphrase is set.
+// Anyone with the binary can derive it.
+func legacyKey() []byte {
 	const secret = "bandwidth-income-manager-secret-key-2024"
 	hash := sha256.Sum256([]byte(secret))
 	return hash[:]
@@ -0,0 +1,205 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+)
+
+// Items a credential store keeps in its backend
+const (
+	itemCredentials    = "credentials"     // credentials encrypted with the data key
+	itemProxyPasswords = "proxy-passwords" // proxy passwords encrypted with the data key
+	itemKeyFile        = "key"             // data key wrapped with the master passphrase
+	itemDataKey        = "data-key"        // raw data key, only in secure backends
+)
+
+// encryptedItems are the items encrypted with the data key
+var encryptedItems = []string{itemCredentials, itemProxyPasswords}
+
+// ErrItemNotFound is returned by a backend for an item it does not hold
+var ErrItemNotFound = errors.New("item not found")
+
+// ErrKeyringUnavailable is returned when the credentials are kept in a
+// keyring that does not answer
+var ErrKeyringUnavailable = errors.New("the keyring holding the credentials is not available")
+
+// keyringMarker is the file in the data directory naming the keyring
+// backend that holds the credentials, so a keyring that stops answering
+// is not mistaken for a new install
+const keyringMarker = "credential_backend"
+
+// Backend is where a credential store keeps its items. Items are opaque
+// blobs; encryption is done by the store.
+type Backend interface {
+	Name() string
+	// Secure reports whether the backend protects its items itself, like
+	// a keyring unlocked at login. The data key is then kept in the
+	// backend, so no passphrase is needed.
+	Secure() bool
+	Get(item string) ([]byte, error)
+	Set(item string, data []byte) error
+	Delete(item string) error
+}
+
+// Backend names for OpenBackend
+const (
+	BackendAuto          = "auto"
+	BackendFile          = "file"
+	BackendSecretService = "secret-service"
+)
+
+// OpenBackend returns the named backend. Auto uses the Secret Service on
+// Linux when a session keyring answers, and files in dir otherwise.
+// Once credentials were kept in the keyring, a keyring that does not
+// answer is an ErrKeyringUnavailable instead.
+func OpenBackend(name, dir string) (Backend, error) {
+	switch name {
+	case BackendFile:
+		return NewFileBackend(dir), nil
+	case BackendSecretService:
+		backend, err := newSecretServiceBackend()
+		if err != nil && keyringUsed(dir) {
+			return nil, fmt.Errorf("%w: %v", ErrKeyringUnavailable, err)
+		}
+		return backend, err
+	case "", BackendAuto:
+		if runtime.GOOS == "linux" {
+			backend, err := newSecretServiceBackend()
+			if err == nil {
+				return backend, nil
+			}
+			if keyringUsed(dir) {
+				return nil, fmt.Errorf("%w: %v; start the keyring, or set credential_backend to %s to begin with an empty store",
+					ErrKeyringUnavailable, err, BackendFile)
+			}
+		}
+		return NewFileBackend(dir), nil
+	default:
+		return nil, fmt.Errorf("unknown credential backend %q", name)
+	}
+}
+
+// OpenCredentialStore opens the credential store on the named backend.
+// Credentials kept in files by earlier versions move into a keyring
+// backend the first time it is used.
+func OpenCredentialStore(backendName, dir string) (*CredentialStore, error) {
+	backend, err := OpenBackend(backendName, dir)
+	if err != nil {
+		return nil, err
+	}
+	if backend.Name() != BackendFile {
+		if err := MigrateBackend(NewFileBackend(dir), backend); err != nil {
+			return nil, fmt.Errorf("failed to move credentials to %s: %w", backend.Name(), err)
+		}
+		if !keyringUsed(dir) {
+			if err := writeFileAtomic(filepath.Join(dir, keyringMarker), []byte(backend.Name()+"\n"), 0600); err != nil {
+				fmt.Printf("failed to record the credential backend: %v\n", err)
+			}
+		}
+	}
+	return NewCredentialStore(backend)
+}
+
+// keyringUsed reports whether credentials in dir were kept in a keyring
+func keyringUsed(dir string) bool {
+	data, err := os.ReadFile(filepath.Join(dir, keyringMarker))
+	if err != nil {
+		return false
+	}
+	name := strings.TrimSpace(string(data))
+	return name != "" && name != BackendFile
+}
+
+// MigrateBackend moves the encrypted items and wrapped key from one backend to
+// another that holds no credentials yet. Items are removed from the old
+// backend only after all of them are copied.
+func MigrateBackend(from, to Backend) error {
+	if _, err := to.Get(itemCredentials); !errors.Is(err, ErrItemNotFound) {
+		return err
+	}
+
+	var moved []string
+	for _, item := range append([]string{itemKeyFile}, encryptedItems...) {
+		data, err := from.Get(item)
+		if errors.Is(err, ErrItemNotFound) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if err := to.Set(item, data); err != nil {
+			return err
+		}
+		moved = append(moved, item)
+	}
+	for _, item := range moved {
+		if err := from.Delete(item); err != nil {
+			fmt.Printf("failed to remove %s from %s after moving it: %v\n", item, from.Name(), err)
+		}
+	}
+	return nil
+}
+
+// fileBackend keeps items as files next to each other in a directory
+type fileBackend struct {
+	dir string
+}
+
+// NewFileBackend returns a backend that keeps the credential store in
+// files in dir
+func NewFileBackend(dir string) Backend {
+	return &fileBackend{dir: dir}
+}
+
+var fileNames = map[string]string{
+	itemCredentials:    "app_credentials.json.enc",
+	itemProxyPasswords: "proxy_passwords.json.enc",
+	itemKeyFile:        "app_credentials.key",
+}
+
+func (b *fileBackend) Name() string { return BackendFile }
+
+func (b *fileBackend) Secure() bool { return false }
+
+func (b *fileBackend) path(item string) (string, error) {
+	name, ok := fileNames[item]
+	if !ok {
+		return "", fmt.Errorf("the file backend cannot store %s", item)
+	}
+	return filepath.Join(b.dir, name), nil
+}
+
+func (b *fileBackend) Get(item string) ([]byte, error) {
+	path, err := b.path(item)
+	if err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, ErrItemNotFound
+	}
+	return data, err
+}
+
+func (b *fileBackend) Set(item string, data []byte) error {
+	path, err := b.path(item)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(path, data, 0600)
+}
+
+func (b *fileBackend) Delete(item string) error {
+	path, err := b.path(item)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,190 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"runtime"
+)
+
+// DataDirEnv names the environment variable that sets the data directory
+const DataDirEnv = "BIM_DATA_DIR"
+
+// appDirName is the directory the app keeps its files in under the
+// platform's data directory
+const appDirName = "bandwidth-income-manager"
+
+// DataPaths are the files and directories kept under the data directory.
+// The layout is the one earlier versions used in the working directory.
+type DataPaths struct {
+	Root string
+}
+
+// Configs is the directory of user app configs
+func (p DataPaths) Configs() string { return filepath.Join(p.Root, "configs") }
+
+// StateFile is where proxies, instances and the activity log are kept
+func (p DataPaths) StateFile() string { return filepath.Join(p.Root, "data", "state.json") }
+
+// SettingsFile holds the desktop settings
+func (p DataPaths) SettingsFile() string { return filepath.Join(p.Root, "data", "settings.json") }
+
+// MonitorDB is the earnings and stats database
+func (p DataPaths) MonitorDB() string { return filepath.Join(p.Root, "data", "monitor.db") }
+
+// Backups is the directory of volume snapshots
+func (p DataPaths) Backups() string { return filepath.Join(p.Root, ".data", "backup") }
+
+// ResolveDataDir returns the absolute data directory: dir if it is set,
+// else BIM_DATA_DIR, else the platform default
+func ResolveDataDir(dir string) (string, error) {
+	if dir == "" {
+		dir = os.Getenv(DataDirEnv)
+	}
+	if dir == "" {
+		return DefaultDataDir()
+	}
+	return filepath.Abs(dir)
+}
+
+// DefaultDataDir returns $XDG_DATA_HOME/bandwidth-income-manager on Linux,
+// ~/.local/share/bandwidth-income-manager if XDG_DATA_HOME is not set, and
+// the user config directory, like %AppData% on Windows, elsewhere
+func DefaultDataDir() (string, error) {
+	if runtime.GOOS == "linux" {
+		// The XDG spec says relative paths are to be ignored
+		if xdg := os.Getenv("XDG_DATA_HOME"); filepath.IsAbs(xdg) {
+			return filepath.Join(xdg, appDirName), nil
+		}
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return "", fmt.Errorf("cannot find the data directory: %w", err)
+		}
+		return filepath.Join(home, ".local", "share", appDirName), nil
+	}
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", fmt.Errorf("cannot find the data directory: %w", err)
+	}
+	return filepath.Join(dir, appDirName), nil
+}
+
+// legacyEntries are what earlier versions kept in the working directory
+var legacyEntries = []string{"configs", "data", ".data", fileNames[itemCredentials], fileNames[itemProxyPasswords], fileNames[itemKeyFile]}
+
+// MigrateDataDir moves the files earlier versions kept in legacyDir, the
+// working directory, into the data directory dir. Nothing is moved unless
+// legacyDir holds state or credentials of the app, and entries dir
+// already has are left alone. The volume directory is replaced by a link
+// to its new place, so existing containers keep finding their data. It
+// returns the entries moved.
+func MigrateDataDir(legacyDir, dir string) ([]string, error) {
+	if filepath.Clean(legacyDir) == filepath.Clean(dir) {
+		return nil, nil
+	}
+	paths := DataPaths{Root: legacyDir}
+	if !exists(paths.StateFile()) && !exists(filepath.Join(legacyDir, fileNames[itemCredentials])) {
+		return nil, nil
+	}
+
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create data directory: %w", err)
+	}
+	var moved []string
+	for _, name := range legacyEntries {
+		from, to := filepath.Join(legacyDir, name), filepath.Join(dir, name)
+		info, err := os.Lstat(from)
+		if err != nil || info.Mode()&fs.ModeSymlink != 0 || exists(to) {
+			continue
+		}
+		if err := move(from, to); err != nil {
+			return moved, fmt.Errorf("failed to move %s to %s: %w", from, to, err)
+		}
+		moved = append(moved, name)
+
+		if name == ".data" {
+			if err := os.Symlink(to, from); err != nil {
+				fmt.Printf("Warning: containers deployed before the move will not find their data until they are redeployed: %v\n", err)
+			}
+		}
+	}
+	return moved, nil
+}
+
+func exists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
+
+// move renames from to to, copying when they are on different file
+// systems
+func move(from, to string) error {
+	err := os.Rename(from, to)
+	if err == nil {
+		return nil
+	}
+	var linkErr *os.LinkError
+	if !errors.As(err, &linkErr) {
+		return err
+	}
+	if err := copyTree(from, to); err != nil {
+		os.RemoveAll(to)
+		return err
+	}
+	return os.RemoveAll(from)
+}
+
+// copyTree copies a file or directory with its modes. Symlinks are copied
+// as links.
+func copyTree(from, to string) error {
+	return filepath.WalkDir(from, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(from, path)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(to, rel)
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+
+		switch {
+		case d.IsDir():
+			return os.MkdirAll(target, info.Mode().Perm())
+		case info.Mode()&fs.ModeSymlink != 0:
+			link, err := os.Readlink(path)
+			if err != nil {
+				return err
+			}
+			return os.Symlink(link, target)
+		case info.Mode().IsRegular():
+			return copyFile(path, target, info.Mode().Perm())
+		default:
+			// Sockets and devices cannot be copied
+			return nil
+		}
+	})
+}
+
+func copyFile(from, to string, perm fs.FileMode) error {
+	in, err := os.Open(from)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.OpenFile(to, os.O_WRONLY|os.O_CREATE|os.O_EXCL, perm)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
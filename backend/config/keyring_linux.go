@@ -0,0 +1,207 @@
+//go:build linux
+
+package config
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/godbus/dbus/v5"
+)
+
+// Secret Service D-Bus API, see
+// https://specifications.freedesktop.org/secret-service-spec/latest/
+const (
+	secretsService    = "org.freedesktop.secrets"
+	secretsPath       = dbus.ObjectPath("/org/freedesktop/secrets")
+	serviceInterface  = "org.freedesktop.Secret.Service"
+	itemInterface     = "org.freedesktop.Secret.Item"
+	promptInterface   = "org.freedesktop.Secret.Prompt"
+	collectionCreate  = "org.freedesktop.Secret.Collection.CreateItem"
+	itemLabelProperty = "org.freedesktop.Secret.Item.Label"
+	itemAttrsProperty = "org.freedesktop.Secret.Item.Attributes"
+	noPrompt          = dbus.ObjectPath("/")
+	keyringAppName    = "bandwidth-income-manager"
+	promptTimeout     = 2 * time.Minute
+)
+
+// secret is the Secret struct of the Secret Service API
+type secret struct {
+	Session     dbus.ObjectPath
+	Parameters  []byte
+	Value       []byte
+	ContentType string
+}
+
+// secretServiceBackend keeps items in the default collection of the
+// session keyring (GNOME Keyring, KWallet and others)
+type secretServiceBackend struct {
+	conn    *dbus.Conn
+	session dbus.ObjectPath
+	mu      sync.Mutex
+}
+
+func newSecretServiceBackend() (Backend, error) {
+	conn, err := dbus.SessionBus()
+	if err != nil {
+		return nil, fmt.Errorf("no D-Bus session: %w", err)
+	}
+
+	// Secrets travel over the local session bus only, so the plain
+	// algorithm is enough
+	var output dbus.Variant
+	var session dbus.ObjectPath
+	err = conn.Object(secretsService, secretsPath).
+		Call(serviceInterface+".OpenSession", 0, "plain", dbus.MakeVariant("")).
+		Store(&output, &session)
+	if err != nil {
+		return nil, fmt.Errorf("secret service is not available: %w", err)
+	}
+	return &secretServiceBackend{conn: conn, session: session}, nil
+}
+
+func (b *secretServiceBackend) Name() string { return BackendSecretService }
+
+func (b *secretServiceBackend) Secure() bool { return true }
+
+func (b *secretServiceBackend) service() dbus.BusObject {
+	return b.conn.Object(secretsService, secretsPath)
+}
+
+func attributes(item string) map[string]string {
+	return map[string]string{"application": keyringAppName, "item": item}
+}
+
+// find returns the keyring item holding item, unlocking it if needed
+func (b *secretServiceBackend) find(item string) (dbus.ObjectPath, error) {
+	var unlocked, locked []dbus.ObjectPath
+	if err := b.service().Call(serviceInterface+".SearchItems", 0, attributes(item)).Store(&unlocked, &locked); err != nil {
+		return "", fmt.Errorf("failed to search keyring: %w", err)
+	}
+	if len(unlocked) > 0 {
+		return unlocked[0], nil
+	}
+	if len(locked) == 0 {
+		return "", ErrItemNotFound
+	}
+	if err := b.unlock(locked[0]); err != nil {
+		return "", err
+	}
+	return locked[0], nil
+}
+
+// unlock unlocks an item or collection, prompting the user if the keyring
+// asks for it
+func (b *secretServiceBackend) unlock(path dbus.ObjectPath) error {
+	var unlocked []dbus.ObjectPath
+	var prompt dbus.ObjectPath
+	if err := b.service().Call(serviceInterface+".Unlock", 0, []dbus.ObjectPath{path}).Store(&unlocked, &prompt); err != nil {
+		return fmt.Errorf("failed to unlock keyring: %w", err)
+	}
+	_, err := b.prompt(prompt)
+	return err
+}
+
+// prompt shows a keyring prompt and waits for the user to complete it
+func (b *secretServiceBackend) prompt(path dbus.ObjectPath) (dbus.Variant, error) {
+	if path == noPrompt || path == "" {
+		return dbus.Variant{}, nil
+	}
+
+	match := []dbus.MatchOption{
+		dbus.WithMatchObjectPath(path),
+		dbus.WithMatchInterface(promptInterface),
+		dbus.WithMatchMember("Completed"),
+	}
+	if err := b.conn.AddMatchSignal(match...); err != nil {
+		return dbus.Variant{}, err
+	}
+	defer b.conn.RemoveMatchSignal(match...)
+	signals := make(chan *dbus.Signal, 1)
+	b.conn.Signal(signals)
+	defer b.conn.RemoveSignal(signals)
+
+	if err := b.conn.Object(secretsService, path).Call(promptInterface+".Prompt", 0, "").Err; err != nil {
+		return dbus.Variant{}, fmt.Errorf("failed to show keyring prompt: %w", err)
+	}
+
+	timeout := time.After(promptTimeout)
+	for {
+		select {
+		case signal := <-signals:
+			if signal.Path != path || signal.Name != promptInterface+".Completed" || len(signal.Body) < 2 {
+				continue
+			}
+			if dismissed, _ := signal.Body[0].(bool); dismissed {
+				return dbus.Variant{}, fmt.Errorf("keyring prompt was dismissed")
+			}
+			result, _ := signal.Body[1].(dbus.Variant)
+			return result, nil
+		case <-timeout:
+			return dbus.Variant{}, fmt.Errorf("timed out waiting for the keyring prompt")
+		}
+	}
+}
+
+func (b *secretServiceBackend) Get(item string) ([]byte, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	path, err := b.find(item)
+	if err != nil {
+		return nil, err
+	}
+	var s secret
+	if err := b.conn.Object(secretsService, path).Call(itemInterface+".GetSecret", 0, b.session).Store(&s); err != nil {
+		return nil, fmt.Errorf("failed to read %s from keyring: %w", item, err)
+	}
+	return s.Value, nil
+}
+
+func (b *secretServiceBackend) Set(item string, data []byte) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	var collection dbus.ObjectPath
+	if err := b.service().Call(serviceInterface+".ReadAlias", 0, "default").Store(&collection); err != nil {
+		return fmt.Errorf("failed to find default keyring: %w", err)
+	}
+	if collection == noPrompt {
+		return fmt.Errorf("the session keyring has no default collection")
+	}
+	if err := b.unlock(collection); err != nil {
+		return err
+	}
+
+	properties := map[string]dbus.Variant{
+		itemLabelProperty: dbus.MakeVariant("Bandwidth Income Manager " + item),
+		itemAttrsProperty: dbus.MakeVariant(attributes(item)),
+	}
+	value := secret{Session: b.session, Value: data, ContentType: "application/octet-stream"}
+	var created, prompt dbus.ObjectPath
+	if err := b.conn.Object(secretsService, collection).Call(collectionCreate, 0, properties, value, true).Store(&created, &prompt); err != nil {
+		return fmt.Errorf("failed to write %s to keyring: %w", item, err)
+	}
+	_, err := b.prompt(prompt)
+	return err
+}
+
+func (b *secretServiceBackend) Delete(item string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	path, err := b.find(item)
+	if err == ErrItemNotFound {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var prompt dbus.ObjectPath
+	if err := b.conn.Object(secretsService, path).Call(itemInterface+".Delete", 0).Store(&prompt); err != nil {
+		return fmt.Errorf("failed to delete %s from keyring: %w", item, err)
+	}
+	_, err = b.prompt(prompt)
+	return err
+}
@@ -0,0 +1,289 @@
+//go:build linux
+
+package config
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/godbus/dbus/v5"
+)
+
+const (
+	mockCollection      = dbus.ObjectPath("/org/freedesktop/secrets/collection/login")
+	mockSession         = dbus.ObjectPath("/org/freedesktop/secrets/session/1")
+	collectionInterface = "org.freedesktop.Secret.Collection"
+)
+
+// mockSecrets is a Secret Service with one always unlocked collection
+type mockSecrets struct {
+	conn  *dbus.Conn
+	mu    sync.Mutex
+	items map[string]*mockItem // by their item attribute
+	next  int
+}
+
+type mockItem struct {
+	service *mockSecrets
+	path    dbus.ObjectPath
+	attrs   map[string]string
+	value   []byte
+}
+
+// startSessionBus runs a private session bus for the test and points
+// DBUS_SESSION_BUS_ADDRESS at it
+func startSessionBus(t *testing.T) {
+	t.Helper()
+	daemon, err := exec.LookPath("dbus-daemon")
+	if err != nil {
+		t.Skip("dbus-daemon is not installed")
+	}
+
+	dir := t.TempDir()
+	config := filepath.Join(dir, "session.conf")
+	err = os.WriteFile(config, []byte(`<busconfig>
+  <type>session</type>
+  <listen>unix:path=`+filepath.Join(dir, "bus")+`</listen>
+  <auth>EXTERNAL</auth>
+  <policy context="default">
+    <allow send_destination="*" eavesdrop="true"/>
+    <allow eavesdrop="true"/>
+    <allow own="*"/>
+  </policy>
+</busconfig>
+`), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cmd := exec.Command(daemon, "--config-file="+config, "--nofork", "--print-address")
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cmd.Start(); err != nil {
+		t.Skipf("dbus-daemon does not start: %v", err)
+	}
+	address, err := bufio.NewReader(stdout).ReadString('\n')
+	if err != nil {
+		_ = cmd.Process.Kill()
+		t.Skipf("dbus-daemon does not start: %v", err)
+	}
+	t.Setenv("DBUS_SESSION_BUS_ADDRESS", strings.TrimSpace(address))
+	t.Cleanup(func() {
+		// Drop the shared connection so the next test connects anew
+		if conn, err := dbus.SessionBus(); err == nil {
+			conn.Close()
+		}
+		_ = cmd.Process.Kill()
+		_ = cmd.Wait()
+	})
+}
+
+// startMockSecrets claims org.freedesktop.secrets on the session bus
+func startMockSecrets(t *testing.T) *mockSecrets {
+	t.Helper()
+	conn, err := dbus.ConnectSessionBus()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	m := &mockSecrets{conn: conn, items: make(map[string]*mockItem)}
+	if err := conn.Export(m, secretsPath, serviceInterface); err != nil {
+		t.Fatal(err)
+	}
+	if err := conn.Export(&mockCollectionObject{m}, mockCollection, collectionInterface); err != nil {
+		t.Fatal(err)
+	}
+	reply, err := conn.RequestName(secretsService, dbus.NameFlagDoNotQueue)
+	if err != nil || reply != dbus.RequestNameReplyPrimaryOwner {
+		t.Fatalf("failed to own %s: %v", secretsService, err)
+	}
+	return m
+}
+
+// stop releases the service name, as when the keyring daemon exits
+func (m *mockSecrets) stop(t *testing.T) {
+	t.Helper()
+	if _, err := m.conn.ReleaseName(secretsService); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func (m *mockSecrets) OpenSession(algorithm string, input dbus.Variant) (dbus.Variant, dbus.ObjectPath, *dbus.Error) {
+	if algorithm != "plain" {
+		return dbus.Variant{}, "", dbus.NewError("org.freedesktop.DBus.Error.NotSupported", nil)
+	}
+	return dbus.MakeVariant(""), mockSession, nil
+}
+
+func (m *mockSecrets) SearchItems(attrs map[string]string) ([]dbus.ObjectPath, []dbus.ObjectPath, *dbus.Error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	unlocked := []dbus.ObjectPath{}
+	if item, ok := m.items[attrs["item"]]; ok && item.attrs["application"] == attrs["application"] {
+		unlocked = append(unlocked, item.path)
+	}
+	return unlocked, []dbus.ObjectPath{}, nil
+}
+
+func (m *mockSecrets) Unlock(paths []dbus.ObjectPath) ([]dbus.ObjectPath, dbus.ObjectPath, *dbus.Error) {
+	return paths, noPrompt, nil
+}
+
+func (m *mockSecrets) ReadAlias(name string) (dbus.ObjectPath, *dbus.Error) {
+	if name != "default" {
+		return noPrompt, nil
+	}
+	return mockCollection, nil
+}
+
+type mockCollectionObject struct {
+	service *mockSecrets
+}
+
+func (c *mockCollectionObject) CreateItem(properties map[string]dbus.Variant, s secret, replace bool) (dbus.ObjectPath, dbus.ObjectPath, *dbus.Error) {
+	m := c.service
+	attrs, ok := properties[itemAttrsProperty].Value().(map[string]string)
+	if !ok || s.Session != mockSession {
+		return "", "", dbus.NewError("org.freedesktop.DBus.Error.InvalidArgs", nil)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if item, ok := m.items[attrs["item"]]; ok && replace {
+		item.value = s.Value
+		return item.path, noPrompt, nil
+	}
+	m.next++
+	item := &mockItem{
+		service: m,
+		path:    dbus.ObjectPath(fmt.Sprintf("%s/%d", mockCollection, m.next)),
+		attrs:   attrs,
+		value:   s.Value,
+	}
+	if err := m.conn.Export(item, item.path, itemInterface); err != nil {
+		return "", "", dbus.MakeFailedError(err)
+	}
+	m.items[attrs["item"]] = item
+	return item.path, noPrompt, nil
+}
+
+func (i *mockItem) GetSecret(session dbus.ObjectPath) (secret, *dbus.Error) {
+	i.service.mu.Lock()
+	defer i.service.mu.Unlock()
+	return secret{Session: session, Value: i.value, ContentType: "application/octet-stream"}, nil
+}
+
+func (i *mockItem) Delete() (dbus.ObjectPath, *dbus.Error) {
+	m := i.service
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.items, i.attrs["item"])
+	_ = m.conn.Export(nil, i.path, itemInterface)
+	return noPrompt, nil
+}
+
+func TestSecretServiceBackend(t *testing.T) {
+	startSessionBus(t)
+	startMockSecrets(t)
+
+	backend, err := newSecretServiceBackend()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := backend.Get(itemCredentials); !errors.Is(err, ErrItemNotFound) {
+		t.Fatalf("Get of a missing item = %v, want ErrItemNotFound", err)
+	}
+	for _, data := range [][]byte{[]byte("first"), []byte("second")} {
+		if err := backend.Set(itemCredentials, data); err != nil {
+			t.Fatal(err)
+		}
+		got, err := backend.Get(itemCredentials)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, data) {
+			t.Fatalf("Get = %q, want %q", got, data)
+		}
+	}
+	if _, err := backend.Get(itemKeyFile); !errors.Is(err, ErrItemNotFound) {
+		t.Fatalf("Get of another item = %v, want ErrItemNotFound", err)
+	}
+	if err := backend.Delete(itemCredentials); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := backend.Get(itemCredentials); !errors.Is(err, ErrItemNotFound) {
+		t.Fatalf("Get after Delete = %v, want ErrItemNotFound", err)
+	}
+	if err := backend.Delete(itemCredentials); err != nil {
+		t.Fatalf("Delete of a missing item = %v", err)
+	}
+}
+
+func TestOpenCredentialStoreKeyring(t *testing.T) {
+	startSessionBus(t)
+	secrets := startMockSecrets(t)
+	dir := t.TempDir()
+
+	// Credentials kept in files by an earlier version
+	files, err := NewCredentialStore(NewFileBackend(dir))
+	if err != nil {
+		t.Fatal(err)
+	}
+	creds := &AppCredentials{AppID: "earnapp", DeviceName: "box", Credentials: map[string]string{"EMAIL": "me@example.com"}}
+	if err := files.SaveCredentials(creds); err != nil {
+		t.Fatal(err)
+	}
+
+	store, err := OpenCredentialStore(BackendAuto, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if store.Backend() != BackendSecretService {
+		t.Fatalf("auto opened %s, want %s", store.Backend(), BackendSecretService)
+	}
+	if store.Locked() {
+		t.Fatal("a keyring store should not need a passphrase")
+	}
+	loaded, err := store.LoadCredentials("earnapp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if loaded.Credentials["EMAIL"] != "me@example.com" {
+		t.Fatalf("credentials after moving to the keyring = %v", loaded.Credentials)
+	}
+	if _, err := os.Stat(filepath.Join(dir, fileNames[itemCredentials])); !os.IsNotExist(err) {
+		t.Fatalf("credentials file left after moving to the keyring: %v", err)
+	}
+
+	// The keyring goes away; auto must not start over with empty files
+	secrets.stop(t)
+	if _, err := OpenCredentialStore(BackendAuto, dir); !errors.Is(err, ErrKeyringUnavailable) {
+		t.Fatalf("auto without the keyring = %v, want ErrKeyringUnavailable", err)
+	}
+	if _, err := OpenCredentialStore(BackendSecretService, dir); !errors.Is(err, ErrKeyringUnavailable) {
+		t.Fatalf("secret-service without the keyring = %v, want ErrKeyringUnavailable", err)
+	}
+	if store, err := OpenCredentialStore(BackendFile, dir); err != nil || store.Backend() != BackendFile {
+		t.Fatalf("file backend = %v", err)
+	}
+
+	// A data directory that never used the keyring still falls back
+	store, err = OpenCredentialStore(BackendAuto, t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if store.Backend() != BackendFile {
+		t.Fatalf("auto without the keyring opened %s, want %s", store.Backend(), BackendFile)
+	}
+}
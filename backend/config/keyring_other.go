@@ -0,0 +1,10 @@
+//go:build !linux
+
+package config
+
+import "errors"
+
+// newSecretServiceBackend is only available on Linux
+func newSecretServiceBackend() (Backend, error) {
+	return nil, errors.New("the Secret Service keyring is only available on Linux")
+}
@@ -1,156 +1,235 @@
 package config
 
 import (
+	"errors"
 	"fmt"
+	"maps"
 	"os"
 	"path/filepath"
+	"reflect"
+	"slices"
+	"strings"
 	"sync"
 
+	"bandwidth-income-manager/backend/apps"
+
 	"gopkg.in/yaml.v3"
 )
 
 // Loader manages loading and hot-reloading of app configurations
 type Loader struct {
-	configsDir string
-	apps       map[string]*AppConfig
-	mu         sync.RWMutex
+	configsDir  string
+	apps        map[string]*apps.AppManifest // last good manifest per app
+	fileErrors  map[string]string            // config file -> why it was rejected
+	subscribers []func(CatalogChange)
+	mu          sync.Mutex
+}
+
+// CatalogChange lists the apps a reload added, changed or removed
+type CatalogChange struct {
+	Added   []string `json:"added"`
+	Changed []string `json:"changed"`
+	Removed []string `json:"removed"`
+}
+
+// IsEmpty reports whether the reload changed nothing
+func (c CatalogChange) IsEmpty() bool {
+	return len(c.Added) == 0 && len(c.Changed) == 0 && len(c.Removed) == 0
 }
 
 // NewLoader creates a new config loader
 func NewLoader(configsDir string) *Loader {
 	return &Loader{
 		configsDir: configsDir,
-		apps:       make(map[string]*AppConfig),
+		apps:       make(map[string]*apps.AppManifest),
+		fileErrors: make(map[string]string),
 	}
 }
 
-// LoadAppConfigs loads all app configurations from the configs directory
-func (l *Loader) LoadAppConfigs() error {
+// Subscribe registers fn to be called after every load that changes the
+// catalog
+func (l *Loader) Subscribe(fn func(CatalogChange)) {
 	l.mu.Lock()
 	defer l.mu.Unlock()
+	l.subscribers = append(l.subscribers, fn)
+}
+
+// LoadAppConfigs builds the app catalog from the built-in manifests and
+// the YAML files in the configs/apps directory. A file whose app_id matches
+// a built-in app patches that manifest; any other file adds a new app. The
+// result becomes the catalog apps are deployed from.
+//
+// A file that cannot be read or does not validate does not fail the whole
+// load: its app keeps the last good manifest, and the file's error is
+// returned alongside the others.
+func (l *Loader) LoadAppConfigs() error {
+	l.mu.Lock()
+	change, err := l.load()
+	subscribers := append([]func(CatalogChange){}, l.subscribers...)
+	l.mu.Unlock()
+
+	if !change.IsEmpty() {
+		for _, fn := range subscribers {
+			fn(change)
+		}
+	}
+	return err
+}
+
+// load rebuilds the catalog; callers hold l.mu
+func (l *Loader) load() (CatalogChange, error) {
+	defaults, err := apps.DefaultManifestSources()
+	if err != nil {
+		return CatalogChange{}, fmt.Errorf("failed to read built-in manifests: %w", err)
+	}
 
 	appsPath := filepath.Join(l.configsDir, "apps")
 	files, err := filepath.Glob(filepath.Join(appsPath, "*.yaml"))
 	if err != nil {
-		return fmt.Errorf("failed to glob config files: %w", err)
+		return CatalogChange{}, fmt.Errorf("failed to glob config files: %w", err)
 	}
 
-	newApps := make(map[string]*AppConfig)
+	fileErrors := make(map[string]string)
+	overrides := make(map[string]string) // app ID -> config file
+	sources := make(map[string][]byte)
+	failed := make(map[string]bool) // apps with a rejected file
 	for _, file := range files {
 		data, err := os.ReadFile(file)
 		if err != nil {
-			return fmt.Errorf("failed to read config file %s: %w", file, err)
+			fileErrors[file] = fmt.Sprintf("failed to read config file: %v", err)
+			failed[configAppID(file, nil)] = true
+			continue
 		}
 
-		var config AppConfig
-		if err := yaml.Unmarshal(data, &config); err != nil {
-			return fmt.Errorf("failed to parse config file %s: %w", file, err)
+		appID := configAppID(file, data)
+		if _, exists := overrides[appID]; exists {
+			fileErrors[file] = fmt.Sprintf("app %s is defined more than once", appID)
+			continue
 		}
+		overrides[appID] = file
+		sources[appID] = data
+	}
 
-		// Validate config against schema
-		if err := l.ValidateConfig(&config); err != nil {
-			return fmt.Errorf("invalid config file %s: %w", file, err)
+	newApps := make(map[string]*apps.AppManifest)
+	for appID, data := range defaults {
+		manifest, err := apps.ParseManifest(data)
+		if err != nil {
+			return CatalogChange{}, fmt.Errorf("invalid built-in manifest %s: %w", appID, err)
+		}
+		newApps[appID] = manifest
+	}
+	for appID, file := range overrides {
+		manifest, err := parseConfig(appID, sources[appID], defaults)
+		if err != nil {
+			fileErrors[file] = fmt.Sprintf("invalid config for app %s: %v", appID, err)
+			failed[appID] = true
+			continue
+		}
+		newApps[appID] = manifest
+	}
+	for appID := range failed {
+		if previous, ok := l.apps[appID]; ok {
+			newApps[appID] = previous
 		}
-
-		newApps[config.AppID] = &config
 	}
 
+	change := diffCatalogs(l.apps, newApps)
 	l.apps = newApps
-	return nil
-}
-
-// GetApps returns all loaded app configurations
-func (l *Loader) GetApps() map[string]*AppConfig {
-	l.mu.RLock()
-	defer l.mu.RUnlock()
+	l.fileErrors = fileErrors
+	apps.SetManifests(newApps)
 
-	result := make(map[string]*AppConfig)
-	for id, config := range l.apps {
-		result[id] = config
+	if len(fileErrors) == 0 {
+		return change, nil
 	}
-	return result
-}
-
-// GetApp returns a specific app configuration by ID
-func (l *Loader) GetApp(appID string) (*AppConfig, error) {
-	l.mu.RLock()
-	defer l.mu.RUnlock()
-
-	config, exists := l.apps[appID]
-	if !exists {
-		return nil, fmt.Errorf("app config not found: %s", appID)
+	errs := make([]error, 0, len(fileErrors))
+	for _, file := range slices.Sorted(maps.Keys(fileErrors)) {
+		errs = append(errs, fmt.Errorf("%s: %s", file, fileErrors[file]))
 	}
-
-	return config, nil
+	return change, errors.Join(errs...)
 }
 
-// ValidateConfig validates an app configuration against the schema
-func (l *Loader) ValidateConfig(config *AppConfig) error {
-	if config.AppID == "" {
-		return fmt.Errorf("app_id is required")
+// configAppID returns the app a config file defines or patches. A patch
+// may leave out app_id and rely on the file name.
+func configAppID(file string, data []byte) string {
+	var header struct {
+		AppID string `yaml:"app_id"`
 	}
-	if config.Name == "" {
-		return fmt.Errorf("name is required")
+	if err := yaml.Unmarshal(data, &header); err == nil && header.AppID != "" {
+		return header.AppID
 	}
-	if config.DockerImage == "" {
-		return fmt.Errorf("docker_image is required")
-	}
-	return nil
+	return strings.TrimSuffix(filepath.Base(file), ".yaml")
 }
 
-// WatchConfigs starts watching for config file changes (hot-reload)
-func (l *Loader) WatchConfigs() error {
-	// TODO: Implement file watcher for hot-reload
-	// For now, just load once
-	return l.LoadAppConfigs()
+// parseConfig parses a config file, on top of the built-in manifest of the
+// same app if there is one
+func parseConfig(appID string, data []byte, defaults map[string][]byte) (*apps.AppManifest, error) {
+	if base, ok := defaults[appID]; ok {
+		return apps.ParseManifest(base, data)
+	}
+	return apps.ParseManifest(data)
 }
 
-// AppConfig represents the structure of an app configuration
-type AppConfig struct {
-	AppID              string          `yaml:"app_id"`
-	Name               string          `yaml:"name"`
-	DockerImage        string          `yaml:"docker_image"`
-	SupportedPlatforms []string        `yaml:"supported_platforms"`
-	EnvironmentVars    []EnvVar        `yaml:"environment_vars"`
-	Volumes            []Volume        `yaml:"volumes,omitempty"`
-	NetworkMode        string          `yaml:"network_mode,omitempty"`
-	ProxySupport       bool            `yaml:"proxy_support"`
-	Ports              []string        `yaml:"ports,omitempty"`
-	HealthCheck        *HealthCheck    `yaml:"health_check,omitempty"`
-	EarningsAPI        *EarningsAPI    `yaml:"earnings_api,omitempty"`
-	Description        string          `yaml:"description,omitempty"`
-	ResourceLimits     *ResourceLimits `yaml:"resource_limits,omitempty"`
+// ValidateFile checks a config file the way LoadAppConfigs would, without
+// changing the catalog. Schema problems are returned as
+// apps.ValidationErrors.
+func ValidateFile(file string) error {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return fmt.Errorf("failed to read config file: %w", err)
+	}
+	defaults, err := apps.DefaultManifestSources()
+	if err != nil {
+		return fmt.Errorf("failed to read built-in manifests: %w", err)
+	}
+	_, err = parseConfig(configAppID(file, data), data, defaults)
+	return err
 }
 
-// EnvVar represents an environment variable
-type EnvVar struct {
-	Key         string `yaml:"key"`
-	Required    bool   `yaml:"required"`
-	Description string `yaml:"description"`
+// FileErrors returns the config files rejected by the last load and why
+func (l *Loader) FileErrors() map[string]string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return maps.Clone(l.fileErrors)
 }
 
-// Volume represents a Docker volume mount
-type Volume struct {
-	Host      string `yaml:"host"`
-	Container string `yaml:"container"`
-	Type      string `yaml:"type,omitempty"` // bind, volume, tmpfs
+// diffCatalogs compares two catalogs by app ID
+func diffCatalogs(previous, current map[string]*apps.AppManifest) CatalogChange {
+	var change CatalogChange
+	for appID, manifest := range current {
+		old, existed := previous[appID]
+		switch {
+		case !existed:
+			change.Added = append(change.Added, appID)
+		case !reflect.DeepEqual(old, manifest):
+			change.Changed = append(change.Changed, appID)
+		}
+	}
+	for appID := range previous {
+		if _, exists := current[appID]; !exists {
+			change.Removed = append(change.Removed, appID)
+		}
+	}
+	slices.Sort(change.Added)
+	slices.Sort(change.Changed)
+	slices.Sort(change.Removed)
+	return change
 }
 
-// HealthCheck represents container health check configuration
-type HealthCheck struct {
-	Endpoint string `yaml:"endpoint,omitempty"`
-	Interval string `yaml:"interval,omitempty"`
+// GetApps returns all manifests in the app catalog
+func (l *Loader) GetApps() map[string]*apps.AppManifest {
+	return apps.GetAllManifests()
 }
 
-// EarningsAPI represents earnings API configuration
-type EarningsAPI struct {
-	Endpoint   string            `yaml:"endpoint"`
-	AuthMethod string            `yaml:"auth_method"`
-	Headers    map[string]string `yaml:"headers,omitempty"`
+// GetApp returns a specific app manifest by ID
+func (l *Loader) GetApp(appID string) (*apps.AppManifest, error) {
+	manifest := apps.GetAppManifest(appID)
+	if manifest == nil {
+		return nil, fmt.Errorf("app config not found: %s", appID)
+	}
+	return manifest, nil
 }
 
-// ResourceLimits represents container resource limits
-type ResourceLimits struct {
-	CPU    string `yaml:"cpu,omitempty"`
-	Memory string `yaml:"memory,omitempty"`
+// ValidateConfig validates an app configuration against the schema
+func (l *Loader) ValidateConfig(config *apps.AppManifest) error {
+	return config.Validate()
 }
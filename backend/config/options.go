@@ -0,0 +1,432 @@
+package config
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"bandwidth-income-manager/backend/apps"
+	"bandwidth-income-manager/backend/docker"
+
+	"gopkg.in/yaml.v3"
+)
+
+// ConfigFileEnv names the environment variable that sets the config file
+const ConfigFileEnv = "BIM_CONFIG"
+
+// Where a setting got its value, from lowest to highest precedence
+const (
+	SourceDefault = "default"
+	SourceFile    = "file"
+	SourceEnv     = "env"
+	SourceFlag    = "flag"
+)
+
+// Options are the settings of the manager itself, as opposed to the app
+// configs. They are loaded once at startup: the defaults, overridden by
+// the config file, then by BIM_* environment variables, then by flags.
+type Options struct {
+	ConfigFile          string // the file that was read, empty if none
+	DataDir             string
+	CredentialBackend   string
+	DockerHost          string
+	Headless            bool
+	BindAddress         string
+	Port                int
+	RestartPolicy       string
+	UpdateCheckInterval time.Duration
+	MetricsInterval     time.Duration
+	Notifications       NotificationOptions
+
+	sources map[string]string // option key -> Source*
+}
+
+// NotificationOptions choose which events are notified and where to
+type NotificationOptions struct {
+	Enabled           bool
+	AppStopped        bool
+	EarningsMilestone bool
+	UpdateAvailable   bool
+	ProxyFailure      bool
+	DiscordWebhook    string
+	TelegramBotToken  string
+	TelegramChatID    string
+}
+
+// DefaultOptions returns the options used when nothing overrides them
+func DefaultOptions() *Options {
+	return &Options{
+		Port:                8080,
+		RestartPolicy:       "always",
+		UpdateCheckInterval: apps.DefaultUpdateInterval,
+		MetricsInterval:     time.Minute,
+		Notifications: NotificationOptions{
+			Enabled:         true,
+			UpdateAvailable: true,
+		},
+		sources: make(map[string]string),
+	}
+}
+
+// option is one setting under its key in the config file, where nested
+// keys are joined with a dot, and the environment variable and flag that
+// override it
+type option struct {
+	key    string
+	env    string
+	flag   string // empty if there is no flag
+	usage  string
+	isBool bool
+	secret bool // redacted when the options are shown
+	get    func(*Options) string
+	set    func(*Options, string) error
+}
+
+func stringOption(key, env, flagName, usage string, field func(*Options) *string) option {
+	return option{
+		key: key, env: env, flag: flagName, usage: usage,
+		get: func(o *Options) string { return *field(o) },
+		set: func(o *Options, value string) error {
+			*field(o) = value
+			return nil
+		},
+	}
+}
+
+func boolOption(key, env, flagName, usage string, field func(*Options) *bool) option {
+	return option{
+		key: key, env: env, flag: flagName, usage: usage, isBool: true,
+		get: func(o *Options) string { return strconv.FormatBool(*field(o)) },
+		set: func(o *Options, value string) error {
+			b, err := strconv.ParseBool(value)
+			if err != nil {
+				return fmt.Errorf("%q is not true or false", value)
+			}
+			*field(o) = b
+			return nil
+		},
+	}
+}
+
+func intOption(key, env, flagName, usage string, field func(*Options) *int) option {
+	return option{
+		key: key, env: env, flag: flagName, usage: usage,
+		get: func(o *Options) string { return strconv.Itoa(*field(o)) },
+		set: func(o *Options, value string) error {
+			n, err := strconv.Atoi(value)
+			if err != nil {
+				return fmt.Errorf("%q is not a number", value)
+			}
+			*field(o) = n
+			return nil
+		},
+	}
+}
+
+func durationOption(key, env, flagName, usage string, field func(*Options) *time.Duration) option {
+	return option{
+		key: key, env: env, flag: flagName, usage: usage,
+		get: func(o *Options) string { return field(o).String() },
+		set: func(o *Options, value string) error {
+			d, err := time.ParseDuration(value)
+			if err != nil {
+				return fmt.Errorf("%q is not a duration such as 30s or 6h", value)
+			}
+			*field(o) = d
+			return nil
+		},
+	}
+}
+
+func secretOption(o option) option {
+	o.secret = true
+	return o
+}
+
+// options lists every setting in the order they are shown
+var options = []option{
+	stringOption("data_dir", DataDirEnv, "data-dir", "Directory for configs, state, credentials and app data (default: the platform data directory)",
+		func(o *Options) *string { return &o.DataDir }),
+	stringOption("credential_backend", "BIM_CREDENTIAL_BACKEND", "", "",
+		func(o *Options) *string { return &o.CredentialBackend }),
+	stringOption("docker_host", "BIM_DOCKER_HOST", "docker-host", "Docker daemon to manage, like unix:///var/run/docker.sock (default: $DOCKER_HOST or the local daemon)",
+		func(o *Options) *string { return &o.DockerHost }),
+	boolOption("headless", "BIM_HEADLESS", "headless", "Run in headless mode",
+		func(o *Options) *bool { return &o.Headless }),
+	stringOption("bind_address", "BIM_BIND_ADDRESS", "bind", "Address the headless server listens on (default: all interfaces)",
+		func(o *Options) *string { return &o.BindAddress }),
+	intOption("port", "BIM_PORT", "port", "Port for headless server",
+		func(o *Options) *int { return &o.Port }),
+	stringOption("restart_policy", "BIM_RESTART_POLICY", "restart-policy", "Restart policy of new containers: no, always, unless-stopped or on-failure[:N]",
+		func(o *Options) *string { return &o.RestartPolicy }),
+	durationOption("intervals.update_check", "BIM_UPDATE_CHECK_INTERVAL", "", "",
+		func(o *Options) *time.Duration { return &o.UpdateCheckInterval }),
+	durationOption("intervals.metrics", "BIM_METRICS_INTERVAL", "", "",
+		func(o *Options) *time.Duration { return &o.MetricsInterval }),
+	boolOption("notifications.enabled", "BIM_NOTIFICATIONS", "", "",
+		func(o *Options) *bool { return &o.Notifications.Enabled }),
+	boolOption("notifications.app_stopped", "BIM_NOTIFY_APP_STOPPED", "", "",
+		func(o *Options) *bool { return &o.Notifications.AppStopped }),
+	boolOption("notifications.earnings_milestone", "BIM_NOTIFY_EARNINGS_MILESTONE", "", "",
+		func(o *Options) *bool { return &o.Notifications.EarningsMilestone }),
+	boolOption("notifications.update_available", "BIM_NOTIFY_UPDATE_AVAILABLE", "", "",
+		func(o *Options) *bool { return &o.Notifications.UpdateAvailable }),
+	boolOption("notifications.proxy_failure", "BIM_NOTIFY_PROXY_FAILURE", "", "",
+		func(o *Options) *bool { return &o.Notifications.ProxyFailure }),
+	secretOption(stringOption("notifications.discord_webhook", "BIM_DISCORD_WEBHOOK", "", "",
+		func(o *Options) *string { return &o.Notifications.DiscordWebhook })),
+	secretOption(stringOption("notifications.telegram_bot_token", "BIM_TELEGRAM_BOT_TOKEN", "", "",
+		func(o *Options) *string { return &o.Notifications.TelegramBotToken })),
+	stringOption("notifications.telegram_chat_id", "BIM_TELEGRAM_CHAT_ID", "", "",
+		func(o *Options) *string { return &o.Notifications.TelegramChatID }),
+}
+
+func findOption(key string) (option, bool) {
+	for _, opt := range options {
+		if opt.key == key {
+			return opt, true
+		}
+	}
+	return option{}, false
+}
+
+// flagValue collects a flag's value for LoadOptions to apply last
+type flagValue struct {
+	opt    option
+	values map[string]string
+}
+
+func (f *flagValue) String() string {
+	if f.opt.get == nil {
+		return ""
+	}
+	return f.opt.get(DefaultOptions())
+}
+
+func (f *flagValue) Set(value string) error {
+	// Checked now so flag reports the bad flag and prints the usage
+	if err := f.opt.set(DefaultOptions(), value); err != nil {
+		return err
+	}
+	f.values[f.opt.key] = value
+	return nil
+}
+
+func (f *flagValue) IsBoolFlag() bool { return f.opt.isBool }
+
+// LoadOptions parses the command-line flags in args and loads the options.
+// The config file is the one named by --config or BIM_CONFIG, or
+// config.yaml in the platform config directory if that exists. The data
+// directory is made absolute. Invalid settings are reported together.
+func LoadOptions(args []string) (*Options, error) {
+	fs := flag.NewFlagSet("bandwidth-income-manager", flag.ContinueOnError)
+	configFile := fs.String("config", "", "Config file (default: $"+ConfigFileEnv+" or config.yaml in the platform config directory)")
+	flagged := make(map[string]string)
+	for _, opt := range options {
+		if opt.flag != "" {
+			fs.Var(&flagValue{opt: opt, values: flagged}, opt.flag, opt.usage)
+		}
+	}
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+
+	o := DefaultOptions()
+	file, required := *configFile, true
+	if file == "" {
+		file = os.Getenv(ConfigFileEnv)
+	}
+	if file == "" {
+		file, required = defaultConfigFile(), false
+	}
+	var errs []error
+	if file != "" {
+		if err := o.loadFile(file, required); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	for _, opt := range options {
+		value := os.Getenv(opt.env)
+		if value == "" {
+			continue
+		}
+		if err := opt.set(o, value); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", opt.env, err))
+			continue
+		}
+		o.sources[opt.key] = SourceEnv
+	}
+	for _, opt := range options {
+		if value, ok := flagged[opt.key]; ok {
+			opt.set(o, value)
+			o.sources[opt.key] = SourceFlag
+		}
+	}
+	if err := o.Validate(); err != nil {
+		errs = append(errs, err)
+	}
+	if len(errs) > 0 {
+		return nil, errors.Join(errs...)
+	}
+
+	dataDir, err := ResolveDataDir(o.DataDir)
+	if err != nil {
+		return nil, err
+	}
+	o.DataDir = dataDir
+	return o, nil
+}
+
+// defaultConfigFile returns config.yaml in the platform config directory,
+// $XDG_CONFIG_HOME/bandwidth-income-manager on Linux
+func defaultConfigFile() string {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, appDirName, "config.yaml")
+}
+
+// loadFile applies the settings of a YAML config file. A missing file is
+// only an error if it is required.
+func (o *Options) loadFile(file string, required bool) error {
+	data, err := os.ReadFile(file)
+	if errors.Is(err, os.ErrNotExist) && !required {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read config file: %w", err)
+	}
+
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return fmt.Errorf("%s: %w", file, err)
+	}
+	o.ConfigFile = file
+	if len(doc.Content) == 0 {
+		return nil
+	}
+
+	var errs []error
+	walkOptions(doc.Content[0], "", func(key string, node *yaml.Node) {
+		opt, ok := findOption(key)
+		switch {
+		case !ok:
+			errs = append(errs, fmt.Errorf("%s:%d: %s: unknown setting", file, node.Line, key))
+		case node.Kind != yaml.ScalarNode:
+			errs = append(errs, fmt.Errorf("%s:%d: %s: expected a single value", file, node.Line, key))
+		default:
+			if err := opt.set(o, node.Value); err != nil {
+				errs = append(errs, fmt.Errorf("%s:%d: %s: %w", file, node.Line, key, err))
+				return
+			}
+			o.sources[key] = SourceFile
+		}
+	})
+	return errors.Join(errs...)
+}
+
+// walkOptions calls fn with the dotted key of every leaf of a mapping
+func walkOptions(node *yaml.Node, prefix string, fn func(key string, node *yaml.Node)) {
+	if node.Kind != yaml.MappingNode {
+		fn(strings.TrimSuffix(prefix, "."), node)
+		return
+	}
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		key, value := node.Content[i].Value, node.Content[i+1]
+		if value.Kind == yaml.MappingNode {
+			walkOptions(value, prefix+key+".", fn)
+			continue
+		}
+		fn(prefix+key, value)
+	}
+}
+
+// Validate checks the options and reports every invalid setting
+func (o *Options) Validate() error {
+	var errs []error
+	add := func(key, format string, args ...interface{}) {
+		errs = append(errs, fmt.Errorf("%s: %s", key, fmt.Sprintf(format, args...)))
+	}
+
+	if o.Port < 1 || o.Port > 65535 {
+		add("port", "%d is not a port between 1 and 65535", o.Port)
+	}
+	if o.BindAddress != "" && net.ParseIP(o.BindAddress) == nil && strings.ContainsAny(o.BindAddress, ":/ ") {
+		add("bind_address", "%q is not an IP address or host name; set the port with port", o.BindAddress)
+	}
+	switch o.CredentialBackend {
+	case "", BackendAuto, BackendFile, BackendSecretService:
+	default:
+		add("credential_backend", "%q is not one of %s, %s or %s", o.CredentialBackend, BackendAuto, BackendFile, BackendSecretService)
+	}
+	if o.DockerHost != "" {
+		if u, err := url.Parse(o.DockerHost); err != nil || u.Scheme == "" {
+			add("docker_host", "%q is not a URL such as unix:///var/run/docker.sock or tcp://host:2375", o.DockerHost)
+		}
+	}
+	if o.RestartPolicy == "" {
+		add("restart_policy", "must not be empty; use no to disable restarts")
+	} else if err := docker.ValidateRestartPolicy(o.RestartPolicy); err != nil {
+		add("restart_policy", "%v", err)
+	}
+	if o.UpdateCheckInterval != 0 && o.UpdateCheckInterval < time.Minute {
+		add("intervals.update_check", "must be at least 1m, or 0 to turn update checks off")
+	}
+	if o.MetricsInterval != 0 && o.MetricsInterval < 5*time.Second {
+		add("intervals.metrics", "must be at least 5s, or 0 to turn metrics collection off")
+	}
+	if webhook := o.Notifications.DiscordWebhook; webhook != "" {
+		if u, err := url.Parse(webhook); err != nil || u.Scheme != "https" || u.Host == "" {
+			add("notifications.discord_webhook", "must be an https URL")
+		}
+	}
+	if (o.Notifications.TelegramBotToken == "") != (o.Notifications.TelegramChatID == "") {
+		add("notifications.telegram_bot_token", "Telegram needs both a bot token and a chat ID")
+	}
+	return errors.Join(errs...)
+}
+
+// Source returns where a setting got its value
+func (o *Options) Source(key string) string {
+	if source, ok := o.sources[key]; ok {
+		return source
+	}
+	return SourceDefault
+}
+
+// EffectiveOption is one setting as in effect, with where it came from
+type EffectiveOption struct {
+	Key    string `json:"key"`
+	Value  string `json:"value"`
+	Source string `json:"source"`
+	Env    string `json:"env"`
+	Flag   string `json:"flag,omitempty"`
+}
+
+// Effective lists every setting with its value and source. Secrets are
+// redacted.
+func (o *Options) Effective() []EffectiveOption {
+	result := make([]EffectiveOption, 0, len(options))
+	for _, opt := range options {
+		value := opt.get(o)
+		if opt.secret && value != "" {
+			value = apps.RedactedValue
+		}
+		result = append(result, EffectiveOption{
+			Key:    opt.key,
+			Value:  value,
+			Source: o.Source(opt.key),
+			Env:    opt.env,
+			Flag:   opt.flag,
+		})
+	}
+	return result
+}
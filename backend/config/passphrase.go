@@ -0,0 +1,320 @@
+package config
+
+import (
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+
+	"golang.org/x/crypto/argon2"
+)
+
+// Credential store states
+const (
+	StoreUnprotected = "unprotected" // no passphrase set yet, built-in key
+	StoreKeyring     = "keyring"     // no passphrase, data key kept by a secure backend
+	StoreLocked      = "locked"
+	StoreUnlocked    = "unlocked"
+)
+
+// MinPassphraseLength is the shortest master passphrase accepted
+const MinPassphraseLength = 8
+
+var (
+	// ErrLocked is returned while the credential store waits for its
+	// passphrase
+	ErrLocked = errors.New("credential store is locked")
+	// ErrWrongPassphrase is returned when a passphrase does not unwrap the
+	// data key
+	ErrWrongPassphrase = errors.New("wrong passphrase")
+)
+
+// KDFParams are the Argon2id parameters a passphrase is stretched with
+type KDFParams struct {
+	Time      uint32 `json:"time"`
+	MemoryKiB uint32 `json:"memory_kib"`
+	Threads   uint8  `json:"threads"`
+}
+
+// DefaultKDFParams follow the second recommendation of RFC 9106 (64 MiB),
+// which stays usable on small hosts running the manager headless
+var DefaultKDFParams = KDFParams{Time: 3, MemoryKiB: 64 * 1024, Threads: 4}
+
+// keyFile is the stored form of the data key: the key that encrypts the
+// credentials, wrapped with a key derived from the master passphrase.
+// Changing the passphrase only rewraps the data key.
+type keyFile struct {
+	Version    int       `json:"version"`
+	KDF        string    `json:"kdf"`
+	Params     KDFParams `json:"params"`
+	Salt       []byte    `json:"salt"`
+	WrappedKey []byte    `json:"wrapped_key"`
+}
+
+const keyFileVersion = 1
+
+// DeriveKey stretches a passphrase into a 256-bit key with Argon2id
+func DeriveKey(passphrase string, salt []byte, params KDFParams) []byte {
+	return argon2.IDKey([]byte(passphrase), salt, params.Time, params.MemoryKiB, params.Threads, 32)
+}
+
+// wrapKey returns a key file holding dataKey wrapped with passphrase
+func wrapKey(dataKey []byte, passphrase string) (*keyFile, error) {
+	salt := make([]byte, 16)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+	kf := &keyFile{
+		Version: keyFileVersion,
+		KDF:     "argon2id",
+		Params:  DefaultKDFParams,
+		Salt:    salt,
+	}
+	wrapped, err := encrypt(dataKey, DeriveKey(passphrase, salt, kf.Params))
+	if err != nil {
+		return nil, err
+	}
+	kf.WrappedKey = wrapped
+	return kf, nil
+}
+
+// unwrap returns the data key if passphrase is right
+func (kf *keyFile) unwrap(passphrase string) ([]byte, error) {
+	if kf.KDF != "argon2id" {
+		return nil, fmt.Errorf("unsupported key derivation %q", kf.KDF)
+	}
+	dataKey, err := decrypt(kf.WrappedKey, DeriveKey(passphrase, kf.Salt, kf.Params))
+	if err != nil {
+		return nil, ErrWrongPassphrase
+	}
+	return dataKey, nil
+}
+
+func readKeyFile(backend Backend) (*keyFile, error) {
+	data, err := backend.Get(itemKeyFile)
+	if err != nil {
+		return nil, err
+	}
+	var kf keyFile
+	if err := json.Unmarshal(data, &kf); err != nil {
+		return nil, fmt.Errorf("invalid key file in %s: %w", backend.Name(), err)
+	}
+	if kf.Version != keyFileVersion {
+		return nil, fmt.Errorf("key file in %s has unsupported version %d", backend.Name(), kf.Version)
+	}
+	return &kf, nil
+}
+
+func writeKeyFile(backend Backend, kf *keyFile) error {
+	data, err := json.MarshalIndent(kf, "", "  ")
+	if err != nil {
+		return err
+	}
+	return backend.Set(itemKeyFile, data)
+}
+
+// writeFileAtomic replaces path with data so readers see either the old or
+// the new contents, never a partial file
+func writeFileAtomic(path string, data []byte, perm os.FileMode) error {
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, perm); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return nil
+}
+
+func checkPassphrase(passphrase string) error {
+	if len([]rune(passphrase)) < MinPassphraseLength {
+		return fmt.Errorf("passphrase must be at least %d characters", MinPassphraseLength)
+	}
+	return nil
+}
+
+// Status returns whether the store is unprotected, kept by a keyring,
+// locked or unlocked
+func (cs *CredentialStore) Status() string {
+	cs.mu.RLock()
+	defer cs.mu.RUnlock()
+	return cs.status()
+}
+
+func (cs *CredentialStore) status() string {
+	switch {
+	case !cs.protected && cs.backend.Secure():
+		return StoreKeyring
+	case !cs.protected:
+		return StoreUnprotected
+	case cs.key == nil:
+		return StoreLocked
+	default:
+		return StoreUnlocked
+	}
+}
+
+// Locked reports whether credentials cannot be read until Unlock
+func (cs *CredentialStore) Locked() bool {
+	return cs.Status() == StoreLocked
+}
+
+// SetPassphrase protects a store without a passphrase. A new random data
+// key is created and existing credentials, encrypted with the built-in or
+// keyring key so far, are re-encrypted with it. The store is left
+// unlocked.
+func (cs *CredentialStore) SetPassphrase(passphrase string) error {
+	if err := checkPassphrase(passphrase); err != nil {
+		return err
+	}
+
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+	if cs.protected {
+		return fmt.Errorf("a passphrase is already set; change it instead")
+	}
+
+	items := make(map[string][]byte, len(encryptedItems))
+	for _, item := range encryptedItems {
+		data, err := cs.loadItem(item)
+		if err != nil {
+			return err
+		}
+		items[item] = data
+	}
+	dataKey := make([]byte, 32)
+	if _, err := rand.Read(dataKey); err != nil {
+		return err
+	}
+	kf, err := wrapKey(dataKey, passphrase)
+	if err != nil {
+		return err
+	}
+
+	// Write the key file first: if we stop before the credentials are
+	// re-encrypted, Unlock finishes the migration
+	if err := writeKeyFile(cs.backend, kf); err != nil {
+		return fmt.Errorf("failed to write key file: %w", err)
+	}
+	cs.protected = true
+	cs.key = dataKey
+	for item, data := range items {
+		if data == nil {
+			continue
+		}
+		if err := cs.saveItem(item, json.RawMessage(data)); err != nil {
+			return err
+		}
+	}
+	if cs.backend.Secure() {
+		if err := cs.backend.Delete(itemDataKey); err != nil {
+			fmt.Printf("failed to remove unwrapped data key from %s: %v\n", cs.backend.Name(), err)
+		}
+	}
+	return nil
+}
+
+// Unlock derives the key encryption key from passphrase and unwraps the
+// data key, so credentials can be read and saved until Lock
+func (cs *CredentialStore) Unlock(passphrase string) error {
+	if err := cs.unlock(passphrase); err != nil {
+		return err
+	}
+
+	cs.mu.RLock()
+	onUnlock := cs.onUnlock
+	cs.mu.RUnlock()
+	if onUnlock != nil {
+		onUnlock()
+	}
+	return nil
+}
+
+func (cs *CredentialStore) unlock(passphrase string) error {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+	if !cs.protected {
+		return nil
+	}
+
+	kf, err := readKeyFile(cs.backend)
+	if err != nil {
+		return err
+	}
+	dataKey, err := kf.unwrap(passphrase)
+	if err != nil {
+		return err
+	}
+	cs.key = dataKey
+	return cs.finishMigration()
+}
+
+// finishMigration re-encrypts items still encrypted with the built-in
+// key, left behind by an interrupted SetPassphrase or moved from files
+// into a keyring. The caller holds cs.mu or owns cs, and the store is
+// unlocked.
+func (cs *CredentialStore) finishMigration() error {
+	for _, item := range encryptedItems {
+		data, err := cs.backend.Get(item)
+		if err != nil || len(data) == 0 {
+			continue
+		}
+		if _, err := decrypt(data, cs.key); err == nil {
+			continue
+		}
+
+		plain, err := decrypt(data, legacyKey())
+		if err != nil {
+			return fmt.Errorf("%s is encrypted with an unknown key: %w", item, err)
+		}
+		if err := cs.saveItem(item, json.RawMessage(plain)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Lock forgets the data key until the next Unlock
+func (cs *CredentialStore) Lock() error {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+	if !cs.protected {
+		return fmt.Errorf("no passphrase is set")
+	}
+	clear(cs.key)
+	cs.key = nil
+	return nil
+}
+
+// ChangePassphrase rewraps the data key with a new passphrase. The
+// credentials themselves are not re-encrypted.
+func (cs *CredentialStore) ChangePassphrase(oldPassphrase, newPassphrase string) error {
+	if err := checkPassphrase(newPassphrase); err != nil {
+		return err
+	}
+
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+	if !cs.protected {
+		return fmt.Errorf("no passphrase is set")
+	}
+
+	kf, err := readKeyFile(cs.backend)
+	if err != nil {
+		return err
+	}
+	dataKey, err := kf.unwrap(oldPassphrase)
+	if err != nil {
+		return err
+	}
+	newKF, err := wrapKey(dataKey, newPassphrase)
+	if err != nil {
+		return err
+	}
+	if err := writeKeyFile(cs.backend, newKF); err != nil {
+		return fmt.Errorf("failed to write key file: %w", err)
+	}
+	return nil
+}
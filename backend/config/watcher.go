@@ -0,0 +1,87 @@
+package config
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+const (
+	// configPollInterval is how often the configs directory is scanned
+	configPollInterval = time.Second
+	// configDebounce is how long files must stay unchanged before a reload,
+	// so an editor writing a file in several steps triggers only one
+	configDebounce = 2 * time.Second
+)
+
+// fileStamp identifies one version of a config file
+type fileStamp struct {
+	modTime time.Time
+	size    int64
+}
+
+// WatchConfigs reloads the app catalog whenever a file in configs/apps is
+// added, changed or removed, until ctx is cancelled. The directory is
+// polled, so it works on every platform and also when the directory does
+// not exist yet. Call LoadAppConfigs first; WatchConfigs only reacts to
+// later changes.
+func (l *Loader) WatchConfigs(ctx context.Context) error {
+	last, err := l.scanConfigs()
+	if err != nil {
+		return err
+	}
+
+	go func() {
+		ticker := time.NewTicker(configPollInterval)
+		defer ticker.Stop()
+
+		var changedAt time.Time // zero when nothing is pending
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case now := <-ticker.C:
+				current, err := l.scanConfigs()
+				if err != nil {
+					fmt.Printf("Warning: Failed to scan app configs: %v\n", err)
+					continue
+				}
+				if !maps.Equal(current, last) {
+					last = current
+					changedAt = now
+					continue
+				}
+				if changedAt.IsZero() || now.Sub(changedAt) < configDebounce {
+					continue
+				}
+				changedAt = time.Time{}
+				if err := l.LoadAppConfigs(); err != nil {
+					fmt.Printf("Warning: Some app configs were rejected: %v\n", err)
+				}
+			}
+		}
+	}()
+	return nil
+}
+
+// scanConfigs stats every config file in configs/apps
+func (l *Loader) scanConfigs() (map[string]fileStamp, error) {
+	files, err := filepath.Glob(filepath.Join(l.configsDir, "apps", "*.yaml"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to glob config files: %w", err)
+	}
+
+	stamps := make(map[string]fileStamp, len(files))
+	for _, file := range files {
+		info, err := os.Stat(file)
+		if err != nil {
+			// Removed between the glob and the stat; the next scan settles it
+			continue
+		}
+		stamps[file] = fileStamp{modTime: info.ModTime(), size: info.Size()}
+	}
+	return stamps, nil
+}
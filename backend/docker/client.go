@@ -4,58 +4,93 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"os"
 	"os/exec"
+	"sort"
 	"strconv"
 	"strings"
 	"time"
 )
 
-// Client manages Docker via CLI commands
-type Client struct {
+// CLIClient manages Docker via CLI commands. It is kept as a fallback for
+// hosts the native Engine client cannot reach, such as ssh:// hosts.
+type CLIClient struct {
 	dockerCmd string
 	ctx       context.Context
 }
 
-// NewDockerClient creates a new Docker client instance
-func NewDockerClient(host string) (*Client, error) {
+// NewCLIClient creates a new Docker CLI client instance
+func NewCLIClient(host string) (*CLIClient, error) {
 	dockerCmd := "docker"
 	if host != "" {
 		dockerCmd = fmt.Sprintf("docker -H %s", host)
 	}
 
-	return &Client{
+	return &CLIClient{
 		dockerCmd: dockerCmd,
 		ctx:       context.Background(),
 	}, nil
 }
 
 // TestConnection tests the Docker daemon connection
-func (c *Client) TestConnection() error {
+func (c *CLIClient) TestConnection() error {
 	ctx, cancel := context.WithTimeout(c.ctx, 5*time.Second)
 	defer cancel()
 
 	args := c.parseCommand("ps")
 	cmd := exec.CommandContext(ctx, args[0], args[1:]...)
+	hideConsoleWindow(cmd)
 
-	if err := cmd.Run(); err != nil {
-		return fmt.Errorf("failed to connect to Docker daemon: %w", err)
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("failed to connect to Docker daemon: %w", cliError(err, output))
 	}
 
 	return nil
 }
 
-// CreateContainer creates a new container
-func (c *Client) CreateContainer(config *ContainerConfig) error {
-	args := c.parseCommand("run", "-d", "--name", config.Name, config.Image)
+// PullImage pulls an image from its registry
+func (c *CLIClient) PullImage(image string) error {
+	args := c.parseCommand("pull", image)
+	cmd := exec.CommandContext(c.ctx, args[0], args[1:]...)
+	hideConsoleWindow(cmd)
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("failed to pull image %s: %w", image, cliError(err, output))
+	}
+	return nil
+}
+
+// CreateContainer creates a new container without starting it
+func (c *CLIClient) CreateContainer(config *ContainerConfig) (string, error) {
+	args := c.parseCommand("create", "--name", config.Name)
+
+	if config.RestartPolicy != "" {
+		args = append(args, "--restart", config.RestartPolicy)
+	}
 
-	// Add environment variables
-	for _, env := range config.Env {
-		args = append(args, "-e", env)
+	// Add environment variables. Secrets go through an env file only we
+	// can read, so they never show up in the arguments of the docker
+	// process; the container's config still holds them.
+	env, secretEnv := config.splitSecretEnv()
+	for _, e := range env {
+		args = append(args, "-e", e)
+	}
+	if len(secretEnv) > 0 {
+		envFile, err := writeEnvFile(secretEnv)
+		if err != nil {
+			return "", fmt.Errorf("failed to write env file for %s: %w", config.Name, err)
+		}
+		defer os.Remove(envFile)
+		args = append(args, "--env-file", envFile)
 	}
 
 	// Add volumes
-	for key := range config.Volumes {
-		args = append(args, "-v", key)
+	for _, vol := range config.Volumes {
+		args = append(args, "-v", vol)
+	}
+
+	// Add ports
+	for _, p := range config.Ports {
+		args = append(args, "-p", p)
 	}
 
 	// Network mode
@@ -63,14 +98,81 @@ func (c *Client) CreateContainer(config *ContainerConfig) error {
 		args = append(args, "--network", config.NetworkMode)
 	}
 
+	for _, capability := range config.CapAdd {
+		args = append(args, "--cap-add", capability)
+	}
+	if config.Privileged {
+		args = append(args, "--privileged")
+	}
+	for _, dns := range config.DNS {
+		args = append(args, "--dns", dns)
+	}
+	labelKeys := make([]string, 0, len(config.Labels))
+	for key := range config.Labels {
+		labelKeys = append(labelKeys, key)
+	}
+	sort.Strings(labelKeys)
+	for _, key := range labelKeys {
+		args = append(args, "--label", key+"="+config.Labels[key])
+	}
+	if err := config.Resources.Validate(); err != nil {
+		return "", err
+	}
+	args = append(args, resourceArgs(config.Resources)...)
+
+	// --entrypoint takes one word; the rest go before the command
+	cmdArgs := config.Cmd
+	if len(config.Entrypoint) > 0 {
+		args = append(args, "--entrypoint", config.Entrypoint[0])
+		cmdArgs = append(append([]string{}, config.Entrypoint[1:]...), config.Cmd...)
+	}
+
+	args = append(args, config.Image)
+	args = append(args, cmdArgs...)
+
 	cmd := exec.CommandContext(c.ctx, args[0], args[1:]...)
 	hideConsoleWindow(cmd)
-	return cmd.Run()
+	output, err := cmd.Output()
+	if err != nil {
+		if exitErr, ok := err.(*exec.ExitError); ok {
+			return "", fmt.Errorf("failed to create container %s: %w", config.Name, cliError(err, exitErr.Stderr))
+		}
+		return "", fmt.Errorf("failed to create container %s: %w", config.Name, err)
+	}
+
+	return strings.TrimSpace(string(output)), nil
+}
+
+// writeEnvFile writes env entries to a new file with 0600 permissions and
+// returns its path. The caller removes it once the container is created.
+func writeEnvFile(env []string) (string, error) {
+	for _, e := range env {
+		if strings.ContainsAny(e, "\r\n") {
+			key, _, _ := strings.Cut(e, "=")
+			return "", fmt.Errorf("value of %s contains a line break", key)
+		}
+	}
+
+	// CreateTemp creates the file with 0600 permissions
+	f, err := os.CreateTemp("", "bim-env-*")
+	if err != nil {
+		return "", err
+	}
+	if _, err := f.WriteString(strings.Join(env, "\n") + "\n"); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return "", err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(f.Name())
+		return "", err
+	}
+	return f.Name(), nil
 }
 
 // GetContainer gets container by name or ID
-func (c *Client) GetContainer(name string) (*ContainerInfo, error) {
-	args := c.parseCommand("ps", "-a", "--filter", fmt.Sprintf("name=^%s$", name), "--format", "json")
+func (c *CLIClient) GetContainer(name string) (*ContainerInfo, error) {
+	args := c.parseCommand("ps", "-a", "--no-trunc", "--filter", fmt.Sprintf("name=^%s$", name), "--format", "json")
 	cmd := exec.CommandContext(c.ctx, args[0], args[1:]...)
 	hideConsoleWindow(cmd)
 
@@ -98,47 +200,113 @@ func (c *Client) GetContainer(name string) (*ContainerInfo, error) {
 	}
 
 	if len(containers) == 0 {
-		return nil, fmt.Errorf("container not found: %s", name)
+		// The name filter does not match IDs, so fall back to inspect
+		details, err := c.InspectContainer(name)
+		if err != nil {
+			return nil, err
+		}
+		return &ContainerInfo{
+			ID:     details.ID,
+			Name:   details.Name,
+			Names:  details.Name,
+			Image:  details.Config.Image,
+			State:  details.State,
+			Labels: details.Config.Labels,
+		}, nil
 	}
 
 	return &containers[0], nil
 }
 
-// StartContainer starts a container
-func (c *Client) StartContainer(name string) error {
-	args := c.parseCommand("start", name)
+// InspectContainer returns the full configuration and state of a container
+func (c *CLIClient) InspectContainer(name string) (*ContainerDetails, error) {
+	args := c.parseCommand("container", "inspect", name)
 	cmd := exec.CommandContext(c.ctx, args[0], args[1:]...)
 	hideConsoleWindow(cmd)
-	return cmd.Run()
+
+	output, err := cmd.Output()
+	if err != nil {
+		if exitErr, ok := err.(*exec.ExitError); ok {
+			return nil, cliError(err, exitErr.Stderr)
+		}
+		return nil, fmt.Errorf("failed to inspect container: %w", err)
+	}
+
+	var inspected []containerInspect
+	if err := json.Unmarshal(output, &inspected); err != nil {
+		return nil, fmt.Errorf("failed to parse inspect output: %w", err)
+	}
+	if len(inspected) == 0 {
+		return nil, &APIError{StatusCode: 404, Message: "No such container: " + name}
+	}
+
+	return inspected[0].details(), nil
+}
+
+// StartContainer starts a container
+func (c *CLIClient) StartContainer(name string) error {
+	return c.run("start", name)
 }
 
 // StopContainer stops a container
-func (c *Client) StopContainer(name string) error {
-	args := c.parseCommand("stop", name)
-	cmd := exec.CommandContext(c.ctx, args[0], args[1:]...)
-	hideConsoleWindow(cmd)
-	return cmd.Run()
+func (c *CLIClient) StopContainer(name string) error {
+	return c.run("stop", name)
 }
 
 // RemoveContainer removes a container
-func (c *Client) RemoveContainer(name string) error {
-	args := c.parseCommand("rm", "-f", name)
-	cmd := exec.CommandContext(c.ctx, args[0], args[1:]...)
-	hideConsoleWindow(cmd)
-	return cmd.Run()
+func (c *CLIClient) RemoveContainer(name string) error {
+	return c.run("rm", "-f", name)
 }
 
 // RestartContainer restarts a container
-func (c *Client) RestartContainer(name string) error {
-	args := c.parseCommand("restart", name)
-	cmd := exec.CommandContext(c.ctx, args[0], args[1:]...)
-	hideConsoleWindow(cmd)
-	return cmd.Run()
+func (c *CLIClient) RestartContainer(name string) error {
+	return c.run("restart", name)
+}
+
+// RenameContainer gives a container a new name
+func (c *CLIClient) RenameContainer(name, newName string) error {
+	return c.run("rename", name, newName)
+}
+
+// UpdateContainerResources changes the CPU and memory limits of a container
+// in place, without recreating it
+func (c *CLIClient) UpdateContainerResources(name string, resources Resources) error {
+	if err := resources.Validate(); err != nil {
+		return err
+	}
+	args := resourceArgs(resources)
+	// Keep swap at the default of twice the memory limit, see EngineClient
+	if resources.Memory > 0 {
+		args = append(args, "--memory-swap", strconv.FormatInt(2*resources.Memory, 10))
+	}
+	if len(args) == 0 {
+		return nil
+	}
+	args = append(args, name)
+	if err := c.run("update", args...); err != nil {
+		return fmt.Errorf("failed to update resources of %s: %w", name, err)
+	}
+	return nil
+}
+
+// resourceArgs converts resources into docker create/update flags
+func resourceArgs(resources Resources) []string {
+	args := []string{}
+	if resources.NanoCPUs > 0 {
+		args = append(args, "--cpus", FormatCPUs(resources.NanoCPUs))
+	}
+	if resources.Memory > 0 {
+		args = append(args, "--memory", strconv.FormatInt(resources.Memory, 10))
+	}
+	if resources.MemoryReservation > 0 {
+		args = append(args, "--memory-reservation", strconv.FormatInt(resources.MemoryReservation, 10))
+	}
+	return args
 }
 
 // ListContainers lists all containers
-func (c *Client) ListContainers() ([]ContainerInfo, error) {
-	args := c.parseCommand("ps", "-a", "--format", "{{json .}}")
+func (c *CLIClient) ListContainers() ([]ContainerInfo, error) {
+	args := c.parseCommand("ps", "-a", "--no-trunc", "--format", "{{json .}}")
 	cmd := exec.CommandContext(c.ctx, args[0], args[1:]...)
 	hideConsoleWindow(cmd)
 
@@ -180,9 +348,10 @@ func (c *Client) ListContainers() ([]ContainerInfo, error) {
 }
 
 // GetContainerLogs gets container logs
-func (c *Client) GetContainerLogs(name string, tail int) (string, error) {
+func (c *CLIClient) GetContainerLogs(name string, tail int) (string, error) {
 	args := c.parseCommand("logs", "--tail", fmt.Sprintf("%d", tail), name)
 	cmd := exec.CommandContext(c.ctx, args[0], args[1:]...)
+	hideConsoleWindow(cmd)
 
 	output, err := cmd.Output()
 	if err != nil {
@@ -193,9 +362,10 @@ func (c *Client) GetContainerLogs(name string, tail int) (string, error) {
 }
 
 // GetContainerLogsAll gets full container logs without tail limit
-func (c *Client) GetContainerLogsAll(name string) (string, error) {
+func (c *CLIClient) GetContainerLogsAll(name string) (string, error) {
 	args := c.parseCommand("logs", name)
 	cmd := exec.CommandContext(c.ctx, args[0], args[1:]...)
+	hideConsoleWindow(cmd)
 	output, err := cmd.Output()
 	if err != nil {
 		return "", err
@@ -203,47 +373,47 @@ func (c *Client) GetContainerLogsAll(name string) (string, error) {
 	return string(output), nil
 }
 
-// parseCommand parses the command string into executable and arguments
-func (c *Client) parseCommand(cmd string, args ...string) []string {
-	parts := strings.Fields(c.dockerCmd)
-	parts = append(parts, cmd)
-	parts = append(parts, args...)
-	return parts
-}
+// EnsureNetwork creates a bridge network unless it already exists
+func (c *CLIClient) EnsureNetwork(name string) error {
+	args := c.parseCommand("network", "inspect", name)
+	checkCmd := exec.CommandContext(c.ctx, args[0], args[1:]...)
+	hideConsoleWindow(checkCmd)
+	if err := checkCmd.Run(); err == nil {
+		// Network already exists
+		return nil
+	}
 
-// ContainerInfo represents container information (Docker CLI format)
-type ContainerInfo struct {
-	ID             string   `json:"ID"`
-	Name           string   // populated from Names field
-	Names          string   `json:"Names"`
-	Image          string   `json:"Image"`
-	Status         string   `json:"Status"`
-	State          string   `json:"State"`
-	Ports          string   `json:"Ports"`
-	PublishedPorts []string // host ports extracted from Ports field
+	if err := c.run("network", "create", name); err != nil && !IsConflict(err) {
+		return fmt.Errorf("failed to create network %s: %w", name, err)
+	}
+	return nil
 }
 
-// ContainerConfig represents container configuration
-type ContainerConfig struct {
-	Name         string
-	Image        string
-	Env          []string
-	PortBindings map[string]interface{}
-	Volumes      map[string]interface{}
-	NetworkMode  string
+// RemoveNetwork removes a network
+func (c *CLIClient) RemoveNetwork(name string) error {
+	return c.run("network", "rm", name)
 }
 
-// Helper to parse ports
-func ParsePort(port string) string {
-	return port
+// run executes a docker command whose output only matters on failure
+func (c *CLIClient) run(cmdName string, args ...string) error {
+	parts := c.parseCommand(cmdName, args...)
+	cmd := exec.CommandContext(c.ctx, parts[0], parts[1:]...)
+	hideConsoleWindow(cmd)
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return cliError(err, output)
+	}
+	return nil
 }
 
-type DockerStats struct {
-	RxBytes int64
-	TxBytes int64
+// parseCommand parses the command string into executable and arguments
+func (c *CLIClient) parseCommand(cmd string, args ...string) []string {
+	parts := strings.Fields(c.dockerCmd)
+	parts = append(parts, cmd)
+	parts = append(parts, args...)
+	return parts
 }
 
-func (c *Client) GetContainersNetworkStats(containerIDs []string) (map[string]DockerStats, error) {
+func (c *CLIClient) GetContainersNetworkStats(containerIDs []string) (map[string]DockerStats, error) {
 	result := map[string]DockerStats{}
 	if len(containerIDs) == 0 {
 		return result, nil
@@ -299,7 +469,7 @@ func (c *Client) GetContainersNetworkStats(containerIDs []string) (map[string]Do
 	return result, nil
 }
 
-func (c *Client) GetContainersStartTimes(containerIDs []string) (map[string]time.Time, error) {
+func (c *CLIClient) GetContainersStartTimes(containerIDs []string) (map[string]time.Time, error) {
 	result := map[string]time.Time{}
 	for _, id := range containerIDs {
 		args := c.parseCommand("inspect", "-f", "{{.State.StartedAt}}", id)
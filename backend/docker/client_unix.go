@@ -6,3 +6,8 @@ import "os/exec"
 
 // hideConsoleWindow is a no-op on Unix-like systems
 func hideConsoleWindow(cmd *exec.Cmd) {}
+
+// defaultEngineHost is the standard Docker daemon socket on Unix-like systems
+func defaultEngineHost() string {
+	return "unix:///var/run/docker.sock"
+}
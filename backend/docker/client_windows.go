@@ -11,3 +11,9 @@ import (
 func hideConsoleWindow(cmd *exec.Cmd) {
 	cmd.SysProcAttr = &syscall.SysProcAttr{HideWindow: true}
 }
+
+// defaultEngineHost is empty on Windows: Docker Desktop listens on a named
+// pipe, which the native client cannot dial, so the CLI is used instead.
+func defaultEngineHost() string {
+	return ""
+}
@@ -0,0 +1,539 @@
+package docker
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// maxAPIVersion is the newest Engine API version this client speaks. Older
+// daemons are talked to at their own version.
+const maxAPIVersion = "1.47"
+
+// EngineClient talks to the Docker Engine HTTP API over a unix socket or TCP
+type EngineClient struct {
+	host       string
+	baseURL    string
+	httpClient *http.Client
+	apiVersion string
+	versionMu  sync.Mutex
+	ctx        context.Context
+}
+
+// NewEngineClient creates a client for the daemon at host, e.g.
+// unix:///var/run/docker.sock or tcp://192.168.1.10:2375. Like the docker
+// CLI, a tcp host is spoken to over TLS when DOCKER_TLS_VERIFY is set; see
+// engineTLSConfig.
+func NewEngineClient(host string) (*EngineClient, error) {
+	if host == "" {
+		host = defaultEngineHost()
+	}
+	u, err := url.Parse(host)
+	if err != nil {
+		return nil, fmt.Errorf("invalid docker host %q: %w", host, err)
+	}
+
+	transport := &http.Transport{}
+	var baseURL string
+	switch u.Scheme {
+	case "unix":
+		socketPath := u.Path
+		transport.DialContext = func(ctx context.Context, _, _ string) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, "unix", socketPath)
+		}
+		baseURL = "http://docker"
+	case "http":
+		baseURL = "http://" + u.Host
+	case "tcp":
+		if os.Getenv("DOCKER_TLS_VERIFY") == "" {
+			baseURL = "http://" + u.Host
+			break
+		}
+		fallthrough
+	case "https":
+		tlsConfig, err := engineTLSConfig()
+		if err != nil {
+			return nil, fmt.Errorf("invalid TLS settings for docker host %q: %w", host, err)
+		}
+		transport.TLSClientConfig = tlsConfig
+		baseURL = "https://" + u.Host
+	default:
+		return nil, fmt.Errorf("unsupported docker host scheme: %s", u.Scheme)
+	}
+
+	return &EngineClient{
+		host:       host,
+		baseURL:    baseURL,
+		httpClient: &http.Client{Transport: transport},
+		ctx:        context.Background(),
+	}, nil
+}
+
+// engineTLSConfig loads the CA and client certificate the docker CLI uses
+// from DOCKER_CERT_PATH, ~/.docker by default: ca.pem, cert.pem and
+// key.pem. Without ca.pem the daemon is verified against the system roots.
+func engineTLSConfig() (*tls.Config, error) {
+	dir := os.Getenv("DOCKER_CERT_PATH")
+	if dir == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return nil, err
+		}
+		dir = filepath.Join(home, ".docker")
+	}
+
+	config := &tls.Config{MinVersion: tls.VersionTLS12}
+	caFile := filepath.Join(dir, "ca.pem")
+	ca, err := os.ReadFile(caFile)
+	switch {
+	case err == nil:
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(ca) {
+			return nil, fmt.Errorf("no certificates found in %s", caFile)
+		}
+		config.RootCAs = pool
+	case !errors.Is(err, fs.ErrNotExist):
+		return nil, err
+	}
+
+	cert, err := tls.LoadX509KeyPair(filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem"))
+	switch {
+	case err == nil:
+		config.Certificates = []tls.Certificate{cert}
+	case !errors.Is(err, fs.ErrNotExist):
+		return nil, fmt.Errorf("failed to load client certificate from %s: %w", dir, err)
+	}
+	return config, nil
+}
+
+// TestConnection tests the Docker daemon connection
+func (c *EngineClient) TestConnection() error {
+	ctx, cancel := context.WithTimeout(c.ctx, 5*time.Second)
+	defer cancel()
+
+	if _, err := c.ping(ctx); err != nil {
+		return fmt.Errorf("failed to connect to Docker daemon: %w", err)
+	}
+	return nil
+}
+
+// PullImage pulls an image and waits for the pull to finish
+func (c *EngineClient) PullImage(image string) error {
+	repo, tag := splitImageRef(image)
+	query := url.Values{"fromImage": {repo}, "tag": {tag}}
+
+	resp, err := c.do(c.ctx, http.MethodPost, "/images/create", query, nil)
+	if err != nil {
+		return fmt.Errorf("failed to pull image %s: %w", image, err)
+	}
+	defer resp.Body.Close()
+
+	// The daemon reports pull failures inside the progress stream
+	dec := json.NewDecoder(resp.Body)
+	for {
+		var msg struct {
+			Error string `json:"error"`
+		}
+		if err := dec.Decode(&msg); err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return fmt.Errorf("failed to read pull progress for %s: %w", image, err)
+		}
+		if msg.Error != "" {
+			return fmt.Errorf("failed to pull image %s: %s", image, msg.Error)
+		}
+	}
+}
+
+// CreateContainer creates a new container without starting it. Secret env
+// entries travel in the request body with the others, over the local
+// socket or the configured TLS connection, and end up in the container's
+// config like them.
+func (c *EngineClient) CreateContainer(config *ContainerConfig) (string, error) {
+	body, err := newCreateRequest(config)
+	if err != nil {
+		return "", err
+	}
+
+	var created struct {
+		ID string `json:"Id"`
+	}
+	query := url.Values{"name": {config.Name}}
+	if err := c.doJSON(http.MethodPost, "/containers/create", query, body, &created); err != nil {
+		return "", fmt.Errorf("failed to create container %s: %w", config.Name, err)
+	}
+	return created.ID, nil
+}
+
+// GetContainer gets container by name or ID
+func (c *EngineClient) GetContainer(name string) (*ContainerInfo, error) {
+	details, err := c.InspectContainer(name)
+	if err != nil {
+		return nil, err
+	}
+	return &ContainerInfo{
+		ID:    details.ID,
+		Name:  details.Name,
+		Names: details.Name,
+		Image: details.Config.Image,
+		State: details.State,
+	}, nil
+}
+
+// InspectContainer returns the full configuration and state of a container
+func (c *EngineClient) InspectContainer(name string) (*ContainerDetails, error) {
+	var inspected containerInspect
+	if err := c.doJSON(http.MethodGet, "/containers/"+url.PathEscape(name)+"/json", nil, nil, &inspected); err != nil {
+		return nil, err
+	}
+	return inspected.details(), nil
+}
+
+// StartContainer starts a container
+func (c *EngineClient) StartContainer(name string) error {
+	return c.doNoContent(http.MethodPost, "/containers/"+url.PathEscape(name)+"/start", nil)
+}
+
+// StopContainer stops a container
+func (c *EngineClient) StopContainer(name string) error {
+	return c.doNoContent(http.MethodPost, "/containers/"+url.PathEscape(name)+"/stop", nil)
+}
+
+// RemoveContainer removes a container
+func (c *EngineClient) RemoveContainer(name string) error {
+	return c.doNoContent(http.MethodDelete, "/containers/"+url.PathEscape(name), url.Values{"force": {"1"}})
+}
+
+// RestartContainer restarts a container
+func (c *EngineClient) RestartContainer(name string) error {
+	return c.doNoContent(http.MethodPost, "/containers/"+url.PathEscape(name)+"/restart", nil)
+}
+
+// RenameContainer gives a container a new name
+func (c *EngineClient) RenameContainer(name, newName string) error {
+	query := url.Values{"name": {newName}}
+	return c.doNoContent(http.MethodPost, "/containers/"+url.PathEscape(name)+"/rename", query)
+}
+
+// UpdateContainerResources changes the CPU and memory limits of a container
+// in place, without recreating it
+func (c *EngineClient) UpdateContainerResources(name string, resources Resources) error {
+	if err := resources.Validate(); err != nil {
+		return err
+	}
+	body := newResourceConfig(resources)
+	// Raising the memory limit above the current swap limit is rejected by
+	// the daemon, so keep swap at the default of twice the memory limit
+	if resources.Memory > 0 {
+		body.MemorySwap = 2 * resources.Memory
+	}
+	if err := c.doJSON(http.MethodPost, "/containers/"+url.PathEscape(name)+"/update", nil, body, nil); err != nil {
+		return fmt.Errorf("failed to update resources of %s: %w", name, err)
+	}
+	return nil
+}
+
+// ListContainers lists all containers
+func (c *EngineClient) ListContainers() ([]ContainerInfo, error) {
+	var summaries []containerSummary
+	if err := c.doJSON(http.MethodGet, "/containers/json", url.Values{"all": {"1"}}, nil, &summaries); err != nil {
+		return nil, err
+	}
+
+	containers := make([]ContainerInfo, 0, len(summaries))
+	for _, s := range summaries {
+		containers = append(containers, s.info())
+	}
+	return containers, nil
+}
+
+// GetContainerLogs gets container logs
+func (c *EngineClient) GetContainerLogs(name string, tail int) (string, error) {
+	return c.logs(name, strconv.Itoa(tail))
+}
+
+// GetContainerLogsAll gets full container logs without tail limit
+func (c *EngineClient) GetContainerLogsAll(name string) (string, error) {
+	return c.logs(name, "all")
+}
+
+func (c *EngineClient) logs(name, tail string) (string, error) {
+	// TTY containers send raw output; others use the multiplexed stream format
+	details, err := c.InspectContainer(name)
+	if err != nil {
+		return "", err
+	}
+
+	query := url.Values{"stdout": {"1"}, "stderr": {"1"}, "tail": {tail}}
+	resp, err := c.do(c.ctx, http.MethodGet, "/containers/"+url.PathEscape(name)+"/logs", query, nil)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	var buf bytes.Buffer
+	if details.Tty {
+		_, err = io.Copy(&buf, resp.Body)
+	} else {
+		err = demuxStream(resp.Body, &buf, &buf)
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to read logs: %w", err)
+	}
+	return buf.String(), nil
+}
+
+// EnsureNetwork creates a bridge network unless it already exists
+func (c *EngineClient) EnsureNetwork(name string) error {
+	err := c.doJSON(http.MethodGet, "/networks/"+url.PathEscape(name), nil, nil, nil)
+	if err == nil {
+		return nil
+	}
+	if !IsNotFound(err) {
+		return err
+	}
+
+	body := map[string]interface{}{"Name": name, "CheckDuplicate": true}
+	if err := c.doJSON(http.MethodPost, "/networks/create", nil, body, nil); err != nil && !IsConflict(err) {
+		return fmt.Errorf("failed to create network %s: %w", name, err)
+	}
+	return nil
+}
+
+// RemoveNetwork removes a network
+func (c *EngineClient) RemoveNetwork(name string) error {
+	return c.doNoContent(http.MethodDelete, "/networks/"+url.PathEscape(name), nil)
+}
+
+func (c *EngineClient) GetContainersNetworkStats(containerIDs []string) (map[string]DockerStats, error) {
+	result := map[string]DockerStats{}
+	for _, id := range containerIDs {
+		var stats struct {
+			Networks map[string]struct {
+				RxBytes int64 `json:"rx_bytes"`
+				TxBytes int64 `json:"tx_bytes"`
+			} `json:"networks"`
+		}
+		query := url.Values{"stream": {"false"}, "one-shot": {"true"}}
+		if err := c.doJSON(http.MethodGet, "/containers/"+url.PathEscape(id)+"/stats", query, nil, &stats); err != nil {
+			continue
+		}
+		var total DockerStats
+		for _, n := range stats.Networks {
+			total.RxBytes += n.RxBytes
+			total.TxBytes += n.TxBytes
+		}
+		result[id] = total
+	}
+	return result, nil
+}
+
+func (c *EngineClient) GetContainersStartTimes(containerIDs []string) (map[string]time.Time, error) {
+	result := map[string]time.Time{}
+	for _, id := range containerIDs {
+		details, err := c.InspectContainer(id)
+		if err != nil || details.StartedAt.IsZero() {
+			continue
+		}
+		result[id] = details.StartedAt
+	}
+	return result, nil
+}
+
+// ping checks the daemon and returns the API version it reports
+func (c *EngineClient) ping(ctx context.Context) (string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.baseURL+"/_ping", nil)
+	if err != nil {
+		return "", err
+	}
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("%w: %v", ErrUnavailable, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", decodeAPIError(resp)
+	}
+	return resp.Header.Get("API-Version"), nil
+}
+
+// version negotiates the API version on first use and caches it. A failed
+// negotiation is retried on the next call so a late-starting daemon is picked up.
+func (c *EngineClient) version(ctx context.Context) (string, error) {
+	c.versionMu.Lock()
+	defer c.versionMu.Unlock()
+
+	if c.apiVersion != "" {
+		return c.apiVersion, nil
+	}
+
+	daemonVersion, err := c.ping(ctx)
+	if err != nil {
+		return "", err
+	}
+	c.apiVersion = maxAPIVersion
+	if daemonVersion != "" && compareAPIVersions(daemonVersion, maxAPIVersion) < 0 {
+		c.apiVersion = daemonVersion
+	}
+	return c.apiVersion, nil
+}
+
+// do sends a versioned API request and returns the response for 2xx/304
+// statuses. Any other status is converted into an *APIError.
+func (c *EngineClient) do(ctx context.Context, method, path string, query url.Values, body interface{}) (*http.Response, error) {
+	version, err := c.version(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return nil, fmt.Errorf("failed to encode request: %w", err)
+		}
+		reader = bytes.NewReader(data)
+	}
+
+	target := c.baseURL + "/v" + version + path
+	if len(query) > 0 {
+		target += "?" + query.Encode()
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, target, reader)
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+			return nil, err
+		}
+		return nil, fmt.Errorf("%w: %v", ErrUnavailable, err)
+	}
+	if resp.StatusCode >= 400 {
+		defer resp.Body.Close()
+		return nil, decodeAPIError(resp)
+	}
+	return resp, nil
+}
+
+// doJSON sends a request and decodes the JSON response into out (if non-nil)
+func (c *EngineClient) doJSON(method, path string, query url.Values, body, out interface{}) error {
+	resp, err := c.do(c.ctx, method, path, query, body)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if out == nil {
+		_, _ = io.Copy(io.Discard, resp.Body)
+		return nil
+	}
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("failed to decode %s response: %w", path, err)
+	}
+	return nil
+}
+
+// doNoContent sends a request whose response body is irrelevant. A 304 (for
+// example starting a running container) counts as success.
+func (c *EngineClient) doNoContent(method, path string, query url.Values) error {
+	return c.doJSON(method, path, query, nil, nil)
+}
+
+func decodeAPIError(resp *http.Response) error {
+	data, _ := io.ReadAll(io.LimitReader(resp.Body, 64*1024))
+	var payload struct {
+		Message string `json:"message"`
+	}
+	msg := strings.TrimSpace(string(data))
+	if json.Unmarshal(data, &payload) == nil && payload.Message != "" {
+		msg = payload.Message
+	}
+	if msg == "" {
+		msg = http.StatusText(resp.StatusCode)
+	}
+	return &APIError{StatusCode: resp.StatusCode, Message: msg}
+}
+
+// demuxStream splits Docker's multiplexed stdout/stderr stream. Each frame
+// starts with an 8-byte header: stream type, three zero bytes and a
+// big-endian uint32 payload size.
+func demuxStream(r io.Reader, stdout, stderr io.Writer) error {
+	header := make([]byte, 8)
+	for {
+		if _, err := io.ReadFull(r, header); err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		}
+
+		out := stdout
+		if header[0] == 2 {
+			out = stderr
+		}
+		size := int64(binary.BigEndian.Uint32(header[4:]))
+		if _, err := io.CopyN(out, r, size); err != nil {
+			return err
+		}
+	}
+}
+
+// splitImageRef splits an image reference into repository and tag. Digest
+// references are passed through whole with an empty tag.
+func splitImageRef(image string) (string, string) {
+	if strings.Contains(image, "@") {
+		return image, ""
+	}
+	lastSlash := strings.LastIndex(image, "/")
+	if idx := strings.LastIndex(image, ":"); idx > lastSlash {
+		return image[:idx], image[idx+1:]
+	}
+	return image, "latest"
+}
+
+// compareAPIVersions compares dotted API versions like "1.41"
+func compareAPIVersions(a, b string) int {
+	as := strings.Split(a, ".")
+	bs := strings.Split(b, ".")
+	for i := 0; i < len(as) || i < len(bs); i++ {
+		var x, y int
+		if i < len(as) {
+			x, _ = strconv.Atoi(as[i])
+		}
+		if i < len(bs) {
+			y, _ = strconv.Atoi(bs[i])
+		}
+		if x != y {
+			if x < y {
+				return -1
+			}
+			return 1
+		}
+	}
+	return 0
+}
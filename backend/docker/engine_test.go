@@ -0,0 +1,81 @@
+package docker
+
+import (
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// startTLSDaemon answers pings over TLS and writes its certificate as the
+// ca.pem of a docker cert directory
+func startTLSDaemon(t *testing.T) (host, certDir string) {
+	t.Helper()
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/_ping" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("API-Version", maxAPIVersion)
+		w.Write([]byte("OK"))
+	}))
+	t.Cleanup(server.Close)
+
+	certDir = t.TempDir()
+	ca := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})
+	if err := os.WriteFile(filepath.Join(certDir, "ca.pem"), ca, 0600); err != nil {
+		t.Fatal(err)
+	}
+	return "tcp://" + strings.TrimPrefix(server.URL, "https://"), certDir
+}
+
+func TestEngineClientTLS(t *testing.T) {
+	host, certDir := startTLSDaemon(t)
+	t.Setenv("DOCKER_CERT_PATH", certDir)
+
+	t.Setenv("DOCKER_TLS_VERIFY", "")
+	client, err := NewEngineClient(host)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(client.baseURL, "http://") {
+		t.Fatalf("tcp host without DOCKER_TLS_VERIFY uses %s, want plain http", client.baseURL)
+	}
+
+	t.Setenv("DOCKER_TLS_VERIFY", "1")
+	client, err = NewEngineClient(host)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := client.TestConnection(); err != nil {
+		t.Fatalf("TestConnection over TLS = %v", err)
+	}
+
+	// The daemon's certificate is not trusted without the CA
+	t.Setenv("DOCKER_CERT_PATH", t.TempDir())
+	client, err = NewEngineClient(host)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := client.TestConnection(); err == nil {
+		t.Fatal("TestConnection without the CA succeeded")
+	}
+}
+
+func TestEngineClientTLSInvalidCertPath(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "ca.pem"), []byte("not a certificate"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("DOCKER_CERT_PATH", dir)
+	t.Setenv("DOCKER_TLS_VERIFY", "1")
+	if _, err := NewEngineClient("tcp://127.0.0.1:2376"); err == nil {
+		t.Fatal("NewEngineClient with an invalid ca.pem succeeded")
+	}
+	if _, err := NewEngineClient("https://127.0.0.1:2376"); err == nil {
+		t.Fatal("NewEngineClient for https with an invalid ca.pem succeeded")
+	}
+}
@@ -0,0 +1,323 @@
+package docker
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// containerSummary is an entry of GET /containers/json
+type containerSummary struct {
+	ID     string            `json:"Id"`
+	Names  []string          `json:"Names"`
+	Image  string            `json:"Image"`
+	State  string            `json:"State"`
+	Status string            `json:"Status"`
+	Labels map[string]string `json:"Labels"`
+	Ports  []struct {
+		IP          string `json:"IP"`
+		PrivatePort int    `json:"PrivatePort"`
+		PublicPort  int    `json:"PublicPort"`
+		Type        string `json:"Type"`
+	} `json:"Ports"`
+}
+
+// info converts the summary into the CLI-shaped ContainerInfo
+func (s containerSummary) info() ContainerInfo {
+	name := ""
+	if len(s.Names) > 0 {
+		name = strings.TrimPrefix(s.Names[0], "/")
+	}
+
+	ports := make([]string, 0, len(s.Ports))
+	published := []string{}
+	for _, p := range s.Ports {
+		if p.PublicPort == 0 {
+			ports = append(ports, fmt.Sprintf("%d/%s", p.PrivatePort, p.Type))
+			continue
+		}
+		ports = append(ports, fmt.Sprintf("%s:%d->%d/%s", p.IP, p.PublicPort, p.PrivatePort, p.Type))
+		published = append(published, strconv.Itoa(p.PublicPort))
+	}
+
+	return ContainerInfo{
+		ID:             s.ID,
+		Name:           name,
+		Names:          name,
+		Image:          s.Image,
+		Status:         s.Status,
+		State:          s.State,
+		Ports:          strings.Join(ports, ", "),
+		PublishedPorts: published,
+		Labels:         s.Labels,
+	}
+}
+
+// containerInspect is the response of GET /containers/{id}/json, which is
+// also what `docker container inspect` prints
+type containerInspect struct {
+	ID    string `json:"Id"`
+	Name  string `json:"Name"`
+	Image string `json:"Image"`
+	State struct {
+		Status    string `json:"Status"`
+		Running   bool   `json:"Running"`
+		StartedAt string `json:"StartedAt"`
+		Health    *struct {
+			Status string `json:"Status"`
+		} `json:"Health"`
+	} `json:"State"`
+	Config struct {
+		Image      string            `json:"Image"`
+		Env        []string          `json:"Env"`
+		Entrypoint []string          `json:"Entrypoint"`
+		Cmd        []string          `json:"Cmd"`
+		Tty        bool              `json:"Tty"`
+		Labels     map[string]string `json:"Labels"`
+	} `json:"Config"`
+	HostConfig struct {
+		Binds         []string                 `json:"Binds"`
+		NetworkMode   string                   `json:"NetworkMode"`
+		PortBindings  map[string][]portBinding `json:"PortBindings"`
+		RestartPolicy restartPolicy            `json:"RestartPolicy"`
+		CapAdd        []string                 `json:"CapAdd"`
+		Privileged    bool                     `json:"Privileged"`
+		DNS           []string                 `json:"Dns"`
+		resourceConfig
+	} `json:"HostConfig"`
+}
+
+func (ci containerInspect) details() *ContainerDetails {
+	d := &ContainerDetails{
+		ID:      ci.ID,
+		Name:    strings.TrimPrefix(ci.Name, "/"),
+		ImageID: ci.Image,
+		State:   ci.State.Status,
+		Running: ci.State.Running,
+		Tty:     ci.Config.Tty,
+		Config: ContainerConfig{
+			Name:        strings.TrimPrefix(ci.Name, "/"),
+			Image:       ci.Config.Image,
+			Entrypoint:  ci.Config.Entrypoint,
+			Cmd:         ci.Config.Cmd,
+			Env:         ci.Config.Env,
+			Volumes:     ci.HostConfig.Binds,
+			Ports:       formatPortBindings(ci.HostConfig.PortBindings),
+			NetworkMode: ci.HostConfig.NetworkMode,
+			CapAdd:      ci.HostConfig.CapAdd,
+			Privileged:  ci.HostConfig.Privileged,
+			DNS:         ci.HostConfig.DNS,
+			Labels:      ci.Config.Labels,
+			Resources:   ci.HostConfig.resourceConfig.resources(),
+		},
+	}
+	if ci.State.Health != nil {
+		d.Health = ci.State.Health.Status
+	}
+	if t, err := time.Parse(time.RFC3339Nano, ci.State.StartedAt); err == nil && t.Year() > 1 {
+		d.StartedAt = t
+	}
+	d.Config.RestartPolicy = ci.HostConfig.RestartPolicy.String()
+	return d
+}
+
+type portBinding struct {
+	HostIP   string `json:"HostIp"`
+	HostPort string `json:"HostPort"`
+}
+
+type restartPolicy struct {
+	Name              string `json:"Name"`
+	MaximumRetryCount int    `json:"MaximumRetryCount,omitempty"`
+}
+
+func (r restartPolicy) String() string {
+	if r.Name == "on-failure" && r.MaximumRetryCount > 0 {
+		return fmt.Sprintf("on-failure:%d", r.MaximumRetryCount)
+	}
+	return r.Name
+}
+
+// ValidateRestartPolicy checks a restart policy in the docker run
+// --restart syntax, like always or on-failure:3
+func ValidateRestartPolicy(policy string) error {
+	_, err := parseRestartPolicy(policy)
+	return err
+}
+
+// parseRestartPolicy parses the docker run --restart syntax
+func parseRestartPolicy(policy string) (restartPolicy, error) {
+	name, count, hasCount := strings.Cut(policy, ":")
+	switch name {
+	case "", "no", "always", "unless-stopped":
+		if hasCount {
+			return restartPolicy{}, fmt.Errorf("restart policy %s does not take a retry count", name)
+		}
+		return restartPolicy{Name: name}, nil
+	case "on-failure":
+		rp := restartPolicy{Name: name}
+		if hasCount {
+			n, err := strconv.Atoi(count)
+			if err != nil || n < 0 {
+				return restartPolicy{}, fmt.Errorf("invalid restart retry count: %s", count)
+			}
+			rp.MaximumRetryCount = n
+		}
+		return rp, nil
+	}
+	return restartPolicy{}, fmt.Errorf("invalid restart policy: %s", policy)
+}
+
+// createRequest is the body of POST /containers/create
+type createRequest struct {
+	Image        string              `json:"Image"`
+	Entrypoint   []string            `json:"Entrypoint,omitempty"`
+	Cmd          []string            `json:"Cmd,omitempty"`
+	Env          []string            `json:"Env,omitempty"`
+	Labels       map[string]string   `json:"Labels,omitempty"`
+	ExposedPorts map[string]struct{} `json:"ExposedPorts,omitempty"`
+	HostConfig   createHostConfig    `json:"HostConfig"`
+}
+
+type createHostConfig struct {
+	Binds         []string                 `json:"Binds,omitempty"`
+	PortBindings  map[string][]portBinding `json:"PortBindings,omitempty"`
+	RestartPolicy restartPolicy            `json:"RestartPolicy"`
+	NetworkMode   string                   `json:"NetworkMode,omitempty"`
+	CapAdd        []string                 `json:"CapAdd,omitempty"`
+	Privileged    bool                     `json:"Privileged,omitempty"`
+	DNS           []string                 `json:"Dns,omitempty"`
+	resourceConfig
+}
+
+// resourceConfig is the resource part of HostConfig, also used as the body
+// of POST /containers/{id}/update
+type resourceConfig struct {
+	NanoCPUs          int64 `json:"NanoCpus,omitempty"`
+	Memory            int64 `json:"Memory,omitempty"`
+	MemoryReservation int64 `json:"MemoryReservation,omitempty"`
+	MemorySwap        int64 `json:"MemorySwap,omitempty"`
+}
+
+func newResourceConfig(r Resources) resourceConfig {
+	return resourceConfig{
+		NanoCPUs:          r.NanoCPUs,
+		Memory:            r.Memory,
+		MemoryReservation: r.MemoryReservation,
+	}
+}
+
+func (rc resourceConfig) resources() Resources {
+	return Resources{
+		NanoCPUs:          rc.NanoCPUs,
+		Memory:            rc.Memory,
+		MemoryReservation: rc.MemoryReservation,
+	}
+}
+
+func newCreateRequest(config *ContainerConfig) (*createRequest, error) {
+	if err := config.Resources.Validate(); err != nil {
+		return nil, err
+	}
+	policy, err := parseRestartPolicy(config.RestartPolicy)
+	if err != nil {
+		return nil, err
+	}
+	exposed, bindings, err := parsePortSpecs(config.Ports)
+	if err != nil {
+		return nil, err
+	}
+
+	return &createRequest{
+		Image:        config.Image,
+		Entrypoint:   config.Entrypoint,
+		Cmd:          config.Cmd,
+		Env:          config.Env,
+		Labels:       config.Labels,
+		ExposedPorts: exposed,
+		HostConfig: createHostConfig{
+			Binds:          config.Volumes,
+			PortBindings:   bindings,
+			RestartPolicy:  policy,
+			NetworkMode:    config.NetworkMode,
+			CapAdd:         config.CapAdd,
+			Privileged:     config.Privileged,
+			DNS:            config.DNS,
+			resourceConfig: newResourceConfig(config.Resources),
+		},
+	}, nil
+}
+
+// parsePortSpecs parses docker run -p style specs: [ip:]host:container[/proto]
+// or container[/proto]
+func parsePortSpecs(specs []string) (map[string]struct{}, map[string][]portBinding, error) {
+	if len(specs) == 0 {
+		return nil, nil, nil
+	}
+
+	exposed := make(map[string]struct{}, len(specs))
+	bindings := make(map[string][]portBinding, len(specs))
+	for _, spec := range specs {
+		mapping, proto, _ := strings.Cut(spec, "/")
+		if proto == "" {
+			proto = "tcp"
+		}
+
+		parts := strings.Split(mapping, ":")
+		var hostIP, hostPort, containerPort string
+		switch len(parts) {
+		case 1:
+			containerPort = parts[0]
+		case 2:
+			hostPort, containerPort = parts[0], parts[1]
+		case 3:
+			hostIP, hostPort, containerPort = parts[0], parts[1], parts[2]
+		default:
+			return nil, nil, fmt.Errorf("invalid port mapping: %s", spec)
+		}
+		if _, err := strconv.Atoi(containerPort); err != nil {
+			return nil, nil, fmt.Errorf("invalid container port in mapping: %s", spec)
+		}
+		if hostPort != "" {
+			if _, err := strconv.Atoi(hostPort); err != nil {
+				return nil, nil, fmt.Errorf("invalid host port in mapping: %s", spec)
+			}
+		}
+
+		key := containerPort + "/" + proto
+		exposed[key] = struct{}{}
+		bindings[key] = append(bindings[key], portBinding{HostIP: hostIP, HostPort: hostPort})
+	}
+	return exposed, bindings, nil
+}
+
+// formatPortBindings turns inspect port bindings back into -p specs
+func formatPortBindings(bindings map[string][]portBinding) []string {
+	keys := make([]string, 0, len(bindings))
+	for key := range bindings {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	specs := []string{}
+	for _, key := range keys {
+		containerPort, proto, _ := strings.Cut(key, "/")
+		suffix := ""
+		if proto != "" && proto != "tcp" {
+			suffix = "/" + proto
+		}
+		for _, b := range bindings[key] {
+			switch {
+			case b.HostIP != "" && b.HostPort != "":
+				specs = append(specs, fmt.Sprintf("%s:%s:%s%s", b.HostIP, b.HostPort, containerPort, suffix))
+			case b.HostPort != "":
+				specs = append(specs, fmt.Sprintf("%s:%s%s", b.HostPort, containerPort, suffix))
+			default:
+				specs = append(specs, containerPort+suffix)
+			}
+		}
+	}
+	return specs
+}
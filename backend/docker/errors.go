@@ -0,0 +1,72 @@
+package docker
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+var (
+	// ErrNotFound is matched by errors for missing containers, images or networks
+	ErrNotFound = errors.New("not found")
+	// ErrConflict is matched by errors for names already in use or similar conflicts
+	ErrConflict = errors.New("conflict")
+	// ErrUnavailable is returned when the Docker daemon cannot be reached
+	ErrUnavailable = errors.New("docker daemon unavailable")
+)
+
+// APIError is an error reported by the Docker daemon. Both runtimes return it,
+// so callers can use errors.Is with ErrNotFound or ErrConflict regardless of
+// whether the daemon was reached over HTTP or through the CLI.
+type APIError struct {
+	StatusCode int
+	Message    string
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("docker: %s (status %d)", e.Message, e.StatusCode)
+}
+
+// Is maps HTTP status codes onto the package sentinel errors
+func (e *APIError) Is(target error) bool {
+	switch target {
+	case ErrNotFound:
+		return e.StatusCode == http.StatusNotFound
+	case ErrConflict:
+		return e.StatusCode == http.StatusConflict
+	}
+	return false
+}
+
+// IsNotFound reports whether err means the requested object does not exist
+func IsNotFound(err error) bool {
+	return errors.Is(err, ErrNotFound)
+}
+
+// IsConflict reports whether err is a name or state conflict
+func IsConflict(err error) bool {
+	return errors.Is(err, ErrConflict)
+}
+
+// cliError converts a failed docker CLI invocation into an APIError using the
+// same status codes the Engine API would have returned
+func cliError(err error, output []byte) error {
+	msg := strings.TrimSpace(string(output))
+	if msg == "" {
+		return err
+	}
+
+	status := http.StatusInternalServerError
+	lower := strings.ToLower(msg)
+	switch {
+	case strings.Contains(lower, "no such"), strings.Contains(lower, "not found"):
+		status = http.StatusNotFound
+	case strings.Contains(lower, "conflict"), strings.Contains(lower, "already in use"), strings.Contains(lower, "already exists"):
+		status = http.StatusConflict
+	case strings.Contains(lower, "cannot connect to the docker daemon"):
+		return fmt.Errorf("%w: %s", ErrUnavailable, msg)
+	}
+
+	return &APIError{StatusCode: status, Message: msg}
+}
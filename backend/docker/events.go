@@ -0,0 +1,139 @@
+package docker
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// Event is a container lifecycle event from the Docker daemon
+type Event struct {
+	Action      string // start, die, oom, destroy, "health_status: healthy", ...
+	ContainerID string
+	Name        string
+	Attributes  map[string]string
+	Time        time.Time
+}
+
+// EventHandler receives events from StreamEvents
+type EventHandler func(Event)
+
+// eventMessage is the wire format of GET /events and `docker events --format '{{json .}}'`
+type eventMessage struct {
+	Type   string `json:"Type"`
+	Action string `json:"Action"`
+	Actor  struct {
+		ID         string            `json:"ID"`
+		Attributes map[string]string `json:"Attributes"`
+	} `json:"Actor"`
+	TimeNano int64 `json:"timeNano"`
+}
+
+func (m eventMessage) event() Event {
+	return Event{
+		Action:      m.Action,
+		ContainerID: m.Actor.ID,
+		Name:        m.Actor.Attributes["name"],
+		Attributes:  m.Actor.Attributes,
+		Time:        time.Unix(0, m.TimeNano),
+	}
+}
+
+// StreamEvents blocks and calls handle for every container event whose action
+// is in actions, until ctx is cancelled or the stream breaks. Events since the
+// given time are replayed first when since is non-zero.
+func (c *EngineClient) StreamEvents(ctx context.Context, since time.Time, actions []string, handle EventHandler) error {
+	filters := map[string][]string{"type": {"container"}}
+	if len(actions) > 0 {
+		filters["event"] = actions
+	}
+	encoded, err := json.Marshal(filters)
+	if err != nil {
+		return err
+	}
+
+	query := url.Values{"filters": {string(encoded)}}
+	if !since.IsZero() {
+		query.Set("since", formatTimestamp(since))
+	}
+
+	resp, err := c.do(ctx, http.MethodGet, "/events", query, nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	return decodeEvents(ctx, resp.Body, handle)
+}
+
+// StreamEvents blocks and calls handle for every container event whose action
+// is in actions, until ctx is cancelled or the stream breaks. Events since the
+// given time are replayed first when since is non-zero.
+func (c *CLIClient) StreamEvents(ctx context.Context, since time.Time, actions []string, handle EventHandler) error {
+	args := c.parseCommand("events", "--format", "{{json .}}", "--filter", "type=container")
+	if !since.IsZero() {
+		args = append(args, "--since", formatTimestamp(since))
+	}
+	for _, action := range actions {
+		args = append(args, "--filter", "event="+action)
+	}
+
+	cmd := exec.CommandContext(ctx, args[0], args[1:]...)
+	hideConsoleWindow(cmd)
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("failed to start docker events: %w", err)
+	}
+
+	streamErr := decodeEvents(ctx, stdout, handle)
+	waitErr := cmd.Wait()
+	if streamErr != nil {
+		return streamErr
+	}
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	return waitErr
+}
+
+// decodeEvents reads newline-delimited event JSON until EOF or cancellation
+func decodeEvents(ctx context.Context, r io.Reader, handle EventHandler) error {
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		var msg eventMessage
+		if err := json.Unmarshal([]byte(line), &msg); err != nil {
+			continue
+		}
+		if msg.Type != "" && msg.Type != "container" {
+			continue
+		}
+		handle(msg.event())
+	}
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("event stream failed: %w", err)
+	}
+	return io.ErrUnexpectedEOF
+}
+
+// formatTimestamp formats a time as a fractional unix timestamp, the format the
+// daemon accepts for since/until on both events and logs
+func formatTimestamp(t time.Time) string {
+	return fmt.Sprintf("%d.%09d", t.Unix(), t.Nanosecond())
+}
@@ -0,0 +1,85 @@
+package docker
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os/exec"
+	"strings"
+)
+
+// ImageDetails is the result of inspecting a local image
+type ImageDetails struct {
+	ID          string
+	RepoDigests []string // repo@sha256:... for every registry the image was pulled from
+	Env         []string // environment baked into the image
+	Entrypoint  []string // entrypoint of the image
+	Cmd         []string // default command of the image
+}
+
+// HasDigest reports whether the local image was pulled as the given
+// manifest digest
+func (d *ImageDetails) HasDigest(digest string) bool {
+	for _, repoDigest := range d.RepoDigests {
+		if _, localDigest, ok := strings.Cut(repoDigest, "@"); ok && localDigest == digest {
+			return true
+		}
+	}
+	return false
+}
+
+// imageInspect is the part of GET /images/{name}/json that we use
+type imageInspect struct {
+	ID          string   `json:"Id"`
+	RepoDigests []string `json:"RepoDigests"`
+	Config      struct {
+		Env        []string `json:"Env"`
+		Entrypoint []string `json:"Entrypoint"`
+		Cmd        []string `json:"Cmd"`
+	} `json:"Config"`
+}
+
+func (ii imageInspect) details() *ImageDetails {
+	return &ImageDetails{
+		ID:          ii.ID,
+		RepoDigests: ii.RepoDigests,
+		Env:         ii.Config.Env,
+		Entrypoint:  ii.Config.Entrypoint,
+		Cmd:         ii.Config.Cmd,
+	}
+}
+
+// InspectImage returns the ID, repo digests and defaults of a local image
+func (c *EngineClient) InspectImage(image string) (*ImageDetails, error) {
+	var inspected imageInspect
+	// Image references keep their slashes; the daemon routes on the full name
+	if err := c.doJSON(http.MethodGet, "/images/"+image+"/json", nil, nil, &inspected); err != nil {
+		return nil, err
+	}
+	return inspected.details(), nil
+}
+
+// InspectImage returns the ID, repo digests and defaults of a local image
+func (c *CLIClient) InspectImage(image string) (*ImageDetails, error) {
+	args := c.parseCommand("image", "inspect", image)
+	cmd := exec.CommandContext(c.ctx, args[0], args[1:]...)
+	hideConsoleWindow(cmd)
+
+	output, err := cmd.Output()
+	if err != nil {
+		if exitErr, ok := err.(*exec.ExitError); ok {
+			return nil, cliError(err, exitErr.Stderr)
+		}
+		return nil, fmt.Errorf("failed to inspect image: %w", err)
+	}
+
+	var inspected []imageInspect
+	if err := json.Unmarshal(output, &inspected); err != nil {
+		return nil, fmt.Errorf("failed to parse image inspect output: %w", err)
+	}
+	if len(inspected) == 0 {
+		return nil, &APIError{StatusCode: 404, Message: "No such image: " + image}
+	}
+
+	return inspected[0].details(), nil
+}
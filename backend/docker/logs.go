@@ -0,0 +1,181 @@
+package docker
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os/exec"
+	"sync"
+	"time"
+)
+
+// LogOptions selects which part of a container's output to stream
+type LogOptions struct {
+	Follow     bool
+	Since      time.Time // zero means from the start
+	Until      time.Time // zero means no upper bound
+	Timestamps bool      // prefix every line with its RFC3339Nano timestamp
+	Tail       string    // number of lines from the end, or "all"
+	Stdout     bool
+	Stderr     bool
+}
+
+// LogLine is a single line of container output
+type LogLine struct {
+	Stream string `json:"stream"` // "stdout" or "stderr"
+	Text   string `json:"text"`
+}
+
+// LogHandler receives lines from StreamLogs. Calls are never concurrent.
+type LogHandler func(LogLine)
+
+// StreamLogs calls handle for every log line of a container until the output
+// ends, or with Follow set, until ctx is cancelled
+func (c *EngineClient) StreamLogs(ctx context.Context, name string, opts LogOptions, handle LogHandler) error {
+	details, err := c.InspectContainer(name)
+	if err != nil {
+		return err
+	}
+
+	query := url.Values{}
+	query.Set("stdout", boolParam(opts.Stdout))
+	query.Set("stderr", boolParam(opts.Stderr))
+	query.Set("follow", boolParam(opts.Follow))
+	query.Set("timestamps", boolParam(opts.Timestamps))
+	if opts.Tail != "" {
+		query.Set("tail", opts.Tail)
+	}
+	if !opts.Since.IsZero() {
+		query.Set("since", formatTimestamp(opts.Since))
+	}
+	if !opts.Until.IsZero() {
+		query.Set("until", formatTimestamp(opts.Until))
+	}
+
+	resp, err := c.do(ctx, http.MethodGet, "/containers/"+url.PathEscape(name)+"/logs", query, nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	stdout := &lineWriter{stream: "stdout", handle: handle}
+	stderr := &lineWriter{stream: "stderr", handle: handle}
+	if details.Tty {
+		// TTY containers have a single raw stream
+		_, err = io.Copy(stdout, resp.Body)
+	} else {
+		err = demuxStream(resp.Body, stdout, stderr)
+	}
+	stdout.Flush()
+	stderr.Flush()
+
+	if ctx.Err() != nil {
+		return nil
+	}
+	return err
+}
+
+// StreamLogs calls handle for every log line of a container until the output
+// ends, or with Follow set, until ctx is cancelled
+func (c *CLIClient) StreamLogs(ctx context.Context, name string, opts LogOptions, handle LogHandler) error {
+	args := c.parseCommand("logs")
+	if opts.Follow {
+		args = append(args, "--follow")
+	}
+	if opts.Timestamps {
+		args = append(args, "--timestamps")
+	}
+	if opts.Tail != "" {
+		args = append(args, "--tail", opts.Tail)
+	}
+	if !opts.Since.IsZero() {
+		args = append(args, "--since", formatTimestamp(opts.Since))
+	}
+	if !opts.Until.IsZero() {
+		args = append(args, "--until", formatTimestamp(opts.Until))
+	}
+	args = append(args, name)
+
+	cmd := exec.CommandContext(ctx, args[0], args[1:]...)
+	hideConsoleWindow(cmd)
+	stdoutPipe, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	stderrPipe, err := cmd.StderrPipe()
+	if err != nil {
+		return err
+	}
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("failed to start docker logs: %w", err)
+	}
+
+	// docker logs writes the two streams to separate pipes; serialize handler calls
+	var mu sync.Mutex
+	serialized := func(line LogLine) {
+		mu.Lock()
+		defer mu.Unlock()
+		handle(line)
+	}
+
+	var wg sync.WaitGroup
+	scan := func(r io.Reader, stream string, wanted bool) {
+		defer wg.Done()
+		scanner := bufio.NewScanner(r)
+		scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+		for scanner.Scan() {
+			if wanted {
+				serialized(LogLine{Stream: stream, Text: scanner.Text()})
+			}
+		}
+	}
+	wg.Add(2)
+	go scan(stdoutPipe, "stdout", opts.Stdout)
+	go scan(stderrPipe, "stderr", opts.Stderr)
+	wg.Wait()
+
+	if err := cmd.Wait(); err != nil && ctx.Err() == nil {
+		return fmt.Errorf("docker logs failed: %w", err)
+	}
+	return nil
+}
+
+// lineWriter turns a byte stream into LogLines, buffering partial lines
+// across writes
+type lineWriter struct {
+	stream string
+	handle LogHandler
+	buf    bytes.Buffer
+}
+
+func (w *lineWriter) Write(p []byte) (int, error) {
+	w.buf.Write(p)
+	for {
+		idx := bytes.IndexByte(w.buf.Bytes(), '\n')
+		if idx < 0 {
+			break
+		}
+		line := string(bytes.TrimRight(w.buf.Next(idx+1), "\r\n"))
+		w.handle(LogLine{Stream: w.stream, Text: line})
+	}
+	return len(p), nil
+}
+
+// Flush emits a trailing line that did not end with a newline
+func (w *lineWriter) Flush() {
+	if w.buf.Len() > 0 {
+		w.handle(LogLine{Stream: w.stream, Text: w.buf.String()})
+		w.buf.Reset()
+	}
+}
+
+func boolParam(b bool) string {
+	if b {
+		return "1"
+	}
+	return "0"
+}
@@ -0,0 +1,291 @@
+package docker
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	dockerHubRegistry = "registry-1.docker.io"
+
+	// manifestMediaTypes are accepted when resolving a tag. Multi-arch
+	// indexes come first because that is the digest the daemon records in
+	// RepoDigests after pulling by tag.
+	manifestMediaTypes = "application/vnd.oci.image.index.v1+json, " +
+		"application/vnd.docker.distribution.manifest.list.v2+json, " +
+		"application/vnd.oci.image.manifest.v1+json, " +
+		"application/vnd.docker.distribution.manifest.v2+json"
+)
+
+// ImageReference is a parsed image name like "honeygain/honeygain:latest"
+type ImageReference struct {
+	Registry   string // registry host, registry-1.docker.io for Docker Hub
+	Repository string // repository path, with "library/" for official images
+	Tag        string
+	Digest     string
+}
+
+// ParseImageReference normalizes an image name the way the docker CLI does
+func ParseImageReference(image string) (ImageReference, error) {
+	var ref ImageReference
+	name := image
+	if before, digest, ok := strings.Cut(name, "@"); ok {
+		name, ref.Digest = before, digest
+	}
+	lastSlash := strings.LastIndex(name, "/")
+	if idx := strings.LastIndex(name, ":"); idx > lastSlash {
+		name, ref.Tag = name[:idx], name[idx+1:]
+	}
+	if ref.Tag == "" && ref.Digest == "" {
+		ref.Tag = "latest"
+	}
+	if name == "" {
+		return ref, fmt.Errorf("invalid image reference: %q", image)
+	}
+
+	// The first component is a registry host if it looks like one
+	first, rest, hasSlash := strings.Cut(name, "/")
+	if hasSlash && (strings.ContainsAny(first, ".:") || first == "localhost") {
+		ref.Registry, ref.Repository = first, rest
+	} else {
+		ref.Registry, ref.Repository = dockerHubRegistry, name
+	}
+	if ref.Registry == "docker.io" || ref.Registry == "index.docker.io" {
+		ref.Registry = dockerHubRegistry
+	}
+	if ref.Registry == dockerHubRegistry && !strings.Contains(ref.Repository, "/") {
+		ref.Repository = "library/" + ref.Repository
+	}
+	if ref.Repository == "" || strings.ToLower(ref.Repository) != ref.Repository {
+		return ref, fmt.Errorf("invalid image reference: %q", image)
+	}
+	return ref, nil
+}
+
+// String returns the fully qualified reference
+func (r ImageReference) String() string {
+	s := r.Registry + "/" + r.Repository
+	if r.Tag != "" {
+		s += ":" + r.Tag
+	}
+	if r.Digest != "" {
+		s += "@" + r.Digest
+	}
+	return s
+}
+
+// RegistryClient resolves image tags to manifest digests using the registry
+// HTTP API (distribution spec), without pulling anything. Anonymous bearer
+// tokens are requested automatically, which is all public images need.
+type RegistryClient struct {
+	httpClient *http.Client
+
+	// PlainHTTP lists registry hosts (host or host:port) that are spoken to
+	// over http instead of https. Loopback registries always use http.
+	PlainHTTP map[string]bool
+
+	tokens   map[string]string // "registry repository" -> bearer token
+	tokensMu sync.Mutex
+}
+
+// NewRegistryClient creates a registry client
+func NewRegistryClient() *RegistryClient {
+	return &RegistryClient{
+		httpClient: &http.Client{Timeout: 30 * time.Second},
+		PlainHTTP:  make(map[string]bool),
+		tokens:     make(map[string]string),
+	}
+}
+
+// Digest returns the current manifest digest of an image tag in its registry
+func (c *RegistryClient) Digest(ctx context.Context, image string) (string, error) {
+	ref, err := ParseImageReference(image)
+	if err != nil {
+		return "", err
+	}
+	if ref.Digest != "" {
+		// Pinned references never change
+		return ref.Digest, nil
+	}
+
+	resp, err := c.manifestRequest(ctx, http.MethodHead, ref)
+	if err != nil {
+		return "", err
+	}
+	resp.Body.Close()
+	if digest := resp.Header.Get("Docker-Content-Digest"); digest != "" {
+		return digest, nil
+	}
+
+	// Some registries only send the digest header on GET; hash the manifest
+	// ourselves as a last resort
+	resp, err = c.manifestRequest(ctx, http.MethodGet, ref)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if digest := resp.Header.Get("Docker-Content-Digest"); digest != "" {
+		return digest, nil
+	}
+	hash := sha256.New()
+	if _, err := io.Copy(hash, io.LimitReader(resp.Body, 4<<20)); err != nil {
+		return "", fmt.Errorf("failed to read manifest of %s: %w", image, err)
+	}
+	return "sha256:" + hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// manifestRequest fetches the manifest of ref, authenticating once if the
+// registry asks for a bearer token
+func (c *RegistryClient) manifestRequest(ctx context.Context, method string, ref ImageReference) (*http.Response, error) {
+	target := fmt.Sprintf("%s://%s/v2/%s/manifests/%s", c.scheme(ref.Registry), ref.Registry, ref.Repository, ref.Tag)
+	tokenKey := ref.Registry + " " + ref.Repository
+
+	for attempt := 0; ; attempt++ {
+		req, err := http.NewRequestWithContext(ctx, method, target, nil)
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Accept", manifestMediaTypes)
+		c.tokensMu.Lock()
+		token := c.tokens[tokenKey]
+		c.tokensMu.Unlock()
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+
+		resp, err := c.httpClient.Do(req)
+		if err != nil {
+			return nil, fmt.Errorf("failed to reach registry %s: %w", ref.Registry, err)
+		}
+
+		switch {
+		case resp.StatusCode == http.StatusUnauthorized && attempt == 0:
+			challenge := resp.Header.Get("WWW-Authenticate")
+			resp.Body.Close()
+			token, err := c.fetchToken(ctx, challenge)
+			if err != nil {
+				return nil, fmt.Errorf("failed to authenticate with %s: %w", ref.Registry, err)
+			}
+			c.tokensMu.Lock()
+			c.tokens[tokenKey] = token
+			c.tokensMu.Unlock()
+			continue
+		case resp.StatusCode == http.StatusNotFound:
+			resp.Body.Close()
+			return nil, &APIError{StatusCode: resp.StatusCode, Message: "manifest unknown: " + ref.String()}
+		case resp.StatusCode >= 400:
+			resp.Body.Close()
+			return nil, &APIError{StatusCode: resp.StatusCode, Message: fmt.Sprintf("registry %s returned %s", ref.Registry, resp.Status)}
+		}
+		return resp, nil
+	}
+}
+
+// fetchToken requests an anonymous token for a Bearer challenge like
+// Bearer realm="https://auth.docker.io/token",service="registry.docker.io",scope="repository:x:pull"
+func (c *RegistryClient) fetchToken(ctx context.Context, challenge string) (string, error) {
+	scheme, params, _ := strings.Cut(challenge, " ")
+	if !strings.EqualFold(scheme, "Bearer") {
+		return "", fmt.Errorf("unsupported auth challenge: %q", challenge)
+	}
+	fields := parseChallengeParams(params)
+	realm := fields["realm"]
+	if realm == "" {
+		return "", fmt.Errorf("auth challenge without realm: %q", challenge)
+	}
+
+	query := url.Values{}
+	if service := fields["service"]; service != "" {
+		query.Set("service", service)
+	}
+	if scope := fields["scope"]; scope != "" {
+		query.Set("scope", scope)
+	}
+	target := realm
+	if len(query) > 0 {
+		target += "?" + query.Encode()
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, target, nil)
+	if err != nil {
+		return "", err
+	}
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("token endpoint returned %s", resp.Status)
+	}
+
+	var payload struct {
+		Token       string `json:"token"`
+		AccessToken string `json:"access_token"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&payload); err != nil {
+		return "", fmt.Errorf("failed to decode token response: %w", err)
+	}
+	if payload.Token != "" {
+		return payload.Token, nil
+	}
+	if payload.AccessToken != "" {
+		return payload.AccessToken, nil
+	}
+	return "", fmt.Errorf("token endpoint returned no token")
+}
+
+// parseChallengeParams parses the comma separated key="value" list of a
+// WWW-Authenticate header. Values may contain commas inside quotes.
+func parseChallengeParams(s string) map[string]string {
+	params := make(map[string]string)
+	for s = strings.TrimSpace(s); s != ""; s = strings.TrimSpace(s) {
+		key, rest, ok := strings.Cut(s, "=")
+		if !ok {
+			break
+		}
+		key = strings.ToLower(strings.TrimSpace(key))
+		var value string
+		if strings.HasPrefix(rest, `"`) {
+			end := strings.Index(rest[1:], `"`)
+			if end < 0 {
+				value, rest = rest[1:], ""
+			} else {
+				value, rest = rest[1:end+1], rest[end+2:]
+			}
+		} else {
+			value, rest, _ = strings.Cut(rest, ",")
+			rest = "," + rest
+		}
+		params[key] = value
+		s = strings.TrimPrefix(strings.TrimSpace(rest), ",")
+	}
+	return params
+}
+
+func (c *RegistryClient) scheme(registry string) string {
+	if c.PlainHTTP[registry] {
+		return "http"
+	}
+	host := registry
+	if h, _, err := net.SplitHostPort(registry); err == nil {
+		host = h
+	}
+	if host == "localhost" {
+		return "http"
+	}
+	if ip := net.ParseIP(host); ip != nil && ip.IsLoopback() {
+		return "http"
+	}
+	return "https"
+}
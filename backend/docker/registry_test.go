@@ -0,0 +1,166 @@
+package docker
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// mockRegistry serves one repository behind an anonymous bearer token, the
+// way Docker Hub does
+type mockRegistry struct {
+	server   *httptest.Server
+	manifest []byte
+	digest   string // sent as Docker-Content-Digest, none if empty
+
+	mu       sync.Mutex
+	tokens   int
+	requests []string
+}
+
+const (
+	mockRepository = "earn/app"
+	mockToken      = "anonymous-token"
+)
+
+func startMockRegistry(t *testing.T) *mockRegistry {
+	t.Helper()
+	m := &mockRegistry{manifest: []byte(`{"schemaVersion":2}`)}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("service") != "mock" || r.URL.Query().Get("scope") != "repository:"+mockRepository+":pull" {
+			http.Error(w, "bad token request "+r.URL.RawQuery, http.StatusBadRequest)
+			return
+		}
+		m.mu.Lock()
+		m.tokens++
+		m.mu.Unlock()
+		w.Write([]byte(`{"token":"` + mockToken + `"}`))
+	})
+	mux.HandleFunc("/v2/", func(w http.ResponseWriter, r *http.Request) {
+		m.mu.Lock()
+		m.requests = append(m.requests, r.Method+" "+r.URL.Path)
+		m.mu.Unlock()
+		if r.Header.Get("Authorization") != "Bearer "+mockToken {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="`+m.server.URL+`/token",service="mock",scope="repository:`+mockRepository+`:pull"`)
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		if r.URL.Path != "/v2/"+mockRepository+"/manifests/latest" {
+			http.Error(w, "manifest unknown", http.StatusNotFound)
+			return
+		}
+		if !strings.Contains(r.Header.Get("Accept"), "application/vnd.oci.image.index.v1+json") {
+			http.Error(w, "no accepted media type", http.StatusNotAcceptable)
+			return
+		}
+		if m.digest != "" {
+			w.Header().Set("Docker-Content-Digest", m.digest)
+		}
+		if r.Method == http.MethodGet {
+			w.Write(m.manifest)
+		}
+	})
+	m.server = httptest.NewServer(mux)
+	t.Cleanup(m.server.Close)
+	return m
+}
+
+// image names the repository on the mock registry
+func (m *mockRegistry) image(repository string) string {
+	return strings.TrimPrefix(m.server.URL, "http://") + "/" + repository + ":latest"
+}
+
+func TestRegistryDigestWithBearerToken(t *testing.T) {
+	registry := startMockRegistry(t)
+	registry.digest = "sha256:0123456789abcdef"
+	client := NewRegistryClient()
+
+	for i := 0; i < 2; i++ {
+		digest, err := client.Digest(context.Background(), registry.image(mockRepository))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if digest != registry.digest {
+			t.Fatalf("Digest = %q, want %q", digest, registry.digest)
+		}
+	}
+	if registry.tokens != 1 {
+		t.Fatalf("token requested %d times, want 1", registry.tokens)
+	}
+	want := []string{
+		"HEAD /v2/" + mockRepository + "/manifests/latest",
+		"HEAD /v2/" + mockRepository + "/manifests/latest",
+		"HEAD /v2/" + mockRepository + "/manifests/latest",
+	}
+	if strings.Join(registry.requests, "\n") != strings.Join(want, "\n") {
+		t.Fatalf("requests = %q, want %q", registry.requests, want)
+	}
+}
+
+func TestRegistryDigestWithoutHeader(t *testing.T) {
+	registry := startMockRegistry(t)
+	client := NewRegistryClient()
+
+	sum := sha256.Sum256(registry.manifest)
+	want := "sha256:" + hex.EncodeToString(sum[:])
+	digest, err := client.Digest(context.Background(), registry.image(mockRepository))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if digest != want {
+		t.Fatalf("Digest of a manifest without digest header = %q, want %q", digest, want)
+	}
+}
+
+func TestRegistryDigestErrors(t *testing.T) {
+	registry := startMockRegistry(t)
+	client := NewRegistryClient()
+
+	_, err := client.Digest(context.Background(), registry.image("earn/missing"))
+	if !IsNotFound(err) {
+		t.Fatalf("Digest of a missing repository = %v, want a not found error", err)
+	}
+
+	pinned := "example.com/earn/app@sha256:fedcba9876543210"
+	digest, err := client.Digest(context.Background(), pinned)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if digest != "sha256:fedcba9876543210" {
+		t.Fatalf("Digest of a pinned reference = %q", digest)
+	}
+}
+
+func TestParseImageReference(t *testing.T) {
+	tests := []struct {
+		image string
+		want  string
+	}{
+		{"honeygain/honeygain", "registry-1.docker.io/honeygain/honeygain:latest"},
+		{"alpine:3.20", "registry-1.docker.io/library/alpine:3.20"},
+		{"docker.io/traffmonetizer/cli_v2:arm64v8", "registry-1.docker.io/traffmonetizer/cli_v2:arm64v8"},
+		{"ghcr.io/earn/app", "ghcr.io/earn/app:latest"},
+		{"localhost:5000/earn/app:1.0", "localhost:5000/earn/app:1.0"},
+		{"earn/app@sha256:abc", "registry-1.docker.io/earn/app@sha256:abc"},
+	}
+	for _, tt := range tests {
+		ref, err := ParseImageReference(tt.image)
+		if err != nil {
+			t.Fatalf("ParseImageReference(%q) = %v", tt.image, err)
+		}
+		if ref.String() != tt.want {
+			t.Fatalf("ParseImageReference(%q) = %q, want %q", tt.image, ref.String(), tt.want)
+		}
+	}
+	for _, image := range []string{"", "Earn/App"} {
+		if _, err := ParseImageReference(image); err == nil {
+			t.Fatalf("ParseImageReference(%q) succeeded, want an error", image)
+		}
+	}
+}
@@ -0,0 +1,101 @@
+package docker
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Resources are the CPU and memory constraints of a container. Zero values
+// mean "no limit".
+type Resources struct {
+	NanoCPUs          int64 // CPU quota in units of 1e-9 CPUs
+	Memory            int64 // hard memory limit in bytes
+	MemoryReservation int64 // soft memory limit in bytes
+}
+
+// IsZero reports whether no limit is set
+func (r Resources) IsZero() bool {
+	return r.NanoCPUs == 0 && r.Memory == 0 && r.MemoryReservation == 0
+}
+
+// Validate checks the limits are consistent with each other
+func (r Resources) Validate() error {
+	if r.NanoCPUs < 0 || r.Memory < 0 || r.MemoryReservation < 0 {
+		return fmt.Errorf("resource limits must not be negative")
+	}
+	if r.Memory > 0 && r.MemoryReservation > r.Memory {
+		return fmt.Errorf("memory reservation (%d bytes) exceeds memory limit (%d bytes)", r.MemoryReservation, r.Memory)
+	}
+	if r.Memory > 0 && r.Memory < 6*1024*1024 {
+		return fmt.Errorf("memory limit must be at least 6m")
+	}
+	return nil
+}
+
+// ParseCPUs parses a --cpus value such as "0.5" or "2" into nano CPUs
+func ParseCPUs(value string) (int64, error) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return 0, nil
+	}
+	cpus, err := strconv.ParseFloat(value, 64)
+	if err != nil || cpus < 0 {
+		return 0, fmt.Errorf("invalid CPU value: %s", value)
+	}
+	return int64(cpus * 1e9), nil
+}
+
+// ParseMemory parses a docker memory size such as "512m", "1g" or "128mb"
+// into bytes. A plain number is taken as bytes.
+func ParseMemory(value string) (int64, error) {
+	value = strings.ToLower(strings.TrimSpace(value))
+	if value == "" {
+		return 0, nil
+	}
+
+	number := strings.TrimSuffix(value, "b")
+	mult := int64(1)
+	if number != "" {
+		switch number[len(number)-1] {
+		case 'k':
+			mult = 1024
+		case 'm':
+			mult = 1024 * 1024
+		case 'g':
+			mult = 1024 * 1024 * 1024
+		}
+		if mult > 1 {
+			number = number[:len(number)-1]
+		}
+	}
+
+	size, err := strconv.ParseFloat(number, 64)
+	if err != nil || size < 0 {
+		return 0, fmt.Errorf("invalid memory value: %s", value)
+	}
+	return int64(size * float64(mult)), nil
+}
+
+// FormatCPUs formats nano CPUs back into a --cpus value
+func FormatCPUs(nanoCPUs int64) string {
+	if nanoCPUs == 0 {
+		return ""
+	}
+	return strconv.FormatFloat(float64(nanoCPUs)/1e9, 'f', -1, 64)
+}
+
+// FormatMemory formats a byte count using the largest exact docker unit
+func FormatMemory(bytes int64) string {
+	switch {
+	case bytes == 0:
+		return ""
+	case bytes%(1024*1024*1024) == 0:
+		return fmt.Sprintf("%dg", bytes/(1024*1024*1024))
+	case bytes%(1024*1024) == 0:
+		return fmt.Sprintf("%dm", bytes/(1024*1024))
+	case bytes%1024 == 0:
+		return fmt.Sprintf("%dk", bytes/1024)
+	}
+	return strconv.FormatInt(bytes, 10)
+}
@@ -0,0 +1,177 @@
+package docker
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+// Runtime is the set of container operations used by the rest of the app.
+// It is implemented by EngineClient (Docker Engine HTTP API) and CLIClient
+// (docker command line), so callers never care which transport is in use.
+type Runtime interface {
+	TestConnection() error
+	PullImage(image string) error
+	InspectImage(image string) (*ImageDetails, error)
+	CreateContainer(config *ContainerConfig) (string, error)
+	GetContainer(name string) (*ContainerInfo, error)
+	InspectContainer(name string) (*ContainerDetails, error)
+	StartContainer(name string) error
+	StopContainer(name string) error
+	RemoveContainer(name string) error
+	RestartContainer(name string) error
+	RenameContainer(name, newName string) error
+	ListContainers() ([]ContainerInfo, error)
+	GetContainerLogs(name string, tail int) (string, error)
+	GetContainerLogsAll(name string) (string, error)
+	StreamLogs(ctx context.Context, name string, opts LogOptions, handle LogHandler) error
+	GetContainersNetworkStats(containerIDs []string) (map[string]DockerStats, error)
+	GetContainersStartTimes(containerIDs []string) (map[string]time.Time, error)
+	EnsureNetwork(name string) error
+	RemoveNetwork(name string) error
+	UpdateContainerResources(name string, resources Resources) error
+	StreamEvents(ctx context.Context, since time.Time, actions []string, handle EventHandler) error
+}
+
+// NewDockerClient returns the best runtime for the given host. Hosts reachable
+// over a unix socket or TCP use the native Engine API client; anything else
+// (ssh://, Windows named pipes) falls back to the docker CLI.
+func NewDockerClient(host string) (Runtime, error) {
+	if host == "" {
+		host = os.Getenv("DOCKER_HOST")
+	}
+	if host == "" {
+		host = defaultEngineHost()
+	}
+
+	if host != "" {
+		if u, err := url.Parse(host); err == nil {
+			switch u.Scheme {
+			case "unix", "tcp", "http", "https":
+				client, err := NewEngineClient(host)
+				if err != nil {
+					return nil, err
+				}
+				return client, nil
+			}
+		}
+	}
+
+	client, err := NewCLIClient(host)
+	if err != nil {
+		return nil, err
+	}
+	return client, nil
+}
+
+// RunContainer creates a container and starts it, returning the container ID.
+// If the start fails the freshly created container is removed again.
+func RunContainer(rt Runtime, config *ContainerConfig) (string, error) {
+	id, err := rt.CreateContainer(config)
+	if err != nil {
+		return "", err
+	}
+	if err := rt.StartContainer(id); err != nil {
+		_ = rt.RemoveContainer(id)
+		return "", fmt.Errorf("failed to start container %s: %w", config.Name, err)
+	}
+	return id, nil
+}
+
+// ContainerInfo represents container information as shown in a listing
+type ContainerInfo struct {
+	ID             string            `json:"ID"`
+	Name           string            // populated from Names field
+	Names          string            `json:"Names"`
+	Image          string            `json:"Image"`
+	Status         string            `json:"Status"`
+	State          string            `json:"State"`
+	Ports          string            `json:"Ports"`
+	PublishedPorts []string          // host ports extracted from Ports field
+	Labels         map[string]string `json:"-"`
+}
+
+// UnmarshalJSON decodes a line of `docker ps --format json`, which prints
+// labels as a comma separated key=value list
+func (ci *ContainerInfo) UnmarshalJSON(data []byte) error {
+	type plain ContainerInfo
+	aux := struct {
+		*plain
+		Labels string `json:"Labels"`
+	}{plain: (*plain)(ci)}
+	if err := json.Unmarshal(data, &aux); err != nil {
+		return err
+	}
+
+	ci.Labels = map[string]string{}
+	for _, pair := range strings.Split(aux.Labels, ",") {
+		if key, value, ok := strings.Cut(pair, "="); ok {
+			ci.Labels[key] = value
+		}
+	}
+	return nil
+}
+
+// ContainerConfig describes a container to create
+type ContainerConfig struct {
+	Name          string
+	Image         string
+	Entrypoint    []string // replaces the image's entrypoint when set
+	Cmd           []string
+	Env           []string
+	SecretEnv     []string // keys of Env entries holding secrets; kept off command lines, though docker inspect shows them
+	Volumes       []string // bind mounts in host:container[:mode] form
+	Ports         []string // port mappings in [ip:]host:container[/proto] form
+	NetworkMode   string
+	RestartPolicy string // no, always, unless-stopped, on-failure[:N]
+	CapAdd        []string
+	Privileged    bool
+	DNS           []string
+	Labels        map[string]string
+	Resources     Resources
+}
+
+// splitSecretEnv separates the Env entries named in SecretEnv from the rest
+func (c *ContainerConfig) splitSecretEnv() (plain, secret []string) {
+	secretKeys := make(map[string]bool, len(c.SecretEnv))
+	for _, key := range c.SecretEnv {
+		secretKeys[key] = true
+	}
+	for _, env := range c.Env {
+		key, _, _ := strings.Cut(env, "=")
+		if secretKeys[key] {
+			secret = append(secret, env)
+		} else {
+			plain = append(plain, env)
+		}
+	}
+	return plain, secret
+}
+
+// ContainerDetails is the result of inspecting a single container. Config
+// holds enough of the original settings to recreate the container.
+type ContainerDetails struct {
+	ID        string
+	Name      string
+	ImageID   string
+	State     string
+	Running   bool
+	Health    string
+	StartedAt time.Time
+	Tty       bool
+	Config    ContainerConfig
+}
+
+// Helper to parse ports
+func ParsePort(port string) string {
+	return port
+}
+
+type DockerStats struct {
+	RxBytes int64
+	TxBytes int64
+}
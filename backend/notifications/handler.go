@@ -1,7 +1,14 @@
 package notifications
 
 import (
+	"bytes"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
 	"sync"
 	"time"
 
@@ -16,13 +23,21 @@ type Handler struct {
 	mu       sync.RWMutex
 }
 
-// NewHandler creates a new notification handler
+// NewHandler creates a new notification handler with a channel for every
+// destination the config sets up
 func NewHandler(config *Config, monitor *monitor.Collector) *Handler {
-	return &Handler{
+	h := &Handler{
 		config:   config,
 		monitor:  monitor,
 		channels: make([]NotificationChannel, 0),
 	}
+	if config.DiscordWebhook != "" {
+		h.channels = append(h.channels, &DiscordWebhookChannel{WebhookURL: config.DiscordWebhook})
+	}
+	if config.TelegramBotToken != "" && config.TelegramChatID != "" {
+		h.channels = append(h.channels, &TelegramChannel{BotToken: config.TelegramBotToken, ChatID: config.TelegramChatID})
+	}
+	return h
 }
 
 // Config represents notification configuration
@@ -44,6 +59,10 @@ type Config struct {
 
 // SendNotification sends a notification through all channels
 func (h *Handler) SendNotification(event *NotificationEvent) error {
+	if !h.config.Enabled {
+		return nil
+	}
+
 	h.mu.RLock()
 	defer h.mu.RUnlock()
 
@@ -169,8 +188,7 @@ func (d *DiscordWebhookChannel) Name() string {
 }
 
 func (d *DiscordWebhookChannel) Send(event *NotificationEvent) error {
-	// TODO: Implement Discord webhook
-	return nil
+	return postJSON(d.WebhookURL, map[string]string{"content": event.Message})
 }
 
 // TelegramChannel sends notifications to Telegram
@@ -184,6 +202,39 @@ func (t *TelegramChannel) Name() string {
 }
 
 func (t *TelegramChannel) Send(event *NotificationEvent) error {
-	// TODO: Implement Telegram notifications
+	return postJSON(telegramAPI+"/bot"+t.BotToken+"/sendMessage", map[string]string{
+		"chat_id": t.ChatID,
+		"text":    event.Message,
+	})
+}
+
+// telegramAPI is the Telegram Bot API server
+const telegramAPI = "https://api.telegram.org"
+
+// notifyClient sends notifications to webhooks and bot APIs
+var notifyClient = &http.Client{Timeout: 10 * time.Second}
+
+// postJSON posts body as JSON to target. Webhook and bot URLs hold their
+// credentials, so errors leave the URL out.
+func postJSON(target string, body interface{}) error {
+	data, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	resp, err := notifyClient.Post(target, "application/json", bytes.NewReader(data))
+	if err != nil {
+		var urlErr *url.Error
+		if errors.As(err, &urlErr) {
+			err = urlErr.Err
+		}
+		return fmt.Errorf("request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		message, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("server returned %s: %s", resp.Status, strings.TrimSpace(string(message)))
+	}
+	_, _ = io.Copy(io.Discard, resp.Body)
 	return nil
 }
@@ -11,7 +11,7 @@ import (
 // Manager manages multiple Docker hosts (local + remote)
 type Manager struct {
 	devices       map[string]*Device
-	dockerClients map[string]*docker.Client
+	dockerClients map[string]docker.Runtime
 	mu            sync.RWMutex
 }
 
@@ -19,7 +19,7 @@ type Manager struct {
 func NewManager() *Manager {
 	return &Manager{
 		devices:       make(map[string]*Device),
-		dockerClients: make(map[string]*docker.Client),
+		dockerClients: make(map[string]docker.Runtime),
 	}
 }
 
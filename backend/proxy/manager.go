@@ -12,23 +12,79 @@ import (
 // ProxyEventCallback is called when proxy is added
 type ProxyEventCallback func(*Proxy)
 
+// maxHealthHistory is how many health checks are kept per proxy
+const maxHealthHistory = 100
+
 // Manager handles proxy management and validation
 type Manager struct {
 	proxies        map[string]*Proxy
 	healthCheck    map[string]ProxyHealth
+	healthHistory  map[string][]ProxyHealth
 	mu             sync.RWMutex
 	onProxyAdded   ProxyEventCallback
 	onProxyRemoved ProxyEventCallback
+	onChange       func()
 }
 
 // NewManager creates a new proxy manager
 func NewManager() *Manager {
 	return &Manager{
-		proxies:     make(map[string]*Proxy),
-		healthCheck: make(map[string]ProxyHealth),
+		proxies:       make(map[string]*Proxy),
+		healthCheck:   make(map[string]ProxyHealth),
+		healthHistory: make(map[string][]ProxyHealth),
 	}
 }
 
+// SetOnChange sets the callback for any change to proxies or their health
+func (m *Manager) SetOnChange(callback func()) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.onChange = callback
+}
+
+// notifyChange calls the change callback; callers must not hold m.mu
+func (m *Manager) notifyChange() {
+	m.mu.RLock()
+	onChange := m.onChange
+	m.mu.RUnlock()
+
+	if onChange != nil {
+		onChange()
+	}
+}
+
+// RestoreProxies loads previously saved proxies and health history without
+// firing any callbacks. The latest history entry becomes the current health.
+func (m *Manager) RestoreProxies(proxies []*Proxy, history map[string][]ProxyHealth) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, proxy := range proxies {
+		m.proxies[proxy.ID] = proxy
+	}
+	for proxyID, checks := range history {
+		if _, exists := m.proxies[proxyID]; !exists || len(checks) == 0 {
+			continue
+		}
+		m.healthHistory[proxyID] = append([]ProxyHealth(nil), checks...)
+		m.healthCheck[proxyID] = checks[len(checks)-1]
+	}
+}
+
+// SetPassword sets the password of a proxy restored without one, as the
+// credential store holding it was still locked. No callbacks fire.
+func (m *Manager) SetPassword(proxyID, password string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	proxy, exists := m.proxies[proxyID]
+	if !exists {
+		return fmt.Errorf("proxy not found: %s", proxyID)
+	}
+	proxy.Password = password
+	return nil
+}
+
 // SetOnProxyAdded sets the callback for when a proxy is added
 func (m *Manager) SetOnProxyAdded(callback ProxyEventCallback) {
 	m.mu.Lock()
@@ -62,10 +118,31 @@ func (m *Manager) AddProxy(proxyStr string) (*Proxy, error) {
 	if onProxyAdded != nil {
 		onProxyAdded(proxy)
 	}
+	m.notifyChange()
 
 	return proxy, nil
 }
 
+// PutProxy adds a proxy under its own ID, replacing any proxy with that
+// ID, as when proxies are moved from another host. The health of a
+// replaced proxy is reset.
+func (m *Manager) PutProxy(proxy *Proxy) {
+	m.mu.Lock()
+	if existing, exists := m.proxies[proxy.ID]; exists && existing.FormatProxy() != proxy.FormatProxy() {
+		delete(m.healthCheck, proxy.ID)
+		delete(m.healthHistory, proxy.ID)
+	}
+	m.proxies[proxy.ID] = proxy
+
+	onProxyAdded := m.onProxyAdded
+	m.mu.Unlock()
+
+	if onProxyAdded != nil {
+		onProxyAdded(proxy)
+	}
+	m.notifyChange()
+}
+
 // ParseProxy parses a proxy string into a Proxy struct
 func ParseProxy(proxyStr string) (*Proxy, error) {
 	// Parse proxy URL
@@ -103,26 +180,37 @@ func (m *Manager) ValidateProxy(proxyID string) error {
 	}
 
 	// Test connectivity
+	start := time.Now()
 	err := m.TestConnectivity(proxy)
+	health := ProxyHealth{
+		Status:    Healthy,
+		LastCheck: time.Now(),
+		Latency:   time.Since(start),
+	}
 	if err != nil {
-		m.mu.Lock()
-		m.healthCheck[proxyID] = ProxyHealth{
-			Status:    Unhealthy,
-			LastCheck: time.Now(),
-			Error:     err.Error(),
-		}
-		m.mu.Unlock()
-		return err
+		health.Status = Unhealthy
+		health.Error = err.Error()
 	}
 
 	m.mu.Lock()
-	m.healthCheck[proxyID] = ProxyHealth{
-		Status:    Healthy,
-		LastCheck: time.Now(),
+	// The proxy may have been removed while the check ran
+	if _, exists := m.proxies[proxyID]; exists {
+		m.recordHealth(proxyID, health)
 	}
 	m.mu.Unlock()
+	m.notifyChange()
 
-	return nil
+	return err
+}
+
+// recordHealth stores a check result; callers hold m.mu
+func (m *Manager) recordHealth(proxyID string, health ProxyHealth) {
+	m.healthCheck[proxyID] = health
+	history := append(m.healthHistory[proxyID], health)
+	if len(history) > maxHealthHistory {
+		history = history[len(history)-maxHealthHistory:]
+	}
+	m.healthHistory[proxyID] = history
 }
 
 // TestConnectivity tests proxy connectivity
@@ -201,6 +289,15 @@ func (m *Manager) GetProxyHealth(proxyID string) (ProxyHealth, bool) {
 	return health, exists
 }
 
+// GetProxyHealthHistory returns the recorded health checks of a proxy,
+// oldest first
+func (m *Manager) GetProxyHealthHistory(proxyID string) []ProxyHealth {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	return append([]ProxyHealth{}, m.healthHistory[proxyID]...)
+}
+
 // RemoveProxy removes a proxy
 func (m *Manager) RemoveProxy(proxyID string) error {
 	m.mu.Lock()
@@ -213,6 +310,7 @@ func (m *Manager) RemoveProxy(proxyID string) error {
 
 	delete(m.proxies, proxyID)
 	delete(m.healthCheck, proxyID)
+	delete(m.healthHistory, proxyID)
 
 	// Call callback if set
 	onProxyRemoved := m.onProxyRemoved
@@ -221,6 +319,7 @@ func (m *Manager) RemoveProxy(proxyID string) error {
 	if onProxyRemoved != nil {
 		onProxyRemoved(proxy)
 	}
+	m.notifyChange()
 
 	return nil
 }
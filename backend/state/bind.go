@@ -0,0 +1,241 @@
+package state
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"net/url"
+	"sort"
+	"sync"
+	"time"
+
+	"bandwidth-income-manager/backend/apps"
+	"bandwidth-income-manager/backend/config"
+	"bandwidth-income-manager/backend/proxy"
+)
+
+// binding keeps the proxy and instance managers and the state file in
+// sync. Secrets stay out of the state file: proxy passwords are kept in
+// the credential store, as are the credentials instances were deployed
+// with.
+type binding struct {
+	store       *Store
+	proxies     *proxy.Manager
+	instances   *apps.InstanceManager
+	credentials *config.CredentialStore
+
+	mu sync.Mutex
+	// saved are the proxy passwords the credential store holds
+	saved map[string]string
+}
+
+// Bind restores proxies and instances from the store into the managers and
+// saves them back to the store whenever either manager changes. Proxy
+// passwords are restored from the credential store, or once it is
+// unlocked.
+func Bind(store *Store, proxies *proxy.Manager, instances *apps.InstanceManager, credentials *config.CredentialStore) {
+	b := &binding{
+		store:       store,
+		proxies:     proxies,
+		instances:   instances,
+		credentials: credentials,
+		saved:       make(map[string]string),
+	}
+	st := store.State()
+
+	passwords, err := credentials.LoadProxyPasswords()
+	switch {
+	case err == nil:
+		b.saved = passwords
+	case !errors.Is(err, config.ErrLocked):
+		fmt.Printf("failed to load proxy passwords: %v\n", err)
+	}
+
+	restoredProxies := make([]*proxy.Proxy, 0, len(st.Proxies))
+	for _, p := range st.Proxies {
+		restoredProxies = append(restoredProxies, &proxy.Proxy{
+			ID:       p.ID,
+			Original: p.Original,
+			Protocol: p.Protocol,
+			Host:     p.Host,
+			Port:     p.Port,
+			Username: p.Username,
+			Password: b.saved[p.ID],
+		})
+	}
+	history := make(map[string][]proxy.ProxyHealth, len(st.ProxyHealth))
+	for proxyID, checks := range st.ProxyHealth {
+		for _, check := range checks {
+			history[proxyID] = append(history[proxyID], proxy.ProxyHealth{
+				Status:    proxy.HealthStatus(check.Status),
+				LastCheck: check.CheckedAt,
+				Latency:   time.Duration(check.LatencyMs) * time.Millisecond,
+				Error:     check.Error,
+			})
+		}
+	}
+	proxies.RestoreProxies(restoredProxies, history)
+
+	restoredInstances := make([]*apps.AppInstance, 0, len(st.Instances))
+	for _, inst := range st.Instances {
+		instance := &apps.AppInstance{
+			InstanceID:  inst.InstanceID,
+			AppID:       inst.AppID,
+			ProxyID:     inst.ProxyID,
+			ContainerID: inst.ContainerID,
+			DeviceName:  inst.DeviceName,
+			Profile:     inst.Profile,
+			Status:      inst.Status,
+			ProxyURL:    inst.ProxyURL,
+			SDKNodeID:   inst.SDKNodeID,
+			Generated:   inst.Generated,
+		}
+		if inst.ResourceLimits != nil {
+			instance.ResourceLimits = &apps.ResourceLimits{
+				CPUs:              inst.ResourceLimits.CPUs,
+				MemoryReservation: inst.ResourceLimits.MemoryReservation,
+				MemoryLimit:       inst.ResourceLimits.MemoryLimit,
+			}
+		}
+		restoredInstances = append(restoredInstances, instance)
+	}
+	instances.RestoreInstances(restoredInstances)
+
+	proxies.SetOnChange(b.saveProxies)
+	instances.SetOnChange(b.saveInstances)
+	credentials.SetOnUnlock(b.unlocked)
+}
+
+// unlocked restores the proxy passwords the locked credential store held
+// back
+func (b *binding) unlocked() {
+	passwords, err := b.credentials.LoadProxyPasswords()
+	if err != nil {
+		fmt.Printf("failed to load proxy passwords: %v\n", err)
+		return
+	}
+	b.mu.Lock()
+	b.saved = passwords
+	b.mu.Unlock()
+
+	for _, p := range b.proxies.ListProxies() {
+		if p.Password == "" && passwords[p.ID] != "" {
+			_ = b.proxies.SetPassword(p.ID, passwords[p.ID])
+		}
+	}
+	b.saveProxies()
+}
+
+func (b *binding) saveProxies() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	list := b.proxies.ListProxies()
+	sort.Slice(list, func(i, j int) bool { return list[i].ID < list[j].ID })
+
+	if err := b.savePasswords(list); err != nil && !errors.Is(err, config.ErrLocked) {
+		fmt.Printf("failed to save proxy passwords: %v\n", err)
+	}
+	if err := b.store.Update(func(s *State) { b.captureProxies(s, list) }); err != nil {
+		fmt.Printf("failed to save proxies: %v\n", err)
+	}
+}
+
+// savePasswords writes the passwords of proxies to the credential store;
+// callers hold b.mu
+func (b *binding) savePasswords(list []*proxy.Proxy) error {
+	passwords := make(map[string]string, len(list))
+	for _, p := range list {
+		if p.Password != "" {
+			passwords[p.ID] = p.Password
+		} else if password := b.saved[p.ID]; password != "" {
+			// Not restored yet
+			passwords[p.ID] = password
+		}
+	}
+	if maps.Equal(passwords, b.saved) {
+		return nil
+	}
+	if err := b.credentials.SaveProxyPasswords(passwords); err != nil {
+		return err
+	}
+	b.saved = passwords
+	return nil
+}
+
+// captureProxies records the proxies in s without their passwords;
+// callers hold b.mu
+func (b *binding) captureProxies(s *State, list []*proxy.Proxy) {
+	s.Proxies = make([]Proxy, 0, len(list))
+	s.ProxyHealth = make(map[string][]HealthCheck, len(list))
+	for _, p := range list {
+		s.Proxies = append(s.Proxies, Proxy{
+			ID:       p.ID,
+			Original: withoutPassword(p.Original),
+			Protocol: p.Protocol,
+			Host:     p.Host,
+			Port:     p.Port,
+			Username: p.Username,
+		})
+		for _, health := range b.proxies.GetProxyHealthHistory(p.ID) {
+			s.ProxyHealth[p.ID] = append(s.ProxyHealth[p.ID], HealthCheck{
+				Status:    string(health.Status),
+				CheckedAt: health.LastCheck,
+				LatencyMs: health.Latency.Milliseconds(),
+				Error:     health.Error,
+			})
+		}
+	}
+}
+
+func (b *binding) saveInstances() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if err := b.store.Update(b.captureInstances); err != nil {
+		fmt.Printf("failed to save instances: %v\n", err)
+	}
+}
+
+// captureInstances records the instances in s without their credentials;
+// callers hold b.mu
+func (b *binding) captureInstances(s *State) {
+	snapshot := b.instances.Snapshot()
+
+	s.Instances = make([]Instance, 0, len(snapshot))
+	for _, inst := range snapshot {
+		record := Instance{
+			InstanceID:  inst.InstanceID,
+			AppID:       inst.AppID,
+			ProxyID:     inst.ProxyID,
+			ContainerID: inst.ContainerID,
+			DeviceName:  inst.DeviceName,
+			Profile:     inst.Profile,
+			Status:      inst.Status,
+			ProxyURL:    withoutPassword(inst.ProxyURL),
+			SDKNodeID:   inst.SDKNodeID,
+			Generated:   maps.Clone(inst.Generated),
+		}
+		if inst.ResourceLimits != nil {
+			record.ResourceLimits = &ResourceLimits{
+				CPUs:              inst.ResourceLimits.CPUs,
+				MemoryReservation: inst.ResourceLimits.MemoryReservation,
+				MemoryLimit:       inst.ResourceLimits.MemoryLimit,
+			}
+		}
+		s.Instances = append(s.Instances, record)
+	}
+}
+
+// withoutPassword removes the password from a proxy URL
+func withoutPassword(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil || u.User == nil {
+		return rawURL
+	}
+	if _, ok := u.User.Password(); !ok {
+		return rawURL
+	}
+	u.User = url.User(u.User.Username())
+	return u.String()
+}
@@ -0,0 +1,61 @@
+package state
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// CurrentVersion is the schema version written by this build
+const CurrentVersion = 1
+
+// migration upgrades a state document by one version. It works on the raw
+// JSON object so that it does not depend on the current Go types.
+type migration func(doc map[string]json.RawMessage) error
+
+// migrations[v] upgrades a version v document to version v+1. To change the
+// schema, bump CurrentVersion and add the step here; never edit a released
+// step.
+var migrations = map[int]migration{}
+
+// migrate upgrades a state file to CurrentVersion. It returns the upgraded
+// JSON and the version the file was written with.
+func migrate(data []byte) ([]byte, int, error) {
+	var doc map[string]json.RawMessage
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return nil, 0, fmt.Errorf("failed to parse state file: %w", err)
+	}
+
+	version := 0
+	if raw, ok := doc["version"]; ok {
+		if err := json.Unmarshal(raw, &version); err != nil {
+			return nil, 0, fmt.Errorf("invalid state file version: %w", err)
+		}
+	}
+	if version < 1 {
+		return nil, version, fmt.Errorf("state file has no valid version")
+	}
+	if version > CurrentVersion {
+		// Refuse rather than silently dropping fields we do not know about
+		return nil, version, fmt.Errorf("state file version %d is newer than supported version %d", version, CurrentVersion)
+	}
+	if version == CurrentVersion {
+		return data, version, nil
+	}
+
+	for v := version; v < CurrentVersion; v++ {
+		step, ok := migrations[v]
+		if !ok {
+			return nil, version, fmt.Errorf("no migration from state version %d", v)
+		}
+		if err := step(doc); err != nil {
+			return nil, version, fmt.Errorf("state migration %d to %d failed: %w", v, v+1, err)
+		}
+		doc["version"] = json.RawMessage(fmt.Sprint(v + 1))
+	}
+
+	migrated, err := json.Marshal(doc)
+	if err != nil {
+		return nil, version, fmt.Errorf("failed to encode migrated state: %w", err)
+	}
+	return migrated, version, nil
+}
@@ -0,0 +1,220 @@
+package state
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// State is everything the manager needs to remember across restarts. The
+// JSON layout is the on-disk schema; any incompatible change to it needs a
+// new CurrentVersion and a migration.
+type State struct {
+	Version     int                      `json:"version"`
+	SavedAt     time.Time                `json:"saved_at"`
+	Proxies     []Proxy                  `json:"proxies"`
+	ProxyHealth map[string][]HealthCheck `json:"proxy_health"`
+	Instances   []Instance               `json:"instances"`
+	Activity    []string                 `json:"activity"`
+
+	// Generated holds the auto-generated field values of every app identity
+	// ever deployed, keyed by apps.IdentityKey, so a removed and redeployed
+	// instance comes back as the same node
+	Generated map[string]map[string]string `json:"generated,omitempty"`
+}
+
+// Proxy is a configured upstream proxy. Its password is kept in the
+// credential store; only export bundles carry it here.
+type Proxy struct {
+	ID       string `json:"id"`
+	Original string `json:"original"`
+	Protocol string `json:"protocol"`
+	Host     string `json:"host"`
+	Port     string `json:"port"`
+	Username string `json:"username,omitempty"`
+	Password string `json:"password,omitempty"`
+}
+
+// HealthCheck is one proxy connectivity check
+type HealthCheck struct {
+	Status    string    `json:"status"`
+	CheckedAt time.Time `json:"checked_at"`
+	LatencyMs int64     `json:"latency_ms,omitempty"`
+	Error     string    `json:"error,omitempty"`
+}
+
+// Instance is a deployed app container. Its credentials are kept in the
+// credential profile it names.
+type Instance struct {
+	InstanceID     string            `json:"instance_id"`
+	AppID          string            `json:"app_id"`
+	ProxyID        string            `json:"proxy_id,omitempty"`
+	ContainerID    string            `json:"container_id"`
+	DeviceName     string            `json:"device_name"`
+	Profile        string            `json:"profile,omitempty"`
+	Status         string            `json:"status"`
+	ProxyURL       string            `json:"proxy_url,omitempty"`
+	SDKNodeID      string            `json:"sdk_node_id,omitempty"`
+	Generated      map[string]string `json:"generated,omitempty"`
+	ResourceLimits *ResourceLimits   `json:"resource_limits,omitempty"`
+}
+
+// ResourceLimits are the CPU and memory limits of an instance
+type ResourceLimits struct {
+	CPUs              string `json:"cpus,omitempty"`
+	MemoryReservation string `json:"memory_reservation,omitempty"`
+	MemoryLimit       string `json:"memory_limit,omitempty"`
+}
+
+// Store keeps State in a JSON file. Every update rewrites the whole file
+// atomically, so a crash leaves either the old or the new state on disk.
+type Store struct {
+	path  string
+	state State
+	mu    sync.Mutex
+}
+
+// Open loads the state file at path, migrating it to CurrentVersion if it
+// was written by an older version. A missing file yields an empty state.
+func Open(path string) (*Store, error) {
+	s := &Store{path: path}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		s.state = State{Version: CurrentVersion}
+		return s, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read state file: %w", err)
+	}
+
+	migrated, fromVersion, err := migrate(data)
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(migrated, &s.state); err != nil {
+		return nil, fmt.Errorf("failed to parse state file: %w", err)
+	}
+
+	if fromVersion != CurrentVersion {
+		// Keep the original around in case the migration lost something
+		backup := fmt.Sprintf("%s.v%d.bak", path, fromVersion)
+		if err := writeFileAtomic(backup, data, 0600); err != nil {
+			return nil, fmt.Errorf("failed to back up state file before migration: %w", err)
+		}
+		if err := s.save(); err != nil {
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+// State returns a copy of the current state
+func (s *Store) State() State {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.state.clone()
+}
+
+// Update applies fn to the state and writes the result to disk. If the
+// write fails the in-memory state is left unchanged.
+func (s *Store) Update(fn func(*State)) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	previous := s.state.clone()
+	fn(&s.state)
+	if err := s.save(); err != nil {
+		s.state = previous
+		return err
+	}
+	return nil
+}
+
+// save writes the state; callers hold s.mu
+func (s *Store) save() error {
+	s.state.Version = CurrentVersion
+	s.state.SavedAt = time.Now().UTC()
+
+	data, err := json.MarshalIndent(s.state, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode state: %w", err)
+	}
+	if err := writeFileAtomic(s.path, data, 0600); err != nil {
+		return fmt.Errorf("failed to write state file: %w", err)
+	}
+	return nil
+}
+
+func (st State) clone() State {
+	c := st
+	c.Proxies = append([]Proxy(nil), st.Proxies...)
+	c.Activity = append([]string(nil), st.Activity...)
+	c.ProxyHealth = make(map[string][]HealthCheck, len(st.ProxyHealth))
+	for id, checks := range st.ProxyHealth {
+		c.ProxyHealth[id] = append([]HealthCheck(nil), checks...)
+	}
+	if st.Generated != nil {
+		c.Generated = make(map[string]map[string]string, len(st.Generated))
+		for key, values := range st.Generated {
+			c.Generated[key] = maps.Clone(values)
+		}
+	}
+	c.Instances = make([]Instance, len(st.Instances))
+	for i, inst := range st.Instances {
+		c.Instances[i] = inst
+		c.Instances[i].Generated = maps.Clone(inst.Generated)
+		if inst.ResourceLimits != nil {
+			limits := *inst.ResourceLimits
+			c.Instances[i].ResourceLimits = &limits
+		}
+	}
+	return c
+}
+
+// writeFileAtomic writes data to a temporary file next to path and renames
+// it into place
+func writeFileAtomic(path string, data []byte, perm os.FileMode) error {
+	dir := filepath.Dir(path)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(dir, "."+filepath.Base(path)+".tmp*")
+	if err != nil {
+		return err
+	}
+	tmpPath := tmp.Name()
+	defer os.Remove(tmpPath) // no-op after a successful rename
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Chmod(perm); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmpPath, path); err != nil {
+		return err
+	}
+
+	// Persist the rename itself; directories cannot be synced on Windows
+	if d, err := os.Open(dir); err == nil {
+		_ = d.Sync()
+		d.Close()
+	}
+	return nil
+}
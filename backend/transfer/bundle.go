@@ -0,0 +1,182 @@
+package transfer
+
+import (
+	"archive/tar"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"bandwidth-income-manager/backend/config"
+	"bandwidth-income-manager/backend/state"
+)
+
+const bundleVersion = 1
+
+// Entries of the archive inside an export file. The bundle comes first so
+// a preview does not need to decrypt the snapshots.
+const (
+	bundleEntry    = "bundle.json"
+	snapshotPrefix = "snapshots/"
+)
+
+// Bundle is everything an export moves to another host. Proxies and
+// instances use the same records as the state file.
+type Bundle struct {
+	Version     int                          `json:"version"`
+	CreatedAt   time.Time                    `json:"created_at"`
+	Hostname    string                       `json:"hostname,omitempty"`
+	Credentials []config.AppCredentials      `json:"credentials"`
+	Proxies     []state.Proxy                `json:"proxies"`
+	Instances   []state.Instance             `json:"instances"`
+	Generated   map[string]map[string]string `json:"generated,omitempty"`
+	Settings    json.RawMessage              `json:"settings,omitempty"`
+
+	// Snapshots maps an instance ID to the archive entry holding a backup
+	// snapshot of its data. After Read with a directory, it maps to the
+	// extracted file instead.
+	Snapshots map[string]string `json:"snapshots,omitempty"`
+}
+
+// NewBundle returns an empty bundle of the current version
+func NewBundle() *Bundle {
+	hostname, _ := os.Hostname()
+	return &Bundle{
+		Version:   bundleVersion,
+		CreatedAt: time.Now(),
+		Hostname:  hostname,
+	}
+}
+
+// Write encrypts bundle with passphrase into w. snapshots maps instance IDs
+// to backup archives to include; bundle.Snapshots is set from it.
+func Write(w io.Writer, passphrase string, bundle *Bundle, snapshots map[string]string) error {
+	ew, err := newEncryptWriter(w, passphrase)
+	if err != nil {
+		return err
+	}
+
+	bundle.Snapshots = make(map[string]string, len(snapshots))
+	files := make(map[string]string, len(snapshots))
+	n := 0
+	for instanceID, file := range snapshots {
+		n++
+		entry := snapshotPrefix + strconv.Itoa(n) + ".tar.gz"
+		bundle.Snapshots[instanceID] = entry
+		files[entry] = file
+	}
+
+	tw := tar.NewWriter(ew)
+	data, err := json.MarshalIndent(bundle, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := tw.WriteHeader(&tar.Header{Name: bundleEntry, Mode: 0600, Size: int64(len(data)), ModTime: bundle.CreatedAt}); err != nil {
+		return err
+	}
+	if _, err := tw.Write(data); err != nil {
+		return err
+	}
+	for entry, file := range files {
+		if err := addFile(tw, entry, file); err != nil {
+			return fmt.Errorf("failed to add snapshot %s: %w", filepath.Base(file), err)
+		}
+	}
+
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	return ew.Close()
+}
+
+func addFile(tw *tar.Writer, entry, file string) error {
+	f, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	if err := tw.WriteHeader(&tar.Header{Name: entry, Mode: 0600, Size: info.Size(), ModTime: info.ModTime()}); err != nil {
+		return err
+	}
+	_, err = io.Copy(tw, f)
+	return err
+}
+
+// Read decrypts an export. If dir is empty only the bundle is read;
+// otherwise the snapshots are extracted into dir and bundle.Snapshots
+// points at the files there.
+func Read(r io.Reader, passphrase, dir string) (*Bundle, error) {
+	dr, err := newDecryptReader(r, passphrase)
+	if err != nil {
+		return nil, err
+	}
+	tr := tar.NewReader(dr)
+
+	first, err := tr.Next()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read export: %w", err)
+	}
+	if first.Name != bundleEntry {
+		return nil, fmt.Errorf("not an export: first entry is %s", first.Name)
+	}
+	var bundle Bundle
+	if err := json.NewDecoder(tr).Decode(&bundle); err != nil {
+		return nil, fmt.Errorf("invalid export bundle: %w", err)
+	}
+	if bundle.Version != bundleVersion {
+		return nil, fmt.Errorf("export bundle has unsupported version %d", bundle.Version)
+	}
+	if dir == "" {
+		return &bundle, nil
+	}
+
+	files := make(map[string]string)
+	for {
+		h, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read export: %w", err)
+		}
+		name := path.Clean(h.Name)
+		if h.Typeflag != tar.TypeReg || path.Dir(name)+"/" != snapshotPrefix {
+			continue
+		}
+		file := filepath.Join(dir, path.Base(name))
+		if err := extractFile(tr, file); err != nil {
+			return nil, fmt.Errorf("failed to extract %s: %w", name, err)
+		}
+		files[name] = file
+	}
+
+	for instanceID, entry := range bundle.Snapshots {
+		file, ok := files[entry]
+		if !ok {
+			return nil, fmt.Errorf("export is missing snapshot %s", entry)
+		}
+		bundle.Snapshots[instanceID] = file
+	}
+	return &bundle, nil
+}
+
+func extractFile(r io.Reader, file string) error {
+	f, err := os.OpenFile(file, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(f, r); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
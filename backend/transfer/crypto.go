@@ -0,0 +1,265 @@
+package transfer
+
+import (
+	"bufio"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+
+	"bandwidth-income-manager/backend/config"
+)
+
+// An export file is a short header followed by the archive, encrypted in
+// chunks with AES-256-GCM so exports with volume snapshots never have to
+// fit in memory. A chunk's nonce is a random prefix, the chunk number and
+// a flag marking the last chunk, so chunks cannot be reordered, dropped or
+// cut off without the import noticing. The header is authenticated as
+// additional data of every chunk.
+const (
+	magic      = "BIMEXPORT\n"
+	chunkSize  = 64 * 1024
+	prefixSize = 7
+)
+
+const formatVersion = 1
+
+// header holds what is needed to derive the key and decrypt the chunks
+type header struct {
+	Version     int              `json:"version"`
+	KDF         string           `json:"kdf"`
+	Params      config.KDFParams `json:"params"`
+	Salt        []byte           `json:"salt"`
+	NoncePrefix []byte           `json:"nonce_prefix"`
+	ChunkSize   int              `json:"chunk_size"`
+}
+
+func checkPassphrase(passphrase string) error {
+	if len([]rune(passphrase)) < config.MinPassphraseLength {
+		return fmt.Errorf("passphrase must be at least %d characters", config.MinPassphraseLength)
+	}
+	return nil
+}
+
+func newAEAD(passphrase string, h *header) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(config.DeriveKey(passphrase, h.Salt, h.Params))
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+func nonce(prefix []byte, counter uint32, last bool) []byte {
+	n := make([]byte, 0, prefixSize+5)
+	n = append(n, prefix...)
+	n = binary.BigEndian.AppendUint32(n, counter)
+	if last {
+		return append(n, 1)
+	}
+	return append(n, 0)
+}
+
+// encryptWriter encrypts everything written to it into w. Close writes
+// the last chunk and must be called.
+type encryptWriter struct {
+	w       io.Writer
+	aead    cipher.AEAD
+	prefix  []byte
+	aad     []byte
+	buf     []byte
+	counter uint32
+}
+
+// newEncryptWriter writes the header to w and returns a writer for the
+// plaintext
+func newEncryptWriter(w io.Writer, passphrase string) (*encryptWriter, error) {
+	if err := checkPassphrase(passphrase); err != nil {
+		return nil, err
+	}
+
+	h := &header{
+		Version:     formatVersion,
+		KDF:         "argon2id",
+		Params:      config.DefaultKDFParams,
+		Salt:        make([]byte, 16),
+		NoncePrefix: make([]byte, prefixSize),
+		ChunkSize:   chunkSize,
+	}
+	if _, err := rand.Read(h.Salt); err != nil {
+		return nil, err
+	}
+	if _, err := rand.Read(h.NoncePrefix); err != nil {
+		return nil, err
+	}
+	aead, err := newAEAD(passphrase, h)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := json.Marshal(h)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := io.WriteString(w, magic); err != nil {
+		return nil, err
+	}
+	if err := binary.Write(w, binary.BigEndian, uint32(len(data))); err != nil {
+		return nil, err
+	}
+	if _, err := w.Write(data); err != nil {
+		return nil, err
+	}
+
+	return &encryptWriter{
+		w:      w,
+		aead:   aead,
+		prefix: h.NoncePrefix,
+		aad:    data,
+		buf:    make([]byte, 0, chunkSize),
+	}, nil
+}
+
+func (e *encryptWriter) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		// A full chunk is only sealed once more data follows, so Close
+		// always has a chunk left to mark as the last one
+		if len(e.buf) == chunkSize {
+			if err := e.seal(false); err != nil {
+				return written, err
+			}
+		}
+		n := copy(e.buf[len(e.buf):chunkSize], p)
+		e.buf = e.buf[:len(e.buf)+n]
+		p = p[n:]
+		written += n
+	}
+	return written, nil
+}
+
+func (e *encryptWriter) seal(last bool) error {
+	if e.counter == ^uint32(0) {
+		return errors.New("export is too large")
+	}
+	sealed := e.aead.Seal(nil, nonce(e.prefix, e.counter, last), e.buf, e.aad)
+	if _, err := e.w.Write(sealed); err != nil {
+		return err
+	}
+	e.counter++
+	e.buf = e.buf[:0]
+	return nil
+}
+
+// Close seals the last chunk. It does not close the underlying writer.
+func (e *encryptWriter) Close() error {
+	return e.seal(true)
+}
+
+// decryptReader returns the plaintext of an export file
+type decryptReader struct {
+	r       *bufio.Reader
+	aead    cipher.AEAD
+	prefix  []byte
+	aad     []byte
+	sealed  []byte
+	plain   []byte
+	counter uint32
+	done    bool
+}
+
+// newDecryptReader reads the header from r and returns a reader for the
+// plaintext. A wrong passphrase is reported as config.ErrWrongPassphrase
+// on the first read.
+func newDecryptReader(r io.Reader, passphrase string) (*decryptReader, error) {
+	br := bufio.NewReader(r)
+	start := make([]byte, len(magic))
+	if _, err := io.ReadFull(br, start); err != nil || string(start) != magic {
+		return nil, errors.New("not an export file")
+	}
+	var size uint32
+	if err := binary.Read(br, binary.BigEndian, &size); err != nil {
+		return nil, fmt.Errorf("invalid export header: %w", err)
+	}
+	if size > 4096 {
+		return nil, errors.New("invalid export header")
+	}
+	data := make([]byte, size)
+	if _, err := io.ReadFull(br, data); err != nil {
+		return nil, fmt.Errorf("invalid export header: %w", err)
+	}
+
+	var h header
+	if err := json.Unmarshal(data, &h); err != nil {
+		return nil, fmt.Errorf("invalid export header: %w", err)
+	}
+	if h.Version != formatVersion {
+		return nil, fmt.Errorf("export has unsupported version %d", h.Version)
+	}
+	if h.KDF != "argon2id" {
+		return nil, fmt.Errorf("unsupported key derivation %q", h.KDF)
+	}
+	if len(h.NoncePrefix) != prefixSize || h.ChunkSize <= 0 || h.ChunkSize > 16*chunkSize {
+		return nil, errors.New("invalid export header")
+	}
+	// Refuse parameters that would tie up the host deriving the key
+	if h.Params.Time == 0 || h.Params.Time > 16 || h.Params.MemoryKiB > 1024*1024 || h.Params.Threads == 0 {
+		return nil, errors.New("invalid key derivation parameters in export header")
+	}
+	aead, err := newAEAD(passphrase, &h)
+	if err != nil {
+		return nil, err
+	}
+
+	return &decryptReader{
+		r:      br,
+		aead:   aead,
+		prefix: h.NoncePrefix,
+		aad:    data,
+		sealed: make([]byte, h.ChunkSize+aead.Overhead()),
+	}, nil
+}
+
+func (d *decryptReader) Read(p []byte) (int, error) {
+	for len(d.plain) == 0 {
+		if d.done {
+			return 0, io.EOF
+		}
+		if err := d.open(); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, d.plain)
+	d.plain = d.plain[n:]
+	return n, nil
+}
+
+// open decrypts the next chunk
+func (d *decryptReader) open() error {
+	n, err := io.ReadFull(d.r, d.sealed)
+	last := false
+	switch {
+	case err == io.EOF || err == io.ErrUnexpectedEOF:
+		last = true
+	case err != nil:
+		return err
+	default:
+		_, peekErr := d.r.Peek(1)
+		last = peekErr == io.EOF
+	}
+
+	plain, err := d.aead.Open(nil, nonce(d.prefix, d.counter, last), d.sealed[:n], d.aad)
+	if err != nil {
+		if d.counter == 0 {
+			return config.ErrWrongPassphrase
+		}
+		return errors.New("export file is corrupt or truncated")
+	}
+	d.plain = plain
+	d.counter++
+	d.done = last
+	return nil
+}
@@ -0,0 +1,101 @@
+package transfer
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"bandwidth-income-manager/backend/backup"
+	"bandwidth-income-manager/backend/config"
+	"bandwidth-income-manager/backend/state"
+)
+
+// Source is the setup an export is taken from
+type Source struct {
+	Credentials *config.CredentialStore
+	State       state.State
+	Settings    []byte          // settings file contents, nil if none
+	Backups     *backup.Manager // takes the volume snapshots, nil if not available
+}
+
+// Export writes src encrypted with passphrase to w. With volumes, a
+// snapshot of the data of every instance that has some is included.
+func Export(w io.Writer, passphrase string, src *Source, volumes bool) (*Bundle, error) {
+	if err := checkPassphrase(passphrase); err != nil {
+		return nil, err
+	}
+	if src.Credentials.Locked() {
+		return nil, config.ErrLocked
+	}
+	creds, err := src.Credentials.LoadAllCredentials()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read credentials: %w", err)
+	}
+
+	bundle := NewBundle()
+	for _, c := range creds {
+		bundle.Credentials = append(bundle.Credentials, *c)
+	}
+	sort.Slice(bundle.Credentials, func(i, j int) bool {
+		return config.ProfileKey(bundle.Credentials[i].AppID, bundle.Credentials[i].Profile) <
+			config.ProfileKey(bundle.Credentials[j].AppID, bundle.Credentials[j].Profile)
+	})
+	passwords, err := src.Credentials.LoadProxyPasswords()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read proxy passwords: %w", err)
+	}
+	bundle.Proxies = src.State.Proxies
+	for i, p := range bundle.Proxies {
+		if password := passwords[p.ID]; password != "" {
+			bundle.Proxies[i].Password = password
+		}
+	}
+	bundle.Instances = src.State.Instances
+	bundle.Generated = src.State.Generated
+	bundle.Settings = src.Settings
+
+	var snapshots map[string]string
+	if volumes {
+		if src.Backups == nil {
+			return nil, errors.New("volume snapshots are not available")
+		}
+		dir, err := os.MkdirTemp("", "bim-export-*")
+		if err != nil {
+			return nil, err
+		}
+		defer os.RemoveAll(dir)
+		if snapshots, err = snapshotInstances(src.Backups, bundle.Instances, dir); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := Write(w, passphrase, bundle, snapshots); err != nil {
+		return nil, err
+	}
+	return bundle, nil
+}
+
+// snapshotInstances writes a snapshot of each instance's data into dir
+// and returns the files by instance ID. Instances without a container or
+// data are left out.
+func snapshotInstances(backups *backup.Manager, instances []state.Instance, dir string) (map[string]string, error) {
+	snapshots := make(map[string]string)
+	for i, inst := range instances {
+		if inst.ContainerID == "" {
+			continue
+		}
+		file := filepath.Join(dir, fmt.Sprintf("%d.tar.gz", i))
+		_, err := backups.Export(inst.ContainerID, file)
+		if errors.Is(err, backup.ErrNoData) {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to snapshot %s: %w", inst.InstanceID, err)
+		}
+		snapshots[inst.InstanceID] = file
+	}
+	return snapshots, nil
+}
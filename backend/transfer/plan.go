@@ -0,0 +1,401 @@
+package transfer
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"sort"
+	"strconv"
+	"time"
+
+	"bandwidth-income-manager/backend/apps"
+	"bandwidth-income-manager/backend/config"
+	"bandwidth-income-manager/backend/proxy"
+	"bandwidth-income-manager/backend/state"
+)
+
+// Kinds of items in an export
+const (
+	KindSettings = "settings"
+	KindProfile  = "profile"
+	KindProxy    = "proxy"
+	KindInstance = "instance"
+)
+
+// Ways to resolve a conflict. Skip is the default.
+const (
+	Skip      = "skip"
+	Overwrite = "overwrite"
+	Rename    = "rename"
+)
+
+// Item is one thing an import would add and, if it collides with
+// something on this host, the ways the conflict can be resolved
+type Item struct {
+	Kind        string   `json:"kind"`
+	Key         string   `json:"key"`
+	Name        string   `json:"name"`
+	Conflict    string   `json:"conflict,omitempty"`
+	Resolutions []string `json:"resolutions,omitempty"`
+}
+
+// ID identifies the item in a resolutions map
+func (i Item) ID() string {
+	return i.Kind + ":" + i.Key
+}
+
+// Existing describes what this host already has
+type Existing struct {
+	Profiles  map[string]bool   // config.ProfileKey of every credential profile
+	Proxies   map[string]string // proxy ID -> proxy URL
+	Instances map[string]string // apps.IdentityKey -> instance ID
+	LocalApps map[string]string // app ID -> ID of its local instance
+	Settings  json.RawMessage
+}
+
+// Plan is what an import does once its conflicts are resolved
+type Plan struct {
+	Settings  json.RawMessage // nil keeps the current settings
+	Profiles  []PlannedProfile
+	Proxies   []PlannedProxy
+	Instances []PlannedInstance
+	Skipped   []Item
+}
+
+// PlannedProfile is a credential profile to save from an export
+type PlannedProfile struct {
+	Item
+	Credentials config.AppCredentials // under the name it gets on this host
+}
+
+// PlannedProxy is a proxy to add from an export
+type PlannedProxy struct {
+	Item
+	Proxy state.Proxy // under the ID it gets on this host
+}
+
+// PlannedInstance is an instance to deploy from an export
+type PlannedInstance struct {
+	Item
+	Instance state.Instance // device name, proxy and profile as on this host
+	Replace  string         // ID of the instance it replaces, if overwritten
+	Snapshot string         // backup archive its data starts from, if any
+}
+
+// Preview lists the items of bundle with their conflicts on this host,
+// assuming every conflict is skipped
+func Preview(bundle *Bundle, existing *Existing) ([]Item, error) {
+	_, items, err := NewPlan(bundle, existing, nil)
+	return items, err
+}
+
+// NewPlan resolves the conflicts between bundle and this host. resolutions
+// maps Item.ID to Skip, Overwrite or Rename; unresolved conflicts are
+// skipped. Items that depend on a skipped one, like an instance behind a
+// skipped proxy, are skipped too.
+func NewPlan(bundle *Bundle, existing *Existing, resolutions map[string]string) (*Plan, []Item, error) {
+	p := &planner{
+		bundle:      bundle,
+		existing:    existing,
+		resolutions: resolutions,
+		plan:        &Plan{},
+		proxyIDs:    make(map[string]string),
+		profiles:    make(map[string]string),
+		identities:  make(map[string]bool),
+	}
+	steps := []func() error{p.settings, p.proxies, p.credentialProfiles, p.instances}
+	for _, step := range steps {
+		if err := step(); err != nil {
+			return nil, nil, err
+		}
+	}
+	return p.plan, p.items, nil
+}
+
+type planner struct {
+	bundle      *Bundle
+	existing    *Existing
+	resolutions map[string]string
+	plan        *Plan
+	items       []Item
+
+	proxyIDs   map[string]string // bundle proxy ID -> ID here, "" if skipped
+	profiles   map[string]string // bundle profile key -> profile name here
+	identities map[string]bool   // instance identities taken by the plan
+}
+
+// resolve records an item and returns how it is to be imported: "" when
+// it has no conflict, otherwise the chosen resolution
+func (p *planner) resolve(item Item) (string, error) {
+	p.items = append(p.items, item)
+	if item.Conflict == "" {
+		return "", nil
+	}
+	choice := p.resolutions[item.ID()]
+	if choice == "" {
+		choice = Skip
+	}
+	if !slices.Contains(item.Resolutions, choice) {
+		return "", fmt.Errorf("%s %s cannot be resolved with %q", item.Kind, item.Name, choice)
+	}
+	if choice == Skip {
+		p.plan.Skipped = append(p.plan.Skipped, item)
+	}
+	return choice, nil
+}
+
+func (p *planner) skip(item Item) {
+	p.items = append(p.items, item)
+	p.plan.Skipped = append(p.plan.Skipped, item)
+}
+
+func (p *planner) settings() error {
+	if len(p.bundle.Settings) == 0 {
+		return nil
+	}
+	item := Item{Kind: KindSettings, Key: KindSettings, Name: "application settings"}
+	if len(p.existing.Settings) > 0 && !jsonEqual(p.existing.Settings, p.bundle.Settings) {
+		item.Conflict = "this host has different settings"
+		item.Resolutions = []string{Skip, Overwrite}
+	}
+	choice, err := p.resolve(item)
+	if err != nil || choice == Skip {
+		return err
+	}
+	p.plan.Settings = p.bundle.Settings
+	return nil
+}
+
+func (p *planner) proxies() error {
+	byURL := make(map[string]string, len(p.existing.Proxies))
+	for id, url := range p.existing.Proxies {
+		byURL[url] = id
+	}
+
+	for _, px := range sortedProxies(p.bundle.Proxies) {
+		url := proxyURL(px)
+		item := Item{Kind: KindProxy, Key: px.ID, Name: displayProxy(px)}
+		sameAs, sameAddress := byURL[url]
+		current, idTaken := p.existing.Proxies[px.ID]
+
+		switch {
+		case idTaken && current == url:
+			// Already here
+			p.items = append(p.items, item)
+			p.proxyIDs[px.ID] = px.ID
+			continue
+		case sameAddress:
+			item.Conflict = "the same proxy is configured here as " + sameAs
+			item.Resolutions = []string{Skip, Rename}
+		case idTaken:
+			item.Conflict = "another proxy has the same ID here"
+			item.Resolutions = []string{Skip, Overwrite, Rename}
+		}
+
+		choice, err := p.resolve(item)
+		if err != nil {
+			return err
+		}
+		switch choice {
+		case Skip:
+			// Instances can use an identical proxy, but not a different
+			// one that happens to have the same ID
+			if sameAddress {
+				p.proxyIDs[px.ID] = sameAs
+			} else {
+				p.proxyIDs[px.ID] = ""
+			}
+			continue
+		case Rename:
+			px.ID = p.newProxyID()
+		}
+		p.proxyIDs[item.Key] = px.ID
+		p.plan.Proxies = append(p.plan.Proxies, PlannedProxy{Item: item, Proxy: px})
+	}
+	return nil
+}
+
+// newProxyID returns an unused ID in the format proxy.ParseProxy uses
+func (p *planner) newProxyID() string {
+	for n := time.Now().UnixNano(); ; n++ {
+		id := strconv.FormatInt(n, 10)
+		if _, taken := p.existing.Proxies[id]; taken {
+			continue
+		}
+		if slices.ContainsFunc(p.plan.Proxies, func(px PlannedProxy) bool { return px.Proxy.ID == id }) {
+			continue
+		}
+		if _, taken := p.proxyIDs[id]; taken {
+			continue
+		}
+		return id
+	}
+}
+
+func (p *planner) credentialProfiles() error {
+	creds := slices.Clone(p.bundle.Credentials)
+	sort.Slice(creds, func(i, j int) bool {
+		return config.ProfileKey(creds[i].AppID, creds[i].Profile) < config.ProfileKey(creds[j].AppID, creds[j].Profile)
+	})
+
+	taken := make(map[string]bool, len(p.existing.Profiles))
+	for key := range p.existing.Profiles {
+		taken[key] = true
+	}
+	for _, c := range creds {
+		if c.Profile == "" {
+			c.Profile = config.DefaultProfile
+		}
+		key := config.ProfileKey(c.AppID, c.Profile)
+		item := Item{Kind: KindProfile, Key: key, Name: c.Profile + " (" + c.AppID + ")"}
+		if p.existing.Profiles[key] {
+			item.Conflict = "a profile with this name exists here"
+			item.Resolutions = []string{Skip, Overwrite, Rename}
+		}
+
+		choice, err := p.resolve(item)
+		if err != nil {
+			return err
+		}
+		p.profiles[key] = c.Profile
+		switch choice {
+		case Skip:
+			// Instances use the profile already here
+			continue
+		case Rename:
+			c.Profile = uniqueName(c.Profile+"-imported", func(name string) bool {
+				return taken[config.ProfileKey(c.AppID, name)]
+			})
+			p.profiles[key] = c.Profile
+		}
+		taken[config.ProfileKey(c.AppID, c.Profile)] = true
+		p.plan.Profiles = append(p.plan.Profiles, PlannedProfile{Item: item, Credentials: c})
+	}
+	return nil
+}
+
+func (p *planner) instances() error {
+	instances := slices.Clone(p.bundle.Instances)
+	sort.Slice(instances, func(i, j int) bool { return instances[i].InstanceID < instances[j].InstanceID })
+
+	for _, inst := range instances {
+		item := Item{Kind: KindInstance, Key: inst.InstanceID, Name: inst.DeviceName + " (" + inst.AppID + ")"}
+
+		if inst.ProxyID != "" {
+			proxyID, known := p.proxyIDs[inst.ProxyID]
+			if proxyID == "" {
+				if known {
+					item.Conflict = "its proxy is skipped"
+				} else {
+					item.Conflict = "its proxy is missing from the export"
+				}
+				p.skip(item)
+				continue
+			}
+			inst.ProxyID = proxyID
+		}
+		if inst.Profile == "" {
+			inst.Profile = config.DefaultProfile
+		}
+		if name, ok := p.profiles[config.ProfileKey(inst.AppID, inst.Profile)]; ok {
+			inst.Profile = name
+		}
+
+		identity := apps.IdentityKey(inst.AppID, inst.DeviceName, inst.ProxyID)
+		replace := p.existing.Instances[identity]
+		if inst.ProxyID == "" && replace == "" {
+			replace = p.existing.LocalApps[inst.AppID]
+		}
+		switch {
+		case replace != "" && inst.ProxyID == "":
+			item.Conflict = "this host already runs the local instance of " + inst.AppID
+			item.Resolutions = []string{Skip, Overwrite}
+		case replace != "":
+			item.Conflict = "an instance with this device name and proxy exists here"
+			item.Resolutions = []string{Skip, Overwrite, Rename}
+		case p.identities[identity]:
+			item.Conflict = "the export has another instance with this device name and proxy"
+			item.Resolutions = []string{Skip, Rename}
+		}
+
+		choice, err := p.resolve(item)
+		if err != nil {
+			return err
+		}
+		planned := PlannedInstance{Item: item, Snapshot: p.bundle.Snapshots[item.Key]}
+		switch choice {
+		case Skip:
+			continue
+		case Overwrite:
+			planned.Replace = replace
+		case Rename:
+			// A renamed instance is a new node, so it gets new generated
+			// values instead of sharing the original's identity
+			inst.DeviceName = uniqueName(inst.DeviceName, func(name string) bool {
+				key := apps.IdentityKey(inst.AppID, name, inst.ProxyID)
+				return p.existing.Instances[key] != "" || p.identities[key]
+			})
+			inst.Generated = nil
+			identity = apps.IdentityKey(inst.AppID, inst.DeviceName, inst.ProxyID)
+		}
+		p.identities[identity] = true
+		planned.Instance = inst
+		p.plan.Instances = append(p.plan.Instances, planned)
+	}
+	return nil
+}
+
+// uniqueName returns name, or name-2, name-3 and so on, whichever is not
+// taken. Names stay within the 63 characters allowed for device and
+// profile names.
+func uniqueName(name string, taken func(string) bool) string {
+	if !taken(name) {
+		return name
+	}
+	for n := 2; ; n++ {
+		suffix := "-" + strconv.Itoa(n)
+		base := name
+		if len(base)+len(suffix) > 63 {
+			base = base[:63-len(suffix)]
+		}
+		if candidate := base + suffix; !taken(candidate) {
+			return candidate
+		}
+	}
+}
+
+func sortedProxies(proxies []state.Proxy) []state.Proxy {
+	sorted := slices.Clone(proxies)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].ID < sorted[j].ID })
+	return sorted
+}
+
+func proxyURL(px state.Proxy) string {
+	return (&proxy.Proxy{
+		Protocol: px.Protocol,
+		Host:     px.Host,
+		Port:     px.Port,
+		Username: px.Username,
+		Password: px.Password,
+	}).FormatProxy()
+}
+
+// displayProxy names a proxy without its password
+func displayProxy(px state.Proxy) string {
+	name := px.Protocol + "://" + px.Host + ":" + px.Port
+	if px.Username != "" {
+		name = px.Protocol + "://" + px.Username + "@" + px.Host + ":" + px.Port
+	}
+	return name
+}
+
+func jsonEqual(a, b json.RawMessage) bool {
+	var x, y interface{}
+	if json.Unmarshal(a, &x) != nil || json.Unmarshal(b, &y) != nil {
+		return bytes.Equal(a, b)
+	}
+	ax, _ := json.Marshal(x)
+	by, _ := json.Marshal(y)
+	return bytes.Equal(ax, by)
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"bandwidth-income-manager/backend/apps"
+	"bandwidth-income-manager/backend/backup"
+	"bandwidth-income-manager/backend/config"
+	"bandwidth-income-manager/backend/docker"
+	"bandwidth-income-manager/backend/state"
+	"bandwidth-income-manager/backend/transfer"
+)
+
+// runExport implements the export subcommand: it writes the credential
+// profiles, proxies, instances and settings, and with -volumes the data of
+// every instance, to one encrypted file that the import of another host
+// reads. The export passphrase comes from BIM_EXPORT_PASSPHRASE or the
+// first line of stdin; a credential passphrase, if set, from
+// BIM_PASSPHRASE. It returns the process exit code.
+func runExport(args []string) int {
+	flags := flag.NewFlagSet("export", flag.ContinueOnError)
+	output := flags.String("o", "bim-export-"+time.Now().Format("20060102-150405")+".bimx", "File to write the export to")
+	volumes := flags.Bool("volumes", false, "Include snapshots of the instances' data")
+	dataDir := flags.String("data-dir", "", "Data directory to export (default $"+config.DataDirEnv+" or the platform data directory)")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+
+	// The config file and environment apply as they do to the app
+	var optionArgs []string
+	if *dataDir != "" {
+		optionArgs = []string{"--data-dir", *dataDir}
+	}
+	opts, err := config.LoadOptions(optionArgs)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid configuration: %v\n", err)
+		return 2
+	}
+	paths := config.DataPaths{Root: opts.DataDir}
+	apps.DataDir = opts.DataDir
+
+	credentialStore, err := config.OpenCredentialStore(opts.CredentialBackend, paths.Root)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error opening credential store: %v\n", err)
+		return 1
+	}
+	if credentialStore.Locked() {
+		if err := credentialStore.Unlock(os.Getenv("BIM_PASSPHRASE")); err != nil {
+			fmt.Fprintf(os.Stderr, "Credentials are locked; set BIM_PASSPHRASE: %v\n", err)
+			return 1
+		}
+	}
+	stateStore, err := state.Open(paths.StateFile())
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error loading state: %v\n", err)
+		return 1
+	}
+	src := &transfer.Source{Credentials: credentialStore, State: stateStore.State()}
+	if data, err := os.ReadFile(paths.SettingsFile()); err == nil {
+		src.Settings = data
+	}
+	if *volumes {
+		dockerClient, err := docker.NewDockerClient(opts.DockerHost)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error connecting to Docker: %v\n", err)
+			return 1
+		}
+		src.Backups = backup.NewManager(paths.Backups(), dockerClient, apps.NewInstanceManager())
+	}
+
+	passphrase := os.Getenv("BIM_EXPORT_PASSPHRASE")
+	if passphrase == "" {
+		fmt.Fprint(os.Stderr, "Export passphrase: ")
+		line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+		if err != nil && line == "" {
+			fmt.Fprintf(os.Stderr, "\nError reading passphrase: %v\n", err)
+			return 1
+		}
+		passphrase = strings.TrimRight(line, "\r\n")
+	}
+
+	f, err := os.OpenFile(*output, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error creating export: %v\n", err)
+		return 1
+	}
+	bundle, err := transfer.Export(f, passphrase, src, *volumes)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(*output)
+		fmt.Fprintf(os.Stderr, "Error writing export: %v\n", err)
+		return 1
+	}
+
+	fmt.Printf("Exported %d credential profiles, %d proxies, %d instances and %d snapshots to %s\n",
+		len(bundle.Credentials), len(bundle.Proxies), len(bundle.Instances), len(bundle.Snapshots), *output)
+	return 0
+}
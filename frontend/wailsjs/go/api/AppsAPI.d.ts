@@ -2,8 +2,24 @@
 // This file is automatically generated. DO NOT EDIT
 import {context} from '../models';
 
+export function AdoptContainers():Promise<Record<string, any>>;
+
+export function ChangeCredentialPassphrase(arg1:string,arg2:string):Promise<void>;
+
+export function CheckImageUpdates():Promise<Array<Record<string, any>>>;
+
+export function CreateBackup(arg1:string):Promise<Record<string, any>>;
+
+export function CreateCredentialProfile(arg1:string,arg2:string,arg3:Record<string, string>):Promise<void>;
+
+export function DeleteBackup(arg1:string):Promise<void>;
+
+export function DeleteCredentialProfile(arg1:string,arg2:string):Promise<void>;
+
 export function DeployApp(arg1:string,arg2:Record<string, string>):Promise<void>;
 
+export function DeployAppWithProfile(arg1:string,arg2:string,arg3:Record<string, string>,arg4:string):Promise<void>;
+
 export function DeployAppWithProxies(arg1:string,arg2:Record<string, string>,arg3:Array<string>):Promise<Array<Record<string, any>>>;
 
 export function DeployAppWithProxiesSelective(arg1:string,arg2:string,arg3:Array<string>):Promise<Array<Record<string, any>>>;
@@ -14,28 +30,54 @@ export function DeployAppWithProxyId(arg1:string,arg2:Record<string, string>,arg
 
 export function GetAppCredentials(arg1:string):Promise<Record<string, string>>;
 
+export function GetAppCredentialsUnmasked(arg1:string):Promise<Record<string, string>>;
+
 export function GetAppInstances(arg1:string):Promise<Array<Record<string, any>>>;
 
 export function GetAppLogs(arg1:string,arg2:number):Promise<string>;
 
+export function GetAppSchema(arg1:string):Promise<Array<Record<string, any>>>;
+
 export function GetAppStats(arg1:string):Promise<Record<string, any>>;
 
 export function GetAvailableApps():Promise<Record<string, any>>;
 
+export function GetBackupPolicy():Promise<Record<string, number>>;
+
+export function GetConfigErrors():Promise<Record<string, string>>;
+
 export function GetConfiguredApps():Promise<Array<Record<string, any>>>;
 
 export function GetContainerEnvironmentVars(arg1:string):Promise<Record<string, string>>;
 
+export function GetContainerEnvironmentVarsUnmasked(arg1:string):Promise<Record<string, string>>;
+
 export function GetContainerLogs(arg1:string):Promise<string>;
 
 export function GetContainerLogsAll(arg1:string):Promise<string>;
 
 export function GetContainerLogsTail(arg1:string,arg2:number):Promise<string>;
 
+export function GetCredentialProfile(arg1:string,arg2:string):Promise<Record<string, string>>;
+
+export function GetCredentialProfileUnmasked(arg1:string,arg2:string):Promise<Record<string, string>>;
+
+export function GetCredentialStoreStatus():Promise<Record<string, any>>;
+
 export function GetDashboardSummary():Promise<Record<string, any>>;
 
+export function GetImageUpdates():Promise<Array<Record<string, any>>>;
+
+export function GetInstanceResourceLimits(arg1:string):Promise<Record<string, string>>;
+
 export function GetRunningApps():Promise<Array<Record<string, any>>>;
 
+export function ListBackups(arg1:string):Promise<Array<Record<string, any>>>;
+
+export function ListCredentialProfiles(arg1:string):Promise<Array<Record<string, any>>>;
+
+export function LockCredentials():Promise<void>;
+
 export function OnStartup(arg1:context.Context):Promise<void>;
 
 export function OnStartupContext(arg1:context.Context):Promise<void>;
@@ -46,8 +88,30 @@ export function RemoveAppInstance(arg1:string):Promise<void>;
 
 export function RestartApp(arg1:string):Promise<void>;
 
+export function RestoreBackup(arg1:string,arg2:string):Promise<void>;
+
+export function RestoreBackupAsNewInstance(arg1:string,arg2:string,arg3:string):Promise<void>;
+
+export function RotateCredentialProfile(arg1:string,arg2:string,arg3:Record<string, string>):Promise<Array<Record<string, any>>>;
+
+export function SetBackupPolicy(arg1:Record<string, number>):Promise<void>;
+
 export function SetContext(arg1:context.Context):Promise<void>;
 
+export function SetCredentialPassphrase(arg1:string):Promise<void>;
+
 export function StartApp(arg1:string):Promise<void>;
 
+export function StartLogStream(arg1:string,arg2:Record<string, string>):Promise<string>;
+
 export function StopApp(arg1:string):Promise<void>;
+
+export function StopLogStream(arg1:string):Promise<void>;
+
+export function UnlockCredentials(arg1:string):Promise<void>;
+
+export function UpdateApp(arg1:string):Promise<Record<string, any>>;
+
+export function UpdateCredentialProfile(arg1:string,arg2:string,arg3:Record<string, string>):Promise<void>;
+
+export function UpdateInstanceResourceLimits(arg1:string,arg2:Record<string, string>):Promise<void>;
@@ -2,10 +2,42 @@
 // Cynhyrchwyd y ffeil hon yn awtomatig. PEIDIWCH Â MODIWL
 // This file is automatically generated. DO NOT EDIT
 
+export function AdoptContainers() {
+  return window['go']['api']['AppsAPI']['AdoptContainers']();
+}
+
+export function ChangeCredentialPassphrase(arg1, arg2) {
+  return window['go']['api']['AppsAPI']['ChangeCredentialPassphrase'](arg1, arg2);
+}
+
+export function CheckImageUpdates() {
+  return window['go']['api']['AppsAPI']['CheckImageUpdates']();
+}
+
+export function CreateBackup(arg1) {
+  return window['go']['api']['AppsAPI']['CreateBackup'](arg1);
+}
+
+export function CreateCredentialProfile(arg1, arg2, arg3) {
+  return window['go']['api']['AppsAPI']['CreateCredentialProfile'](arg1, arg2, arg3);
+}
+
+export function DeleteBackup(arg1) {
+  return window['go']['api']['AppsAPI']['DeleteBackup'](arg1);
+}
+
+export function DeleteCredentialProfile(arg1, arg2) {
+  return window['go']['api']['AppsAPI']['DeleteCredentialProfile'](arg1, arg2);
+}
+
 export function DeployApp(arg1, arg2) {
   return window['go']['api']['AppsAPI']['DeployApp'](arg1, arg2);
 }
 
+export function DeployAppWithProfile(arg1, arg2, arg3, arg4) {
+  return window['go']['api']['AppsAPI']['DeployAppWithProfile'](arg1, arg2, arg3, arg4);
+}
+
 export function DeployAppWithProxies(arg1, arg2, arg3) {
   return window['go']['api']['AppsAPI']['DeployAppWithProxies'](arg1, arg2, arg3);
 }
@@ -26,6 +58,10 @@ export function GetAppCredentials(arg1) {
   return window['go']['api']['AppsAPI']['GetAppCredentials'](arg1);
 }
 
+export function GetAppCredentialsUnmasked(arg1) {
+  return window['go']['api']['AppsAPI']['GetAppCredentialsUnmasked'](arg1);
+}
+
 export function GetAppInstances(arg1) {
   return window['go']['api']['AppsAPI']['GetAppInstances'](arg1);
 }
@@ -34,6 +70,10 @@ export function GetAppLogs(arg1, arg2) {
   return window['go']['api']['AppsAPI']['GetAppLogs'](arg1, arg2);
 }
 
+export function GetAppSchema(arg1) {
+  return window['go']['api']['AppsAPI']['GetAppSchema'](arg1);
+}
+
 export function GetAppStats(arg1) {
   return window['go']['api']['AppsAPI']['GetAppStats'](arg1);
 }
@@ -42,6 +82,14 @@ export function GetAvailableApps() {
   return window['go']['api']['AppsAPI']['GetAvailableApps']();
 }
 
+export function GetBackupPolicy() {
+  return window['go']['api']['AppsAPI']['GetBackupPolicy']();
+}
+
+export function GetConfigErrors() {
+  return window['go']['api']['AppsAPI']['GetConfigErrors']();
+}
+
 export function GetConfiguredApps() {
   return window['go']['api']['AppsAPI']['GetConfiguredApps']();
 }
@@ -50,6 +98,10 @@ export function GetContainerEnvironmentVars(arg1) {
   return window['go']['api']['AppsAPI']['GetContainerEnvironmentVars'](arg1);
 }
 
+export function GetContainerEnvironmentVarsUnmasked(arg1) {
+  return window['go']['api']['AppsAPI']['GetContainerEnvironmentVarsUnmasked'](arg1);
+}
+
 export function GetContainerLogs(arg1) {
   return window['go']['api']['AppsAPI']['GetContainerLogs'](arg1);
 }
@@ -62,14 +114,46 @@ export function GetContainerLogsTail(arg1, arg2) {
   return window['go']['api']['AppsAPI']['GetContainerLogsTail'](arg1, arg2);
 }
 
+export function GetCredentialProfile(arg1, arg2) {
+  return window['go']['api']['AppsAPI']['GetCredentialProfile'](arg1, arg2);
+}
+
+export function GetCredentialProfileUnmasked(arg1, arg2) {
+  return window['go']['api']['AppsAPI']['GetCredentialProfileUnmasked'](arg1, arg2);
+}
+
+export function GetCredentialStoreStatus() {
+  return window['go']['api']['AppsAPI']['GetCredentialStoreStatus']();
+}
+
 export function GetDashboardSummary() {
   return window['go']['api']['AppsAPI']['GetDashboardSummary']();
 }
 
+export function GetImageUpdates() {
+  return window['go']['api']['AppsAPI']['GetImageUpdates']();
+}
+
+export function GetInstanceResourceLimits(arg1) {
+  return window['go']['api']['AppsAPI']['GetInstanceResourceLimits'](arg1);
+}
+
 export function GetRunningApps() {
   return window['go']['api']['AppsAPI']['GetRunningApps']();
 }
 
+export function ListBackups(arg1) {
+  return window['go']['api']['AppsAPI']['ListBackups'](arg1);
+}
+
+export function ListCredentialProfiles(arg1) {
+  return window['go']['api']['AppsAPI']['ListCredentialProfiles'](arg1);
+}
+
+export function LockCredentials() {
+  return window['go']['api']['AppsAPI']['LockCredentials']();
+}
+
 export function OnStartup(arg1) {
   return window['go']['api']['AppsAPI']['OnStartup'](arg1);
 }
@@ -90,14 +174,58 @@ export function RestartApp(arg1) {
   return window['go']['api']['AppsAPI']['RestartApp'](arg1);
 }
 
+export function RestoreBackup(arg1, arg2) {
+  return window['go']['api']['AppsAPI']['RestoreBackup'](arg1, arg2);
+}
+
+export function RestoreBackupAsNewInstance(arg1, arg2, arg3) {
+  return window['go']['api']['AppsAPI']['RestoreBackupAsNewInstance'](arg1, arg2, arg3);
+}
+
+export function RotateCredentialProfile(arg1, arg2, arg3) {
+  return window['go']['api']['AppsAPI']['RotateCredentialProfile'](arg1, arg2, arg3);
+}
+
+export function SetBackupPolicy(arg1) {
+  return window['go']['api']['AppsAPI']['SetBackupPolicy'](arg1);
+}
+
 export function SetContext(arg1) {
   return window['go']['api']['AppsAPI']['SetContext'](arg1);
 }
 
+export function SetCredentialPassphrase(arg1) {
+  return window['go']['api']['AppsAPI']['SetCredentialPassphrase'](arg1);
+}
+
 export function StartApp(arg1) {
   return window['go']['api']['AppsAPI']['StartApp'](arg1);
 }
 
+export function StartLogStream(arg1, arg2) {
+  return window['go']['api']['AppsAPI']['StartLogStream'](arg1, arg2);
+}
+
 export function StopApp(arg1) {
   return window['go']['api']['AppsAPI']['StopApp'](arg1);
 }
+
+export function StopLogStream(arg1) {
+  return window['go']['api']['AppsAPI']['StopLogStream'](arg1);
+}
+
+export function UnlockCredentials(arg1) {
+  return window['go']['api']['AppsAPI']['UnlockCredentials'](arg1);
+}
+
+export function UpdateApp(arg1) {
+  return window['go']['api']['AppsAPI']['UpdateApp'](arg1);
+}
+
+export function UpdateCredentialProfile(arg1, arg2, arg3) {
+  return window['go']['api']['AppsAPI']['UpdateCredentialProfile'](arg1, arg2, arg3);
+}
+
+export function UpdateInstanceResourceLimits(arg1, arg2) {
+  return window['go']['api']['AppsAPI']['UpdateInstanceResourceLimits'](arg1, arg2);
+}
@@ -3,6 +3,8 @@
 import {api} from '../models';
 import {context} from '../models';
 
+export function GetEffectiveConfig():Promise<Record<string, any>>;
+
 export function GetSettings():Promise<api.AppSettings>;
 
 export function OnStartup(arg1:context.Context):Promise<void>;
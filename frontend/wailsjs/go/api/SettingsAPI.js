@@ -2,6 +2,10 @@
 // Cynhyrchwyd y ffeil hon yn awtomatig. PEIDIWCH Â MODIWL
 // This file is automatically generated. DO NOT EDIT
 
+export function GetEffectiveConfig() {
+  return window['go']['api']['SettingsAPI']['GetEffectiveConfig']();
+}
+
 export function GetSettings() {
   return window['go']['api']['SettingsAPI']['GetSettings']();
 }
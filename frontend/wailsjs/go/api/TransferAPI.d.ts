@@ -0,0 +1,11 @@
+// Cynhyrchwyd y ffeil hon yn awtomatig. PEIDIWCH Â MODIWL
+// This file is automatically generated. DO NOT EDIT
+import {context} from '../models';
+
+export function ExportState(arg1:string,arg2:string,arg3:boolean):Promise<Record<string, any>>;
+
+export function ImportState(arg1:string,arg2:string,arg3:Record<string, string>):Promise<Array<Record<string, any>>>;
+
+export function OnStartup(arg1:context.Context):Promise<void>;
+
+export function PreviewImport(arg1:string,arg2:string):Promise<Record<string, any>>;
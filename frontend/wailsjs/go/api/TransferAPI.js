@@ -0,0 +1,19 @@
+// @ts-check
+// Cynhyrchwyd y ffeil hon yn awtomatig. PEIDIWCH Â MODIWL
+// This file is automatically generated. DO NOT EDIT
+
+export function ExportState(arg1, arg2, arg3) {
+  return window['go']['api']['TransferAPI']['ExportState'](arg1, arg2, arg3);
+}
+
+export function ImportState(arg1, arg2, arg3) {
+  return window['go']['api']['TransferAPI']['ImportState'](arg1, arg2, arg3);
+}
+
+export function OnStartup(arg1) {
+  return window['go']['api']['TransferAPI']['OnStartup'](arg1);
+}
+
+export function PreviewImport(arg1, arg2) {
+  return window['go']['api']['TransferAPI']['PreviewImport'](arg1, arg2);
+}
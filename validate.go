@@ -0,0 +1,82 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"bandwidth-income-manager/backend/apps"
+	"bandwidth-income-manager/backend/config"
+)
+
+// runValidate implements the validate subcommand: it checks app config
+// files and prints every problem as file:line: field: message. Arguments
+// are files or directories; the default is configs/apps in the data
+// directory. It returns the process exit code.
+func runValidate(args []string) int {
+	if len(args) == 0 {
+		opts, err := config.LoadOptions(nil)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Invalid configuration: %v\n", err)
+			return 2
+		}
+		args = []string{filepath.Join(config.DataPaths{Root: opts.DataDir}.Configs(), "apps")}
+	}
+
+	var files []string
+	for _, arg := range args {
+		info, err := os.Stat(arg)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %v\n", arg, err)
+			return 2
+		}
+		if !info.IsDir() {
+			files = append(files, arg)
+			continue
+		}
+		matches, err := filepath.Glob(filepath.Join(arg, "*.yaml"))
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %v\n", arg, err)
+			return 2
+		}
+		files = append(files, matches...)
+	}
+	if len(files) == 0 {
+		fmt.Println("No app config files found")
+		return 0
+	}
+
+	failed := 0
+	for _, file := range files {
+		err := config.ValidateFile(file)
+		if err == nil {
+			continue
+		}
+		failed++
+
+		var errs apps.ValidationErrors
+		if !errors.As(err, &errs) {
+			fmt.Printf("%s: %v\n", file, err)
+			continue
+		}
+		for _, e := range errs {
+			location := file
+			if e.Line > 0 {
+				location = fmt.Sprintf("%s:%d", file, e.Line)
+			}
+			if e.Field != "" {
+				fmt.Printf("%s: %s: %s\n", location, e.Field, e.Message)
+			} else {
+				fmt.Printf("%s: %s\n", location, e.Message)
+			}
+		}
+	}
+
+	if failed > 0 {
+		fmt.Printf("%d of %d app config files are invalid\n", failed, len(files))
+		return 1
+	}
+	fmt.Printf("%d app config files are valid\n", len(files))
+	return 0
+}